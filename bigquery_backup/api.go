@@ -0,0 +1,162 @@
+package bigquerybackup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+// Config describes a table backup for callers using this package as a library rather than
+// through the HTTP function. Format and Compression follow the same rules as the
+// destination_format and compression_type request fields.
+type Config struct {
+	// ProjectID is the project holding the dataset. It defaults to the GCP_PROJECT
+	// environment variable.
+	ProjectID string
+	DatasetID string
+	TableID   string
+	// Bucket may be left empty when DATASET_BUCKET_MAP is configured.
+	Bucket      string
+	Format      string
+	Compression string
+}
+
+// Result describes a completed backup.
+type Result struct {
+	JobID string `json:"job_id"`
+	// BytesExported is the total size of the shards written to cloud storage.
+	BytesExported  int64  `json:"bytes_exported"`
+	DestinationURI string `json:"destination_uri"`
+	// DurationSeconds is how long the extract job took, from submitting it to its completion.
+	DurationSeconds float64 `json:"duration_seconds"`
+}
+
+// postBody converts the configuration to the request body the HTTP function accepts, so that
+// both entry points share the same validation.
+func (cfg Config) postBody() postBodyParams {
+	return postBodyParams{
+		DatasetName:   strings.TrimSpace(cfg.DatasetID),
+		TableName:     strings.TrimSpace(cfg.TableID),
+		StorageBucket: strings.TrimSpace(cfg.Bucket),
+		Format:        cfg.Format,
+		Compression:   cfg.Compression,
+	}
+}
+
+// Backup exports one BigQuery table to cloud storage and waits for the export to complete.
+// It runs the same validation and backup as the BigQueryBackup HTTP function. An extract job
+// that outlasts JOB_TIMEOUT_SECONDS is reported as an error naming the job, which keeps running.
+func Backup(ctx context.Context, cfg Config) (*Result, error) {
+	pb := cfg.postBody()
+	if pb.TableName == "" || pb.TableName == allTables {
+		return nil, errors.New("missing table ID")
+	}
+
+	bp := &backupParams{runID: uuid.NewString(), cache: newMetadataCache(), projectID: strings.TrimSpace(cfg.ProjectID)}
+	if bp.projectID == "" {
+		if err := bp.setProjectID(); err != nil {
+			return nil, errors.New("missing project ID, set Config.ProjectID or the GCP_PROJECT environment variable")
+		}
+	}
+	defer func() {
+		_ = bp.flushLogs()
+	}()
+	if ok, err := bp.checkPostBody(&pb); !ok || err != nil {
+		return nil, fmt.Errorf("invalid config: %v", err)
+	}
+	if err := bp.warmUpClients(ctx); err != nil {
+		return nil, err
+	}
+	bp.setBackupParams(pb)
+	if err := bp.resolveStorageBucket(ctx); err != nil {
+		return nil, fmt.Errorf("invalid config: %v", err)
+	}
+
+	return bp.backup(ctx)
+}
+
+// Close flushes the buffered log entries and closes the clients shared by every backup in this
+// process. Call it once when the process shuts down; no backup may run afterwards.
+func Close() error {
+	var errs []error
+	lbMu.Lock()
+	if lb != nil {
+		errs = append(errs, lb.Close())
+		lb = nil
+	}
+	lbMu.Unlock()
+	if bc != nil {
+		errs = append(errs, bc.Close())
+	}
+	if sc != nil {
+		errs = append(errs, sc.Close())
+	}
+	if mc != nil {
+		errs = append(errs, mc.Close())
+	}
+	return errors.Join(errs...)
+}
+
+// backup runs a single-table backup, see runTableBackup, and returns its result. It is shared by
+// Backup and the BigQueryBackup HTTP function, which answers with the result and the state the
+// backup left in bp.
+func (bp *backupParams) backup(ctx context.Context) (*Result, error) {
+	skipped, err := bp.runTableBackup(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return bp.completedResult(ctx, skipped)
+}
+
+// completedResult returns the result of the backup runTableBackup ran, or an error wrapping
+// errJobStillRunning when its extract job outlasted JOB_TIMEOUT_SECONDS, since the backup has
+// not completed yet. The result of a backup that was skipped, a dry run or started
+// asynchronously only names the job and destination, as nothing was exported by this call.
+func (bp *backupParams) completedResult(ctx context.Context, skipped bool) (*Result, error) {
+	switch {
+	case bp.jobTimedOut && !bp.reusedJob():
+		return nil, fmt.Errorf("%w: job %s", errJobStillRunning, bp.jobID)
+	case skipped || bp.dryRun || (bp.async && !bp.reusedJob()):
+		return &Result{JobID: bp.jobID, DestinationURI: bp.destinationURI()}, nil
+	}
+	return bp.result(ctx), nil
+}
+
+// result describes the completed backup. The exported bytes are those counted by the manifest,
+// or measured under the backup prefix for a reused job, whose manifest an earlier run wrote.
+// Failing to measure them is logged rather than returned, since the backup itself succeeded.
+func (bp *backupParams) result(ctx context.Context) *Result {
+	bytes := bp.bytesExported
+	if bp.reusedJob() {
+		var err error
+		if bytes, err = bp.exportedBytes(ctx); err != nil {
+			_ = bp.logError(fmt.Sprintf("Failed to measure backup of table %s.%s: %v", bp.sourceDatasetID, bp.backupTableID, err))
+		}
+	}
+	res := &Result{JobID: bp.jobID, BytesExported: bytes, DestinationURI: bp.destinationURI()}
+	if bp.backupResult != nil {
+		res.DurationSeconds = bp.backupResult.Duration.Seconds()
+	}
+	return res
+}
+
+// exportedBytes returns the total size of the shards under the final backup prefix.
+func (bp *backupParams) exportedBytes(ctx context.Context) (int64, error) {
+	c, err := sharedStorageClient()
+	if err != nil {
+		return 0, err
+	}
+
+	shards, err := bp.listShards(ctx, c, bp.backupPrefix())
+	if err != nil {
+		return 0, err
+	}
+	var total int64
+	for _, attrs := range shards {
+		total += attrs.Size
+	}
+	return total, nil
+}
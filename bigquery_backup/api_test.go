@@ -0,0 +1,93 @@
+package bigquerybackup
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConfigPostBody(t *testing.T) {
+	cfg := Config{DatasetID: " ds ", TableID: "t", Bucket: "b", Format: "parquet", Compression: "zstd"}
+	assert.Equal(t, postBodyParams{
+		DatasetName:   "ds",
+		TableName:     "t",
+		StorageBucket: "b",
+		Format:        "parquet",
+		Compression:   "zstd",
+	}, cfg.postBody())
+}
+
+func TestBackupRejectsInvalidConfig(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  Config
+	}{
+		{name: "Missing table", cfg: Config{ProjectID: "p", DatasetID: "ds", Bucket: "b"}},
+		{name: "Whole dataset", cfg: Config{ProjectID: "p", DatasetID: "ds", TableID: allTables, Bucket: "b"}},
+		{name: "Missing dataset", cfg: Config{ProjectID: "p", TableID: "t", Bucket: "b"}},
+		{name: "Unknown format", cfg: Config{ProjectID: "p", DatasetID: "ds", TableID: "t", Bucket: "b", Format: "ORC"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res, err := Backup(context.Background(), tt.cfg)
+			assert.Error(t, err)
+			assert.Nil(t, res)
+		})
+	}
+}
+
+func TestBackupRequiresProject(t *testing.T) {
+	t.Setenv("GCP_PROJECT", "")
+	_, err := Backup(context.Background(), Config{DatasetID: "ds", TableID: "t", Bucket: "b"})
+	assert.EqualError(t, err, "missing project ID, set Config.ProjectID or the GCP_PROJECT environment variable")
+}
+
+func TestCompletedResultJobTimedOut(t *testing.T) {
+	t.Setenv("JOB_TIMEOUT_SECONDS", "1")
+	bp := &backupParams{sourceDatasetID: "ds", backupTableID: "t", storageBucket: "backups", destinationFormat: "AVRO"}
+	job := &fakeJob{id: "job_1", running: true}
+	bp.jobID = job.ID()
+	bp.backupResult = bp.waitForJob(context.Background(), job)
+
+	res, err := bp.completedResult(context.Background(), false)
+	assert.Nil(t, res)
+	assert.ErrorIs(t, err, errJobStillRunning)
+	assert.ErrorContains(t, err, "job_1")
+}
+
+func TestCompletedResult(t *testing.T) {
+	tests := []struct {
+		name      string
+		skipped   bool
+		dryRun    bool
+		async     bool
+		wantBytes int64
+	}{
+		{name: "Completed", wantBytes: 150},
+		{name: "Skipped", skipped: true},
+		{name: "Dry run", dryRun: true},
+		{name: "Async", async: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bp := &backupParams{
+				sourceDatasetID:   "ds",
+				backupTableID:     "t",
+				storageBucket:     "backups",
+				destinationFormat: "AVRO",
+				jobID:             "job_1",
+				bytesExported:     150,
+				dryRun:            tt.dryRun,
+				async:             tt.async,
+			}
+			res, err := bp.completedResult(context.Background(), tt.skipped)
+			assert.NoError(t, err)
+			assert.Equal(t, "job_1", res.JobID)
+			assert.Equal(t, bp.destinationURI(), res.DestinationURI)
+			assert.Equal(t, tt.wantBytes, res.BytesExported)
+		})
+	}
+}
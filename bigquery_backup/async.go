@@ -0,0 +1,77 @@
+package bigquerybackup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// asyncStatusRunning is the status of an async backup whose extract job has been started.
+const asyncStatusRunning = "running"
+
+// checkAsync rejects options that cannot be combined with an async backup. An async request
+// returns as soon as the extract job has started, so nothing that needs the finished export
+// can run, and a dataset-wide backup has no single job to report.
+func checkAsync(pb *postBodyParams) error {
+	switch {
+	case pb.TableName == "" || pb.TableName == allTables:
+		return errors.New("async is only supported for single-table backups")
+	case pb.StagedPublish:
+		return errors.New("async cannot be combined with staged_publish")
+	case pb.MarkBackupParts:
+		return errors.New("async cannot be combined with mark_backup_parts")
+	case pb.CheckShardSchemas:
+		return errors.New("async cannot be combined with check_shard_schemas")
+	case pb.SanityCheck != nil:
+		return errors.New("async cannot be combined with sanity_check")
+	case pb.Verify:
+		return errors.New("async cannot be combined with verify")
+	case pb.SketchColumn != "":
+		return errors.New("async cannot be combined with sketch_column")
+	case pb.OnSuccessTrigger != nil:
+		return errors.New("async cannot be combined with on_success_trigger")
+	case pb.SecondaryBucket != "":
+		return errors.New("async cannot be combined with secondary_bucket, the export has to be complete before it can be copied")
+	case pb.GenerateSignedURLs:
+		return errors.New("async cannot be combined with generate_signed_urls, the export has to be complete before its files can be signed")
+	case pb.MaxFileBytes > 0:
+		return errors.New("async cannot be combined with max_file_bytes, the export has to be complete before its files can be measured")
+	case pb.StorageClass != "":
+		return errors.New("async cannot be combined with storage_class, the export has to be complete before its objects can be rewritten")
+	}
+	return nil
+}
+
+// startAsyncBackup starts the extract job without waiting for it and writes the load config and
+// schema, neither of which depends on the finished export.
+func (bp *backupParams) startAsyncBackup(ctx context.Context) error {
+	if _, err := bp.startBackup(ctx); err != nil {
+		_ = bp.logError("Problem starting backup of BigQuery table")
+		return fmt.Errorf("problem starting backup of BigQuery table: %v", err)
+	}
+	if err := bp.writeLoadConfigObject(ctx); err != nil {
+		_ = bp.logError(fmt.Sprintf("Problem writing load config: %v", err))
+		return fmt.Errorf("problem writing load config: %v", err)
+	}
+	if err := bp.writeSchemaObject(ctx); err != nil {
+		_ = bp.logError(fmt.Sprintf("Problem writing schema: %v", err))
+		return fmt.Errorf("problem writing schema: %v", err)
+	}
+	return nil
+}
+
+// startedResponse is the 202 body for a backup whose extract job is still running, because it
+// was started asynchronously or outlasted JOB_TIMEOUT_SECONDS. It names the job and the location
+// it runs in, which the status function needs to find a job outside BIGQUERY_LOCATION.
+func (bp *backupParams) startedResponse() successResponse {
+	return successResponse{
+		Status:         asyncStatusRunning,
+		JobID:          bp.jobID,
+		Location:       bp.location,
+		RunID:          bp.runID,
+		DestinationURI: bp.destinationURI(),
+		Export:         bp.export,
+		Warnings:       bp.warnings,
+		Result:         bp.backupResult,
+	}
+}
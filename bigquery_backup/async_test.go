@@ -0,0 +1,84 @@
+package bigquerybackup
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckAsync(t *testing.T) {
+	tests := []struct {
+		name    string
+		pb      postBodyParams
+		wantErr bool
+	}{
+		{name: "Single table", pb: postBodyParams{TableName: "t", WriteLoadConfig: true}},
+		{name: "Whole dataset", pb: postBodyParams{}, wantErr: true},
+		{name: "All tables", pb: postBodyParams{TableName: allTables}, wantErr: true},
+		{name: "Staged publish", pb: postBodyParams{TableName: "t", StagedPublish: true}, wantErr: true},
+		{name: "Mark backup parts", pb: postBodyParams{TableName: "t", MarkBackupParts: true}, wantErr: true},
+		{name: "Shard schemas", pb: postBodyParams{TableName: "t", CheckShardSchemas: true}, wantErr: true},
+		{name: "Sanity check", pb: postBodyParams{TableName: "t", SanityCheck: &sanityCheck{SampleRows: 10}}, wantErr: true},
+		{name: "Verify", pb: postBodyParams{TableName: "t", Verify: true}, wantErr: true},
+		{name: "Sketch", pb: postBodyParams{TableName: "t", SketchColumn: "id"}, wantErr: true},
+		{name: "Success trigger", pb: postBodyParams{TableName: "t", OnSuccessTrigger: &successTrigger{Topic: "done"}}, wantErr: true},
+		{name: "Storage class", pb: postBodyParams{TableName: "t", StorageClass: "COLDLINE"}, wantErr: true},
+		{name: "Secondary bucket", pb: postBodyParams{TableName: "t", SecondaryBucket: "backups-dr"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkAsync(&tt.pb)
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestStartAsyncBackupDoesNotWait(t *testing.T) {
+	job := &fakeJob{id: "job_1", err: errors.New("must not wait")}
+	bp := &backupParams{
+		projectID:         "test-project",
+		sourceDatasetID:   "ds",
+		backupTableID:     "t",
+		storageBucket:     "b",
+		destinationFormat: avroFormat,
+		compressionType:   snappyCompression,
+		async:             true,
+		extractor:         &fakeBigQuery{job: job},
+	}
+	assert.NoError(t, bp.setBigQueryClient(context.Background()))
+	assert.NoError(t, bp.startAsyncBackup(context.Background()))
+	assert.Equal(t, "job_1", bp.jobID)
+}
+
+func TestStartedResponse(t *testing.T) {
+	bp := &backupParams{
+		sourceDatasetID:   "ds",
+		backupTableID:     "t",
+		storageBucket:     "b",
+		destinationFormat: avroFormat,
+		jobID:             "job_1",
+		runID:             "run-1",
+		location:          "europe-west1",
+	}
+	resp := bp.startedResponse()
+	assert.Equal(t, asyncStatusRunning, resp.Status)
+	assert.Equal(t, "job_1", resp.JobID)
+	assert.Equal(t, "europe-west1", resp.Location)
+
+	data, err := json.Marshal(resp)
+	assert.NoError(t, err)
+	assert.Contains(t, string(data), `"location":"europe-west1"`)
+
+	// The job ID and location of the response are what the status function is polled with.
+	var body statusBodyParams
+	assert.NoError(t, json.Unmarshal(data, &body))
+	assert.Equal(t, statusBodyParams{JobID: "job_1", Location: "europe-west1"}, body)
+}
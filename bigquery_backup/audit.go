@@ -0,0 +1,68 @@
+package bigquerybackup
+
+import (
+	"context"
+	"os"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/logging"
+)
+
+const (
+	auditOutcomeSuccess   = "success"
+	auditOutcomeFailure   = "failure"
+	auditOutcomeDuplicate = "duplicate"
+	auditOutcomeSkipped   = "skipped"
+	auditOutcomeStarted   = "started"
+)
+
+// auditEntry is the structured payload written to the audit log for every backup request.
+type auditEntry struct {
+	Caller        string    `json:"caller"`
+	ProjectID     string    `json:"project_id"`
+	DatasetName   string    `json:"dataset_name"`
+	TableName     string    `json:"table_name"`
+	StorageBucket string    `json:"storage_bucket"`
+	RunID         string    `json:"run_id"`
+	Outcome       string    `json:"outcome"`
+	Timestamp     time.Time `json:"timestamp"`
+}
+
+// auditLogName returns the log the audit trail is written to, taken from the AUDIT_LOG_NAME
+// environment variable. An empty name means auditing is turned off.
+func auditLogName() string {
+	return strings.TrimSpace(os.Getenv("AUDIT_LOG_NAME"))
+}
+
+// newAuditEntry builds the audit payload describing this backup and its outcome.
+func (bp *backupParams) newAuditEntry(outcome string) auditEntry {
+	return auditEntry{
+		Caller:        bp.caller,
+		ProjectID:     bp.sourceProjectID(),
+		DatasetName:   bp.sourceDatasetID,
+		TableName:     bp.backupTableID,
+		StorageBucket: bp.storageBucket,
+		RunID:         bp.runID,
+		Outcome:       outcome,
+		Timestamp:     time.Now().UTC(),
+	}
+}
+
+// logAudit writes a structured audit entry for this backup to the log named by AUDIT_LOG_NAME.
+// The entry is written synchronously and is independent of the operational logger, so it is
+// never dropped or filtered by verbosity settings, even when the request was cancelled. It does
+// nothing when AUDIT_LOG_NAME is unset.
+func (bp *backupParams) logAudit(ctx context.Context, outcome string) error {
+	name := auditLogName()
+	if name == "" {
+		return nil
+	}
+	entry := logging.Entry{Severity: logging.Notice, Payload: bp.newAuditEntry(outcome)}
+	b, err := bp.logger()
+	if err != nil {
+		logFallback(entry.Severity, entry.Payload, err)
+		return err
+	}
+	return b.LogSync(context.WithoutCancel(ctx), name, entry)
+}
@@ -0,0 +1,34 @@
+package bigquerybackup
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewAuditEntry(t *testing.T) {
+	bp := &backupParams{
+		projectID:       "test-project",
+		sourceDatasetID: "ds",
+		backupTableID:   "t",
+		storageBucket:   "bucket",
+		runID:           "run-1",
+		caller:          "scheduler@test-project.iam.gserviceaccount.com",
+	}
+
+	e := bp.newAuditEntry(auditOutcomeSuccess)
+	assert.Equal(t, "scheduler@test-project.iam.gserviceaccount.com", e.Caller)
+	assert.Equal(t, "ds", e.DatasetName)
+	assert.Equal(t, "t", e.TableName)
+	assert.Equal(t, "bucket", e.StorageBucket)
+	assert.Equal(t, "run-1", e.RunID)
+	assert.Equal(t, auditOutcomeSuccess, e.Outcome)
+	assert.False(t, e.Timestamp.IsZero())
+}
+
+func TestLogAuditDisabled(t *testing.T) {
+	t.Setenv("AUDIT_LOG_NAME", "")
+	bp := &backupParams{projectID: "test-project"}
+	assert.NoError(t, bp.logAudit(context.Background(), auditOutcomeFailure))
+}
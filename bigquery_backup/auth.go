@@ -0,0 +1,80 @@
+package bigquerybackup
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+)
+
+const authTokenEnv = "BACKUP_AUTH_TOKEN"
+
+func init() {
+	switch {
+	case authToken() == "" && len(allowedInvokers()) == 0:
+		log.Printf("WARNING: neither %s nor %s is set, requests are not authenticated", authTokenEnv, allowedInvokersEnv)
+	case len(allowedInvokers()) > 0 && oidcAudience() == "":
+		log.Printf("WARNING: %s is set without %s, requests with ID tokens will be rejected", allowedInvokersEnv, oidcAudienceEnv)
+	}
+}
+
+// authToken returns the bearer token requests must carry, read from BACKUP_AUTH_TOKEN, or ""
+// when requests are not authenticated.
+func authToken() string {
+	return strings.TrimSpace(os.Getenv(authTokenEnv))
+}
+
+// checkBearerToken checks the Authorization header of a request against want. The tokens are
+// hashed before the constant-time comparison, so neither their content nor their length can be
+// learned from how long the check takes.
+func checkBearerToken(header, want string) error {
+	scheme, token, ok := strings.Cut(strings.TrimSpace(header), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return errors.New("missing bearer token")
+	}
+	got := sha256.Sum256([]byte(strings.TrimSpace(token)))
+	expected := sha256.Sum256([]byte(want))
+	if subtle.ConstantTimeCompare(got[:], expected[:]) != 1 {
+		return errors.New("invalid bearer token")
+	}
+	return nil
+}
+
+// authorize authenticates the request when BACKUP_AUTH_TOKEN or ALLOWED_INVOKERS is set. The
+// bearer token must either be BACKUP_AUTH_TOKEN or a Google-signed ID token of a service account
+// in ALLOWED_INVOKERS, which is then recorded as the caller. A request that passes neither is
+// logged and answered with 401, or 403 for a valid ID token of another service account, and
+// authorize returns false. With neither variable set every request is authorized.
+func (bp *backupParams) authorize(w http.ResponseWriter, r *http.Request) bool {
+	want, invokers := authToken(), allowedInvokers()
+	if want == "" && len(invokers) == 0 {
+		return true
+	}
+	header := r.Header.Get("Authorization")
+	var err error
+	if want != "" {
+		if err = checkBearerToken(header, want); err == nil {
+			return true
+		}
+		err = withStatus(http.StatusUnauthorized, err)
+	}
+	if len(invokers) > 0 {
+		email, verr := verifyInvoker(r.Context(), header, invokers)
+		if verr == nil {
+			bp.caller = email
+			return true
+		}
+		err = verr
+	}
+	code := statusCode(err)
+	_ = bp.logWarning(fmt.Sprintf("Rejected request: %v", err))
+	if code == http.StatusUnauthorized {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="bigquery-backup"`)
+	}
+	writeError(w, code, err.Error())
+	return false
+}
@@ -0,0 +1,48 @@
+package bigquerybackup
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckBearerToken(t *testing.T) {
+	tests := []struct {
+		name    string
+		header  string
+		wantErr bool
+	}{
+		{name: "Matching token", header: "Bearer s3cret"},
+		{name: "Lowercase scheme", header: "bearer s3cret"},
+		{name: "Missing header", header: "", wantErr: true},
+		{name: "Other scheme", header: "Basic s3cret", wantErr: true},
+		{name: "Scheme only", header: "Bearer", wantErr: true},
+		{name: "Wrong token", header: "Bearer s3cre", wantErr: true},
+		{name: "Longer token", header: "Bearer s3cret2", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.wantErr, checkBearerToken(tt.header, "s3cret") != nil)
+		})
+	}
+}
+
+func TestAuthorize(t *testing.T) {
+	t.Setenv(authTokenEnv, "")
+	rec := httptest.NewRecorder()
+	assert.True(t, (&backupParams{}).authorize(rec, httptest.NewRequest(http.MethodPost, "/", nil)), "no token configured")
+
+	t.Setenv(authTokenEnv, "s3cret")
+	r := httptest.NewRequest(http.MethodPost, "/", nil)
+	r.Header.Set("Authorization", "Bearer s3cret")
+	assert.True(t, (&backupParams{}).authorize(httptest.NewRecorder(), r))
+
+	rec = httptest.NewRecorder()
+	assert.False(t, (&backupParams{}).authorize(rec, httptest.NewRequest(http.MethodPost, "/", nil)))
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	assert.NotEmpty(t, rec.Header().Get("WWW-Authenticate"))
+	assert.JSONEq(t, `{"error": "missing bearer token", "code": 401}`, rec.Body.String())
+}
@@ -0,0 +1,94 @@
+package bigquerybackup
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// defaultMaxBodyBytes bounds the size of a request body unless MAX_REQUEST_BODY_BYTES is set.
+// The largest legitimate bodies, such as a long tables list, are a few kilobytes.
+const defaultMaxBodyBytes = 1 << 20
+
+// errBodyTooLarge is returned for a request body larger than maxBodyBytes, which is answered
+// with 413 instead of 400.
+var errBodyTooLarge = errors.New("request body is too large")
+
+// maxBodyBytes returns the largest request body accepted, read from MAX_REQUEST_BODY_BYTES.
+func maxBodyBytes() int64 {
+	n, err := strconv.ParseInt(strings.TrimSpace(os.Getenv("MAX_REQUEST_BODY_BYTES")), 10, 64)
+	if err != nil || n <= 0 {
+		return defaultMaxBodyBytes
+	}
+	return n
+}
+
+// decodeRequestBody decodes the JSON body of r into v, reading at most maxBodyBytes of it. See
+// decodeJSON for the errors it returns.
+func decodeRequestBody(w http.ResponseWriter, r *http.Request, v interface{}) error {
+	limit := maxBodyBytes()
+	err := decodeJSON(http.MaxBytesReader(w, r.Body, limit), v)
+	if errors.Is(err, errBodyTooLarge) {
+		return fmt.Errorf("%w, the limit is %d bytes", errBodyTooLarge, limit)
+	}
+	return err
+}
+
+// decodeJSON decodes a single JSON object from r into v. Fields v does not know and data after
+// the object are rejected, so a misspelled option fails the request instead of being ignored.
+// The error is a short description of what is wrong rather than the decoder's own, which can
+// quote large parts of the body.
+func decodeJSON(r io.Reader, v interface{}) error {
+	dec := json.NewDecoder(r)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		return jsonBodyError(err)
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		var maxErr *http.MaxBytesError
+		if errors.As(err, &maxErr) {
+			return errBodyTooLarge
+		}
+		return errors.New("unexpected data after the JSON object")
+	}
+	return nil
+}
+
+// jsonBodyError turns an error of json.Decoder.Decode into the concise error decodeJSON returns.
+func jsonBodyError(err error) error {
+	var maxErr *http.MaxBytesError
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case errors.As(err, &maxErr):
+		return errBodyTooLarge
+	case errors.Is(err, io.EOF):
+		return errors.New("empty body, expected a JSON object")
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		return errors.New("malformed JSON, the body ends early")
+	case errors.As(err, &syntaxErr):
+		return fmt.Errorf("malformed JSON at byte %d", syntaxErr.Offset)
+	case errors.As(err, &typeErr):
+		if typeErr.Field == "" {
+			return fmt.Errorf("expected a JSON object, got %s", typeErr.Value)
+		}
+		return fmt.Errorf("invalid value for %s, got %s", typeErr.Field, typeErr.Value)
+	case strings.HasPrefix(err.Error(), "json: unknown field "):
+		return fmt.Errorf("unknown field %s", strings.TrimPrefix(err.Error(), "json: unknown field "))
+	}
+	return errors.New("malformed JSON")
+}
+
+// bodyErrorStatus returns the HTTP status to reject a body that decodeRequestBody could not
+// decode with: 413 when it is too large and 400 otherwise.
+func bodyErrorStatus(err error) int {
+	if errors.Is(err, errBodyTooLarge) {
+		return http.StatusRequestEntityTooLarge
+	}
+	return http.StatusBadRequest
+}
@@ -0,0 +1,64 @@
+package bigquerybackup
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDecodePostBodyLimits(t *testing.T) {
+	t.Setenv("MAX_REQUEST_BODY_BYTES", "256")
+
+	tests := []struct {
+		name       string
+		body       string
+		wantErr    string
+		wantStatus int
+	}{
+		{name: "Valid", body: `{"dataset_name": "ds", "table_name": "t", "storage_bucket": "backups"}`},
+		{name: "Trailing whitespace", body: "{\"dataset_name\": \"ds\"}\n"},
+		{name: "Oversized", body: `{"dataset_name": "` + strings.Repeat("a", 300) + `"}`, wantErr: "request body is too large, the limit is 256 bytes", wantStatus: http.StatusRequestEntityTooLarge},
+		{name: "Oversized after the object", body: `{"dataset_name": "ds"}` + strings.Repeat(" ", 300), wantErr: "request body is too large", wantStatus: http.StatusRequestEntityTooLarge},
+		{name: "Trailing junk", body: `{"dataset_name": "ds"} junk`, wantErr: "unexpected data after the JSON object", wantStatus: http.StatusBadRequest},
+		{name: "Second object", body: `{"dataset_name": "ds"}{"dataset_name": "other"}`, wantErr: "unexpected data after the JSON object", wantStatus: http.StatusBadRequest},
+		{name: "Unknown field", body: `{"dataset_name": "ds", "tabel_name": "t"}`, wantErr: `unknown field "tabel_name"`, wantStatus: http.StatusBadRequest},
+		{name: "Malformed", body: `{"dataset_name": ds}`, wantErr: "malformed JSON at byte", wantStatus: http.StatusBadRequest},
+		{name: "Truncated", body: `{"dataset_name": "ds"`, wantErr: "malformed JSON, the body ends early", wantStatus: http.StatusBadRequest},
+		{name: "Empty", body: "", wantErr: "empty body", wantStatus: http.StatusBadRequest},
+		{name: "Wrong type", body: `{"dataset_name": 42}`, wantErr: "invalid value for dataset_name, got number", wantStatus: http.StatusBadRequest},
+		{name: "Not an object", body: `["ds"]`, wantErr: "expected a JSON object, got array", wantStatus: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(tt.body))
+			pb, err := decodePostBody(httptest.NewRecorder(), r)
+			if tt.wantErr == "" {
+				assert.NoError(t, err)
+				assert.Equal(t, "ds", pb.DatasetName)
+				return
+			}
+			assert.ErrorContains(t, err, tt.wantErr)
+			assert.NotContains(t, err.Error(), "aaaa")
+			assert.Equal(t, tt.wantStatus, bodyErrorStatus(err))
+		})
+	}
+}
+
+func TestMaxBodyBytes(t *testing.T) {
+	t.Setenv("MAX_REQUEST_BODY_BYTES", "")
+	assert.Equal(t, int64(defaultMaxBodyBytes), maxBodyBytes())
+	t.Setenv("MAX_REQUEST_BODY_BYTES", "4096")
+	assert.Equal(t, int64(4096), maxBodyBytes())
+	t.Setenv("MAX_REQUEST_BODY_BYTES", "-1")
+	assert.Equal(t, int64(defaultMaxBodyBytes), maxBodyBytes())
+}
+
+func TestDecodeJSONRejectsUnknownFieldsInOtherBodies(t *testing.T) {
+	var body cleanupBodyParams
+	err := decodeJSON(strings.NewReader(`{"dataset_name": "ds", "retention_days": 30, "dry-run": true}`), &body)
+	assert.EqualError(t, err, `unknown field "dry-run"`)
+}
@@ -0,0 +1,103 @@
+package bigquerybackup
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+)
+
+// datasetBucketMapEnv names the environment variable holding the dataset-to-bucket mapping.
+// It is either a JSON object such as {"tenant_a_*": "tenant-a-backups"} or a gs:// URI of an
+// object containing that JSON.
+const datasetBucketMapEnv = "DATASET_BUCKET_MAP"
+
+// datasetBucketMapConfigured reports whether a dataset-to-bucket mapping is set.
+func datasetBucketMapConfigured() bool {
+	return strings.TrimSpace(os.Getenv(datasetBucketMapEnv)) != ""
+}
+
+// loadDatasetBucketMap reads the dataset-to-bucket mapping from DATASET_BUCKET_MAP, fetching it
+// from Cloud Storage when the variable holds a gs:// URI.
+func loadDatasetBucketMap(ctx context.Context) (map[string]string, error) {
+	raw := strings.TrimSpace(os.Getenv(datasetBucketMapEnv))
+	if strings.HasPrefix(raw, "gs://") {
+		bucket, object, ok := strings.Cut(strings.TrimPrefix(raw, "gs://"), "/")
+		if !ok || bucket == "" || object == "" {
+			return nil, fmt.Errorf("invalid %s URI %q", datasetBucketMapEnv, raw)
+		}
+		c, err := sharedStorageClient()
+		if err != nil {
+			return nil, err
+		}
+		ctx, cancel := withStorageTimeout(ctx)
+		defer cancel()
+		r, err := c.Bucket(bucket).Object(object).NewReader(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s from %s: %v", datasetBucketMapEnv, raw, err)
+		}
+		defer r.Close()
+		data, err := io.ReadAll(r)
+		if err != nil {
+			return nil, err
+		}
+		raw = string(data)
+	}
+
+	var m map[string]string
+	if err := json.Unmarshal([]byte(raw), &m); err != nil {
+		return nil, fmt.Errorf("invalid %s: %v", datasetBucketMapEnv, err)
+	}
+	for pattern := range m {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid %s pattern %q: %v", datasetBucketMapEnv, pattern, err)
+		}
+	}
+	return m, nil
+}
+
+// matchDatasetBucket returns the bucket mapped to dataset. An exact dataset name wins over any
+// glob pattern; among matching globs the longest pattern wins, so that "sales_eu_*" takes
+// precedence over "sales_*". It returns false when nothing matches.
+func matchDatasetBucket(m map[string]string, dataset string) (string, bool) {
+	if bucket, ok := m[dataset]; ok {
+		return bucket, true
+	}
+	best := ""
+	found := false
+	for pattern := range m {
+		if ok, _ := path.Match(pattern, dataset); !ok {
+			continue
+		}
+		if !found || len(pattern) > len(best) || (len(pattern) == len(best) && pattern < best) {
+			best = pattern
+			found = true
+		}
+	}
+	if !found {
+		return "", false
+	}
+	return m[best], true
+}
+
+// resolveStorageBucket fills in the storage bucket from DATASET_BUCKET_MAP when the request
+// did not name one. It fails when the dataset has no mapping, so backups never fall back to a
+// bucket that belongs to another tenant.
+func (bp *backupParams) resolveStorageBucket(ctx context.Context) error {
+	if bp.storageBucket != "" {
+		return nil
+	}
+	m, err := loadDatasetBucketMap(ctx)
+	if err != nil {
+		return err
+	}
+	bucket, ok := matchDatasetBucket(m, bp.sourceDatasetID)
+	if !ok || strings.TrimSpace(bucket) == "" {
+		return fmt.Errorf("no storage_bucket given and dataset %s has no mapping in %s", bp.sourceDatasetID, datasetBucketMapEnv)
+	}
+	bp.storageBucket = bucket
+	return bp.logInfo(fmt.Sprintf("Resolved storage bucket %s for dataset %s from %s", bucket, bp.sourceDatasetID, datasetBucketMapEnv))
+}
@@ -0,0 +1,63 @@
+package bigquerybackup
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMatchDatasetBucket(t *testing.T) {
+	m := map[string]string{
+		"sales_*":    "sales-backups",
+		"sales_eu_*": "sales-eu-backups",
+		"sales_eu_1": "sales-eu-1-backups",
+		"*":          "catch-all-backups",
+	}
+
+	tests := []struct {
+		dataset string
+		want    string
+	}{
+		{dataset: "sales_eu_1", want: "sales-eu-1-backups"},
+		{dataset: "sales_eu_2", want: "sales-eu-backups"},
+		{dataset: "sales_us", want: "sales-backups"},
+		{dataset: "marketing", want: "catch-all-backups"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.dataset, func(t *testing.T) {
+			got, ok := matchDatasetBucket(m, tt.dataset)
+			assert.True(t, ok)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+
+	_, ok := matchDatasetBucket(map[string]string{"sales_*": "sales-backups"}, "marketing")
+	assert.False(t, ok)
+}
+
+func TestLoadDatasetBucketMap(t *testing.T) {
+	t.Setenv(datasetBucketMapEnv, `{"tenant_a_*": "tenant-a-backups"}`)
+	m, err := loadDatasetBucketMap(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]string{"tenant_a_*": "tenant-a-backups"}, m)
+
+	t.Setenv(datasetBucketMapEnv, `{"tenant_[": "broken"}`)
+	_, err = loadDatasetBucketMap(context.Background())
+	assert.Error(t, err)
+
+	t.Setenv(datasetBucketMapEnv, `gs://only-a-bucket`)
+	_, err = loadDatasetBucketMap(context.Background())
+	assert.Error(t, err)
+}
+
+func TestResolveStorageBucket(t *testing.T) {
+	bp := &backupParams{sourceDatasetID: "marketing", storageBucket: "explicit"}
+	assert.NoError(t, bp.resolveStorageBucket(context.Background()))
+	assert.Equal(t, "explicit", bp.storageBucket)
+
+	t.Setenv(datasetBucketMapEnv, `{"sales_*": "sales-backups"}`)
+	bp = &backupParams{sourceDatasetID: "marketing"}
+	assert.Error(t, bp.resolveStorageBucket(context.Background()))
+	assert.Empty(t, bp.storageBucket)
+}
@@ -0,0 +1,46 @@
+package bigquerybackup
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/cloudresourcemanager/v1"
+)
+
+// projectNumber returns the number of a project given its ID or number. A number is returned as
+// is; an ID is looked up with Cloud Resource Manager, which needs resourcemanager.projects.get
+// on the project.
+func projectNumber(ctx context.Context, project string) (uint64, error) {
+	if n, err := strconv.ParseUint(project, 10, 64); err == nil {
+		return n, nil
+	}
+	svc, err := cloudresourcemanager.NewService(ctx)
+	if err != nil {
+		return 0, err
+	}
+	p, err := svc.Projects.Get(project).Context(ctx).Do()
+	if err != nil {
+		return 0, err
+	}
+	return uint64(p.ProjectNumber), nil
+}
+
+// checkBucketProject verifies that the storage bucket belongs to bucket_project. Bucket names
+// are global, so this keeps a mistyped bucket name from sending the backup to a bucket owned by
+// someone else. It does nothing when bucket_project is not set.
+func (bp *backupParams) checkBucketProject(ctx context.Context, attrs *storage.BucketAttrs) error {
+	if bp.bucketProject == "" {
+		return nil
+	}
+	want, err := projectNumber(ctx, bp.bucketProject)
+	if err != nil {
+		return fmt.Errorf("failed to look up bucket_project %s: %v", bp.bucketProject, err)
+	}
+	if attrs.ProjectNumber != want {
+		return withStatus(http.StatusBadRequest, fmt.Errorf("bucket %s belongs to project number %d, not bucket_project %s", bp.storageBucket, attrs.ProjectNumber, bp.bucketProject))
+	}
+	return nil
+}
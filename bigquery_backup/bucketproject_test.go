@@ -0,0 +1,36 @@
+package bigquerybackup
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"cloud.google.com/go/storage"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckBucketProject(t *testing.T) {
+	attrs := &storage.BucketAttrs{Name: "b", ProjectNumber: 123456789}
+	tests := []struct {
+		name          string
+		bucketProject string
+		wantCode      int
+	}{
+		{name: "Not set"},
+		{name: "Matching number", bucketProject: "123456789"},
+		{name: "Other project", bucketProject: "987654321", wantCode: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bp := &backupParams{storageBucket: "b", bucketProject: tt.bucketProject}
+			err := bp.checkBucketProject(context.Background(), attrs)
+			if tt.wantCode == 0 {
+				assert.NoError(t, err)
+				return
+			}
+			assert.Error(t, err)
+			assert.Equal(t, tt.wantCode, statusCode(err))
+		})
+	}
+}
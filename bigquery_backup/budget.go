@@ -0,0 +1,88 @@
+package bigquerybackup
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"cloud.google.com/go/bigquery"
+)
+
+// skipReasonBudgetExceeded is the reason of a table left out of a dataset or table list backup
+// because batch_max_bytes was used up.
+const skipReasonBudgetExceeded = "budget_exceeded"
+
+// checkBatchMaxBytes validates the batch_max_bytes field of the POST body, which only applies to
+// backups of a whole dataset or of listed tables.
+func checkBatchMaxBytes(pb *postBodyParams) error {
+	switch {
+	case pb.BatchMaxBytes < 0:
+		return errors.New("batch_max_bytes must not be negative")
+	case pb.BatchMaxBytes == 0:
+		return nil
+	case pb.Query != "" || (pb.Tables == nil && pb.TableName != "" && pb.TableName != allTables):
+		return errors.New("batch_max_bytes is only supported for dataset-wide backups and tables, use max_output_bytes for a single table")
+	}
+	return nil
+}
+
+// byteBudget is the share of batch_max_bytes left to the tables of one batch, which the workers
+// of runPool draw from as they start their tables. A nil budget is unlimited.
+type byteBudget struct {
+	mu        sync.Mutex
+	remaining int64
+	exhausted bool
+}
+
+// newByteBudget returns the budget of a batch capped at max bytes, or nil when max is 0.
+func newByteBudget(max int64) *byteBudget {
+	if max <= 0 {
+		return nil
+	}
+	return &byteBudget{remaining: max}
+}
+
+// reserve takes n bytes from the budget and reports whether they fit. Once a table does not fit,
+// the budget is exhausted and every later table is refused too, so the batch stops scheduling
+// tables rather than filling the rest of the cap with whichever tables are small enough.
+func (b *byteBudget) reserve(n int64) bool {
+	if b == nil {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.exhausted || n > b.remaining {
+		b.exhausted = true
+		return false
+	}
+	b.remaining -= n
+	return true
+}
+
+// reserveBudget takes the estimated output of the table md describes from the batch's
+// batch_max_bytes, estimated like max_output_bytes from the table's NumBytes. It reports false
+// with a logged note when the table does not fit and must be skipped.
+func (bp *backupParams) reserveBudget(md *bigquery.TableMetadata) (bool, error) {
+	if bp.budget == nil {
+		return true, nil
+	}
+	ratios, err := compressionRatios()
+	if err != nil {
+		return false, err
+	}
+	format, err := normalizeFormat(bp.destinationFormat)
+	if err != nil {
+		return false, err
+	}
+	compression := strings.ToUpper(strings.TrimSpace(bp.compressionType))
+	if compression == "" {
+		compression = envFormatDefaults.defaultCompression(format)
+	}
+	estimate := estimateOutputBytes(md.NumBytes, format, compression, ratios)
+	if bp.budget.reserve(estimate) {
+		return true, nil
+	}
+	_ = bp.logInfo(fmt.Sprintf("Skipping %s.%s, its estimated output of %d bytes exceeds what is left of batch_max_bytes %d", bp.sourceDatasetID, bp.backupTableID, estimate, bp.batchMaxBytes))
+	return false, nil
+}
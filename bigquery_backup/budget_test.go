@@ -0,0 +1,77 @@
+package bigquerybackup
+
+import (
+	"context"
+	"testing"
+
+	"cloud.google.com/go/bigquery"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckBatchMaxBytes(t *testing.T) {
+	tests := []struct {
+		name    string
+		pb      postBodyParams
+		wantErr bool
+	}{
+		{name: "Not set", pb: postBodyParams{TableName: "t"}},
+		{name: "Dataset", pb: postBodyParams{BatchMaxBytes: 1 << 30}},
+		{name: "All tables", pb: postBodyParams{TableName: allTables, BatchMaxBytes: 1 << 30}},
+		{name: "Listed tables", pb: postBodyParams{Tables: []string{"a", "b"}, BatchMaxBytes: 1 << 30}},
+		{name: "Negative", pb: postBodyParams{BatchMaxBytes: -1}, wantErr: true},
+		{name: "Single table", pb: postBodyParams{TableName: "t", BatchMaxBytes: 1 << 30}, wantErr: true},
+		{name: "Query", pb: postBodyParams{Query: "SELECT 1", BatchMaxBytes: 1 << 30}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkBatchMaxBytes(&tt.pb)
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestByteBudget(t *testing.T) {
+	var unlimited *byteBudget
+	assert.True(t, unlimited.reserve(1<<40))
+	assert.Nil(t, newByteBudget(0))
+
+	b := newByteBudget(100)
+	assert.True(t, b.reserve(60))
+	assert.True(t, b.reserve(40))
+	assert.False(t, b.reserve(1))
+	assert.False(t, b.reserve(0), "once exhausted, later tables are skipped too")
+
+	b = newByteBudget(100)
+	assert.True(t, b.reserve(30))
+	assert.False(t, b.reserve(80))
+	assert.False(t, b.reserve(10), "a table that would have fit is not scheduled after the cap was hit")
+}
+
+func TestBackupDatasetTableBudgetExceeded(t *testing.T) {
+	bp := &backupParams{
+		projectID:         "p",
+		sourceDatasetID:   "ds",
+		destinationFormat: csvFormat,
+		compressionType:   noneCompression,
+		batchMaxBytes:     1000,
+		budget:            newByteBudget(1000),
+		cache:             newMetadataCache(),
+	}
+	for id, size := range map[string]int64{"big": 2000, "small": 10} {
+		size := size
+		_, _ = bp.cache.table("p:ds."+id, func() (*bigquery.TableMetadata, error) {
+			return &bigquery.TableMetadata{Type: bigquery.RegularTable, NumBytes: size}, nil
+		})
+	}
+
+	result := bp.forTable("big").backupDatasetTable(context.Background())
+	assert.Equal(t, tableResult{Table: "big", Status: tableStatusSkipped, Reason: skipReasonBudgetExceeded}, result)
+	result = bp.forTable("small").backupDatasetTable(context.Background())
+	assert.Equal(t, tableStatusSkipped, result.Status)
+	assert.Equal(t, skipReasonBudgetExceeded, result.Reason)
+}
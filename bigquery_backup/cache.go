@@ -0,0 +1,120 @@
+package bigquerybackup
+
+import (
+	"context"
+	"os"
+	"strings"
+	"sync"
+
+	"cloud.google.com/go/bigquery"
+)
+
+// metadataCache holds the dataset and table metadata fetched during a single invocation.
+// Each entry is fetched once, even when several goroutines ask for it at the same time, so
+// tables that share a dataset reuse one Metadata call. Only successful fetches and 404s are
+// kept; any other failure may be transient, so the next caller fetches again. A new cache is
+// created for every request, which keeps entries from going stale across runs.
+type metadataCache struct {
+	mu       sync.Mutex
+	disabled bool
+	datasets map[string]*datasetCacheEntry
+	tables   map[string]*tableCacheEntry
+}
+
+type datasetCacheEntry struct {
+	mu   sync.Mutex
+	done bool
+	md   *bigquery.DatasetMetadata
+	err  error
+}
+
+type tableCacheEntry struct {
+	mu   sync.Mutex
+	done bool
+	md   *bigquery.TableMetadata
+	err  error
+}
+
+// cacheable reports whether the outcome of a metadata fetch may be cached: a success, or a 404,
+// which does not change within a request.
+func cacheable(err error) bool {
+	return err == nil || isNotFound(err)
+}
+
+// newMetadataCache returns an empty metadataCache. Setting the DISABLE_METADATA_CACHE
+// environment variable to "true" returns a cache that always fetches.
+func newMetadataCache() *metadataCache {
+	return &metadataCache{
+		disabled: strings.EqualFold(strings.TrimSpace(os.Getenv("DISABLE_METADATA_CACHE")), "true"),
+		datasets: make(map[string]*datasetCacheEntry),
+		tables:   make(map[string]*tableCacheEntry),
+	}
+}
+
+// dataset returns the metadata stored under key, calling fetch to populate it on first use.
+// A 404 is cached as well so that every table in a missing dataset fails the same way.
+func (c *metadataCache) dataset(key string, fetch func() (*bigquery.DatasetMetadata, error)) (*bigquery.DatasetMetadata, error) {
+	if c == nil || c.disabled {
+		return fetch()
+	}
+	c.mu.Lock()
+	e, ok := c.datasets[key]
+	if !ok {
+		e = &datasetCacheEntry{}
+		c.datasets[key] = e
+	}
+	c.mu.Unlock()
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.done {
+		return e.md, e.err
+	}
+	md, err := fetch()
+	if cacheable(err) {
+		e.md, e.err, e.done = md, err, true
+	}
+	return md, err
+}
+
+// table returns the metadata stored under key, calling fetch to populate it on first use.
+func (c *metadataCache) table(key string, fetch func() (*bigquery.TableMetadata, error)) (*bigquery.TableMetadata, error) {
+	if c == nil || c.disabled {
+		return fetch()
+	}
+	c.mu.Lock()
+	e, ok := c.tables[key]
+	if !ok {
+		e = &tableCacheEntry{}
+		c.tables[key] = e
+	}
+	c.mu.Unlock()
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.done {
+		return e.md, e.err
+	}
+	md, err := fetch()
+	if cacheable(err) {
+		e.md, e.err, e.done = md, err, true
+	}
+	return md, err
+}
+
+// datasetMetadata returns the metadata of the source dataset, using the per-run cache.
+func (bp *backupParams) datasetMetadata(ctx context.Context) (*bigquery.DatasetMetadata, error) {
+	key := bp.sourceProjectID() + ":" + bp.sourceDatasetID
+	return bp.cache.dataset(key, func() (*bigquery.DatasetMetadata, error) {
+		return bp.metadataClient().datasetMetadata(ctx, bp.sourceProjectID(), bp.sourceDatasetID)
+	})
+}
+
+// tableMetadata returns the metadata of the table the export reads, using the per-run cache. For
+// a query backup this is the query result table.
+func (bp *backupParams) tableMetadata(ctx context.Context) (*bigquery.TableMetadata, error) {
+	key := bp.sourceProjectID() + ":" + bp.sourceDatasetID + "." + bp.sourceTableID()
+	return bp.cache.table(key, func() (*bigquery.TableMetadata, error) {
+		return bp.metadataClient().tableMetadata(ctx, bp.sourceProjectID(), bp.sourceDatasetID, bp.sourceTableID())
+	})
+}
@@ -0,0 +1,100 @@
+package bigquerybackup
+
+import (
+	"errors"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"cloud.google.com/go/bigquery"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/api/googleapi"
+)
+
+func TestMetadataCacheFetchesDatasetOnce(t *testing.T) {
+	c := newMetadataCache()
+	var calls int32
+	fetch := func() (*bigquery.DatasetMetadata, error) {
+		atomic.AddInt32(&calls, 1)
+		return &bigquery.DatasetMetadata{Location: "US"}, nil
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			md, err := c.dataset("test-project:ds", fetch)
+			assert.NoError(t, err)
+			assert.Equal(t, "US", md.Location)
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+
+	_, _ = c.dataset("test-project:other", fetch)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&calls))
+}
+
+func TestMetadataCacheCachesNotFound(t *testing.T) {
+	c := newMetadataCache()
+	calls := 0
+	fetch := func() (*bigquery.TableMetadata, error) {
+		calls++
+		return nil, &googleapi.Error{Code: http.StatusNotFound, Message: "Not found: Table test-project:ds.t"}
+	}
+
+	_, err1 := c.table("test-project:ds.t", fetch)
+	_, err2 := c.table("test-project:ds.t", fetch)
+	assert.Error(t, err1)
+	assert.Equal(t, err1, err2)
+	assert.Equal(t, 1, calls)
+}
+
+func TestMetadataCacheRetriesTransientErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+	}{
+		{name: "Server error", err: &googleapi.Error{Code: http.StatusServiceUnavailable}},
+		{name: "Rate limited", err: &googleapi.Error{Code: http.StatusTooManyRequests}},
+		{name: "Network error", err: errors.New("connection reset by peer")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newMetadataCache()
+			calls := 0
+			fetch := func() (*bigquery.DatasetMetadata, error) {
+				calls++
+				if calls == 1 {
+					return nil, tt.err
+				}
+				return &bigquery.DatasetMetadata{Location: "US"}, nil
+			}
+
+			_, err := c.dataset("test-project:ds", fetch)
+			assert.Equal(t, tt.err, err)
+			md, err := c.dataset("test-project:ds", fetch)
+			assert.NoError(t, err)
+			assert.Equal(t, "US", md.Location)
+			_, _ = c.dataset("test-project:ds", fetch)
+			assert.Equal(t, 2, calls, "the success is cached")
+		})
+	}
+}
+
+func TestMetadataCacheDisabled(t *testing.T) {
+	t.Setenv("DISABLE_METADATA_CACHE", "true")
+	c := newMetadataCache()
+	calls := 0
+	fetch := func() (*bigquery.DatasetMetadata, error) {
+		calls++
+		return &bigquery.DatasetMetadata{}, nil
+	}
+
+	_, _ = c.dataset("test-project:ds", fetch)
+	_, _ = c.dataset("test-project:ds", fetch)
+	assert.Equal(t, 2, calls)
+}
@@ -0,0 +1,36 @@
+package bigquerybackup
+
+// exportFormat is the destination format and compression a backup was written with, echoed in
+// its response. RequestedCompression is the compression_type of the request as given, empty
+// when it named none and Compression is the default for Format.
+type exportFormat struct {
+	Format               string `json:"format"`
+	Compression          string `json:"compression"`
+	RequestedCompression string `json:"requested_compression"`
+}
+
+// capabilitiesResponse is the body answering GET /capabilities: the destination formats a
+// backup may use, with their default and allowed compression types as configured on this
+// instance.
+type capabilitiesResponse struct {
+	DefaultFormat string                        `json:"default_format"`
+	Formats       map[string]formatCapabilities `json:"formats"`
+}
+
+type formatCapabilities struct {
+	DefaultCompression  string   `json:"default_compression"`
+	AllowedCompressions []string `json:"allowed_compressions"`
+}
+
+// capabilities describes the formats and compression types of d, from the same lookups
+// setCompression validates requests against, so the two cannot disagree.
+func (d formatDefaults) capabilities() capabilitiesResponse {
+	resp := capabilitiesResponse{DefaultFormat: d.defaultFormat(), Formats: make(map[string]formatCapabilities)}
+	for format := range builtinFormatCompressions {
+		resp.Formats[format] = formatCapabilities{
+			DefaultCompression:  d.defaultCompression(format),
+			AllowedCompressions: d.compressions(format).allowed,
+		}
+	}
+	return resp
+}
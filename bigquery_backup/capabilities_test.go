@@ -0,0 +1,45 @@
+package bigquerybackup
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCapabilities(t *testing.T) {
+	formats, warnings := parseFormatCompressions(`{"PARQUET": {"default": "ZSTD", "allowed": ["SNAPPY", "ZSTD"]}}`)
+	assert.Empty(t, warnings)
+	d := formatDefaults{format: parquetFormat, compression: noneCompression, formats: formats}
+
+	got := d.capabilities()
+	assert.Equal(t, parquetFormat, got.DefaultFormat)
+	assert.Len(t, got.Formats, 4)
+	assert.Equal(t, formatCapabilities{DefaultCompression: zstdCompression, AllowedCompressions: []string{snappyCompression, zstdCompression}}, got.Formats[parquetFormat])
+	assert.Equal(t, noneCompression, got.Formats[csvFormat].DefaultCompression, "DEFAULT_COMPRESSION applies to every format allowing it")
+
+	// Every advertised pair is accepted by the request validation, and the default applies
+	// when a request names no compression.
+	for format, fc := range got.Formats {
+		for _, c := range fc.AllowedCompressions {
+			assert.True(t, d.compressionAllowed(format, c), format+"/"+c)
+		}
+		assert.Equal(t, d.defaultCompression(format), fc.DefaultCompression)
+	}
+}
+
+func TestServeCapabilities(t *testing.T) {
+	saved := envFormatDefaults
+	t.Cleanup(func() { envFormatDefaults = saved })
+	envFormatDefaults = formatDefaults{}
+
+	rec := httptest.NewRecorder()
+	bigQueryBackup(rec, httptest.NewRequest(http.MethodGet, "/capabilities", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var got capabilitiesResponse
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &got))
+	assert.Equal(t, avroFormat, got.DefaultFormat)
+	assert.Equal(t, formatCapabilities{DefaultCompression: gzipCompression, AllowedCompressions: []string{noneCompression, gzipCompression}}, got.Formats[csvFormat])
+}
@@ -0,0 +1,181 @@
+package bigquerybackup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/bigquery"
+	"google.golang.org/api/googleapi"
+)
+
+const catalogTableEnv = "BACKUP_CATALOG_TABLE"
+
+func init() {
+	if raw := strings.TrimSpace(os.Getenv(catalogTableEnv)); raw != "" {
+		if _, _, _, err := parseCatalogTable(raw, ""); err != nil {
+			log.Printf("WARNING: %v, backups will not be cataloged", err)
+		}
+	}
+}
+
+// catalogEntry is the row written to the backup catalog table once a table backup has finished,
+// whether it succeeded, failed or was skipped. Status follows notify_topic. RowCount is the
+// table's as of the start of the backup, and NULL for a query backup.
+type catalogEntry struct {
+	ProjectID      string             `bigquery:"project_id"`
+	DatasetName    string             `bigquery:"dataset_name"`
+	TableName      string             `bigquery:"table_name"`
+	DestinationURI string             `bigquery:"destination_uri"`
+	Format         string             `bigquery:"format"`
+	Compression    string             `bigquery:"compression"`
+	BytesExported  int64              `bigquery:"bytes_exported"`
+	RowCount       bigquery.NullInt64 `bigquery:"row_count"`
+	Status         string             `bigquery:"status"`
+	JobID          string             `bigquery:"job_id"`
+	RunID          string             `bigquery:"run_id"`
+	Error          string             `bigquery:"error"`
+	Timestamp      time.Time          `bigquery:"timestamp"`
+}
+
+// catalogSchema returns the schema of the catalog table, inferred from catalogEntry.
+func catalogSchema() (bigquery.Schema, error) {
+	return bigquery.InferSchema(catalogEntry{})
+}
+
+// catalogTablesReady records the catalog tables this instance found or created, as
+// "project:dataset.table", so the check runs once per table rather than once per backup.
+var catalogTablesReady sync.Map
+
+// parseCatalogTable splits a catalog table given as "dataset.table" or "project.dataset.table"
+// into its parts. A table without a project is in defaultProject.
+func parseCatalogTable(raw, defaultProject string) (project, dataset, table string, err error) {
+	parts := strings.Split(raw, ".")
+	valid := len(parts) == 2 || len(parts) == 3
+	for _, p := range parts {
+		valid = valid && p != ""
+	}
+	if !valid {
+		return "", "", "", fmt.Errorf("invalid catalog table %q, expected dataset.table or project.dataset.table", raw)
+	}
+	if len(parts) == 2 {
+		return defaultProject, parts[0], parts[1], nil
+	}
+	return parts[0], parts[1], parts[2], nil
+}
+
+// checkCatalogTable validates the catalog_table field of the POST body.
+func checkCatalogTable(pb *postBodyParams) error {
+	raw := strings.TrimSpace(pb.CatalogTable)
+	if raw == "" {
+		return nil
+	}
+	_, _, _, err := parseCatalogTable(raw, "")
+	return err
+}
+
+// catalogTableName returns the catalog table of the request: catalog_table, or
+// BACKUP_CATALOG_TABLE when the request names none. An empty name turns cataloging off.
+func catalogTableName(requested string) string {
+	if requested != "" {
+		return requested
+	}
+	return strings.TrimSpace(os.Getenv(catalogTableEnv))
+}
+
+// newCatalogEntry describes the outcome of the backup like newBackupNotification does.
+func (bp *backupParams) newCatalogEntry(ctx context.Context, skipped bool, backupErr error) catalogEntry {
+	n := bp.newBackupNotification(skipped, backupErr)
+	e := catalogEntry{
+		ProjectID:      n.ProjectID,
+		DatasetName:    n.DatasetName,
+		TableName:      n.TableName,
+		DestinationURI: n.DestinationURI,
+		Format:         bp.destinationFormat,
+		Compression:    bp.compressionType,
+		BytesExported:  n.BytesExported,
+		Status:         n.Status,
+		JobID:          n.JobID,
+		RunID:          n.RunID,
+		Error:          n.Error,
+		Timestamp:      n.Timestamp,
+	}
+	if !bp.isQueryBackup() {
+		if rows, _, err := bp.manifestSource(ctx); err == nil {
+			e.RowCount = bigquery.NullInt64{Int64: int64(rows), Valid: true}
+		}
+	}
+	return e
+}
+
+// writeCatalogEntry inserts the outcome of the backup into the catalog table when one was
+// requested or configured, creating the table first if it does not exist. Like the other
+// notifications it is best-effort: a failure is logged and never changes the outcome of the
+// backup.
+func (bp *backupParams) writeCatalogEntry(ctx context.Context, skipped bool, backupErr error) {
+	if bp.catalogTable == "" {
+		return
+	}
+	project, dataset, table, err := parseCatalogTable(bp.catalogTable, bp.projectID)
+	if err != nil {
+		_ = bp.logError(fmt.Sprintf("Failed to write catalog entry: %v", err))
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), triggerTimeout)
+	defer cancel()
+	t := bc.DatasetInProject(project, dataset).Table(table)
+	if err := bp.ensureCatalogTable(ctx, t); err != nil {
+		_ = bp.logError(fmt.Sprintf("Failed to prepare catalog table %s: %v", bp.catalogTable, err))
+		return
+	}
+	e := bp.newCatalogEntry(ctx, skipped, backupErr)
+	saver := &bigquery.StructSaver{Struct: e, InsertID: bp.runID + ":" + bp.sourceDatasetID + "." + bp.backupName()}
+	if err := t.Inserter().Put(ctx, saver); err != nil {
+		_ = bp.logError(fmt.Sprintf("Failed to write catalog entry to %s: %v", bp.catalogTable, err))
+		return
+	}
+	_ = bp.logInfo(fmt.Sprintf("Wrote %s catalog entry for backup of table %s.%s to %s", e.Status, bp.sourceDatasetID, bp.backupTableID, bp.catalogTable))
+}
+
+// ensureCatalogTable creates the catalog table with the catalog schema, partitioned by day on
+// its timestamp, unless it already exists. Its dataset must exist.
+func (bp *backupParams) ensureCatalogTable(ctx context.Context, t *bigquery.Table) error {
+	key := t.FullyQualifiedName()
+	if _, ok := catalogTablesReady.Load(key); ok {
+		return nil
+	}
+	_, err := t.Metadata(ctx)
+	if isNotFound(err) {
+		var schema bigquery.Schema
+		if schema, err = catalogSchema(); err != nil {
+			return err
+		}
+		err = t.Create(ctx, &bigquery.TableMetadata{
+			Schema:           schema,
+			TimePartitioning: &bigquery.TimePartitioning{Type: bigquery.DayPartitioningType, Field: "timestamp"},
+		})
+		if err == nil {
+			_ = bp.logInfo(fmt.Sprintf("Created catalog table %s", key))
+		} else if isAlreadyExists(err) {
+			err = nil
+		}
+	}
+	if err != nil {
+		return err
+	}
+	catalogTablesReady.Store(key, true)
+	return nil
+}
+
+// isAlreadyExists reports whether err is a 409 from a Google API, as returned when creating a
+// table that another request created first.
+func isAlreadyExists(err error) bool {
+	var gerr *googleapi.Error
+	return errors.As(err, &gerr) && gerr.Code == http.StatusConflict
+}
@@ -0,0 +1,100 @@
+package bigquerybackup
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/bigquery"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseCatalogTable(t *testing.T) {
+	tests := []struct {
+		name                         string
+		raw                          string
+		wantProject, wantDS, wantTbl string
+		wantErr                      bool
+	}{
+		{name: "Dataset and table", raw: "ops.backup_catalog", wantProject: "p", wantDS: "ops", wantTbl: "backup_catalog"},
+		{name: "Project, dataset and table", raw: "admin.ops.backup_catalog", wantProject: "admin", wantDS: "ops", wantTbl: "backup_catalog"},
+		{name: "Table only", raw: "backup_catalog", wantErr: true},
+		{name: "Empty part", raw: "ops..backup_catalog", wantErr: true},
+		{name: "Too many parts", raw: "a.b.c.d", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			project, ds, tbl, err := parseCatalogTable(tt.raw, "p")
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.wantProject, project)
+			assert.Equal(t, tt.wantDS, ds)
+			assert.Equal(t, tt.wantTbl, tbl)
+		})
+	}
+}
+
+func TestCatalogTableName(t *testing.T) {
+	t.Setenv(catalogTableEnv, "")
+	assert.Empty(t, catalogTableName(""))
+	assert.Equal(t, "ops.requested", catalogTableName("ops.requested"))
+	t.Setenv(catalogTableEnv, "ops.backup_catalog")
+	assert.Equal(t, "ops.backup_catalog", catalogTableName(""))
+	assert.Equal(t, "ops.requested", catalogTableName("ops.requested"))
+}
+
+func TestCatalogSchema(t *testing.T) {
+	schema, err := catalogSchema()
+	assert.NoError(t, err)
+	fields := map[string]bigquery.FieldType{}
+	for _, f := range schema {
+		fields[f.Name] = f.Type
+	}
+	assert.Equal(t, bigquery.StringFieldType, fields["destination_uri"])
+	assert.Equal(t, bigquery.IntegerFieldType, fields["bytes_exported"])
+	assert.Equal(t, bigquery.IntegerFieldType, fields["row_count"])
+	assert.Equal(t, bigquery.TimestampFieldType, fields["timestamp"])
+	assert.Len(t, schema, 13)
+}
+
+func TestNewCatalogEntry(t *testing.T) {
+	bp := &backupParams{
+		projectID:         "p",
+		sourceDatasetID:   "ds",
+		backupTableID:     "t",
+		storageBucket:     "backups",
+		destinationFormat: avroFormat,
+		compressionType:   snappyCompression,
+		backupTime:        time.Date(2024, 1, 15, 2, 0, 0, 0, time.UTC),
+		runID:             "0b6c-42",
+		jobID:             "job_1",
+		bytesExported:     2048,
+		cache:             newMetadataCache(),
+	}
+	_, _ = bp.cache.table("p:ds.t", func() (*bigquery.TableMetadata, error) {
+		return &bigquery.TableMetadata{NumRows: 1000}, nil
+	})
+
+	e := bp.newCatalogEntry(context.Background(), false, nil)
+	assert.Equal(t, notifyStatusSucceeded, e.Status)
+	assert.Equal(t, "gs://backups/ds/t.20240115T020000Z/t-*.avro", e.DestinationURI)
+	assert.Equal(t, avroFormat, e.Format)
+	assert.Equal(t, snappyCompression, e.Compression)
+	assert.Equal(t, int64(2048), e.BytesExported)
+	assert.Equal(t, bigquery.NullInt64{Int64: 1000, Valid: true}, e.RowCount)
+	assert.Equal(t, "job_1", e.JobID)
+
+	e = bp.newCatalogEntry(context.Background(), false, errors.New("boom"))
+	assert.Equal(t, notifyStatusFailed, e.Status)
+	assert.Equal(t, "boom", e.Error)
+
+	bp.query = "SELECT 1"
+	e = bp.newCatalogEntry(context.Background(), true, nil)
+	assert.Equal(t, notifyStatusSkipped, e.Status)
+	assert.False(t, e.RowCount.Valid, "a query backup has no row count")
+}
@@ -0,0 +1,299 @@
+package bigquerybackup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/GoogleCloudPlatform/functions-framework-go/functions"
+	"github.com/google/uuid"
+	"google.golang.org/api/iterator"
+)
+
+func init() {
+	functions.HTTP("BigQueryBackupCleanup", trackHTTP(bigQueryBackupCleanup))
+}
+
+// cleanupParams holds a request to delete expired backups of one dataset. It embeds
+// backupParams for the project, bucket and logging; backupTableID is empty to clean up every
+// table of the dataset.
+type cleanupParams struct {
+	*backupParams
+	retentionDays int
+	dryRun        bool
+}
+
+type cleanupBodyParams struct {
+	StorageBucket string `json:"storage_bucket"`
+	DatasetName   string `json:"dataset_name"`
+	TableName     string `json:"table_name"`
+	RetentionDays int    `json:"retention_days"`
+	DryRun        bool   `json:"dry_run"`
+}
+
+// cleanupResponse is the body returned once the expired backups have been deleted, or listed
+// for a dry run.
+type cleanupResponse struct {
+	Status         string   `json:"status"`
+	DryRun         bool     `json:"dry_run"`
+	ExpiredBackups []string `json:"expired_backups"`
+	DeletedObjects int      `json:"deleted_objects"`
+}
+
+// backupRun is one backup found in the bucket: its prefix, e.g.
+// "dataset/table.20060102T150405Z", the time it was taken and the objects under it.
+type backupRun struct {
+	prefix  string
+	table   string
+	time    time.Time
+	objects []string
+}
+
+// complete reports whether the backup finished, that is whether it wrote its manifest or its
+// _SUCCESS marker. A backup that failed or is still running has neither.
+func (r *backupRun) complete() bool {
+	for _, name := range r.objects {
+		if base := path.Base(name); base == manifestObjectName || base == successMarkerName {
+			return true
+		}
+	}
+	return false
+}
+
+// series returns what the backup is a copy of: its table, or table and partition for a
+// partition backup. Point-in-time backups belong to the series of their table, so a newer
+// backup of the table replaces them like any other.
+func (r *backupRun) series() string {
+	table, partition := splitBackupName(r.table)
+	if partition != "" {
+		return table + partitionDecorator + partition
+	}
+	return table
+}
+
+// bigQueryBackupCleanup is an HTTP function that deletes the backups of a dataset, or of one of
+// its tables, that are older than retention_days. The most recent complete backup of every
+// table is always kept, however old it is. With dry_run the expired backups are only reported.
+func bigQueryBackupCleanup(w http.ResponseWriter, r *http.Request) {
+	cp := cleanupParams{backupParams: &backupParams{runID: uuid.NewString(), cache: newMetadataCache()}}
+	if err := cp.setProjectID(); err != nil {
+		rejectMissingProject(w, err)
+		return
+	}
+	ctx, cancel := requestContext(r)
+	defer cancel()
+	defer func() {
+		_ = cp.flushLogs()
+	}()
+	if !cp.authorize(w, r) {
+		return
+	}
+
+	var body cleanupBodyParams
+	if err := decodeRequestBody(w, r, &body); err != nil {
+		_ = cp.logError(fmt.Sprintf("Failed to decode POST body: %v", err))
+		writeError(w, bodyErrorStatus(err), fmt.Sprintf("failed to decode POST body: %v", err))
+		return
+	}
+	if err := cp.setCleanupParams(body); err != nil {
+		_ = cp.logError(fmt.Sprintf("Invalid POST body: %v", err))
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid POST body: %v", err))
+		return
+	}
+	if err := cp.resolveStorageBucket(ctx); err != nil {
+		_ = cp.logError(fmt.Sprintf("Invalid POST body: %v", err))
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid POST body: %v", err))
+		return
+	}
+
+	resp, err := cp.cleanup(ctx, time.Now())
+	if err != nil {
+		_ = cp.logError(fmt.Sprintf("Problem cleaning up backups of dataset %s: %v", cp.sourceDatasetID, err))
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("problem cleaning up backups of dataset %s: %v", cp.sourceDatasetID, err))
+		return
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// setCleanupParams validates the POST body and copies it into the cleanup parameters.
+// storage_bucket may be omitted when DATASET_BUCKET_MAP maps the dataset to a bucket.
+func (cp *cleanupParams) setCleanupParams(body cleanupBodyParams) error {
+	switch {
+	case strings.TrimSpace(body.DatasetName) == "":
+		return errors.New("missing dataset_name")
+	case body.RetentionDays <= 0:
+		return fmt.Errorf("invalid retention_days %d, must be a positive number of days", body.RetentionDays)
+	}
+	cp.storageBucket = strings.TrimSpace(body.StorageBucket)
+	if cp.storageBucket != "" {
+		if err := validateBucketName(cp.storageBucket); err != nil {
+			return err
+		}
+	}
+	cp.sourceDatasetID = strings.TrimSpace(body.DatasetName)
+	cp.backupTableID = strings.TrimSpace(body.TableName)
+	if cp.backupTableID == allTables {
+		cp.backupTableID = ""
+	}
+	cp.retentionDays = body.RetentionDays
+	cp.dryRun = body.DryRun
+	return nil
+}
+
+// cleanupPrefix returns the object prefix listed for backups: the dataset folder, narrowed to
+// one table's backups when a table was given. The prefix also matches other tables whose names
+// start with the table name; cleanupTable filters them out.
+func (cp *cleanupParams) cleanupPrefix() string {
+	return cp.sourceDatasetID + "/" + cp.backupTableID
+}
+
+// cleanupTable reports whether backups written under name, see backupOfTable, are cleaned up.
+func (cp *cleanupParams) cleanupTable(name string) bool {
+	return backupOfTable(name, cp.backupTableID)
+}
+
+// backupOfTable reports whether backups written under name, a table name, a partition such as
+// "table$20240115" or a point-in-time backup such as "table@20240115T080000Z", belong to table.
+// Every name belongs to an empty table.
+func backupOfTable(name, table string) bool {
+	if table == "" {
+		return true
+	}
+	t, _ := splitBackupName(name)
+	return t == table
+}
+
+// parseBackupPrefix splits an object name written by a backup, e.g.
+// "dataset/table.20060102T150405Z/table-000000000000.avro", into its backup prefix, table and
+// backup time. It returns false for objects that were not written by a backup.
+func parseBackupPrefix(name string) (prefix, table string, t time.Time, ok bool) {
+	parts := strings.SplitN(name, "/", 3)
+	if len(parts) < 3 {
+		return "", "", time.Time{}, false
+	}
+	i := strings.LastIndex(parts[1], ".")
+	if i <= 0 {
+		return "", "", time.Time{}, false
+	}
+	t, err := time.Parse(backupTimeFormat, parts[1][i+1:])
+	if err != nil {
+		return "", "", time.Time{}, false
+	}
+	return parts[0] + "/" + parts[1], parts[1][:i], t, true
+}
+
+// groupBackups collects object names into the backups they belong to, ignoring objects whose
+// names do not follow the backup layout.
+func groupBackups(names []string) []*backupRun {
+	runs := make(map[string]*backupRun)
+	var ordered []*backupRun
+	for _, name := range names {
+		prefix, table, t, ok := parseBackupPrefix(name)
+		if !ok {
+			continue
+		}
+		run, found := runs[prefix]
+		if !found {
+			run = &backupRun{prefix: prefix, table: table, time: t}
+			runs[prefix] = run
+			ordered = append(ordered, run)
+		}
+		run.objects = append(run.objects, name)
+	}
+	return ordered
+}
+
+// expiredBackups returns the backups taken before cutoff, oldest first. The most recent
+// complete backup of each table, and of each backed up partition, is never returned, so a table
+// that stopped being backed up, or whose later backups failed, keeps its last usable copy. A
+// table without any complete backup keeps its most recent one.
+func expiredBackups(runs []*backupRun, cutoff time.Time) []*backupRun {
+	kept := make(map[string]*backupRun)
+	for _, run := range runs {
+		k, ok := kept[run.series()]
+		if !ok || (run.complete() && !k.complete()) || (run.complete() == k.complete() && run.time.After(k.time)) {
+			kept[run.series()] = run
+		}
+	}
+	var expired []*backupRun
+	for _, run := range runs {
+		if run.time.Before(cutoff) && run != kept[run.series()] {
+			expired = append(expired, run)
+		}
+	}
+	sort.Slice(expired, func(i, j int) bool {
+		if !expired[i].time.Equal(expired[j].time) {
+			return expired[i].time.Before(expired[j].time)
+		}
+		return expired[i].prefix < expired[j].prefix
+	})
+	return expired
+}
+
+// cleanup lists the backups under the cleanup prefix and deletes those older than the
+// retention window at now, logging every deleted object. For a dry run nothing is deleted.
+func (cp *cleanupParams) cleanup(ctx context.Context, now time.Time) (cleanupResponse, error) {
+	c, err := sharedStorageClient()
+	if err != nil {
+		return cleanupResponse{}, err
+	}
+
+	names, err := cp.listCleanupObjects(ctx, c)
+	if err != nil {
+		return cleanupResponse{}, fmt.Errorf("failed to list backups: %v", err)
+	}
+	cutoff := now.UTC().AddDate(0, 0, -cp.retentionDays)
+	var runs []*backupRun
+	for _, run := range groupBackups(names) {
+		if cp.cleanupTable(run.table) {
+			runs = append(runs, run)
+		}
+	}
+	expired := expiredBackups(runs, cutoff)
+
+	resp := cleanupResponse{Status: "ok", DryRun: cp.dryRun, ExpiredBackups: []string{}}
+	bucket := c.Bucket(cp.storageBucket)
+	for _, run := range expired {
+		resp.ExpiredBackups = append(resp.ExpiredBackups, run.prefix)
+		if cp.dryRun {
+			_ = cp.logInfo(fmt.Sprintf("Dry run, would delete backup gs://%s/%s taken at %s (%d objects)", cp.storageBucket, run.prefix, run.time.Format(time.RFC3339), len(run.objects)))
+			continue
+		}
+		for _, name := range run.objects {
+			opCtx, cancel := withStorageTimeout(ctx)
+			err := bucket.Object(name).Delete(opCtx)
+			cancel()
+			if err != nil && err != storage.ErrObjectNotExist {
+				return resp, fmt.Errorf("failed to delete gs://%s/%s: %v", cp.storageBucket, name, err)
+			}
+			resp.DeletedObjects++
+			_ = cp.logInfo(fmt.Sprintf("Deleted gs://%s/%s from backup taken at %s", cp.storageBucket, name, run.time.Format(time.RFC3339)))
+		}
+	}
+	_ = cp.logInfo(fmt.Sprintf("Cleanup of dataset %s found %d backups older than %d days, deleted %d objects", cp.sourceDatasetID, len(expired), cp.retentionDays, resp.DeletedObjects))
+	return resp, nil
+}
+
+// listCleanupObjects returns the names of all objects under the cleanup prefix.
+func (cp *cleanupParams) listCleanupObjects(ctx context.Context, c *storage.Client) ([]string, error) {
+	ctx, cancel := withStorageTimeout(ctx)
+	defer cancel()
+	var names []string
+	it := c.Bucket(cp.storageBucket).Objects(ctx, &storage.Query{Prefix: cp.cleanupPrefix()})
+	for {
+		attrs, err := it.Next()
+		if err == iterator.Done {
+			return names, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		names = append(names, attrs.Name)
+	}
+}
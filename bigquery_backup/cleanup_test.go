@@ -0,0 +1,150 @@
+package bigquerybackup
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSetCleanupParams(t *testing.T) {
+	tests := []struct {
+		name      string
+		body      cleanupBodyParams
+		wantErr   bool
+		wantTable string
+	}{
+		{name: "Dataset", body: cleanupBodyParams{StorageBucket: "backups", DatasetName: "ds", RetentionDays: 30}},
+		{name: "Table", body: cleanupBodyParams{StorageBucket: "backups", DatasetName: "ds", TableName: "t", RetentionDays: 30}, wantTable: "t"},
+		{name: "All tables", body: cleanupBodyParams{DatasetName: "ds", TableName: allTables, RetentionDays: 7}},
+		{name: "Missing dataset", body: cleanupBodyParams{StorageBucket: "backups", RetentionDays: 30}, wantErr: true},
+		{name: "Missing retention", body: cleanupBodyParams{StorageBucket: "backups", DatasetName: "ds"}, wantErr: true},
+		{name: "Negative retention", body: cleanupBodyParams{StorageBucket: "backups", DatasetName: "ds", RetentionDays: -1}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cp := &cleanupParams{backupParams: &backupParams{}}
+			err := cp.setCleanupParams(tt.body)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.wantTable, cp.backupTableID)
+			assert.Equal(t, tt.body.RetentionDays, cp.retentionDays)
+		})
+	}
+}
+
+func TestCleanupPrefix(t *testing.T) {
+	cp := &cleanupParams{backupParams: &backupParams{sourceDatasetID: "ds"}}
+	assert.Equal(t, "ds/", cp.cleanupPrefix())
+
+	assert.True(t, cp.cleanupTable("t2"))
+
+	cp.backupTableID = "t"
+	assert.Equal(t, "ds/t", cp.cleanupPrefix())
+	assert.True(t, cp.cleanupTable("t"))
+	assert.True(t, cp.cleanupTable("t$20240115"))
+	assert.False(t, cp.cleanupTable("t2"))
+}
+
+func TestParseBackupPrefix(t *testing.T) {
+	prefix, table, ts, ok := parseBackupPrefix("ds/t.20240115T100000Z/t-000000000000.avro")
+	assert.True(t, ok)
+	assert.Equal(t, "ds/t.20240115T100000Z", prefix)
+	assert.Equal(t, "t", table)
+	assert.Equal(t, time.Date(2024, 1, 15, 10, 0, 0, 0, time.UTC), ts)
+
+	for _, name := range []string{
+		"ds/t.20240115T100000Z",
+		"ds/t/t-000000000000.avro",
+		"ds/t.latest/t-000000000000.avro",
+		"ds/.20240115T100000Z/t-000000000000.avro",
+	} {
+		_, _, _, ok := parseBackupPrefix(name)
+		assert.False(t, ok, name)
+	}
+}
+
+func TestExpiredBackups(t *testing.T) {
+	runs := groupBackups([]string{
+		"ds/a.20240101T000000Z/a-000000000000.avro",
+		"ds/a.20240101T000000Z/manifest.json",
+		"ds/a.20240201T000000Z/a-000000000000.avro",
+		"ds/a.20240201T000000Z/manifest.json",
+		"ds/a.20240301T000000Z/a-000000000000.avro",
+		"ds/a.20240301T000000Z/_SUCCESS",
+		"ds/b.20231201T000000Z/b-000000000000.avro",
+		"ds/notes.txt",
+	})
+	assert.Len(t, runs, 4)
+
+	cutoff := time.Date(2024, 2, 15, 0, 0, 0, 0, time.UTC)
+	expired := expiredBackups(runs, cutoff)
+	var prefixes []string
+	for _, run := range expired {
+		prefixes = append(prefixes, run.prefix)
+	}
+	// b's only backup is past retention but is the most recent one, so it is kept.
+	assert.Equal(t, []string{"ds/a.20240101T000000Z", "ds/a.20240201T000000Z"}, prefixes)
+	assert.Len(t, expired[0].objects, 2)
+
+	assert.Empty(t, expiredBackups(runs, time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)))
+}
+
+func TestExpiredBackupsKeepsLatestComplete(t *testing.T) {
+	runs := groupBackups([]string{
+		"ds/a.20240101T000000Z/a-000000000000.avro",
+		"ds/a.20240101T000000Z/manifest.json",
+		"ds/a.20240201T000000Z/a-000000000000.avro",
+		"ds/a.20240201T000000Z/manifest.json",
+		"ds/a.20240301T000000Z/a-000000000000.avro",
+	})
+
+	cutoff := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	var prefixes []string
+	for _, run := range expiredBackups(runs, cutoff) {
+		prefixes = append(prefixes, run.prefix)
+	}
+	// The newest backup never finished, so the newest complete one is kept instead.
+	assert.Equal(t, []string{"ds/a.20240101T000000Z", "ds/a.20240301T000000Z"}, prefixes)
+}
+
+func TestExpiredBackupsPointInTime(t *testing.T) {
+	runs := groupBackups([]string{
+		"ds/a@20231215T000000Z.20240101T000000Z/a-000000000000.avro",
+		"ds/a@20231215T000000Z.20240101T000000Z/manifest.json",
+		"ds/a@20240115T000000Z.20240201T000000Z/a-000000000000.avro",
+		"ds/a@20240115T000000Z.20240201T000000Z/manifest.json",
+		"ds/a.20240301T000000Z/a-000000000000.avro",
+		"ds/a.20240301T000000Z/manifest.json",
+		"ds/a$20240101.20240101T000000Z/a-000000000000.avro",
+		"ds/a$20240101.20240101T000000Z/manifest.json",
+	})
+
+	cutoff := time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC)
+	var prefixes []string
+	for _, run := range expiredBackups(runs, cutoff) {
+		prefixes = append(prefixes, run.prefix)
+	}
+	// Point-in-time backups are copies of the table and are replaced by its newer backups, while
+	// the partition keeps its own latest backup.
+	assert.Equal(t, []string{"ds/a@20231215T000000Z.20240101T000000Z", "ds/a@20240115T000000Z.20240201T000000Z"}, prefixes)
+}
+
+func TestBackupOfTable(t *testing.T) {
+	assert.True(t, backupOfTable("orders", "orders"))
+	assert.True(t, backupOfTable("orders$20240115", "orders"))
+	assert.True(t, backupOfTable("orders@20240115T080000Z", "orders"))
+	assert.True(t, backupOfTable("orders_archive", ""))
+	assert.False(t, backupOfTable("orders_archive", "orders"))
+	assert.False(t, backupOfTable("orders_archive@20240115T080000Z", "orders"))
+}
+
+func TestBackupRunComplete(t *testing.T) {
+	assert.True(t, (&backupRun{objects: []string{"ds/t.20240101T000000Z/t-000000000000.avro", "ds/t.20240101T000000Z/manifest.json"}}).complete())
+	assert.True(t, (&backupRun{objects: []string{"ds/t.20240101T000000Z/_SUCCESS"}}).complete())
+	assert.False(t, (&backupRun{objects: []string{"ds/t.20240101T000000Z/t-000000000000.avro"}}).complete())
+}
@@ -0,0 +1,283 @@
+package bigquerybackup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/bigquery"
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/iterator"
+)
+
+// datasetMetadataGetter fetches the dataset and table metadata the backup is validated against.
+type datasetMetadataGetter interface {
+	datasetMetadata(ctx context.Context, projectID, datasetID string) (*bigquery.DatasetMetadata, error)
+	tableMetadata(ctx context.Context, projectID, datasetID, tableID string) (*bigquery.TableMetadata, error)
+}
+
+// tableExtractor starts the extract job configured by an Extractor.
+type tableExtractor interface {
+	runExtract(ctx context.Context, extractor *bigquery.Extractor) (jobWaiter, error)
+}
+
+// extractJobGetter looks up an earlier extract job by its ID.
+type extractJobGetter interface {
+	extractJob(ctx context.Context, jobID, location string) (*extractJobInfo, error)
+}
+
+// extractJobInfo is the state of an earlier extract job: whether it is done, its error once it
+// failed, and the URIs it exported to.
+type extractJobInfo struct {
+	done            bool
+	err             error
+	destinationURIs []string
+}
+
+// objectLister lists the objects of a storage bucket.
+type objectLister interface {
+	listObjectNames(ctx context.Context, bucket, prefix string, limit int) ([]string, error)
+}
+
+// jobWaiter is a started BigQuery job. Wait blocks until the job is done. When waiting fails it
+// returns a nil status; when the job itself failed it returns the final status together with
+// the job's error. Cancel asks BigQuery to stop the job without waiting for it to stop.
+type jobWaiter interface {
+	ID() string
+	Wait(ctx context.Context) (*bigquery.JobStatus, error)
+	Cancel(ctx context.Context) error
+}
+
+// bigQueryJob adapts *bigquery.Job to jobWaiter, whose Wait reports a failed job as an error
+// rather than through JobStatus.Err, which cannot be set outside the bigquery package. It polls
+// every JOB_POLL_INTERVAL_SECONDS when that is set.
+type bigQueryJob struct {
+	*bigquery.Job
+}
+
+func (j bigQueryJob) Wait(ctx context.Context) (*bigquery.JobStatus, error) {
+	if interval := jobPollInterval(); interval > 0 {
+		return pollJob(ctx, j.Job, interval)
+	}
+	status, err := j.Job.Wait(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return status, status.Err()
+}
+
+// bigQueryClient implements the client interfaces with the shared BigQuery client. It is used
+// unless backupParams was given other implementations, as the tests do.
+type bigQueryClient struct{}
+
+func (bigQueryClient) datasetMetadata(ctx context.Context, projectID, datasetID string) (*bigquery.DatasetMetadata, error) {
+	return bc.DatasetInProject(projectID, datasetID).Metadata(ctx)
+}
+
+func (bigQueryClient) tableMetadata(ctx context.Context, projectID, datasetID, tableID string) (*bigquery.TableMetadata, error) {
+	return bc.DatasetInProject(projectID, datasetID).Table(tableID).Metadata(ctx)
+}
+
+func (bigQueryClient) runExtract(ctx context.Context, extractor *bigquery.Extractor) (jobWaiter, error) {
+	job, err := extractor.Run(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return bigQueryJob{job}, nil
+}
+
+func (bigQueryClient) extractJob(ctx context.Context, jobID, location string) (*extractJobInfo, error) {
+	job, err := lookupJob(ctx, jobID, location)
+	if err != nil {
+		return nil, err
+	}
+	info := &extractJobInfo{}
+	if status := job.LastStatus(); status != nil {
+		info.done = status.Done()
+		info.err = status.Err()
+	}
+	config, err := job.Config()
+	if err != nil {
+		return nil, err
+	}
+	if extract, ok := config.(*bigquery.ExtractConfig); ok && extract.Dst != nil {
+		info.destinationURIs = extract.Dst.URIs
+	}
+	return info, nil
+}
+
+// storageClient implements objectLister with the shared Cloud Storage client. It is used
+// unless backupParams was given another implementation.
+type storageClient struct{}
+
+// listObjectNames returns the names of up to limit objects under prefix.
+func (storageClient) listObjectNames(ctx context.Context, bucket, prefix string, limit int) ([]string, error) {
+	c, err := sharedStorageClient()
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := withStorageTimeout(ctx)
+	defer cancel()
+	var names []string
+	it := c.Bucket(bucket).Objects(ctx, &storage.Query{Prefix: prefix})
+	for len(names) < limit {
+		attrs, err := it.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		names = append(names, attrs.Name)
+	}
+	return names, nil
+}
+
+// warmUpClients creates the shared BigQuery, storage and logging clients concurrently, so a cold
+// instance waits for the slowest of them rather than for all three in turn. Each client keeps
+// its own sync.Once or mutex, so warm instances return at once and a request needing a client
+// while another is still creating it waits for that one. A logging client that cannot be created
+// is not an error, since entries then fall back to standard error.
+func (bp *backupParams) warmUpClients(ctx context.Context) error {
+	var wg sync.WaitGroup
+	var bqErr, storageErr error
+	wg.Add(3)
+	go func() {
+		defer wg.Done()
+		bqErr = bp.setBigQueryClient(ctx)
+	}()
+	go func() {
+		defer wg.Done()
+		if _, err := sharedStorageClient(); err != nil {
+			storageErr = fmt.Errorf("failed to create storage client: %v", err)
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		_, _ = bp.logger()
+	}()
+	wg.Wait()
+	return errors.Join(bqErr, storageErr)
+}
+
+// metadataClient returns the datasetMetadataGetter the backup uses.
+func (bp *backupParams) metadataClient() datasetMetadataGetter {
+	if bp.metadata != nil {
+		return bp.metadata
+	}
+	return bigQueryClient{}
+}
+
+// extractClient returns the tableExtractor the backup uses.
+func (bp *backupParams) extractClient() tableExtractor {
+	if bp.extractor != nil {
+		return bp.extractor
+	}
+	return bigQueryClient{}
+}
+
+// jobClient returns the extractJobGetter the backup uses.
+func (bp *backupParams) jobClient() extractJobGetter {
+	if bp.jobs != nil {
+		return bp.jobs
+	}
+	return bigQueryClient{}
+}
+
+// objectClient returns the objectLister the backup uses.
+func (bp *backupParams) objectClient() objectLister {
+	if bp.objects != nil {
+		return bp.objects
+	}
+	return storageClient{}
+}
+
+// bucketProbeTTL is how long a bucket found writable by checkBucketWritable is trusted before it
+// is probed again.
+const bucketProbeTTL = 15 * time.Minute
+
+// defaultBucketProbeRetries is how often a failed write probe is retried when
+// BUCKET_PROBE_RETRIES is not set.
+const defaultBucketProbeRetries = 2
+
+// bucketProbes caches, like the shared clients, for the lifetime of the instance when each
+// bucket was last found writable, so a cold instance probes a bucket once and later requests
+// skip the probe. Only successful probes are cached.
+var bucketProbes = struct {
+	sync.Mutex
+	writable map[string]time.Time
+}{writable: make(map[string]time.Time)}
+
+// testBucketPermissions returns which of permissions the function's service account holds on
+// bucket. Tests replace it.
+var testBucketPermissions = func(ctx context.Context, bucket string, permissions []string) ([]string, error) {
+	c, err := sharedStorageClient()
+	if err != nil {
+		return nil, err
+	}
+	return c.Bucket(bucket).IAM().TestPermissions(ctx, permissions)
+}
+
+// bucketProbeRetries returns how often a failed write probe is retried, read from the
+// BUCKET_PROBE_RETRIES environment variable.
+func bucketProbeRetries() int {
+	n, err := strconv.Atoi(strings.TrimSpace(os.Getenv("BUCKET_PROBE_RETRIES")))
+	if err != nil || n < 0 {
+		return defaultBucketProbeRetries
+	}
+	return n
+}
+
+// checkBucketWritable checks with TestIamPermissions that the function's service account may
+// create objects in bucket, so a missing IAM binding is reported before the extract runs rather
+// than as a failed export. A probe that fails is retried BUCKET_PROBE_RETRIES times; a bucket
+// that is not writable is reported with 403. A bucket found writable within bucketProbeTTL is not
+// probed again.
+func checkBucketWritable(ctx context.Context, bucket string) error {
+	bucketProbes.Lock()
+	probed, ok := bucketProbes.writable[bucket]
+	bucketProbes.Unlock()
+	if ok && time.Since(probed) < bucketProbeTTL {
+		return nil
+	}
+
+	var granted []string
+	var err error
+	for attempt := 0; attempt <= bucketProbeRetries(); attempt++ {
+		if attempt > 0 {
+			select {
+			case <-ctx.Done():
+				return fmt.Errorf("failed to check permissions on bucket %s: %v", bucket, err)
+			case <-time.After(time.Duration(attempt) * 100 * time.Millisecond):
+			}
+		}
+		if granted, err = testBucketPermissions(ctx, bucket, requiredBucketPermissions); err == nil {
+			break
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("failed to check permissions on bucket %s: %v", bucket, err)
+	}
+	if missing := missingPermissions(requiredBucketPermissions, granted); len(missing) > 0 {
+		return withStatus(http.StatusForbidden, fmt.Errorf("service account lacks write access to bucket %s, missing %s", bucket, strings.Join(missing, ", ")))
+	}
+	bucketProbes.Lock()
+	bucketProbes.writable[bucket] = time.Now()
+	bucketProbes.Unlock()
+	return nil
+}
+
+// forgetBucketWritable drops the cached probe of bucket after a write to it failed, so the next
+// request probes it again.
+func forgetBucketWritable(bucket string) {
+	bucketProbes.Lock()
+	delete(bucketProbes.writable, bucket)
+	bucketProbes.Unlock()
+}
@@ -0,0 +1,307 @@
+package bigquerybackup
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/bigquery"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/api/googleapi"
+)
+
+// fakeBigQuery implements the client interfaces without network access.
+type fakeBigQuery struct {
+	datasets  map[string]*bigquery.DatasetMetadata
+	tables    map[string]*bigquery.TableMetadata
+	tableErrs map[string]error
+	job       *fakeJob
+	runErr    error
+	extracted *bigquery.Extractor
+	jobs      map[string]*extractJobInfo
+}
+
+func (f *fakeBigQuery) datasetMetadata(ctx context.Context, projectID, datasetID string) (*bigquery.DatasetMetadata, error) {
+	if md, ok := f.datasets[projectID+":"+datasetID]; ok {
+		return md, nil
+	}
+	return nil, errors.New("dataset not found")
+}
+
+func (f *fakeBigQuery) tableMetadata(ctx context.Context, projectID, datasetID, tableID string) (*bigquery.TableMetadata, error) {
+	if err, ok := f.tableErrs[projectID+":"+datasetID+"."+tableID]; ok {
+		return nil, err
+	}
+	if md, ok := f.tables[projectID+":"+datasetID+"."+tableID]; ok {
+		return md, nil
+	}
+	return nil, errors.New("table not found")
+}
+
+func (f *fakeBigQuery) runExtract(ctx context.Context, extractor *bigquery.Extractor) (jobWaiter, error) {
+	f.extracted = extractor
+	if f.runErr != nil {
+		return nil, f.runErr
+	}
+	return f.job, nil
+}
+
+func (f *fakeBigQuery) extractJob(ctx context.Context, jobID, location string) (*extractJobInfo, error) {
+	if info, ok := f.jobs[jobID]; ok {
+		return info, nil
+	}
+	return nil, &googleapi.Error{Code: http.StatusNotFound}
+}
+
+type fakeJob struct {
+	id     string
+	status *bigquery.JobStatus
+	err    error
+	// running makes Wait block until ctx is done, like a job that never finishes.
+	running bool
+	// delay makes Wait take this long before returning the status.
+	delay     time.Duration
+	cancelErr error
+	cancelled int
+}
+
+func (j *fakeJob) ID() string { return j.id }
+
+func (j *fakeJob) Wait(ctx context.Context) (*bigquery.JobStatus, error) {
+	if j.running {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	time.Sleep(j.delay)
+	return j.status, j.err
+}
+
+func (j *fakeJob) Cancel(ctx context.Context) error {
+	j.cancelled++
+	return j.cancelErr
+}
+
+// fakeObjectLister serves object names from memory, recording the prefixes it was asked for.
+type fakeObjectLister struct {
+	names    []string
+	err      error
+	prefixes []string
+}
+
+func (f *fakeObjectLister) listObjectNames(ctx context.Context, bucket, prefix string, limit int) ([]string, error) {
+	f.prefixes = append(f.prefixes, prefix)
+	if f.err != nil {
+		return nil, f.err
+	}
+	var names []string
+	for _, name := range f.names {
+		if strings.HasPrefix(name, prefix) && len(names) < limit {
+			names = append(names, name)
+		}
+	}
+	return names, nil
+}
+
+func TestValidateDatasetWithFake(t *testing.T) {
+	tests := []struct {
+		name   string
+		fullID string
+		wantOK bool
+	}{
+		{name: "Matching ID", fullID: "p:ds", wantOK: true},
+		{name: "ID mismatch", fullID: "other:ds"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bp := &backupParams{projectID: "p", sourceDatasetID: "ds", metadata: &fakeBigQuery{
+				datasets: map[string]*bigquery.DatasetMetadata{"p:ds": {FullID: tt.fullID}},
+			}}
+			ok, err := bp.validateDataset(context.Background())
+			assert.Equal(t, tt.wantOK, ok)
+			if tt.wantOK {
+				assert.NoError(t, err)
+			} else {
+				assert.Error(t, err)
+			}
+		})
+	}
+}
+
+func TestWaitForJobWithFake(t *testing.T) {
+	jobErr := errors.New("extract failed")
+	tests := []struct {
+		name      string
+		job       *fakeJob
+		wantErr   error
+		wantFiles int64
+	}{
+		{
+			name: "Job succeeded",
+			job: &fakeJob{id: "job_1", status: &bigquery.JobStatus{State: bigquery.Done, Statistics: &bigquery.JobStatistics{
+				Details: &bigquery.ExtractStatistics{DestinationURIFileCounts: []int64{3}},
+			}}},
+			wantFiles: 3,
+		},
+		{name: "Job failed", job: &fakeJob{id: "job_1", status: &bigquery.JobStatus{State: bigquery.Done}, err: jobErr}, wantErr: jobErr},
+		{name: "Wait failed", job: &fakeJob{id: "job_1", err: jobErr}, wantErr: jobErr},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bp := &backupParams{sourceDatasetID: "ds", backupTableID: "t"}
+			res := bp.waitForJob(context.Background(), tt.job)
+			assert.Equal(t, tt.wantErr, res.Err)
+			assert.Equal(t, tt.wantErr == nil, res.Succeeded())
+			assert.Equal(t, "job_1", res.JobID)
+			assert.Equal(t, tt.wantFiles, bp.extractFileCount)
+		})
+	}
+}
+
+func TestRunExtractorWithFake(t *testing.T) {
+	fake := &fakeBigQuery{job: &fakeJob{id: "job_1"}}
+	bp := &backupParams{
+		projectID:         "test-project",
+		sourceDatasetID:   "ds",
+		backupTableID:     "t",
+		storageBucket:     "b",
+		destinationFormat: csvFormat,
+		compressionType:   gzipCompression,
+		extractor:         fake,
+	}
+	assert.NoError(t, bp.setBigQueryClient(context.Background()))
+
+	job, err := bp.runExtractor(context.Background(), setupExtractor(bp))
+	assert.NoError(t, err)
+	assert.Equal(t, "job_1", job.ID())
+	assert.Equal(t, "job_1", bp.jobID)
+	assert.Equal(t, []string{bp.extractURI()}, fake.extracted.Dst.URIs)
+	assert.Equal(t, bigquery.CSV, fake.extracted.Dst.DestinationFormat)
+	assert.Equal(t, bigquery.Compression(gzipCompression), fake.extracted.Dst.Compression)
+	assert.True(t, fake.extracted.DisableHeader)
+
+	fake.runErr = errors.New("quota exceeded")
+	_, err = bp.runExtractor(context.Background(), setupExtractor(bp))
+	assert.Error(t, err)
+}
+
+func TestBackupBigQueryTableWithFake(t *testing.T) {
+	jobErr := errors.New("extract failed: access denied")
+	tests := []struct {
+		name      string
+		job       *fakeJob
+		runErr    error
+		wantErr   error
+		wantJobID string
+		wantState string
+	}{
+		{name: "Job succeeded", job: &fakeJob{id: "job_1", status: &bigquery.JobStatus{State: bigquery.Done}}, wantJobID: "job_1", wantState: jobStateDone},
+		{name: "Job status carries an error", job: &fakeJob{id: "job_1", status: &bigquery.JobStatus{State: bigquery.Done}, err: jobErr}, wantErr: jobErr, wantJobID: "job_1", wantState: jobStateDone},
+		{name: "Wait failed", job: &fakeJob{id: "job_1", err: jobErr}, wantErr: jobErr, wantJobID: "job_1"},
+		{name: "Job not started", runErr: jobErr, wantErr: jobErr},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bp := &backupParams{
+				projectID:         "test-project",
+				sourceDatasetID:   "ds",
+				backupTableID:     "t",
+				storageBucket:     "b",
+				destinationFormat: avroFormat,
+				compressionType:   snappyCompression,
+				extractor:         &fakeBigQuery{job: tt.job, runErr: tt.runErr},
+			}
+			assert.NoError(t, bp.setBigQueryClient(context.Background()))
+
+			res := bp.backupBigQueryTable(context.Background())
+			assert.Equal(t, tt.wantErr == nil, res.Succeeded())
+			assert.Equal(t, tt.wantErr, res.Err)
+			assert.Equal(t, tt.wantJobID, res.JobID)
+			assert.Equal(t, tt.wantState, res.State)
+			assert.Equal(t, []string{bp.extractURI()}, res.DestinationURIs)
+		})
+	}
+}
+
+func TestCheckBucketWritable(t *testing.T) {
+	saved := testBucketPermissions
+	t.Cleanup(func() {
+		testBucketPermissions = saved
+		forgetBucketWritable("writable")
+		forgetBucketWritable("flaky")
+	})
+	t.Setenv("BUCKET_PROBE_RETRIES", "1")
+	calls := make(map[string]int)
+	testBucketPermissions = func(_ context.Context, bucket string, permissions []string) ([]string, error) {
+		calls[bucket]++
+		switch {
+		case bucket == "flaky" && calls[bucket] == 1:
+			return nil, errors.New("transient")
+		case bucket == "readonly":
+			return nil, nil
+		case bucket == "down":
+			return nil, errors.New("unavailable")
+		}
+		return permissions, nil
+	}
+	ctx := context.Background()
+
+	assert.NoError(t, checkBucketWritable(ctx, "writable"))
+	assert.NoError(t, checkBucketWritable(ctx, "writable"))
+	assert.Equal(t, 1, calls["writable"], "a writable bucket is probed once")
+	forgetBucketWritable("writable")
+	assert.NoError(t, checkBucketWritable(ctx, "writable"))
+	assert.Equal(t, 2, calls["writable"], "a failed write forces a new probe")
+
+	assert.NoError(t, checkBucketWritable(ctx, "flaky"))
+	assert.Equal(t, 2, calls["flaky"], "a failed probe is retried")
+
+	err := checkBucketWritable(ctx, "readonly")
+	assert.Equal(t, http.StatusForbidden, statusCode(err))
+	assert.ErrorContains(t, checkBucketWritable(ctx, "readonly"), "missing storage.objects.create")
+	assert.Equal(t, 2, calls["readonly"], "a bucket that is not writable is not cached")
+
+	assert.ErrorContains(t, checkBucketWritable(ctx, "down"), "failed to check permissions on bucket down: unavailable")
+	assert.Equal(t, 2, calls["down"])
+}
+
+func TestBucketProbeRetries(t *testing.T) {
+	t.Setenv("BUCKET_PROBE_RETRIES", "")
+	assert.Equal(t, defaultBucketProbeRetries, bucketProbeRetries())
+	t.Setenv("BUCKET_PROBE_RETRIES", "0")
+	assert.Equal(t, 0, bucketProbeRetries())
+	t.Setenv("BUCKET_PROBE_RETRIES", "-1")
+	assert.Equal(t, defaultBucketProbeRetries, bucketProbeRetries())
+}
+
+func TestWarmUpClientsConcurrently(t *testing.T) {
+	savedFactory, savedBackend := newLogBackend, lb
+	t.Cleanup(func() { newLogBackend, lb = savedFactory, savedBackend })
+	lb = nil
+	created := make(chan struct{})
+	newLogBackend = func(string) (logBackend, error) {
+		close(created)
+		return &stdoutLogBackend{out: &strings.Builder{}}, nil
+	}
+
+	bp := &backupParams{projectID: "test-project"}
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			assert.NoError(t, bp.warmUpClients(context.Background()))
+		}()
+	}
+	wg.Wait()
+	<-created
+	assert.NotNil(t, bc)
+	assert.NotNil(t, sc)
+	assert.NotNil(t, lb)
+}
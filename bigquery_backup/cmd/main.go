@@ -1,35 +1,109 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
+	"flag"
 	"fmt"
 	"log"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
-	// Blank-import the function package so the init() runs
 	"github.com/GoogleCloudPlatform/functions-framework-go/funcframework"
-	_ "github.com/sirhco/google-cloud-functions/bigquerybackup"
+	// Importing the function package runs its init(), which registers the functions
+	"github.com/sirhco/google-cloud-functions/bigquerybackup"
 )
 
 var port = "8080"
 
+// shutdownGracePeriod is how long requests in flight may still run after SIGTERM. Cloud Run and
+// Cloud Functions kill the instance 10 seconds after sending it, so this leaves time to flush the
+// logs and close the clients.
+const shutdownGracePeriod = 8 * time.Second
+
+var (
+	runOnce     = flag.Bool("run-once", false, "back up one table and exit instead of starting the server")
+	project     = flag.String("project", "", "project of the dataset, defaults to GCP_PROJECT (with -run-once)")
+	dataset     = flag.String("dataset", "", "dataset of the table to back up (with -run-once)")
+	table       = flag.String("table", "", "table to back up (with -run-once)")
+	bucket      = flag.String("bucket", "", "storage bucket to back up to (with -run-once)")
+	format      = flag.String("format", "", "destination format: CSV, JSON, AVRO or PARQUET (with -run-once)")
+	compression = flag.String("compression", "", "compression type (with -run-once)")
+)
+
 func init() {
 	if envPort := os.Getenv("PORT"); envPort != "" {
 		port = envPort
 	}
+}
+
+func main() {
+	flag.Parse()
+	if *runOnce {
+		os.Exit(backupOnce())
+	}
 	if gcpProject := os.Getenv("GCP_PROJECT"); gcpProject == "" {
 		log.Fatalf("GCP_PROJECT environment variable must be set.")
 	}
+
 	fmt.Printf("BigQuery Backup starting up on PORT %v\n", os.Getenv("PORT"))
 	fmt.Printf("GCP_PROJECT: %v\n\n", os.Getenv("GCP_PROJECT"))
 	fmt.Printf(`Open a new tab in your terminal and run:
 
 // curl http://localhost:%v`, os.Getenv("PORT"))
-}
-func main() {
+
 	// Use PORT environment variable, or default to 8080.
+	errc := make(chan error, 1)
+	go func() {
+		errc <- funcframework.Start(port)
+	}()
 
-	err := funcframework.Start(port)
-	if err != nil {
+	sigc := make(chan os.Signal, 1)
+	signal.Notify(sigc, syscall.SIGTERM, syscall.SIGINT)
+	select {
+	case err := <-errc:
 		log.Fatalf("funcframework.Start: %v\n", err)
+	case sig := <-sigc:
+		log.Printf("Received %v, shutting down", sig)
+	}
+
+	// The server keeps accepting connections until main returns; Shutdown answers their
+	// requests with 503.
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownGracePeriod)
+	defer cancel()
+	if err := bigquerybackup.Shutdown(ctx); err != nil {
+		log.Printf("Shutdown: %v", err)
+	}
+}
+
+// backupOnce runs the backup described by the flags, prints its result as JSON and returns the
+// exit code: 0 on success, 1 when the backup failed and 2 when the flags are incomplete.
+func backupOnce() int {
+	defer bigquerybackup.Close()
+	if *dataset == "" || *table == "" {
+		fmt.Fprintln(os.Stderr, "-run-once needs -dataset and -table")
+		flag.Usage()
+		return 2
+	}
+	res, err := bigquerybackup.Backup(context.Background(), bigquerybackup.Config{
+		ProjectID:   *project,
+		DatasetID:   *dataset,
+		TableID:     *table,
+		Bucket:      *bucket,
+		Format:      *format,
+		Compression: *compression,
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Backup of %s.%s failed: %v\n", *dataset, *table, err)
+		return 1
+	}
+	out, err := json.MarshalIndent(res, "", "  ")
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to encode result: %v\n", err)
+		return 1
 	}
+	fmt.Println(string(out))
+	return 0
 }
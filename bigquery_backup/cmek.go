@@ -0,0 +1,48 @@
+package bigquerybackup
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"cloud.google.com/go/storage"
+)
+
+// kmsKeyNamePattern matches the resource name of a Cloud KMS key.
+var kmsKeyNamePattern = regexp.MustCompile(`^projects/[^/]+/locations/[^/]+/keyRings/[^/]+/cryptoKeys/[^/]+$`)
+
+// checkKMSKeyName validates the kms_key_name field of the POST body.
+func checkKMSKeyName(name string) error {
+	if name == "" || kmsKeyNamePattern.MatchString(name) {
+		return nil
+	}
+	return fmt.Errorf("invalid kms_key_name %q, must be projects/<project>/locations/<location>/keyRings/<key_ring>/cryptoKeys/<key>", name)
+}
+
+// checkBucketEncryption verifies that the storage bucket encrypts new objects with the
+// requested KMS key by default. An extract job cannot name a key for the files it writes, so
+// they are encrypted with the bucket's default key, which therefore has to be the requested
+// one. It does nothing when no key was requested.
+func (bp *backupParams) checkBucketEncryption(attrs *storage.BucketAttrs) error {
+	if bp.kmsKeyName == "" {
+		return nil
+	}
+	if attrs.Encryption == nil || attrs.Encryption.DefaultKMSKeyName == "" {
+		return withStatus(http.StatusBadRequest, fmt.Errorf("bucket %s has no default KMS key, set it to %s so the export is encrypted with it", bp.storageBucket, bp.kmsKeyName))
+	}
+	if attrs.Encryption.DefaultKMSKeyName != bp.kmsKeyName {
+		return withStatus(http.StatusBadRequest, fmt.Errorf("bucket %s encrypts with default KMS key %s, not kms_key_name %s", bp.storageBucket, attrs.Encryption.DefaultKMSKeyName, bp.kmsKeyName))
+	}
+	return nil
+}
+
+// kmsError explains a failure to write the backup that was caused by the KMS key, which almost
+// always means the Cloud Storage service agent may not use it. It reports such a failure as 403
+// and returns any other error unchanged.
+func (bp *backupParams) kmsError(err error) error {
+	if err == nil || bp.kmsKeyName == "" || !strings.Contains(strings.ToLower(err.Error()), "kms") {
+		return err
+	}
+	return withStatus(http.StatusForbidden, fmt.Errorf("%v: the Cloud Storage service agent of the bucket's project needs roles/cloudkms.cryptoKeyEncrypterDecrypter on %s", err, bp.kmsKeyName))
+}
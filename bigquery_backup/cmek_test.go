@@ -0,0 +1,61 @@
+package bigquerybackup
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+
+	"cloud.google.com/go/storage"
+	"github.com/stretchr/testify/assert"
+)
+
+const testKMSKey = "projects/p/locations/us/keyRings/backups/cryptoKeys/bq"
+
+func TestCheckKMSKeyName(t *testing.T) {
+	assert.NoError(t, checkKMSKeyName(""))
+	assert.NoError(t, checkKMSKeyName(testKMSKey))
+	assert.Error(t, checkKMSKeyName("bq"))
+	assert.Error(t, checkKMSKeyName(testKMSKey+"/cryptoKeyVersions/1"))
+	assert.Error(t, checkKMSKeyName("projects/p/locations/us/keyRings/backups"))
+}
+
+func TestCheckBucketEncryption(t *testing.T) {
+	tests := []struct {
+		name     string
+		key      string
+		attrs    *storage.BucketAttrs
+		wantCode int
+	}{
+		{name: "No key requested", attrs: &storage.BucketAttrs{}},
+		{name: "Matching default", key: testKMSKey, attrs: &storage.BucketAttrs{Encryption: &storage.BucketEncryption{DefaultKMSKeyName: testKMSKey}}},
+		{name: "No default", key: testKMSKey, attrs: &storage.BucketAttrs{}, wantCode: http.StatusBadRequest},
+		{name: "Other default", key: testKMSKey, attrs: &storage.BucketAttrs{Encryption: &storage.BucketEncryption{DefaultKMSKeyName: testKMSKey + "2"}}, wantCode: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bp := &backupParams{storageBucket: "b", kmsKeyName: tt.key}
+			err := bp.checkBucketEncryption(tt.attrs)
+			if tt.wantCode == 0 {
+				assert.NoError(t, err)
+				return
+			}
+			assert.Equal(t, tt.wantCode, statusCode(err))
+		})
+	}
+}
+
+func TestKMSError(t *testing.T) {
+	bp := &backupParams{}
+	kmsErr := errors.New("Cloud KMS key access denied")
+	assert.Equal(t, kmsErr, bp.kmsError(kmsErr))
+
+	bp.kmsKeyName = testKMSKey
+	err := bp.kmsError(kmsErr)
+	assert.Equal(t, http.StatusForbidden, statusCode(err))
+	assert.Contains(t, err.Error(), "roles/cloudkms.cryptoKeyEncrypterDecrypter")
+
+	other := errors.New("quota exceeded")
+	assert.Equal(t, other, bp.kmsError(other))
+	assert.NoError(t, bp.kmsError(nil))
+}
@@ -0,0 +1,72 @@
+package bigquerybackup
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"cloud.google.com/go/bigquery"
+)
+
+// nestedColumn returns the name of the first RECORD or REPEATED column of schema, which a CSV
+// export cannot hold, or "" when the schema is flat.
+func nestedColumn(schema bigquery.Schema) string {
+	for _, field := range schema {
+		if field.Repeated || field.Type == bigquery.RecordFieldType {
+			return field.Name
+		}
+	}
+	return ""
+}
+
+// Values of the nested_csv field, which decides what happens to a CSV backup of a table with
+// nested or repeated columns.
+const (
+	nestedCSVReject = "reject"
+	nestedCSVWarn   = "warn"
+)
+
+// checkNestedCSV validates the nested_csv field of the POST body.
+func checkNestedCSV(pb *postBodyParams) error {
+	value := strings.ToLower(strings.TrimSpace(pb.NestedCSV))
+	if value == "" {
+		return nil
+	}
+	if value != nestedCSVReject && value != nestedCSVWarn {
+		return fmt.Errorf("invalid nested_csv %q, must be %s or %s", pb.NestedCSV, nestedCSVReject, nestedCSVWarn)
+	}
+	if format, _ := normalizeFormat(pb.Format); format != csvFormat {
+		return fmt.Errorf("nested_csv is only supported for %s backups", csvFormat)
+	}
+	return nil
+}
+
+// checkExportFormat rejects a CSV backup of a table with nested or repeated columns with 400,
+// before BigQuery fails the extract job with a less helpful error. With nested_csv set to warn
+// the backup goes ahead with a warning instead, which also points out that a print_header
+// header names the flattened columns ambiguously. It reads the table metadata already fetched
+// to validate the table. Query backups are not checked, their schema is only known once the
+// query ran.
+func (bp *backupParams) checkExportFormat(ctx context.Context) error {
+	if bp.destinationFormat != csvFormat {
+		return nil
+	}
+	md, err := bp.tableMetadata(ctx)
+	if err != nil {
+		return err
+	}
+	column := nestedColumn(md.Schema)
+	if column == "" {
+		return nil
+	}
+	if bp.nestedCSV != nestedCSVWarn {
+		return withStatus(http.StatusBadRequest, fmt.Errorf("table %s.%s cannot be exported to CSV, column %q is a nested or repeated field, use AVRO, PARQUET or JSON instead", bp.sourceDatasetID, bp.backupTableID, column))
+	}
+	msg := fmt.Sprintf("Table %s.%s has the nested or repeated column %q, so its CSV backup may fail or not restore faithfully", bp.sourceDatasetID, bp.backupTableID, column)
+	if bp.printHeader {
+		msg += " and its header row may name columns ambiguously"
+	}
+	_ = bp.warn(msg + ", use AVRO or PARQUET instead")
+	return nil
+}
@@ -0,0 +1,107 @@
+package bigquerybackup
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"cloud.google.com/go/bigquery"
+	"github.com/stretchr/testify/assert"
+)
+
+var (
+	flatSchema = bigquery.Schema{
+		{Name: "id", Type: bigquery.IntegerFieldType},
+		{Name: "name", Type: bigquery.StringFieldType},
+	}
+	recordSchema = bigquery.Schema{
+		{Name: "id", Type: bigquery.IntegerFieldType},
+		{Name: "customer", Type: bigquery.RecordFieldType, Schema: bigquery.Schema{{Name: "email", Type: bigquery.StringFieldType}}},
+	}
+	repeatedSchema = bigquery.Schema{
+		{Name: "id", Type: bigquery.IntegerFieldType},
+		{Name: "tags", Type: bigquery.StringFieldType, Repeated: true},
+	}
+)
+
+func TestNestedColumn(t *testing.T) {
+	assert.Equal(t, "", nestedColumn(flatSchema))
+	assert.Equal(t, "customer", nestedColumn(recordSchema))
+	assert.Equal(t, "tags", nestedColumn(repeatedSchema))
+	assert.Equal(t, "", nestedColumn(nil))
+}
+
+func TestCheckExportFormat(t *testing.T) {
+	tests := []struct {
+		name         string
+		format       string
+		schema       bigquery.Schema
+		nestedCSV    string
+		printHeader  bool
+		wantCode     int
+		wantWarnings int
+	}{
+		{name: "CSV flat", format: csvFormat, schema: flatSchema},
+		{name: "CSV record", format: csvFormat, schema: recordSchema, wantCode: http.StatusBadRequest},
+		{name: "CSV repeated", format: csvFormat, schema: repeatedSchema, wantCode: http.StatusBadRequest},
+		{name: "Avro record", format: avroFormat, schema: recordSchema},
+		{name: "JSON repeated", format: jsonFormat, schema: repeatedSchema},
+		{name: "CSV record rejected explicitly", format: csvFormat, schema: recordSchema, nestedCSV: nestedCSVReject, wantCode: http.StatusBadRequest},
+		{name: "CSV record with warning", format: csvFormat, schema: recordSchema, nestedCSV: nestedCSVWarn, wantWarnings: 1},
+		{name: "CSV repeated with header warning", format: csvFormat, schema: repeatedSchema, nestedCSV: nestedCSVWarn, printHeader: true, wantWarnings: 1},
+		{name: "CSV flat with warning allowed", format: csvFormat, schema: flatSchema, nestedCSV: nestedCSVWarn, printHeader: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bp := &backupParams{
+				projectID:         "p",
+				sourceDatasetID:   "ds",
+				backupTableID:     "t",
+				destinationFormat: tt.format,
+				nestedCSV:         tt.nestedCSV,
+				printHeader:       tt.printHeader,
+				cache:             newMetadataCache(),
+			}
+			_, _ = bp.cache.table("p:ds.t", func() (*bigquery.TableMetadata, error) {
+				return &bigquery.TableMetadata{Schema: tt.schema}, nil
+			})
+			err := bp.checkExportFormat(context.Background())
+			if tt.wantCode == 0 {
+				assert.NoError(t, err)
+				assert.Len(t, bp.warnings, tt.wantWarnings)
+				if tt.printHeader && tt.wantWarnings > 0 {
+					assert.Contains(t, bp.warnings[0], "header row")
+				}
+				return
+			}
+			assert.Equal(t, tt.wantCode, statusCode(err))
+			assert.Contains(t, err.Error(), "AVRO, PARQUET or JSON")
+		})
+	}
+}
+
+func TestCheckNestedCSV(t *testing.T) {
+	tests := []struct {
+		name    string
+		pb      postBodyParams
+		wantErr bool
+	}{
+		{name: "Not set", pb: postBodyParams{Format: "AVRO"}},
+		{name: "Warn", pb: postBodyParams{Format: "csv", NestedCSV: "Warn"}},
+		{name: "Reject", pb: postBodyParams{Format: "CSV", NestedCSV: "reject"}},
+		{name: "Unknown value", pb: postBodyParams{Format: "CSV", NestedCSV: "flatten"}, wantErr: true},
+		{name: "Not CSV", pb: postBodyParams{Format: "PARQUET", NestedCSV: "warn"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkNestedCSV(&tt.pb)
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
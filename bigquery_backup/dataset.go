@@ -0,0 +1,288 @@
+package bigquerybackup
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"cloud.google.com/go/bigquery"
+	"google.golang.org/api/iterator"
+)
+
+// allTables is the table_name that, like an empty table_name, backs up every table in the
+// dataset.
+const allTables = "*"
+
+const (
+	tableStatusOK      = "ok"
+	tableStatusSkipped = "skipped"
+	tableStatusFailed  = "failed"
+
+	skipReasonUnsupportedType = "unsupported_table_type"
+)
+
+// defaultBackupConcurrency is how many tables of a dataset are backed up at once unless
+// BACKUP_CONCURRENCY says otherwise. It stays well below BigQuery's limit on concurrent extract
+// jobs per project, which is shared with every other exporter in the project.
+const defaultBackupConcurrency = 4
+
+// backupConcurrency returns how many tables are backed up in parallel, read from the
+// BACKUP_CONCURRENCY environment variable.
+func backupConcurrency() int {
+	n, err := strconv.Atoi(strings.TrimSpace(os.Getenv("BACKUP_CONCURRENCY")))
+	if err != nil || n <= 0 {
+		return defaultBackupConcurrency
+	}
+	return n
+}
+
+// tableResult is the outcome of backing up one table of a dataset-wide backup or of the tables
+// listed in tables. Dataset is only set for listed tables, which may come from several datasets.
+// DurationSeconds is how long the extract job of a completed backup took, including its submission.
+type tableResult struct {
+	Dataset         string          `json:"dataset,omitempty"`
+	Table           string          `json:"table"`
+	Status          string          `json:"status"`
+	JobID           string          `json:"job_id,omitempty"`
+	Location        string          `json:"location,omitempty"`
+	DestinationURI  string          `json:"destination_uri,omitempty"`
+	FileCount       int64           `json:"file_count,omitempty"`
+	Reason          string          `json:"reason,omitempty"`
+	Error           string          `json:"error,omitempty"`
+	PartialSuccess  bool            `json:"partial_success,omitempty"`
+	Secondary       *mirrorResult   `json:"secondary,omitempty"`
+	OversizedFiles  []oversizedFile `json:"oversized_files,omitempty"`
+	SignedURLs      []signedURL     `json:"signed_urls,omitempty"`
+	Export          *exportFormat   `json:"export,omitempty"`
+	Warnings        []string        `json:"warnings,omitempty"`
+	DurationSeconds float64         `json:"duration_seconds,omitempty"`
+}
+
+// datasetResponse summarizes a dataset-wide backup or a backup of listed tables. When any table
+// failed, Error and Code are set as in errorResponse, so callers can handle it like any other
+// failed request.
+type datasetResponse struct {
+	Status string        `json:"status"`
+	Error  string        `json:"error,omitempty"`
+	Code   int           `json:"code,omitempty"`
+	RunID  string        `json:"run_id"`
+	DryRun bool          `json:"dry_run,omitempty"`
+	Tables []tableResult `json:"tables"`
+}
+
+// isDatasetBackup reports whether the request asks for every table in the dataset.
+func (bp *backupParams) isDatasetBackup() bool {
+	return !bp.isTableListBackup() && (bp.backupTableID == "" || bp.backupTableID == allTables)
+}
+
+// forTable returns a copy of the request parameters that backs up the named table.
+func (bp *backupParams) forTable(tableID string) *backupParams {
+	t := *bp
+	t.backupTableID = tableID
+	t.jobID = ""
+	t.extractFileCount = 0
+	t.jobStats = nil
+	t.backupResult = nil
+	t.bytesExported = 0
+	t.snapshotTable = ""
+	t.mirror = nil
+	t.oversizedFiles, t.signedURLs = nil, nil
+	t.emptyTable = false
+	t.warnings, t.export = nil, nil
+	t.extractJobID = ""
+	t.jobReused, t.reusedJobURI = false, ""
+	t.jobTimedOut = false
+	t.materializeView, t.queryTable = false, ""
+	t.skipReason = ""
+	t.validated, t.validationErr = false, nil
+	return &t
+}
+
+// listTables returns the IDs of all tables in the source dataset.
+func (bp *backupParams) listTables(ctx context.Context) ([]string, error) {
+	var ids []string
+	it := bc.DatasetInProject(bp.sourceProjectID(), bp.sourceDatasetID).Tables(ctx)
+	for {
+		t, err := it.Next()
+		if err == iterator.Done {
+			return ids, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, t.TableID)
+	}
+}
+
+// backupDataset backs up every table in the source dataset, each under its own prefix, running
+// up to BACKUP_CONCURRENCY tables in parallel. Views, materialized views, external tables and
+// snapshots cannot be exported and are skipped with a logged note, and so are the tables left
+// once batch_max_bytes is used up. A failing table does not stop the others; the outcome of every
+// table is collected into the returned summary.
+func (bp *backupParams) backupDataset(ctx context.Context) datasetResponse {
+	resp := datasetResponse{RunID: bp.runID, DryRun: bp.dryRun, Tables: []tableResult{}}
+	if err := bp.validateRequest(ctx); err != nil {
+		return resp.fail(statusCode(err), err.Error())
+	}
+	ids, err := bp.listTables(ctx)
+	if err != nil {
+		_ = bp.logError(fmt.Sprintf("Problem listing tables of dataset %s: %v", bp.sourceDatasetID, err))
+		return resp.fail(http.StatusInternalServerError, fmt.Sprintf("problem listing tables of dataset %s: %v", bp.sourceDatasetID, err))
+	}
+	workers := backupConcurrency()
+	_ = bp.logInfo(fmt.Sprintf("Backing up %d tables of dataset %s, %d at a time", len(ids), bp.sourceDatasetID, workers))
+	bp.budget = newByteBudget(bp.batchMaxBytes)
+
+	resp.Tables = runPool(ids, workers, func(id string) tableResult {
+		return bp.forTable(id).backupDatasetTable(ctx)
+	})
+	return resp.summarize()
+}
+
+// validateDatasetBackup checks that the source dataset may be backed up and that it exists and
+// can be read.
+func (bp *backupParams) validateDatasetBackup(ctx context.Context) error {
+	if err := bp.checkDatasetPolicy(); err != nil {
+		_ = bp.logError(fmt.Sprintf("Backup rejected: %v", err))
+		return err
+	}
+	if ok, err := bp.validateDataset(ctx); !ok || err != nil {
+		err = lookupError("dataset "+bp.sourceDatasetID, err)
+		_ = bp.logError(fmt.Sprintf("Invalid dataset: %v", err))
+		return err
+	}
+	return nil
+}
+
+// indexedResult carries a table result back from a worker with the position of its table.
+type indexedResult struct {
+	index  int
+	result tableResult
+}
+
+// runPool calls backup for every table ID using at most workers goroutines and returns the
+// results in the order of ids. Workers report over a channel; a failing table only affects its
+// own result.
+func runPool(ids []string, workers int, backup func(string) tableResult) []tableResult {
+	jobs := make(chan int)
+	results := make(chan indexedResult)
+	var wg sync.WaitGroup
+	for w := 0; w < workers && w < len(ids); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				results <- indexedResult{index: i, result: backup(ids[i])}
+			}
+		}()
+	}
+	go func() {
+		for i := range ids {
+			jobs <- i
+		}
+		close(jobs)
+		wg.Wait()
+		close(results)
+	}()
+
+	out := make([]tableResult, len(ids))
+	for r := range results {
+		out[r.index] = r.result
+	}
+	return out
+}
+
+// backupDatasetTable backs up one table of a dataset-wide backup and reports its outcome.
+func (bp *backupParams) backupDatasetTable(ctx context.Context) tableResult {
+	result := tableResult{Table: bp.backupTableID}
+	md, err := bp.tableMetadata(ctx)
+	if err != nil {
+		result.Status, result.Error = tableStatusFailed, err.Error()
+		return result
+	}
+	if md.Type != bigquery.RegularTable && !(bp.allowViews && isView(md.Type)) {
+		_ = bp.logInfo(fmt.Sprintf("Skipping %s %s.%s, only tables can be exported", md.Type, bp.sourceDatasetID, bp.backupTableID))
+		result.Status, result.Reason = tableStatusSkipped, skipReasonUnsupportedType
+		return result
+	}
+	if fits, err := bp.reserveBudget(md); err != nil {
+		result.Status, result.Error = tableStatusFailed, err.Error()
+		return result
+	} else if !fits {
+		result.Status, result.Reason = tableStatusSkipped, skipReasonBudgetExceeded
+		return result
+	}
+
+	skipped, err := bp.runTableBackup(ctx)
+	switch {
+	case err != nil:
+		result.Status, result.Error = tableStatusFailed, err.Error()
+	case skipped:
+		result.Status, result.Reason = tableStatusSkipped, bp.skipReason
+	case bp.jobTimedOut:
+		result.Status, result.JobID, result.Location, result.DestinationURI = asyncStatusRunning, bp.jobID, bp.location, bp.destinationURI()
+	case bp.dryRun:
+		result.Status, result.DestinationURI = tableStatusOK, bp.destinationURI()
+	default:
+		result.Status, result.JobID, result.DestinationURI = tableStatusOK, bp.jobID, bp.destinationURI()
+		result.FileCount = bp.extractFileCount
+		result.PartialSuccess, result.Secondary = bp.mirror.partial(), bp.mirror
+		result.OversizedFiles, result.SignedURLs = bp.oversizedFiles, bp.signedURLs
+		if bp.backupResult != nil {
+			result.DurationSeconds = bp.backupResult.Duration.Seconds()
+		}
+		if bp.reusedJob() {
+			break
+		}
+		if err := bp.fireSuccessTrigger(ctx); err != nil {
+			_ = bp.logError(fmt.Sprintf("Problem triggering next stage: %v", err))
+		}
+	}
+	result.Export, result.Warnings = bp.export, bp.warnings
+	return result
+}
+
+// fail marks the whole dataset backup as failed before any table was attempted.
+func (resp datasetResponse) fail(code int, msg string) datasetResponse {
+	resp.Status, resp.Code, resp.Error = tableStatusFailed, code, msg
+	return resp
+}
+
+// summarize sets the overall status from the table results: ok when no table failed, partial
+// when some did, and failed when every attempted table failed.
+func (resp datasetResponse) summarize() datasetResponse {
+	failed, succeeded := 0, 0
+	for _, t := range resp.Tables {
+		switch t.Status {
+		case tableStatusFailed:
+			failed++
+		case tableStatusOK:
+			succeeded++
+		}
+	}
+	switch {
+	case failed == 0:
+		resp.Status = tableStatusOK
+		return resp
+	case succeeded == 0:
+		resp.Status = tableStatusFailed
+	default:
+		resp.Status = "partial"
+	}
+	resp.Code = http.StatusInternalServerError
+	resp.Error = fmt.Sprintf("%d of %d tables failed", failed, len(resp.Tables))
+	return resp
+}
+
+// httpStatus returns the HTTP status a dataset-wide backup is answered with: Code when the
+// backup failed, otherwise 200.
+func (resp datasetResponse) httpStatus() int {
+	if resp.Code == 0 {
+		return http.StatusOK
+	}
+	return resp.Code
+}
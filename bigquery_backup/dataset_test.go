@@ -0,0 +1,109 @@
+package bigquerybackup
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/bigquery"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsDatasetBackup(t *testing.T) {
+	assert.True(t, (&backupParams{}).isDatasetBackup())
+	assert.True(t, (&backupParams{backupTableID: "*"}).isDatasetBackup())
+	assert.False(t, (&backupParams{backupTableID: "t"}).isDatasetBackup())
+}
+
+func TestForTable(t *testing.T) {
+	bp := &backupParams{sourceDatasetID: "ds", backupTableID: "*", jobID: "job_1", snapshotTable: "s"}
+	tbp := bp.forTable("t")
+	assert.Equal(t, "t", tbp.backupTableID)
+	assert.Equal(t, "ds", tbp.sourceDatasetID)
+	assert.Empty(t, tbp.jobID)
+	assert.Empty(t, tbp.snapshotTable)
+	assert.Equal(t, "*", bp.backupTableID, "the dataset request is not modified")
+}
+
+func TestDatasetResponseSummarize(t *testing.T) {
+	ok := tableResult{Table: "a", Status: tableStatusOK}
+	skipped := tableResult{Table: "v", Status: tableStatusSkipped, Reason: skipReasonUnsupportedType}
+	failed := tableResult{Table: "b", Status: tableStatusFailed, Error: "boom"}
+
+	tests := []struct {
+		name       string
+		tables     []tableResult
+		wantStatus string
+		wantCode   int
+	}{
+		{name: "Empty dataset", tables: []tableResult{}, wantStatus: tableStatusOK},
+		{name: "All succeeded or skipped", tables: []tableResult{ok, skipped}, wantStatus: tableStatusOK},
+		{name: "Some failed", tables: []tableResult{ok, failed, skipped}, wantStatus: "partial", wantCode: http.StatusInternalServerError},
+		{name: "All failed", tables: []tableResult{failed, skipped}, wantStatus: tableStatusFailed, wantCode: http.StatusInternalServerError},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := datasetResponse{Tables: tt.tables}.summarize()
+			assert.Equal(t, tt.wantStatus, resp.Status)
+			assert.Equal(t, tt.wantCode, resp.Code)
+			assert.Equal(t, tt.wantCode != 0, resp.Error != "")
+		})
+	}
+}
+
+func TestBackupDatasetTableSkipsViews(t *testing.T) {
+	bp := &backupParams{projectID: "p", sourceDatasetID: "ds", cache: newMetadataCache()}
+	_, _ = bp.cache.table("p:ds.v", func() (*bigquery.TableMetadata, error) {
+		return &bigquery.TableMetadata{Type: bigquery.ViewTable}, nil
+	})
+
+	result := bp.forTable("v").backupDatasetTable(context.Background())
+	assert.Equal(t, tableResult{Table: "v", Status: tableStatusSkipped, Reason: skipReasonUnsupportedType}, result)
+}
+
+func TestBackupConcurrency(t *testing.T) {
+	t.Setenv("BACKUP_CONCURRENCY", "")
+	assert.Equal(t, defaultBackupConcurrency, backupConcurrency())
+	t.Setenv("BACKUP_CONCURRENCY", "16")
+	assert.Equal(t, 16, backupConcurrency())
+	t.Setenv("BACKUP_CONCURRENCY", "0")
+	assert.Equal(t, defaultBackupConcurrency, backupConcurrency())
+	t.Setenv("BACKUP_CONCURRENCY", "many")
+	assert.Equal(t, defaultBackupConcurrency, backupConcurrency())
+}
+
+func TestRunPool(t *testing.T) {
+	ids := []string{"a", "b", "c", "d", "e", "f", "g"}
+	var mu sync.Mutex
+	running, peak := 0, 0
+	results := runPool(ids, 3, func(id string) tableResult {
+		mu.Lock()
+		running++
+		if running > peak {
+			peak = running
+		}
+		mu.Unlock()
+		time.Sleep(10 * time.Millisecond)
+		mu.Lock()
+		running--
+		mu.Unlock()
+		if id == "c" {
+			return tableResult{Table: id, Status: tableStatusFailed}
+		}
+		return tableResult{Table: id, Status: tableStatusOK}
+	})
+
+	assert.Len(t, results, len(ids))
+	for i, id := range ids {
+		assert.Equal(t, id, results[i].Table, "results keep the order of the tables")
+	}
+	assert.Equal(t, tableStatusFailed, results[2].Status)
+	assert.Equal(t, tableStatusOK, results[3].Status, "a failure does not stop the other tables")
+	assert.LessOrEqual(t, peak, 3)
+	assert.Greater(t, peak, 1)
+
+	assert.Empty(t, runPool(nil, 3, func(string) tableResult { return tableResult{} }))
+}
@@ -0,0 +1,70 @@
+package bigquerybackup
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+)
+
+const (
+	allowedDatasetsEnv = "ALLOWED_DATASETS"
+	deniedDatasetsEnv  = "DENIED_DATASETS"
+)
+
+func init() {
+	for _, env := range []string{allowedDatasetsEnv, deniedDatasetsEnv} {
+		for _, pattern := range datasetPatterns(env) {
+			if _, err := path.Match(pattern, ""); err != nil {
+				log.Printf("WARNING: invalid %s pattern %q matches no dataset: %v", env, pattern, err)
+			}
+		}
+	}
+}
+
+// datasetPatterns returns the dataset name patterns of the comma-separated list in env, e.g.
+// "sales,tenant_*". An unset variable gives an empty list.
+func datasetPatterns(env string) []string {
+	var patterns []string
+	for _, p := range strings.Split(os.Getenv(env), ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			patterns = append(patterns, p)
+		}
+	}
+	return patterns
+}
+
+// matchDataset returns the first of patterns that dataset matches, or "" when none does.
+// Patterns use path.Match syntax; a malformed pattern matches nothing.
+func matchDataset(dataset string, patterns []string) string {
+	for _, p := range patterns {
+		if ok, _ := path.Match(p, dataset); ok {
+			return p
+		}
+	}
+	return ""
+}
+
+// checkDatasetAccess applies the denied and allowed dataset patterns to dataset. A denied
+// dataset is rejected even when it is also allowed; with an allowlist, a dataset missing from it
+// is rejected too. Empty lists allow every dataset.
+func checkDatasetAccess(dataset string, allowed, denied []string) error {
+	if p := matchDataset(dataset, denied); p != "" {
+		return fmt.Errorf("dataset %s may not be backed up, it matches %q in %s", dataset, p, deniedDatasetsEnv)
+	}
+	if len(allowed) > 0 && matchDataset(dataset, allowed) == "" {
+		return fmt.Errorf("dataset %s may not be backed up, it is not in %s", dataset, allowedDatasetsEnv)
+	}
+	return nil
+}
+
+// checkDatasetPolicy rejects a backup of a dataset that ALLOWED_DATASETS or DENIED_DATASETS
+// excludes with 403. It makes no API calls, so it runs before the dataset is looked up.
+func (bp *backupParams) checkDatasetPolicy() error {
+	if err := checkDatasetAccess(bp.sourceDatasetID, datasetPatterns(allowedDatasetsEnv), datasetPatterns(deniedDatasetsEnv)); err != nil {
+		return withStatus(http.StatusForbidden, err)
+	}
+	return nil
+}
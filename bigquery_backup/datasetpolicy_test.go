@@ -0,0 +1,72 @@
+package bigquerybackup
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMatchDataset(t *testing.T) {
+	tests := []struct {
+		name     string
+		dataset  string
+		patterns []string
+		want     string
+	}{
+		{name: "Exact", dataset: "sales", patterns: []string{"sales"}, want: "sales"},
+		{name: "Glob", dataset: "tenant_a", patterns: []string{"sales", "tenant_*"}, want: "tenant_*"},
+		{name: "Single character", dataset: "hr1", patterns: []string{"hr?"}, want: "hr?"},
+		{name: "Character class", dataset: "pii_eu", patterns: []string{"pii_[ae][su]"}, want: "pii_[ae][su]"},
+		{name: "No match", dataset: "finance", patterns: []string{"sales", "tenant_*"}},
+		{name: "Prefix is not a match", dataset: "sales_eu", patterns: []string{"sales"}},
+		{name: "Malformed pattern", dataset: "sales", patterns: []string{"[sales"}},
+		{name: "Empty list", dataset: "sales"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, matchDataset(tt.dataset, tt.patterns))
+		})
+	}
+}
+
+func TestCheckDatasetAccess(t *testing.T) {
+	tests := []struct {
+		name    string
+		dataset string
+		allowed []string
+		denied  []string
+		wantErr bool
+	}{
+		{name: "No lists", dataset: "pii"},
+		{name: "Allowed", dataset: "sales", allowed: []string{"sales", "tenant_*"}},
+		{name: "Allowed by glob", dataset: "tenant_a", allowed: []string{"tenant_*"}},
+		{name: "Not on allowlist", dataset: "pii", allowed: []string{"sales"}, wantErr: true},
+		{name: "Denied", dataset: "pii", denied: []string{"pii*"}, wantErr: true},
+		{name: "Not denied", dataset: "sales", denied: []string{"pii*"}},
+		{name: "Deny wins over allow", dataset: "tenant_secret", allowed: []string{"tenant_*"}, denied: []string{"*_secret"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkDatasetAccess(tt.dataset, tt.allowed, tt.denied)
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestCheckDatasetPolicy(t *testing.T) {
+	t.Setenv(allowedDatasetsEnv, " sales , tenant_* ")
+	t.Setenv(deniedDatasetsEnv, "tenant_secret")
+
+	assert.NoError(t, (&backupParams{sourceDatasetID: "tenant_a"}).checkDatasetPolicy())
+	for _, dataset := range []string{"tenant_secret", "finance"} {
+		err := (&backupParams{sourceDatasetID: dataset}).checkDatasetPolicy()
+		assert.Equal(t, http.StatusForbidden, statusCode(err), dataset)
+	}
+}
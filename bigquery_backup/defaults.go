@@ -0,0 +1,192 @@
+package bigquerybackup
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+)
+
+const formatDefaultsEnv = "FORMAT_DEFAULTS"
+
+// formatDefaults are the destination format and compression used when a request names none,
+// as configured by the DEFAULT_FORMAT and DEFAULT_COMPRESSION environment variables, and the
+// per-format overrides of FORMAT_DEFAULTS. Empty fields fall back to Avro and
+// builtinFormatCompressions. An override's defaultCompression is empty when FORMAT_DEFAULTS
+// only narrowed the allowed compression types of its format.
+type formatDefaults struct {
+	format      string
+	compression string
+	formats     map[string]formatCompression
+}
+
+// envFormatDefaults is read once when the instance starts.
+var envFormatDefaults formatDefaults
+
+func init() {
+	envFormatDefaults = loadFormatDefaults()
+}
+
+// loadFormatDefaults reads DEFAULT_FORMAT and DEFAULT_COMPRESSION. An invalid value is logged
+// as a warning and ignored, so a misconfigured instance still serves requests with the
+// built-in defaults instead of rejecting them.
+func loadFormatDefaults() formatDefaults {
+	d, warnings := parseFormatDefaults(os.Getenv("DEFAULT_FORMAT"), os.Getenv("DEFAULT_COMPRESSION"))
+	formats, formatWarnings := parseFormatCompressions(os.Getenv(formatDefaultsEnv))
+	d.formats = formats
+	for _, w := range append(warnings, formatWarnings...) {
+		log.Printf("WARNING: %s", w)
+	}
+	return d
+}
+
+// parseFormatDefaults validates the configured default format and compression, returning the
+// valid ones and a warning for each value that was ignored. The compression must be supported
+// by at least one format; it is only applied to requests whose format supports it.
+func parseFormatDefaults(format, compression string) (formatDefaults, []string) {
+	var d formatDefaults
+	var warnings []string
+	if f := strings.ToUpper(strings.TrimSpace(format)); f != "" {
+		if _, ok := builtinFormatCompressions[f]; ok {
+			d.format = f
+		} else {
+			warnings = append(warnings, fmt.Sprintf("ignoring DEFAULT_FORMAT %q, must be one of %s, %s, %s or %s", format, csvFormat, jsonFormat, avroFormat, parquetFormat))
+		}
+	}
+	c := strings.ToUpper(strings.TrimSpace(compression))
+	if c == "" {
+		return d, warnings
+	}
+	var formats []string
+	for _, f := range []string{csvFormat, jsonFormat, avroFormat, parquetFormat} {
+		if compressionAllowed(f, c) {
+			formats = append(formats, f)
+		}
+	}
+	if len(formats) == 0 {
+		return d, append(warnings, fmt.Sprintf("ignoring DEFAULT_COMPRESSION %q, must be one of %s, %s, %s, %s or %s", compression, noneCompression, gzipCompression, deflateCompression, snappyCompression, zstdCompression))
+	}
+	d.compression = c
+	if !compressionAllowed(d.defaultFormat(), c) {
+		warnings = append(warnings, fmt.Sprintf("DEFAULT_COMPRESSION %s is not supported for the default format %s, it only applies to %s backups", c, d.defaultFormat(), strings.Join(formats, " and ")))
+	}
+	return d, warnings
+}
+
+// defaultFormat returns the format used when a request names none.
+func (d formatDefaults) defaultFormat() string {
+	if d.format != "" {
+		return d.format
+	}
+	return avroFormat
+}
+
+// formatCompressionConfig is one entry of FORMAT_DEFAULTS. Both fields are optional.
+type formatCompressionConfig struct {
+	Default string   `json:"default"`
+	Allowed []string `json:"allowed"`
+}
+
+// parseFormatCompressions validates FORMAT_DEFAULTS, a JSON object keyed by format such as
+// {"PARQUET": {"default": "ZSTD", "allowed": ["SNAPPY", "ZSTD"]}}, and returns the valid entries
+// and a warning for each one that was ignored. The allowed types can only narrow what BigQuery
+// supports for the format, and must include the default, the configured one or the built-in one.
+func parseFormatCompressions(raw string) (map[string]formatCompression, []string) {
+	if strings.TrimSpace(raw) == "" {
+		return nil, nil
+	}
+	var cfg map[string]formatCompressionConfig
+	if err := json.Unmarshal([]byte(raw), &cfg); err != nil {
+		return nil, []string{fmt.Sprintf("ignoring %s, it is not a JSON object of formats: %v", formatDefaultsEnv, err)}
+	}
+	keys := make([]string, 0, len(cfg))
+	for k := range cfg {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	formats := make(map[string]formatCompression)
+	var warnings []string
+	for _, key := range keys {
+		fc, err := parseFormatCompression(key, cfg[key])
+		if err != nil {
+			warnings = append(warnings, fmt.Sprintf("ignoring %s entry %q: %v", formatDefaultsEnv, key, err))
+			continue
+		}
+		formats[strings.ToUpper(strings.TrimSpace(key))] = fc
+	}
+	return formats, warnings
+}
+
+// parseFormatCompression validates one entry of FORMAT_DEFAULTS for format.
+func parseFormatCompression(format string, cfg formatCompressionConfig) (formatCompression, error) {
+	format = strings.ToUpper(strings.TrimSpace(format))
+	builtin, ok := builtinFormatCompressions[format]
+	if !ok {
+		return formatCompression{}, fmt.Errorf("unknown format, must be one of %s, %s, %s or %s", csvFormat, jsonFormat, avroFormat, parquetFormat)
+	}
+	fc := formatCompression{defaultCompression: strings.ToUpper(strings.TrimSpace(cfg.Default)), allowed: builtin.allowed}
+	if len(cfg.Allowed) > 0 {
+		fc.allowed = nil
+		for _, c := range cfg.Allowed {
+			c = strings.ToUpper(strings.TrimSpace(c))
+			if !compressionAllowed(format, c) {
+				return formatCompression{}, fmt.Errorf("compression %q is not supported for %s, must be one of %s", c, format, strings.Join(builtin.allowed, ", "))
+			}
+			fc.allowed = append(fc.allowed, c)
+		}
+	}
+	def := fc.defaultCompression
+	if def == "" {
+		def = builtin.defaultCompression
+	}
+	if !fc.compressionAllowed(def) {
+		return formatCompression{}, fmt.Errorf("default compression %s is not one of the allowed %s", def, strings.Join(fc.allowed, ", "))
+	}
+	return fc, nil
+}
+
+// compressions returns the default and allowed compression types of format, as overridden by
+// FORMAT_DEFAULTS.
+func (d formatDefaults) compressions(format string) formatCompression {
+	if fc, ok := d.formats[format]; ok {
+		return fc
+	}
+	return builtinFormatCompressions[format]
+}
+
+// defaultCompression returns the compression used for format when a request names none: the
+// default FORMAT_DEFAULTS sets for format, then DEFAULT_COMPRESSION when format allows it,
+// otherwise the format's built-in default.
+func (d formatDefaults) defaultCompression(format string) string {
+	if fc, ok := d.formats[format]; ok && fc.defaultCompression != "" {
+		return fc.defaultCompression
+	}
+	if d.compression != "" && d.compressionAllowed(format, d.compression) {
+		return d.compression
+	}
+	return builtinFormatCompressions[format].defaultCompression
+}
+
+// compressionAllowed reports whether a request may ask for compression with format, which
+// FORMAT_DEFAULTS may restrict to fewer types than BigQuery supports.
+func (d formatDefaults) compressionAllowed(format, compression string) bool {
+	return d.compressions(format).compressionAllowed(compression)
+}
+
+// compressionAllowed reports whether compression is one of the allowed types.
+func (fc formatCompression) compressionAllowed(compression string) bool {
+	for _, c := range fc.allowed {
+		if c == compression {
+			return true
+		}
+	}
+	return false
+}
+
+// compressionAllowed reports whether BigQuery can apply compression to exports in format.
+func compressionAllowed(format, compression string) bool {
+	return builtinFormatCompressions[format].compressionAllowed(compression)
+}
@@ -0,0 +1,152 @@
+package bigquerybackup
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseFormatDefaults(t *testing.T) {
+	tests := []struct {
+		name         string
+		format       string
+		compression  string
+		want         formatDefaults
+		wantWarnings int
+	}{
+		{name: "Unset", want: formatDefaults{}},
+		{name: "Parquet snappy", format: "parquet", compression: "snappy", want: formatDefaults{format: parquetFormat, compression: snappyCompression}},
+		{name: "Format only", format: " CSV ", want: formatDefaults{format: csvFormat}},
+		{name: "Invalid format", format: "ORC", compression: "SNAPPY", want: formatDefaults{compression: snappyCompression}, wantWarnings: 1},
+		{name: "Invalid compression", format: "PARQUET", compression: "LZ4", want: formatDefaults{format: parquetFormat}, wantWarnings: 1},
+		{name: "Compression not for default format", format: "CSV", compression: "ZSTD", want: formatDefaults{format: csvFormat, compression: zstdCompression}, wantWarnings: 1},
+		{name: "Compression not for Avro", compression: "GZIP", want: formatDefaults{compression: gzipCompression}, wantWarnings: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, warnings := parseFormatDefaults(tt.format, tt.compression)
+			assert.Equal(t, tt.want, got)
+			assert.Len(t, warnings, tt.wantWarnings)
+		})
+	}
+}
+
+func TestCheckBackupFormatEnvDefaults(t *testing.T) {
+	saved := envFormatDefaults
+	t.Cleanup(func() { envFormatDefaults = saved })
+	envFormatDefaults = formatDefaults{format: parquetFormat, compression: snappyCompression}
+
+	tests := []struct {
+		name            string
+		format          string
+		compression     string
+		wantFormat      string
+		wantCompression string
+	}{
+		{name: "Both omitted", wantFormat: parquetFormat, wantCompression: snappyCompression},
+		{name: "Body format", format: "avro", wantFormat: avroFormat, wantCompression: snappyCompression},
+		{name: "Body compression", compression: "zstd", wantFormat: parquetFormat, wantCompression: zstdCompression},
+		{name: "Default compression unsupported", format: "csv", wantFormat: csvFormat, wantCompression: gzipCompression},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bp := &backupParams{destinationFormat: tt.format, compressionType: tt.compression}
+			ok, err := bp.checkBackupFormat()
+			assert.True(t, ok)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.wantFormat, bp.destinationFormat)
+			assert.Equal(t, tt.wantCompression, bp.compressionType)
+		})
+	}
+}
+
+func TestParseFormatCompressions(t *testing.T) {
+	tests := []struct {
+		name         string
+		raw          string
+		want         map[string]formatCompression
+		wantWarnings int
+	}{
+		{name: "Unset"},
+		{name: "Default only", raw: `{"parquet": {"default": "zstd"}}`, want: map[string]formatCompression{
+			parquetFormat: {defaultCompression: zstdCompression, allowed: builtinFormatCompressions[parquetFormat].allowed},
+		}},
+		{name: "Default and allowed", raw: `{"PARQUET": {"default": "ZSTD", "allowed": ["snappy", "ZSTD"]}}`, want: map[string]formatCompression{
+			parquetFormat: {defaultCompression: zstdCompression, allowed: []string{snappyCompression, zstdCompression}},
+		}},
+		{name: "Allowed only", raw: `{"CSV": {"allowed": ["GZIP"]}}`, want: map[string]formatCompression{
+			csvFormat: {allowed: []string{gzipCompression}},
+		}},
+		{name: "Invalid JSON", raw: `PARQUET=ZSTD`, wantWarnings: 1},
+		{name: "Unknown format", raw: `{"ORC": {"default": "ZSTD"}, "AVRO": {"default": "DEFLATE"}}`, want: map[string]formatCompression{
+			avroFormat: {defaultCompression: deflateCompression, allowed: builtinFormatCompressions[avroFormat].allowed},
+		}, wantWarnings: 1},
+		{name: "Unsupported default", raw: `{"CSV": {"default": "ZSTD"}}`, want: map[string]formatCompression{}, wantWarnings: 1},
+		{name: "Unsupported allowed", raw: `{"AVRO": {"allowed": ["GZIP"]}}`, want: map[string]formatCompression{}, wantWarnings: 1},
+		{name: "Default not allowed", raw: `{"PARQUET": {"default": "GZIP", "allowed": ["ZSTD"]}}`, want: map[string]formatCompression{}, wantWarnings: 1},
+		{name: "Built-in default not allowed", raw: `{"JSON": {"allowed": ["NONE"]}}`, want: map[string]formatCompression{}, wantWarnings: 1},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, warnings := parseFormatCompressions(tt.raw)
+			assert.Equal(t, tt.want, got)
+			assert.Len(t, warnings, tt.wantWarnings)
+		})
+	}
+}
+
+func TestFormatDefaultsLookup(t *testing.T) {
+	d := formatDefaults{
+		compression: gzipCompression,
+		formats: map[string]formatCompression{
+			parquetFormat: {defaultCompression: zstdCompression, allowed: []string{snappyCompression, zstdCompression}},
+			avroFormat:    {allowed: []string{noneCompression, snappyCompression}},
+		},
+	}
+
+	tests := []struct {
+		name        string
+		format      string
+		wantDefault string
+		allowed     []string
+		notAllowed  []string
+	}{
+		{name: "Overridden default wins over DEFAULT_COMPRESSION", format: parquetFormat, wantDefault: zstdCompression, allowed: []string{snappyCompression, zstdCompression}, notAllowed: []string{gzipCompression, noneCompression}},
+		{name: "DEFAULT_COMPRESSION", format: jsonFormat, wantDefault: gzipCompression, allowed: []string{noneCompression, gzipCompression}},
+		{name: "Narrowed allowed", format: avroFormat, wantDefault: snappyCompression, allowed: []string{noneCompression, snappyCompression}, notAllowed: []string{deflateCompression, gzipCompression}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.wantDefault, d.defaultCompression(tt.format))
+			for _, c := range tt.allowed {
+				assert.True(t, d.compressionAllowed(tt.format, c), c)
+			}
+			for _, c := range tt.notAllowed {
+				assert.False(t, d.compressionAllowed(tt.format, c), c)
+			}
+		})
+	}
+}
+
+func TestCheckBackupFormatFormatDefaults(t *testing.T) {
+	saved := envFormatDefaults
+	t.Cleanup(func() { envFormatDefaults = saved })
+	formats, warnings := parseFormatCompressions(`{"PARQUET": {"default": "ZSTD", "allowed": ["SNAPPY", "ZSTD"]}}`)
+	assert.Empty(t, warnings)
+	envFormatDefaults = formatDefaults{format: parquetFormat, formats: formats}
+
+	bp := &backupParams{}
+	ok, err := bp.checkBackupFormat()
+	assert.True(t, ok)
+	assert.NoError(t, err)
+	assert.Equal(t, zstdCompression, bp.compressionType)
+
+	bp = &backupParams{compressionType: "gzip"}
+	ok, err = bp.checkBackupFormat()
+	assert.False(t, ok)
+	assert.ErrorContains(t, err, "must be one of SNAPPY, ZSTD")
+}
@@ -0,0 +1,26 @@
+package bigquerybackup
+
+import (
+	"fmt"
+
+	"cloud.google.com/go/bigquery"
+)
+
+const auditOutcomeDryRun = "dry_run"
+
+// dryRunExtractor finishes a dry run of a table backup that passed every check. It builds the
+// extractor the backup would run, without running it, logs what it would write and returns it.
+func (bp *backupParams) dryRunExtractor() *bigquery.Extractor {
+	extractor := setupExtractor(bp)
+	_ = bp.logInfo(fmt.Sprintf("Dry run of table %s.%s passed, the backup would export it as %s with %s compression to %s", bp.sourceDatasetID, bp.backupTableID, extractor.Dst.DestinationFormat, bp.compressionType, bp.destinationURI()))
+	return extractor
+}
+
+// successOutcome returns the audit outcome of a request whose backups all passed: dry_run for a
+// dry run, which did not back anything up, and success otherwise.
+func (bp *backupParams) successOutcome() string {
+	if bp.dryRun {
+		return auditOutcomeDryRun
+	}
+	return auditOutcomeSuccess
+}
@@ -0,0 +1,32 @@
+package bigquerybackup
+
+import (
+	"testing"
+	"time"
+
+	"cloud.google.com/go/bigquery"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDryRunExtractor(t *testing.T) {
+	bp := &backupParams{
+		projectID:         "p",
+		sourceDatasetID:   "ds",
+		backupTableID:     "t",
+		storageBucket:     "backups",
+		destinationFormat: avroFormat,
+		compressionType:   snappyCompression,
+		backupTime:        time.Date(2024, 1, 15, 2, 0, 0, 0, time.UTC),
+		dryRun:            true,
+	}
+	extractor := bp.dryRunExtractor()
+	assert.Equal(t, bigquery.Avro, extractor.Dst.DestinationFormat)
+	assert.Equal(t, bigquery.Compression(snappyCompression), extractor.Dst.Compression)
+	assert.Equal(t, []string{bp.extractURI()}, extractor.Dst.URIs)
+	assert.Equal(t, "t", extractor.Src.TableID)
+}
+
+func TestSuccessOutcome(t *testing.T) {
+	assert.Equal(t, auditOutcomeSuccess, (&backupParams{}).successOutcome())
+	assert.Equal(t, auditOutcomeDryRun, (&backupParams{dryRun: true}).successOutcome())
+}
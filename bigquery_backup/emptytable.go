@@ -0,0 +1,37 @@
+package bigquerybackup
+
+import (
+	"context"
+	"fmt"
+
+	"cloud.google.com/go/bigquery"
+)
+
+// tableRows returns the number of rows of the table described by md, including the rows still
+// in its streaming buffer, which NumRows leaves out.
+func tableRows(md *bigquery.TableMetadata) uint64 {
+	rows := md.NumRows
+	if md.StreamingBuffer != nil {
+		rows += md.StreamingBuffer.EstimatedRows
+	}
+	return rows
+}
+
+// checkEmptyTable records whether the exported table, or partition for a partition backup, has
+// no rows. Depending on the format the export of an empty table writes no file or a single file
+// without data, which the checks after the export then accept as a complete backup. The manifest
+// marks the backup as empty.
+func (bp *backupParams) checkEmptyTable(ctx context.Context) error {
+	rows, err := bp.sourceRows(ctx)
+	if err != nil {
+		return err
+	}
+	if rows > 0 {
+		return nil
+	}
+	bp.emptyTable = true
+	if bp.partition != "" {
+		return bp.logInfo(fmt.Sprintf("Partition %s of table %s.%s is empty, backup produced no data files", bp.partition, bp.sourceDatasetID, bp.backupTableID))
+	}
+	return bp.logInfo(fmt.Sprintf("Table %s.%s is empty, backup produced no data files", bp.sourceDatasetID, bp.backupTableID))
+}
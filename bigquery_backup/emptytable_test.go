@@ -0,0 +1,115 @@
+package bigquerybackup
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/bigquery"
+	"cloud.google.com/go/storage"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTableRows(t *testing.T) {
+	assert.Equal(t, uint64(0), tableRows(&bigquery.TableMetadata{}))
+	assert.Equal(t, uint64(10), tableRows(&bigquery.TableMetadata{NumRows: 10}))
+	assert.Equal(t, uint64(3), tableRows(&bigquery.TableMetadata{StreamingBuffer: &bigquery.StreamingBuffer{EstimatedRows: 3}}))
+}
+
+func TestCheckEmptyTable(t *testing.T) {
+	tests := []struct {
+		name          string
+		md            *bigquery.TableMetadata
+		partition     string
+		partitionRows uint64
+		wantEmpty     bool
+	}{
+		{name: "Empty", md: &bigquery.TableMetadata{}, wantEmpty: true},
+		{name: "Rows", md: &bigquery.TableMetadata{NumRows: 100}},
+		{name: "Only streaming buffer", md: &bigquery.TableMetadata{StreamingBuffer: &bigquery.StreamingBuffer{EstimatedRows: 5}}},
+		{name: "Empty partition of a table with rows", md: &bigquery.TableMetadata{NumRows: 100}, partition: "20240115", wantEmpty: true},
+		{name: "Partition with rows", md: &bigquery.TableMetadata{NumRows: 100}, partition: "20240115", partitionRows: 40},
+	}
+
+	orig := queryPartitionStats
+	defer func() { queryPartitionStats = orig }()
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			queryPartitionStats = func(ctx context.Context, projectID, datasetID, tableID, partition string) (partitionStats, error) {
+				assert.Equal(t, "p", projectID)
+				assert.Equal(t, "ds", datasetID)
+				assert.Equal(t, "t", tableID)
+				assert.Equal(t, tt.partition, partition)
+				return partitionStats{rows: tt.partitionRows}, nil
+			}
+			bp := &backupParams{projectID: "p", sourceDatasetID: "ds", backupTableID: "t", partition: tt.partition, cache: newMetadataCache()}
+			_, _ = bp.cache.table("p:ds.t", func() (*bigquery.TableMetadata, error) {
+				return tt.md, nil
+			})
+			assert.NoError(t, bp.checkEmptyTable(context.Background()))
+			assert.Equal(t, tt.wantEmpty, bp.emptyTable)
+		})
+	}
+}
+
+func TestSourcePartitionStatsQueriedOnce(t *testing.T) {
+	orig := queryPartitionStats
+	defer func() { queryPartitionStats = orig }()
+	calls := 0
+	queryPartitionStats = func(ctx context.Context, projectID, datasetID, tableID, partition string) (partitionStats, error) {
+		calls++
+		return partitionStats{rows: 7}, nil
+	}
+
+	bp := &backupParams{projectID: "p", sourceDatasetID: "ds", backupTableID: "t", partition: "20240115"}
+	for i := 0; i < 2; i++ {
+		rows, err := bp.sourceRows(context.Background())
+		assert.NoError(t, err)
+		assert.Equal(t, uint64(7), rows)
+	}
+	assert.Equal(t, 1, calls)
+
+	queryPartitionStats = func(ctx context.Context, projectID, datasetID, tableID, partition string) (partitionStats, error) {
+		return partitionStats{}, errors.New("access denied")
+	}
+	bp = &backupParams{projectID: "p", sourceDatasetID: "ds", backupTableID: "t", partition: "20240115"}
+	_, err := bp.sourceRows(context.Background())
+	assert.ErrorContains(t, err, "partition 20240115")
+}
+
+func TestEmptyTableVerification(t *testing.T) {
+	bp := &backupParams{
+		projectID:         "p",
+		sourceDatasetID:   "ds",
+		backupTableID:     "t",
+		storageBucket:     "backups",
+		destinationFormat: avroFormat,
+		compressionType:   snappyCompression,
+		runID:             "run-1",
+		backupTime:        time.Date(2024, 1, 15, 10, 0, 0, 0, time.UTC),
+		cache:             newMetadataCache(),
+	}
+	md := &bigquery.TableMetadata{}
+	_, _ = bp.cache.table("p:ds.t", func() (*bigquery.TableMetadata, error) {
+		return md, nil
+	})
+	assert.NoError(t, bp.checkEmptyTable(context.Background()))
+
+	// The export of an empty table may write no shard at all, or one without data.
+	emptyShard := &storage.ObjectAttrs{Name: "ds/t.20240115T100000Z/t-000000000000.avro"}
+	assert.NoError(t, verifyShards(tableRows(md), 0, nil))
+	assert.NoError(t, verifyShards(tableRows(md), 1, []*storage.ObjectAttrs{emptyShard}))
+
+	bp.stagedPublish = true
+	assert.NoError(t, bp.verifyStagedShards(nil, 0))
+	bp.stagedPublish = false
+
+	data, err := json.Marshal(bp.newManifest(md.NumRows, md.NumBytes, nil))
+	assert.NoError(t, err)
+	assert.Contains(t, string(data), `"row_count":0`)
+	assert.Contains(t, string(data), `"empty":true`)
+	assert.Contains(t, string(data), `"objects":[]`)
+}
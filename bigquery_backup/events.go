@@ -0,0 +1,81 @@
+package bigquerybackup
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"cloud.google.com/go/logging"
+	"cloud.google.com/go/pubsub"
+)
+
+const backupStartedEvent = "backup_started"
+
+// backupStartedPayload marks a backup whose extract job has been submitted but not yet waited
+// on. Dashboards can pair it with the completion log line to show in-progress backups and to
+// find jobs that started but never finished.
+type backupStartedPayload struct {
+	Event          string    `json:"event"`
+	ProjectID      string    `json:"project_id"`
+	DatasetName    string    `json:"dataset_name"`
+	TableName      string    `json:"table_name"`
+	DestinationURI string    `json:"destination_uri"`
+	JobID          string    `json:"job_id"`
+	RunID          string    `json:"run_id"`
+	StartedAt      time.Time `json:"started_at"`
+}
+
+// newBackupStartedPayload describes the backup that was just submitted.
+func (bp *backupParams) newBackupStartedPayload() backupStartedPayload {
+	return backupStartedPayload{
+		Event:          backupStartedEvent,
+		ProjectID:      bp.sourceProjectID(),
+		DatasetName:    bp.sourceDatasetID,
+		TableName:      bp.backupTableID,
+		DestinationURI: bp.destinationURI(),
+		JobID:          bp.jobID,
+		RunID:          bp.runID,
+		StartedAt:      time.Now().UTC(),
+	}
+}
+
+// emitBackupStarted writes a structured "backup started" log entry and, when started_topic was
+// requested, publishes the same payload to that Pub/Sub topic. It is called once the extract
+// job exists and before waiting on it. Failures are logged and never fail the backup.
+func (bp *backupParams) emitBackupStarted(ctx context.Context) {
+	payload := bp.newBackupStartedPayload()
+	_ = bp.logEvent(logging.Info, payload)
+
+	if bp.startedTopic == "" {
+		return
+	}
+	data, err := json.Marshal(payload)
+	if err != nil {
+		_ = bp.logError(fmt.Sprintf("Failed to encode backup started event: %v", err))
+		return
+	}
+	ctx, cancel := context.WithTimeout(ctx, triggerTimeout)
+	defer cancel()
+	if err := publishToTopic(ctx, bp.projectID, bp.startedTopic, data); err != nil {
+		_ = bp.logError(fmt.Sprintf("Failed to publish backup started event to %s: %v", bp.startedTopic, err))
+	}
+}
+
+// publishToTopic publishes one message to topic, a topic ID in projectID or a full
+// "projects/<project>/topics/<topic>" name, and waits for the server to accept it.
+func publishToTopic(ctx context.Context, projectID, topic string, data []byte) error {
+	project, topicID, err := parseTopic(topic, projectID)
+	if err != nil {
+		return err
+	}
+	c, err := pubsub.NewClient(ctx, projectID)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+	t := c.TopicInProject(topicID, project)
+	defer t.Stop()
+	_, err = t.Publish(ctx, &pubsub.Message{Data: data}).Get(ctx)
+	return err
+}
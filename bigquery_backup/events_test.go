@@ -0,0 +1,28 @@
+package bigquerybackup
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewBackupStartedPayload(t *testing.T) {
+	bp := &backupParams{
+		projectID:         "test-project",
+		sourceDatasetID:   "ds",
+		backupTableID:     "t",
+		storageBucket:     "bucket",
+		destinationFormat: avroFormat,
+		backupTime:        time.Date(2024, 1, 15, 0, 0, 0, 0, time.UTC),
+		jobID:             "job-1",
+		runID:             "run-1",
+	}
+
+	p := bp.newBackupStartedPayload()
+	assert.Equal(t, backupStartedEvent, p.Event)
+	assert.Equal(t, "job-1", p.JobID)
+	assert.Equal(t, "run-1", p.RunID)
+	assert.Equal(t, "gs://bucket/ds/t.20240115T000000Z/t-*.avro", p.DestinationURI)
+	assert.False(t, p.StartedAt.IsZero())
+}
@@ -0,0 +1,79 @@
+package bigquerybackup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+
+	"cloud.google.com/go/storage"
+)
+
+// bigQueryMaxFileBytes is the most BigQuery writes to one file of an export. A table larger
+// than that can only be exported to a URI with a wildcard.
+const bigQueryMaxFileBytes = 1 << 30
+
+// oversizedFile is a shard of the export that is larger than max_file_bytes.
+type oversizedFile struct {
+	Object string `json:"object"`
+	Size   int64  `json:"size"`
+}
+
+// checkMaxFileBytes validates the max_file_bytes field of the POST body.
+func checkMaxFileBytes(pb *postBodyParams) error {
+	if pb.MaxFileBytes < 0 {
+		return errors.New("max_file_bytes must not be negative")
+	}
+	return nil
+}
+
+// checkShardWildcard checks that the shard pattern holds exactly one wildcard, which BigQuery
+// replaces with the shard number. Without it an export of more than 1 GB fails, and with more
+// than one BigQuery rejects the URI.
+func checkShardWildcard(pattern string) error {
+	if strings.Count(pattern, "*") != 1 {
+		return fmt.Errorf("invalid shard name %q, must contain exactly one * wildcard for the shard number", pattern)
+	}
+	return nil
+}
+
+// oversizedShards returns the shards larger than maxBytes, by object name within the backup.
+func oversizedShards(shards []*storage.ObjectAttrs, maxBytes int64) []oversizedFile {
+	var oversized []oversizedFile
+	for _, attrs := range shards {
+		if attrs.Size > maxBytes {
+			oversized = append(oversized, oversizedFile{Object: path.Base(attrs.Name), Size: attrs.Size})
+		}
+	}
+	return oversized
+}
+
+// checkFileSizes records the shards of the export that are larger than max_file_bytes. BigQuery
+// decides how an export is split and takes no size limit, so the files cannot be kept under one;
+// instead the oversized ones are logged and reported with the result, and the backup still
+// succeeds. It does nothing when max_file_bytes is not set or is at least 1 GB, which no file
+// of an export exceeds.
+func (bp *backupParams) checkFileSizes(ctx context.Context) error {
+	if bp.maxFileBytes == 0 || bp.maxFileBytes >= bigQueryMaxFileBytes {
+		return nil
+	}
+	c, err := sharedStorageClient()
+	if err != nil {
+		return err
+	}
+
+	shards, err := bp.listShards(ctx, c, bp.outputPrefix())
+	if err != nil {
+		return fmt.Errorf("failed to list shards: %v", err)
+	}
+	bp.oversizedFiles = oversizedShards(shards, bp.maxFileBytes)
+	if len(bp.oversizedFiles) == 0 {
+		return nil
+	}
+	names := make([]string, len(bp.oversizedFiles))
+	for i, f := range bp.oversizedFiles {
+		names[i] = fmt.Sprintf("%s (%d bytes)", f.Object, f.Size)
+	}
+	return bp.logWarning(fmt.Sprintf("Backup of table %s.%s has %d of %d files larger than max_file_bytes %d: %s", bp.sourceDatasetID, bp.backupTableID, len(names), len(shards), bp.maxFileBytes, strings.Join(names, ", ")))
+}
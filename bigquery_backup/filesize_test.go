@@ -0,0 +1,61 @@
+package bigquerybackup
+
+import (
+	"context"
+	"testing"
+
+	"cloud.google.com/go/storage"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckMaxFileBytes(t *testing.T) {
+	assert.NoError(t, checkMaxFileBytes(&postBodyParams{}))
+	assert.NoError(t, checkMaxFileBytes(&postBodyParams{MaxFileBytes: 256 << 20}))
+	assert.EqualError(t, checkMaxFileBytes(&postBodyParams{MaxFileBytes: -1}), "max_file_bytes must not be negative")
+	assert.EqualError(t, checkAsync(&postBodyParams{TableName: "t", Async: true, MaxFileBytes: 1}), "async cannot be combined with max_file_bytes, the export has to be complete before its files can be measured")
+}
+
+func TestCheckShardWildcard(t *testing.T) {
+	tests := []struct {
+		name    string
+		pattern string
+		wantErr bool
+	}{
+		{name: "One wildcard", pattern: "orders-*.avro"},
+		{name: "No wildcard", pattern: "orders.avro", wantErr: true},
+		{name: "Two wildcards", pattern: "orders-*-*.avro", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkShardWildcard(tt.pattern)
+			assert.Equal(t, tt.wantErr, err != nil, err)
+		})
+	}
+}
+
+func TestValidateDestinationObjectNameWildcard(t *testing.T) {
+	bp := &backupParams{sourceDatasetID: "ds", backupTableID: "orders", storageBucket: "backups", destinationFormat: avroFormat}
+	assert.NoError(t, bp.validateDestinationObjectName())
+
+	bp.pathTemplate = "{dataset}/{table}/{table}.{format}"
+	assert.EqualError(t, bp.validateDestinationObjectName(), `invalid shard name "orders.avro", must contain exactly one * wildcard for the shard number`)
+}
+
+func TestOversizedShards(t *testing.T) {
+	shards := []*storage.ObjectAttrs{
+		{Name: "ds/orders.20240115T100000Z/orders-000000000000.avro", Size: 300 << 20},
+		{Name: "ds/orders.20240115T100000Z/orders-000000000001.avro", Size: 256 << 20},
+		{Name: "ds/orders.20240115T100000Z/orders-000000000002.avro", Size: 12},
+	}
+	assert.Equal(t, []oversizedFile{{Object: "orders-000000000000.avro", Size: 300 << 20}}, oversizedShards(shards, 256<<20))
+	assert.Nil(t, oversizedShards(shards, 1<<30))
+}
+
+func TestCheckFileSizesSkipsWithoutLimit(t *testing.T) {
+	for _, limit := range []int64{0, bigQueryMaxFileBytes, 2 << 30} {
+		bp := &backupParams{maxFileBytes: limit}
+		assert.NoError(t, bp.checkFileSizes(context.Background()))
+		assert.Nil(t, bp.oversizedFiles)
+	}
+}
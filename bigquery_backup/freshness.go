@@ -0,0 +1,50 @@
+package bigquerybackup
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+const skipReasonStaleSource = "stale_source"
+
+// skippedResponse is the body returned when a backup is deliberately not run.
+type skippedResponse struct {
+	Status string `json:"status"`
+	Reason string `json:"reason"`
+	RunID  string `json:"run_id,omitempty"`
+}
+
+// isStale reports whether a table last modified at lastModified falls outside window at now.
+func isStale(lastModified, now time.Time, window time.Duration) bool {
+	return now.Sub(lastModified) > window
+}
+
+// checkFreshness enforces require_updated_within_hours. It returns true when the backup
+// should go ahead, false when a stale source table should be skipped, and an error when the
+// table is stale and fail_if_stale was requested. Without require_updated_within_hours every
+// table is fresh.
+func (bp *backupParams) checkFreshness(ctx context.Context) (bool, error) {
+	if bp.requireUpdatedWithinHours <= 0 {
+		return true, nil
+	}
+	md, err := bp.tableMetadata(ctx)
+	if err != nil {
+		return false, err
+	}
+	window := time.Duration(bp.requireUpdatedWithinHours) * time.Hour
+	if !isStale(md.LastModifiedTime, time.Now(), window) {
+		return true, nil
+	}
+	msg := fmt.Sprintf("table %s.%s was last modified at %s, more than %d hours ago", bp.sourceDatasetID, bp.backupTableID, md.LastModifiedTime.UTC().Format(time.RFC3339), bp.requireUpdatedWithinHours)
+	if bp.failIfStale {
+		return false, fmt.Errorf("source is stale: %s", msg)
+	}
+	bp.skipReason = skipReasonStaleSource
+	return false, bp.logInfo(fmt.Sprintf("Skipping backup, %s", msg))
+}
+
+// newSkippedResponse returns the body answering a request that was skipped for reason.
+func newSkippedResponse(reason string) skippedResponse {
+	return skippedResponse{Status: "skipped", Reason: reason}
+}
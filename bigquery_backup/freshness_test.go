@@ -0,0 +1,72 @@
+package bigquerybackup
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/bigquery"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsStale(t *testing.T) {
+	now := time.Date(2024, 1, 15, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name         string
+		lastModified time.Time
+		want         bool
+	}{
+		{name: "Modified recently", lastModified: now.Add(-time.Hour), want: false},
+		{name: "At the edge of the window", lastModified: now.Add(-24 * time.Hour), want: false},
+		{name: "Outside the window", lastModified: now.Add(-25 * time.Hour), want: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, isStale(tt.lastModified, now, 24*time.Hour))
+		})
+	}
+}
+
+func TestCheckFreshness(t *testing.T) {
+	newParams := func(lastModified time.Time, hours int, failIfStale bool) *backupParams {
+		bp := &backupParams{
+			projectID:                 "p",
+			sourceDatasetID:           "ds",
+			backupTableID:             "t",
+			requireUpdatedWithinHours: hours,
+			failIfStale:               failIfStale,
+			cache:                     newMetadataCache(),
+		}
+		_, _ = bp.cache.table("p:ds.t", func() (*bigquery.TableMetadata, error) {
+			return &bigquery.TableMetadata{LastModifiedTime: lastModified}, nil
+		})
+		return bp
+	}
+	ctx := context.Background()
+
+	ok, err := newParams(time.Now().Add(-time.Hour), 2, true).checkFreshness(ctx)
+	assert.True(t, ok)
+	assert.NoError(t, err)
+
+	ok, err = newParams(time.Now().Add(-48*time.Hour), 0, true).checkFreshness(ctx)
+	assert.True(t, ok, "no window configured")
+	assert.NoError(t, err)
+
+	ok, err = newParams(time.Now().Add(-48*time.Hour), 24, true).checkFreshness(ctx)
+	assert.False(t, ok)
+	assert.Error(t, err)
+
+	bp := newParams(time.Now().Add(-48*time.Hour), 24, false)
+	ok, err = bp.checkFreshness(ctx)
+	assert.False(t, ok)
+	assert.NoError(t, err)
+	assert.Equal(t, skipReasonStaleSource, bp.skipReason)
+}
+
+func TestSkippedResponse(t *testing.T) {
+	rec := httptest.NewRecorder()
+	writeJSON(rec, 200, newSkippedResponse(skipReasonStaleSource))
+	assert.JSONEq(t, `{"status":"skipped","reason":"stale_source"}`, rec.Body.String())
+}
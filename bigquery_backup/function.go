@@ -8,14 +8,17 @@ import (
 	"log"
 	"net/http"
 	"os"
+	"path"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
+	"unicode/utf8"
 
 	"cloud.google.com/go/bigquery"
 	"cloud.google.com/go/logging"
-	"cloud.google.com/go/storage"
 	"github.com/GoogleCloudPlatform/functions-framework-go/functions"
+	"github.com/google/uuid"
 )
 
 const (
@@ -24,142 +27,605 @@ const (
 	avroFormat    = "AVRO"
 	parquetFormat = "PARQUET"
 
+	noneCompression    = "NONE"
 	gzipCompression    = "GZIP"
 	snappyCompression  = "SNAPPY"
 	zstdCompression    = "ZSTD"
 	deflateCompression = "DEFLATE"
 )
 
+// formatCompression describes the compression of one export format: the compression used when
+// a request names none and the compression types a request may ask for.
+type formatCompression struct {
+	defaultCompression string
+	allowed            []string
+}
+
+// builtinFormatCompressions lists the compression types BigQuery can apply to each export
+// format, and the default of each. FORMAT_DEFAULTS may narrow them, see formatDefaults.
+var builtinFormatCompressions = map[string]formatCompression{
+	csvFormat:     {defaultCompression: gzipCompression, allowed: []string{noneCompression, gzipCompression}},
+	jsonFormat:    {defaultCompression: gzipCompression, allowed: []string{noneCompression, gzipCompression}},
+	avroFormat:    {defaultCompression: snappyCompression, allowed: []string{noneCompression, deflateCompression, snappyCompression}},
+	parquetFormat: {defaultCompression: snappyCompression, allowed: []string{noneCompression, gzipCompression, snappyCompression, zstdCompression}},
+}
+
 type backupParams struct {
-	projectID         string
-	sourceDatasetID   string
-	backupTableID     string
-	storageBucket     string
-	compressionType   string
-	destinationFormat string
+	projectID                 string
+	sourceProject             string
+	sourceDatasetID           string
+	location                  string
+	maxFileBytes              int64
+	oversizedFiles            []oversizedFile
+	generateSignedURLs        bool
+	signedURLTTLMinutes       int
+	signedURLs                []signedURL
+	emptyTable                bool
+	nestedCSV                 string
+	export                    *exportFormat
+	warnings                  []string
+	backupTableID             string
+	storageBucket             string
+	compressionType           string
+	destinationFormat         string
+	maxOutputBytes            int64
+	batchMaxBytes             int64
+	budget                    *byteBudget
+	writeLoadConfig           bool
+	backupTime                time.Time
+	runID                     string
+	jobID                     string
+	idempotencyKey            string
+	sketchColumn              string
+	sketchHLL                 bool
+	successTrigger            *successTrigger
+	sanityCheck               *sanityCheck
+	snapshotDataset           string
+	snapshotRetentionDays     int
+	snapshotTable             string
+	startedTopic              string
+	stagedPublish             bool
+	markBackupParts           bool
+	checkShardSchemasEnabled  bool
+	requireUpdatedWithinHours int
+	overwrite                 bool
+	failIfStale               bool
+	incremental               bool
+	skipReason                string
+	tables                    []string
+	allowViews                bool
+	materializeView           bool
+	dryRun                    bool
+	snapshotTime              time.Time
+	catalogTable              string
+	printHeader               bool
+	fieldDelimiter            string
+	useAvroLogicalTypes       bool
+	verify                    bool
+	notifyTopic               string
+	webhookURL                string
+	async                     bool
+	bucketProject             string
+	includeSchema             bool
+	query                     string
+	queryTable                string
+	partition                 string
+	partitionStats            *partitionStats
+	kmsKeyName                string
+	pathTemplate              string
+	storageClass              string
+	bucketStorageClass        string
+	labels                    map[string]string
+	secondaryBucket           string
+	mirror                    *mirrorResult
+	extractFileCount          int64
+	jobStats                  *bigquery.JobStatistics
+	bytesExported             int64
+	caller                    string
+	cache                     *metadataCache
+	metadata                  datasetMetadataGetter
+	extractor                 tableExtractor
+	jobs                      extractJobGetter
+	objects                   objectLister
+	extractJobID              string
+	jobReused                 bool
+	jobTimedOut               bool
+	backupResult              *BackupResult
+	reusedJobURI              string
+	validated                 bool
+	validationErr             error
 }
 
 type postBodyParams struct {
-	DatasetName   string `json:"dataset_name"`
-	TableName     string `json:"table_name"`
-	StorageBucket string `json:"storage_bucket"`
-	Format        string `json:"destination_format"`
-	Compression   string `json:"compression_type"`
+	DatasetName               string            `json:"dataset_name"`
+	TableName                 string            `json:"table_name"`
+	Tables                    []string          `json:"tables"`
+	AllowViews                bool              `json:"allow_views"`
+	DryRun                    bool              `json:"dry_run"`
+	SnapshotTime              string            `json:"snapshot_time"`
+	CatalogTable              string            `json:"catalog_table"`
+	StorageBucket             string            `json:"storage_bucket"`
+	Format                    string            `json:"destination_format"`
+	Compression               string            `json:"compression_type"`
+	MaxOutputBytes            int64             `json:"max_output_bytes"`
+	BatchMaxBytes             int64             `json:"batch_max_bytes"`
+	WriteLoadConfig           bool              `json:"write_load_config"`
+	IdempotencyKey            string            `json:"idempotency_key"`
+	SketchColumn              string            `json:"sketch_column"`
+	SketchHLL                 bool              `json:"sketch_hll"`
+	OnSuccessTrigger          *successTrigger   `json:"on_success_trigger"`
+	SanityCheck               *sanityCheck      `json:"sanity_check"`
+	SnapshotDataset           string            `json:"snapshot_dataset"`
+	SnapshotRetentionDays     int               `json:"snapshot_retention_days"`
+	StartedTopic              string            `json:"started_topic"`
+	StagedPublish             bool              `json:"staged_publish"`
+	MarkBackupParts           bool              `json:"mark_backup_parts"`
+	CheckShardSchemas         bool              `json:"check_shard_schemas"`
+	RequireUpdatedWithinHours int               `json:"require_updated_within_hours"`
+	FailIfStale               bool              `json:"fail_if_stale"`
+	Incremental               bool              `json:"incremental"`
+	Overwrite                 bool              `json:"overwrite"`
+	AllowOverwrite            bool              `json:"allow_overwrite"`
+	PrintHeader               bool              `json:"print_header"`
+	NestedCSV                 string            `json:"nested_csv"`
+	FieldDelimiter            string            `json:"field_delimiter"`
+	UseAvroLogicalTypes       bool              `json:"use_avro_logical_types"`
+	Verify                    bool              `json:"verify"`
+	NotifyTopic               string            `json:"notify_topic"`
+	WebhookURL                string            `json:"webhook_url"`
+	Async                     bool              `json:"async"`
+	BucketProject             string            `json:"bucket_project"`
+	SourceProject             string            `json:"source_project"`
+	Location                  string            `json:"location"`
+	MaxFileBytes              int64             `json:"max_file_bytes"`
+	GenerateSignedURLs        bool              `json:"generate_signed_urls"`
+	SignedURLTTLMinutes       int               `json:"signed_url_ttl_minutes"`
+	IncludeSchema             *bool             `json:"include_schema"`
+	Query                     string            `json:"query"`
+	QueryName                 string            `json:"query_name"`
+	Partition                 string            `json:"partition"`
+	KMSKeyName                string            `json:"kms_key_name"`
+	PathTemplate              string            `json:"path_template"`
+	StorageClass              string            `json:"storage_class"`
+	Labels                    map[string]string `json:"labels"`
+	SecondaryBucket           string            `json:"secondary_bucket"`
 }
 
+// bc is shared by every request handled by this instance and is kept open between requests.
 var bc *bigquery.Client
+var bcErr error
 var bcOnce sync.Once
 
 func init() {
-	functions.HTTP("BigQueryBackup", bigQueryBackup)
+	functions.HTTP("BigQueryBackup", trackHTTP(bigQueryBackup))
 }
 
-// bigQueryBackup is an HTTP function that handles a request to back up a BigQuery table to cloud storage.
-// It sets up the necessary clients, validates the input parameters, and then calls the backupBigQueryTable
-// function to perform the actual backup. If the backup is successful, it returns a success response.
-// If there are any errors, it logs the error and returns an error response.
+// bigQueryBackup is an HTTP function that handles a request to back up a BigQuery table, or every table
+// of a dataset, to cloud storage. It decodes the POST body and hands the request to serveBackup, which
+// validates the input parameters and performs the backup. If the backup is successful, it responds with 200
+// and the job ID, or with 202 as soon as the extract job has started for an async backup.
+// If there are any errors, it logs the error and responds with a JSON error: 400 for an invalid request,
+// 404 when the dataset, table or bucket cannot be found, and 500 when the backup itself fails.
+// GET requests are health and readiness probes answered by serveHealth.
 func bigQueryBackup(w http.ResponseWriter, r *http.Request) {
-
-	backupParams := backupParams{}
-	err := backupParams.setProjectID()
-	if err != nil {
+	if r.Method == http.MethodGet {
+		serveHealth(w, r)
 		return
 	}
-	ctx := context.Background()
 
-	err = backupParams.setBigQueryClient(ctx)
-	if err != nil {
+	backupParams := backupParams{runID: uuid.NewString(), cache: newMetadataCache()}
+	if err := backupParams.setProjectID(); err != nil {
+		rejectMissingProject(w, err)
 		return
 	}
-
-	hasError := backupParams.handleSetup(r)
-	if hasError {
+	ctx, cancel := requestContext(r)
+	defer cancel()
+	defer func() {
+		_ = backupParams.flushLogs()
+	}()
+	w.Header().Set(runIDHeader, backupParams.runID)
+	if !backupParams.authorize(w, r) {
 		return
 	}
 
-	hasError = backupParams.validateParams(ctx)
-	if hasError {
-		return
+	code, body := backupParams.serveBackup(ctx, func() error {
+		return backupParams.handleSetup(ctx, w, r)
+	})
+	writeJSON(w, code, body)
+}
+
+// serveBackup runs a backup request for either entry point and returns the HTTP status and body
+// to answer it with. setup reads the request into the backup parameters; an error from it is
+// answered with 400, or 413 for a body that is too large. It claims the idempotency key, backs up the table or the whole dataset,
+// and writes the audit entry for the outcome. A single table is backed up by backup, like
+// Backup does.
+func (bp *backupParams) serveBackup(ctx context.Context, setup func() error) (code int, body interface{}) {
+	outcome := auditOutcomeFailure
+	defer func() {
+		_ = bp.logAudit(ctx, outcome)
+	}()
+
+	if err := bp.warmUpClients(ctx); err != nil {
+		return http.StatusInternalServerError, bp.errorResponse(http.StatusInternalServerError, err.Error())
 	}
 
-	if ok, err := backupParams.checkBackupFormat(); !ok || err != nil {
-		err = backupParams.logError("Problem validating destination format")
-		if err != nil {
-			return
-		}
-		return
+	if err := setup(); err != nil {
+		code := bodyErrorStatus(err)
+		return code, bp.errorResponse(code, err.Error())
 	}
 
-	if ok, err := backupParams.backupBigQueryTable(ctx); !ok {
+	// A request that fails validation is not claimed, so that it writes nothing to the bucket;
+	// the backup below answers it with the recorded validation error.
+	if bp.idempotencyKey != "" && !bp.dryRun && bp.validateRequest(ctx) == nil {
+		claimed, rec, err := bp.claimIdempotencyKey(ctx)
 		if err != nil {
-			err = backupParams.logError("Problem backing up BigQuery table")
-			if err != nil {
-				return
+			_ = bp.logError(fmt.Sprintf("Problem claiming idempotency key: %v", err))
+			return http.StatusInternalServerError, bp.errorResponse(http.StatusInternalServerError, fmt.Sprintf("problem claiming idempotency key: %v", err))
+		}
+		if !claimed {
+			outcome = auditOutcomeDuplicate
+			_ = bp.logInfo(fmt.Sprintf("Idempotency key is already %s by run %s, jobID: %s, skipping backup", rec.Status, rec.RunID, rec.JobID))
+			if rec.Status == idempotencyRunning {
+				return http.StatusConflict, bp.errorResponse(http.StatusConflict, fmt.Sprintf("a backup with this idempotency key is already running as run %s", rec.RunID))
 			}
+			return rec.replay()
+		}
+		defer func() {
+			status := ""
+			switch outcome {
+			case auditOutcomeSuccess:
+				status = idempotencyCompleted
+			case auditOutcomeStarted:
+				status = idempotencyStarted
+			}
+			if err := bp.finishIdempotencyKey(context.WithoutCancel(ctx), status, body); err != nil {
+				_ = bp.logError(fmt.Sprintf("Problem recording idempotency key: %v", err))
+			}
+		}()
+	}
+
+	if bp.isTableListBackup() {
+		resp := bp.backupTables(ctx)
+		if resp.Code == 0 {
+			outcome = bp.successOutcome()
 		}
+		return resp.httpStatus(), resp
+	}
+
+	if bp.isDatasetBackup() {
+		resp := bp.backupDataset(ctx)
+		if resp.Code == 0 {
+			outcome = bp.successOutcome()
+		}
+		return resp.httpStatus(), resp
+	}
+
+	res, err := bp.backup(ctx)
+	switch {
+	case errors.Is(err, errJobStillRunning):
+		outcome = auditOutcomeStarted
+		return http.StatusAccepted, bp.startedResponse()
+	case err != nil:
+		return statusCode(err), bp.errorResponse(statusCode(err), err.Error())
+	case bp.skipReason != "":
+		outcome = auditOutcomeSkipped
+		resp := newSkippedResponse(bp.skipReason)
+		resp.RunID = bp.runID
+		return http.StatusOK, resp
+	case bp.dryRun:
+		outcome = auditOutcomeDryRun
+		return http.StatusOK, successResponse{Status: "ok", RunID: bp.runID, DestinationURI: res.DestinationURI, Export: bp.export, Warnings: bp.warnings, DryRun: true}
+	case bp.async && !bp.reusedJob():
+		outcome = auditOutcomeStarted
+		return http.StatusAccepted, bp.startedResponse()
+	}
+	outcome = auditOutcomeSuccess
+
+	if bp.reusedJob() {
+		return http.StatusOK, successResponse{Status: "ok", JobID: res.JobID, RunID: bp.runID, DestinationURI: res.DestinationURI}
+	}
+	if err := bp.fireSuccessTrigger(ctx); err != nil {
+		_ = bp.logError(fmt.Sprintf("Problem triggering next stage: %v", err))
+	}
+	return http.StatusOK, successResponse{
+		Status:         "ok",
+		JobID:          res.JobID,
+		RunID:          bp.runID,
+		DestinationURI: res.DestinationURI,
+		FileCount:      bp.extractFileCount,
+		PartialSuccess: bp.mirror.partial(),
+		Secondary:      bp.mirror,
+		OversizedFiles: bp.oversizedFiles,
+		SignedURLs:     bp.signedURLs,
+		Export:         bp.export,
+		Warnings:       bp.warnings,
+		Result:         bp.backupResult,
 	}
 }
 
-// backupBigQueryTable backs up the specified BigQuery table to cloud storage.
-// It sets up an extractor, runs the extractor, and waits for the job to complete.
-// If the backup is successful, it returns true. If there is an error, it returns false and the error.
-func (bp *backupParams) backupBigQueryTable(ctx context.Context) (bool, error) {
-	extractor := setupExtractor(bp)
+// runTableBackup validates and backs up a single table, then runs the requested checks and
+// writes the side files. An async backup returns as soon as the extract job has started. It
+// returns true when the table was skipped because it is stale or, with incremental, unchanged
+// since its last backup; bp.skipReason says which. Any failure is logged and returned as an
+// error carrying the HTTP status to report. The outcome of a finished or failed backup is
+// reported to Cloud Monitoring when ENABLE_METRICS is set and to Prometheus when
+// ENABLE_PROMETHEUS is set, sent to notify_topic and
+// webhook_url when they were requested, and written to the catalog table. A dry run stops once every check passed, before
+// anything is created, and reports nothing.
+func (bp *backupParams) runTableBackup(ctx context.Context) (skipped bool, err error) {
+	start := time.Now()
+	defer func() {
+		if bp.dryRun {
+			return
+		}
+		if err != nil || (!bp.async && !bp.jobTimedOut) {
+			bp.notifyBackupResult(ctx, skipped, err)
+			bp.callWebhook(ctx, skipped, err, time.Since(start))
+			bp.writeCatalogEntry(ctx, skipped, err)
+		}
+		if err != nil || (!skipped && !bp.async && !bp.jobTimedOut && !bp.reusedJob()) {
+			bp.emitBackupMetrics(ctx, err == nil, time.Since(start))
+		}
+		if err != nil || (!bp.async && !bp.jobTimedOut && !bp.reusedJob()) {
+			bp.observeBackup(skipped, err, time.Since(start))
+		}
+	}()
 
-	err := bp.logInfo(fmt.Sprintf("Starting backup of table %s.%s to cloud storage", bp.sourceDatasetID, bp.backupTableID))
-	if err != nil {
+	if err := bp.validateRequest(ctx); err != nil {
 		return false, err
 	}
 
-	job, err := bp.runExtractor(ctx, extractor)
-	if err != nil {
+	if reused, err := bp.resolveIdempotentJob(ctx); err != nil {
+		_ = bp.logError(fmt.Sprintf("Problem checking earlier backup jobs: %v", err))
 		return false, err
+	} else if reused {
+		return false, nil
+	}
+
+	if fresh, err := bp.checkFreshness(ctx); err != nil {
+		_ = bp.logError(fmt.Sprintf("Backup rejected: %v", err))
+		return false, withStatus(http.StatusPreconditionFailed, err)
+	} else if !fresh {
+		return true, nil
+	}
+
+	if changed, err := bp.checkChanged(ctx); err != nil {
+		_ = bp.logError(fmt.Sprintf("Problem checking for changes since the last backup: %v", err))
+		return false, fmt.Errorf("problem checking for changes since the last backup: %v", err)
+	} else if !changed {
+		return true, nil
+	}
+
+	if ok, err := bp.checkBackupFormat(); !ok || err != nil {
+		_ = bp.logError(fmt.Sprintf("Problem validating destination format: %v", err))
+		return false, withStatus(http.StatusBadRequest, fmt.Errorf("problem validating destination format: %v", err))
 	}
 
-	ok, err := bp.waitForJob(ctx, job)
-	if !ok {
+	if err := bp.validateDestinationObjectName(); err != nil {
+		_ = bp.logError(err.Error())
+		return false, withStatus(http.StatusBadRequest, err)
+	}
+
+	if err := bp.checkOverwrite(ctx); err != nil {
+		_ = bp.logError(fmt.Sprintf("Backup rejected: %v", err))
 		return false, err
 	}
-	return true, nil
-}
 
-// waitForJob waits for the provided BigQuery job to complete and logs the status.
-// It returns true if the job completed successfully, or false if there was an error.
-// If there is an error, it also returns the error.
-func (bp *backupParams) waitForJob(ctx context.Context, job *bigquery.Job) (bool, error) {
-	status, err := job.Wait(ctx)
+	if ok, err := bp.checkOutputQuota(ctx); !ok || err != nil {
+		_ = bp.logError(fmt.Sprintf("Backup rejected: %v", err))
+		return false, withStatus(http.StatusBadRequest, fmt.Errorf("backup rejected: %v", err))
+	}
+
+	if bp.dryRun {
+		bp.dryRunExtractor()
+		return false, nil
+	}
+
+	if err := bp.createSnapshot(ctx); err != nil {
+		_ = bp.logError(fmt.Sprintf("Problem creating snapshot: %v", err))
+		return false, fmt.Errorf("problem creating snapshot: %v", err)
+	}
+
+	if bp.async {
+		return false, bp.startAsyncBackup(ctx)
+	}
+
+	cleanupQuery, err := bp.runQuery(ctx)
 	if err != nil {
-		err := bp.logError(fmt.Sprintf("Error waiting for backup of table %s.%s to cloud storage: %v", bp.sourceDatasetID, bp.backupTableID, err))
-		if err != nil {
-			return false, err
+		_ = bp.logError(fmt.Sprintf("Problem running backup query: %v", err))
+		return false, fmt.Errorf("problem running backup query: %v", err)
+	}
+	defer func() {
+		// An export that outlasted JOB_TIMEOUT_SECONDS still reads the query's table, which
+		// is left to expire instead.
+		if !bp.jobTimedOut {
+			cleanupQuery()
 		}
-		return true, err
+	}()
+
+	defer bp.cleanupStaging(context.WithoutCancel(ctx))
+	bp.backupResult = bp.backupBigQueryTable(ctx)
+	if bp.jobTimedOut {
+		return false, nil
 	}
-	if status.Err() != nil {
-		err := bp.logError(fmt.Sprintf("Error backing up table %s.%s to cloud storage: %v", bp.sourceDatasetID, bp.backupTableID, status.Err()))
-		if err != nil {
-			return false, err
+	if errors.Is(bp.backupResult.Err, errRequestCancelled) {
+		return false, bp.backupResult.Err
+	}
+	if !bp.backupResult.Succeeded() {
+		forgetBucketWritable(bp.storageBucket)
+		_ = bp.logError("Problem backing up BigQuery table")
+		if conflict := bp.jobIDConflict(bp.backupResult.Err); conflict != nil {
+			return false, conflict
 		}
-		return true, err
+		return false, bp.kmsError(fmt.Errorf("problem backing up BigQuery table: %v", bp.backupResult.Err))
+	}
+
+	if err := bp.checkEmptyTable(ctx); err != nil {
+		_ = bp.logError(fmt.Sprintf("Problem checking for an empty table: %v", err))
+		return false, fmt.Errorf("problem checking for an empty table: %v", err)
+	}
+
+	if err := bp.verifyBackup(ctx); err != nil {
+		_ = bp.logError(fmt.Sprintf("Backup failed verification: %v", err))
+		return false, fmt.Errorf("backup failed verification: %v", err)
+	}
+
+	if err := bp.checkFileSizes(ctx); err != nil {
+		_ = bp.logError(fmt.Sprintf("Problem checking file sizes: %v", err))
+		return false, fmt.Errorf("problem checking file sizes: %v", err)
+	}
+
+	if err := bp.checkShardSchemas(ctx); err != nil {
+		_ = bp.logError(fmt.Sprintf("Backup failed shard schema check: %v", err))
+		return false, fmt.Errorf("backup failed shard schema check: %v", err)
+	}
+
+	if err := bp.runSanityCheck(ctx); err != nil {
+		_ = bp.logError(fmt.Sprintf("Backup failed sanity check: %v", err))
+		return false, fmt.Errorf("backup failed sanity check: %v", err)
+	}
+
+	if err := bp.writeLoadConfigObject(ctx); err != nil {
+		_ = bp.logError(fmt.Sprintf("Problem writing load config: %v", err))
+		return false, fmt.Errorf("problem writing load config: %v", err)
+	}
+
+	if err := bp.writeSchemaObject(ctx); err != nil {
+		_ = bp.logError(fmt.Sprintf("Problem writing schema: %v", err))
+		return false, fmt.Errorf("problem writing schema: %v", err)
+	}
+
+	if err := bp.writeQueryObject(ctx); err != nil {
+		_ = bp.logError(fmt.Sprintf("Problem writing query: %v", err))
+		return false, fmt.Errorf("problem writing query: %v", err)
+	}
+
+	if err := bp.writeManifest(ctx); err != nil {
+		_ = bp.logError(fmt.Sprintf("Problem writing manifest: %v", err))
+		return false, fmt.Errorf("problem writing manifest: %v", err)
+	}
+
+	if err := bp.writeSketch(ctx); err != nil {
+		_ = bp.logError(fmt.Sprintf("Problem writing sketch: %v", err))
+		return false, fmt.Errorf("problem writing sketch: %v", err)
 	}
-	err = bp.logInfo(fmt.Sprintf("Backup of table %s.%s completed successfully", bp.sourceDatasetID, bp.backupTableID))
+
+	if err := bp.applyStorageClass(ctx); err != nil {
+		_ = bp.logError(fmt.Sprintf("Problem setting storage class: %v", err))
+		return false, fmt.Errorf("problem setting storage class: %v", err)
+	}
+
+	if err := bp.publishStagedBackup(ctx); err != nil {
+		_ = bp.logError(fmt.Sprintf("Problem publishing staged backup: %v", err))
+		return false, fmt.Errorf("problem publishing staged backup: %v", err)
+	}
+
+	if err := bp.markPublishedParts(ctx); err != nil {
+		_ = bp.logError(fmt.Sprintf("Problem marking backup parts: %v", err))
+		return false, fmt.Errorf("problem marking backup parts: %v", err)
+	}
+
+	if err := bp.signBackupURLs(ctx); err != nil {
+		_ = bp.logError(fmt.Sprintf("Problem signing URLs: %v", err))
+		return false, fmt.Errorf("problem signing URLs: %v", err)
+	}
+
+	bp.mirrorBackup(ctx)
+	return false, nil
+}
+
+// backupBigQueryTable backs up the specified BigQuery table to cloud storage.
+// It starts the extract job and waits for it to complete, returning the result of the job.
+// The backup succeeded when the result has no error.
+func (bp *backupParams) backupBigQueryTable(ctx context.Context) *BackupResult {
+	start := time.Now()
+	job, err := bp.startBackup(ctx)
 	if err != nil {
-		return false, err
+		d := time.Since(start)
+		return &BackupResult{DestinationURIs: []string{bp.extractURI()}, Duration: d, SubmitDuration: d, Err: err}
 	}
-	return true, nil
+	submitted := time.Now()
+
+	res := bp.waitForJob(ctx, job)
+	res.SubmitDuration, res.WaitDuration = submitted.Sub(start), time.Since(submitted)
+	res.Duration = res.SubmitDuration + res.WaitDuration
+	_ = bp.logInfo(fmt.Sprintf("Backup of table %s.%s took %s: %s to submit extract job %s, %s waiting for it", bp.sourceDatasetID, bp.backupTableID, res.Duration.Round(time.Millisecond), res.SubmitDuration.Round(time.Millisecond), job.ID(), res.WaitDuration.Round(time.Millisecond)))
+	return res
+}
+
+// startBackup sets up and runs the extractor, and announces that the backup has started. It
+// returns the running extract job without waiting for it.
+func (bp *backupParams) startBackup(ctx context.Context) (jobWaiter, error) {
+	extractor := setupExtractor(bp)
+
+	err := bp.logInfo(fmt.Sprintf("Starting backup of table %s.%s to cloud storage", bp.sourceDatasetID, bp.backupTableID))
+	if err != nil {
+		return nil, err
+	}
+
+	job, err := bp.runExtractor(ctx, extractor)
+	if err != nil {
+		return nil, err
+	}
+	bp.emitBackupStarted(ctx)
+	return job, nil
+}
+
+// waitForJob waits for the provided BigQuery job to complete and logs the status. It returns the
+// result of the job, whose Err is set when waiting failed or the job itself failed. When the job
+// outlasts JOB_TIMEOUT_SECONDS the wait is given up and bp.jobTimedOut is set; the result is
+// then RUNNING with errJobStillRunning, and the job goes on without the request. When the
+// request is cancelled while waiting, the job is cancelled too and the result is CANCELLED with
+// errRequestCancelled.
+func (bp *backupParams) waitForJob(ctx context.Context, job jobWaiter) *BackupResult {
+	res := &BackupResult{JobID: job.ID(), DestinationURIs: []string{bp.extractURI()}}
+	waitCtx, cancel := withJobTimeout(ctx)
+	defer cancel()
+	status, err := job.Wait(waitCtx)
+	if status != nil {
+		res.State = jobState(status.State)
+	}
+	if status == nil && jobWaitTimedOut(ctx, waitCtx, err) {
+		bp.jobTimedOut = true
+		bp.logJobStillRunning(job.ID())
+		res.State, res.Err = jobStateRunning, errJobStillRunning
+		return res
+	}
+	if status == nil && ctx.Err() != nil {
+		res.State, res.Err = jobStateCancelled, bp.abandonJob(ctx, job, ctx.Err())
+		return res
+	}
+	if err != nil && status == nil {
+		_ = bp.logError(fmt.Sprintf("Error waiting for backup of table %s.%s to cloud storage: %v", bp.sourceDatasetID, bp.backupTableID, err))
+		res.Err = err
+		return res
+	}
+	if err != nil {
+		_ = bp.logError(fmt.Sprintf("Error backing up table %s.%s to cloud storage: %v", bp.sourceDatasetID, bp.backupTableID, err))
+		res.Err = err
+		return res
+	}
+	bp.jobStats = status.Statistics
+	if status.Statistics != nil {
+		res.BytesProcessed = status.Statistics.TotalBytesProcessed
+		if stats, ok := status.Statistics.Details.(*bigquery.ExtractStatistics); ok {
+			res.FileCounts = stats.DestinationURIFileCounts
+			bp.extractFileCount = res.FileCount()
+		}
+	}
+	_ = bp.logInfo(fmt.Sprintf("Backup of table %s.%s completed successfully, wrote %d files to %s", bp.sourceDatasetID, bp.backupTableID, res.FileCount(), strings.Join(res.DestinationURIs, ", ")))
+	return res
 }
 
 // runExtractor runs the provided BigQuery extractor and logs the start and job ID of the backup operation.
 // It returns the BigQuery job that was started, or an error if there was a problem starting the job.
-func (bp *backupParams) runExtractor(ctx context.Context, extractor *bigquery.Extractor) (*bigquery.Job, error) {
-	job, err := extractor.Run(ctx)
+func (bp *backupParams) runExtractor(ctx context.Context, extractor *bigquery.Extractor) (jobWaiter, error) {
+	job, err := bp.extractClient().runExtract(ctx, extractor)
 	if err != nil {
-		err = bp.logError(fmt.Sprintf("Error starting backup of table %s.%s to cloud storage: %v", bp.sourceDatasetID, bp.backupTableID, err))
-		if err != nil {
-			return nil, err
-		}
+		_ = bp.logError(fmt.Sprintf("Error starting backup of table %s.%s to cloud storage: %v", bp.sourceDatasetID, bp.backupTableID, err))
 		return nil, err
 	}
+	bp.jobID = job.ID()
 	err = bp.logInfo(fmt.Sprintf("Backup of table %s.%s started successfully, jobID: %s", bp.sourceDatasetID, bp.backupTableID, job.ID()))
 	if err != nil {
 		return nil, err
@@ -169,6 +635,26 @@ func (bp *backupParams) runExtractor(ctx context.Context, extractor *bigquery.Ex
 
 // Setup functions
 
+// requestContext returns the context a request runs under: the request's own context, which
+// the platform cancels when the caller goes away or the function times out, bounded by
+// REQUEST_TIMEOUT_SECONDS when it is set.
+func requestContext(r *http.Request) (context.Context, context.CancelFunc) {
+	if d := requestTimeout(); d > 0 {
+		return context.WithTimeout(r.Context(), d)
+	}
+	return context.WithCancel(r.Context())
+}
+
+// requestTimeout returns the overall deadline for a request read from the
+// REQUEST_TIMEOUT_SECONDS environment variable, or 0 when no deadline is configured.
+func requestTimeout() time.Duration {
+	secs, err := strconv.Atoi(strings.TrimSpace(os.Getenv("REQUEST_TIMEOUT_SECONDS")))
+	if err != nil || secs <= 0 {
+		return 0
+	}
+	return time.Duration(secs) * time.Second
+}
+
 // setProjectID sets the project ID for the backup parameters based on the
 // GCP_PROJECT environment variable. If the environment variable is not set or
 // is empty, it returns an error.
@@ -182,285 +668,713 @@ func (bp *backupParams) setProjectID() error {
 	return nil
 }
 
-// setBigQueryClient creates a new BigQuery client for the specified project ID.
-// It uses the bcOnce sync.Once to ensure the client is only created once, and
-// returns any error that occurred during the client creation process.
+// rejectMissingProject answers a request that cannot be served because GCP_PROJECT is not set
+// with 500. The operational log is written to that project, so the error is logged to standard
+// error instead, which Cloud Functions still collects.
+func rejectMissingProject(w http.ResponseWriter, err error) {
+	log.Printf("ERROR: rejected request: %v", err)
+	writeError(w, http.StatusInternalServerError, err.Error())
+}
+
+// newBigQueryClient creates the BigQuery client shared by every request. Tests replace it.
+var newBigQueryClient = func(ctx context.Context, projectID string) (*bigquery.Client, error) {
+	return bigquery.NewClient(ctx, projectID)
+}
+
+// setBigQueryClient creates the shared BigQuery client for the specified project ID.
+// It uses the bcOnce sync.Once to ensure the client is only created once per instance,
+// and returns the error from creating it, if any. The client is not tied to the request
+// context and is never closed, so warm instances reuse it across invocations.
 func (bp *backupParams) setBigQueryClient(ctx context.Context) error {
-	var err error
 	bcOnce.Do(func() {
-		bc, err = bigquery.NewClient(ctx, bp.projectID)
-		if err != nil {
-			err = bp.logError(fmt.Sprintf("Failed to create new BigQuery client: %v", err))
-			if err != nil {
-				return
-			}
+		bc, bcErr = newBigQueryClient(context.Background(), bp.projectID)
+		if bcErr != nil {
+			return
 		}
+		bc.Location = bigQueryLocation()
 	})
-	defer bc.Close()
+	if bcErr != nil {
+		_ = bp.logError(fmt.Sprintf("Failed to create new BigQuery client: %v", bcErr))
+		return fmt.Errorf("failed to create BigQuery client: %v", bcErr)
+	}
 	return nil
 }
 
-// handleSetup processes the incoming HTTP request, decodes the request body,
-// validates the required fields, and sets the backup parameters based on the
-// provided post body. It returns a boolean indicating whether an error occurred
-// during the setup process.
-func (bp *backupParams) handleSetup(r *http.Request) bool {
-	pb, err := decodePostBody(r)
+// handleSetup processes the incoming HTTP request, decodes the request body, and sets up the
+// backup parameters from it, taking the idempotency key from the Idempotency-Key header when
+// present. It logs and returns an error describing what is wrong with the request.
+func (bp *backupParams) handleSetup(ctx context.Context, w http.ResponseWriter, r *http.Request) error {
+	pb, err := decodePostBody(w, r)
 	if err != nil {
 		_ = bp.logError(fmt.Sprintf("Failed to decode POST body: %v", err))
-		return true
+		return fmt.Errorf("failed to decode POST body: %w", err)
 	}
+	if err := bp.setup(ctx, pb); err != nil {
+		return err
+	}
+	bp.setIdempotencyKey(r, pb)
+	return nil
+}
 
+// setup validates the required fields of the request body and sets the backup parameters from
+// it. It logs and returns an error describing what is wrong with the request.
+func (bp *backupParams) setup(ctx context.Context, pb postBodyParams) error {
 	if ok, err := bp.checkPostBody(&pb); !ok || err != nil {
 		_ = bp.logError(fmt.Sprintf("Invalid POST body: %v", err))
-		return true
+		return fmt.Errorf("invalid POST body: %v", err)
 	}
 
 	bp.setBackupParams(pb)
-	p := fmt.Sprintf("Backup params: %s, %s, %s, %s", bp.projectID, bp.sourceDatasetID, bp.backupTableID, bp.storageBucket)
-	err = bp.logInfo(p)
-	if err != nil {
-		_ = bp.logError(fmt.Sprintf("Failed to set backup params: %v", err))
-		return true
+	bp.idempotencyKey = strings.TrimSpace(pb.IdempotencyKey)
+	if format, _ := normalizeFormat(pb.Format); pb.UseAvroLogicalTypes && format != avroFormat {
+		_ = bp.logInfo(fmt.Sprintf("Ignoring use_avro_logical_types, it only applies to %s backups, not %s", avroFormat, format))
 	}
-	return false
+	if err := bp.resolveStorageBucket(ctx); err != nil {
+		_ = bp.logError(fmt.Sprintf("Invalid POST body: %v", err))
+		return fmt.Errorf("invalid POST body: %v", err)
+	}
+	p := fmt.Sprintf("Backup params: %s, %s, %s, %s", bp.sourceProjectID(), bp.sourceDatasetID, bp.backupTableID, bp.storageBucket)
+	return bp.logInfo(p)
 }
 
 // checkPostBody validates the required fields in the postBodyParams struct.
-// It checks that the DatasetName, TableName, and StorageBucket fields are
-// not empty. If any of these fields are missing, it logs an error and
-// returns false along with the error.
+// It checks that the DatasetName and StorageBucket fields are not empty, where
+// StorageBucket may be left out when DATASET_BUCKET_MAP is configured. An empty
+// TableName backs up the whole dataset, unless Tables lists the tables to back up, in which
+// case DatasetName may be left out when every entry names its dataset. If any of these fields are missing, it
+// returns false along with an error naming the missing field.
 func (bp *backupParams) checkPostBody(pb *postBodyParams) (bool, error) {
-	if pb.DatasetName == "" {
-		err := bp.logError("Missing DatasetName in Post Body")
+	if pb.DatasetName == "" && pb.Tables == nil {
+		return false, errors.New("missing dataset_name")
+	} else if pb.StorageBucket == "" && !datasetBucketMapConfigured() {
+		return false, errors.New("missing storage_bucket")
+	}
+	if pb.StorageBucket != "" {
+		if err := validateBucketName(pb.StorageBucket); err != nil {
+			return false, err
+		}
+	}
+	if pb.SanityCheck != nil {
+		if err := pb.SanityCheck.validate(); err != nil {
+			return false, err
+		}
+	}
+	format, err := normalizeFormat(pb.Format)
+	if err != nil {
+		return false, err
+	}
+	if pb.CheckShardSchemas && (format == csvFormat || format == jsonFormat) {
+		return false, fmt.Errorf("check_shard_schemas is only supported for %s and %s backups", avroFormat, parquetFormat)
+	}
+	if pb.PrintHeader && format != csvFormat {
+		return false, fmt.Errorf("print_header is only supported for %s backups", csvFormat)
+	}
+	if pb.FieldDelimiter != "" {
+		if format != csvFormat {
+			return false, fmt.Errorf("field_delimiter is only supported for %s backups", csvFormat)
+		}
+		if utf8.RuneCountInString(pb.FieldDelimiter) != 1 {
+			return false, fmt.Errorf("invalid field_delimiter %q, must be a single character", pb.FieldDelimiter)
+		}
+	}
+	if err := checkQuery(pb); err != nil {
 		return false, err
-	} else if pb.TableName == "" {
-		err := bp.logError("Missing TableName in Post Body")
+	}
+	if err := checkPartition(pb); err != nil {
+		return false, err
+	}
+	if err := checkKMSKeyName(strings.TrimSpace(pb.KMSKeyName)); err != nil {
 		return false, err
-	} else if pb.StorageBucket == "" {
-		err := bp.logError("Missing StorageBucket in Post Body")
+	}
+	if err := checkPathTemplate(strings.TrimSpace(pb.PathTemplate)); err != nil {
+		return false, err
+	}
+	if err := checkStorageClass(pb); err != nil {
 		return false, err
 	}
+	if err := checkLabels(pb.Labels); err != nil {
+		return false, err
+	}
+	if err := checkSecondaryBucket(pb); err != nil {
+		return false, err
+	}
+	if err := checkIncremental(pb); err != nil {
+		return false, err
+	}
+	if err := checkTables(pb); err != nil {
+		return false, err
+	}
+	if err := checkSnapshotTime(pb); err != nil {
+		return false, err
+	}
+	if err := checkCatalogTable(pb); err != nil {
+		return false, err
+	}
+	if err := checkSourceProject(pb); err != nil {
+		return false, err
+	}
+	if err := checkLocation(pb); err != nil {
+		return false, err
+	}
+	if err := checkMaxFileBytes(pb); err != nil {
+		return false, err
+	}
+	if err := checkMaxOutputBytes(pb); err != nil {
+		return false, err
+	}
+	if err := checkSignedURLs(pb); err != nil {
+		return false, err
+	}
+	if err := checkNestedCSV(pb); err != nil {
+		return false, err
+	}
+	if err := checkBatchMaxBytes(pb); err != nil {
+		return false, err
+	}
+	if pb.Async {
+		if err := checkAsync(pb); err != nil {
+			return false, err
+		}
+	}
+	if pb.RequireUpdatedWithinHours < 0 {
+		return false, errors.New("require_updated_within_hours must not be negative")
+	}
+	if pb.StartedTopic != "" {
+		if _, _, err := parseTopic(strings.TrimSpace(pb.StartedTopic), bp.projectID); err != nil {
+			return false, err
+		}
+	}
+	if pb.NotifyTopic != "" {
+		if _, _, err := parseTopic(strings.TrimSpace(pb.NotifyTopic), bp.projectID); err != nil {
+			return false, fmt.Errorf("invalid notify_topic: %v", err)
+		}
+	}
+	if err := checkWebhookURL(strings.TrimSpace(pb.WebhookURL)); err != nil {
+		return false, err
+	}
+	if pb.OnSuccessTrigger != nil {
+		if err := pb.OnSuccessTrigger.validate(); err != nil {
+			return false, err
+		}
+	}
 	return true, nil
 }
 
 // decodePostBody decodes the HTTP request body into a postBodyParams struct.
-// It uses json.NewDecoder to decode the request body into the provided
-// postBodyParams struct, and returns the populated struct and any error
+// It uses decodeRequestBody, which limits the size of the body and rejects unknown
+// fields and trailing data, and returns the populated struct and any error
 // that occurred during the decoding process.
-func decodePostBody(r *http.Request) (postBodyParams, error) {
+func decodePostBody(w http.ResponseWriter, r *http.Request) (postBodyParams, error) {
 	var pb postBodyParams
-	if err := json.NewDecoder(r.Body).Decode(&pb); err != nil {
+	if err := decodeRequestBody(w, r, &pb); err != nil {
 		return pb, err
 	}
 	return pb, nil
 }
 
 // setBackupParams sets the backup parameters based on the provided postBodyParams.
-// It assigns the DatasetName, TableName, StorageBucket, Format, and Compression
-// from the postBodyParams to the corresponding fields in the backupParams struct.
+// It copies the fields of the postBodyParams to the corresponding fields in the
+// backupParams struct and records the time the backup was requested.
 func (bp *backupParams) setBackupParams(pb postBodyParams) {
 	bp.sourceDatasetID = pb.DatasetName
 	bp.backupTableID = pb.TableName
+	bp.tables = pb.Tables
+	bp.allowViews = pb.AllowViews
+	bp.dryRun = pb.DryRun
+	bp.catalogTable = catalogTableName(strings.TrimSpace(pb.CatalogTable))
+	if raw := strings.TrimSpace(pb.SnapshotTime); raw != "" {
+		bp.snapshotTime, _ = time.Parse(time.RFC3339, raw)
+		bp.snapshotTime = bp.snapshotTime.UTC()
+	}
 	bp.storageBucket = pb.StorageBucket
 	bp.destinationFormat = pb.Format
 	bp.compressionType = pb.Compression
+	bp.maxOutputBytes = pb.MaxOutputBytes
+	bp.batchMaxBytes = pb.BatchMaxBytes
+	bp.writeLoadConfig = pb.WriteLoadConfig
+	bp.sketchColumn = strings.TrimSpace(pb.SketchColumn)
+	bp.sketchHLL = pb.SketchHLL
+	bp.successTrigger = pb.OnSuccessTrigger
+	bp.sanityCheck = pb.SanityCheck
+	bp.snapshotDataset = strings.TrimSpace(pb.SnapshotDataset)
+	bp.snapshotRetentionDays = pb.SnapshotRetentionDays
+	bp.startedTopic = strings.TrimSpace(pb.StartedTopic)
+	bp.stagedPublish = pb.StagedPublish
+	bp.markBackupParts = pb.MarkBackupParts
+	bp.checkShardSchemasEnabled = pb.CheckShardSchemas
+	bp.requireUpdatedWithinHours = pb.RequireUpdatedWithinHours
+	bp.failIfStale = pb.FailIfStale
+	bp.incremental = pb.Incremental
+	bp.overwrite = pb.Overwrite || pb.AllowOverwrite
+	bp.printHeader = pb.PrintHeader
+	bp.nestedCSV = strings.ToLower(strings.TrimSpace(pb.NestedCSV))
+	bp.fieldDelimiter = pb.FieldDelimiter
+	bp.useAvroLogicalTypes = pb.UseAvroLogicalTypes
+	bp.verify = pb.Verify
+	bp.notifyTopic = strings.TrimSpace(pb.NotifyTopic)
+	bp.webhookURL = strings.TrimSpace(pb.WebhookURL)
+	bp.async = pb.Async
+	bp.bucketProject = strings.TrimSpace(pb.BucketProject)
+	bp.sourceProject = strings.TrimSpace(pb.SourceProject)
+	bp.location = strings.TrimSpace(pb.Location)
+	bp.maxFileBytes = pb.MaxFileBytes
+	bp.generateSignedURLs = pb.GenerateSignedURLs
+	bp.signedURLTTLMinutes = pb.SignedURLTTLMinutes
+	bp.includeSchema = pb.IncludeSchema == nil || *pb.IncludeSchema
+	bp.query = strings.TrimSpace(pb.Query)
+	bp.partition = normalizePartition(pb.Partition)
+	bp.kmsKeyName = strings.TrimSpace(pb.KMSKeyName)
+	bp.pathTemplate = strings.TrimSpace(pb.PathTemplate)
+	bp.storageClass = strings.ToUpper(strings.TrimSpace(pb.StorageClass))
+	bp.labels = pb.Labels
+	bp.secondaryBucket = strings.TrimSpace(pb.SecondaryBucket)
+	if bp.isQueryBackup() {
+		bp.backupTableID = strings.TrimSpace(pb.QueryName)
+		if bp.backupTableID == "" {
+			bp.backupTableID = defaultQueryName
+		}
+	}
+	bp.backupTime = time.Now().UTC()
+}
+
+// backupTimeFormat formats the backup time in backup prefixes. It is always applied in UTC, so
+// prefixes of backups taken from different regions sort in the order they were taken.
+const backupTimeFormat = "20060102T150405Z"
+
+// backupPrefix returns the object prefix, relative to the storage bucket, that the backup is
+// written under, e.g. "dataset/table.20060102T150405Z", or "dataset/table$20240115.20060102T150405Z"
+// for a partition. With a path template it is the folder of the rendered template.
+func (bp *backupParams) backupPrefix() string {
+	if bp.pathTemplate != "" {
+		return path.Dir(bp.renderPathTemplate())
+	}
+	backup := fmt.Sprintf("%s.%s", bp.backupName(), bp.backupTime.UTC().Format(backupTimeFormat))
+	return fmt.Sprintf("%s/%s", bp.sourceDatasetID, backup)
+}
+
+// destinationURI returns the wildcard GCS URI the extract job writes its shards to, or the one
+// an earlier job reused for the idempotency key wrote to.
+func (bp *backupParams) destinationURI() string {
+	if bp.reusedJobURI != "" {
+		return bp.reusedJobURI
+	}
+	return fmt.Sprintf("gs://%s/%s/%s", bp.storageBucket, bp.backupPrefix(), bp.shardObjectPattern())
+}
+
+// gcsReference returns the fully configured GCS reference the backup is exported to: the
+// extract URI, destination format, compression type and, for CSV, the field delimiter, which
+// BigQuery defaults to a comma.
+func (bp *backupParams) gcsReference() *bigquery.GCSReference {
+	gcsRef := bigquery.NewGCSReference(bp.extractURI())
+	gcsRef.DestinationFormat = bigQueryDataFormat(bp.destinationFormat)
+	gcsRef.Compression = bigQueryCompression(bp.compressionType)
+	gcsRef.FieldDelimiter = bp.fieldDelimiter
+	return gcsRef
+}
+
+// bigQueryCompression maps a compression type to the BigQuery client's constant for it, so NONE
+// asks for an uncompressed export explicitly. ZSTD has no constant in the client and is passed
+// by name.
+func bigQueryCompression(compression string) bigquery.Compression {
+	switch compression {
+	case noneCompression:
+		return bigquery.None
+	case gzipCompression:
+		return bigquery.Gzip
+	case deflateCompression:
+		return bigquery.Deflate
+	case snappyCompression:
+		return bigquery.Snappy
+	}
+	return bigquery.Compression(compression)
 }
 
 // setupExtractor creates a BigQuery Extractor to export the specified table to a GCS location.
-// It constructs the GCS URI for the backup file, creates a GCS reference, and configures the extractor
-// with the appropriate destination format and compression type. The extractor is returned for use
-// in the backup process.
+// The GCS reference is configured completely before it is handed to the extractor, a CSV
+// header row is only written when print_header was requested, Avro logical types are only
+// used for Avro backups that asked for them, and the job carries the default and requested labels.
+// With an idempotency key the job gets the ID chosen by resolveIdempotentJob.
 func setupExtractor(bp *backupParams) *bigquery.Extractor {
-	backup := fmt.Sprintf("%s.%s", bp.backupTableID, time.Now().Format("2006-01-02"))
-	gcsURI := fmt.Sprintf("gs://%s/%s/%s/%s-*.%s", bp.storageBucket, bp.sourceDatasetID, backup, bp.backupTableID, strings.ToLower(bp.destinationFormat))
-	gcsRef := bigquery.NewGCSReference(gcsURI)
-	extractor := bc.DatasetInProject(bp.projectID, bp.sourceDatasetID).Table(bp.backupTableID).ExtractorTo(gcsRef)
-	extractor.DisableHeader = true
-	gcsRef.DestinationFormat = bigquery.DataFormat(bp.destinationFormat)
-	gcsRef.Compression = bigquery.Compression(bp.compressionType)
+	extractor := bc.DatasetInProject(bp.sourceProjectID(), bp.sourceDatasetID).Table(bp.extractTableID()).ExtractorTo(bp.gcsReference())
+	extractor.DisableHeader = !bp.printHeader
+	extractor.UseAvroLogicalTypes = bp.useAvroLogicalTypes && bp.destinationFormat == avroFormat
+	extractor.Labels = jobLabels(bp.labels)
+	if bp.extractJobID != "" {
+		extractor.JobIDConfig = bigquery.JobIDConfig{JobID: bp.extractJobID}
+	}
+	extractor.Location = bp.location
 	return extractor
 }
 
 // Validation functions
 
 // validateParams validates that the specified dataset, table, and storage bucket exist and are accessible.
-// It first checks that the dataset exists and is valid, then checks that the table exists and is valid,
-// and finally checks that the storage bucket exists and is accessible. If any of these validations fail,
-// the function returns true, indicating that the backup parameters are not valid. Otherwise, it returns false.
-func (bp *backupParams) validateParams(ctx context.Context) bool {
+// It first checks that ALLOWED_DATASETS and DENIED_DATASETS permit the dataset and that it exists and is
+// valid, then checks that the table exists and is valid, and finally checks that the storage bucket exists
+// and is accessible. If any of these validations fail, it logs and returns an error carrying the HTTP status
+// to report: 404 for a dataset, table or bucket that cannot be found, 403 for an excluded dataset, one the
+// function may not read or a bucket it cannot write to, 400
+// for invalid options and 500 for any other failure. Otherwise, it returns nil.
+func (bp *backupParams) validateParams(ctx context.Context) error {
+	if err := bp.checkDatasetPolicy(); err != nil {
+		_ = bp.logError(fmt.Sprintf("Backup rejected: %v", err))
+		return err
+	}
 	validDataset, err := bp.validateDataset(ctx)
 	if err != nil || !validDataset {
-		_ = bp.logError("Dataset does not exist or is not valid")
-		return true
+		err = lookupError("dataset "+bp.sourceDatasetID, err)
+		_ = bp.logError(fmt.Sprintf("Invalid dataset: %v", err))
+		return err
+	}
+	if err := bp.resolveLocation(ctx); err != nil {
+		err = lookupError("location", err)
+		_ = bp.logError(fmt.Sprintf("Invalid location: %v", err))
+		return err
+	}
+
+	if !bp.isQueryBackup() {
+		validTable, err := bp.validateTable(ctx)
+		if err != nil || !validTable {
+			err = lookupError(fmt.Sprintf("table %s.%s", bp.sourceDatasetID, bp.backupTableID), err)
+			_ = bp.logError(fmt.Sprintf("Invalid table: %v", err))
+			return err
+		}
+		if err := bp.checkView(ctx); err != nil {
+			_ = bp.logError(fmt.Sprintf("Problem checking table type: %v", err))
+			return err
+		}
+		if err := bp.checkExportFormat(ctx); err != nil {
+			_ = bp.logError(fmt.Sprintf("Unsupported destination format: %v", err))
+			return err
+		}
+	}
+
+	if ok, err := bp.validatePartition(ctx); !ok || err != nil {
+		_ = bp.logError(fmt.Sprintf("Invalid partition: %v", err))
+		return withStatus(http.StatusBadRequest, fmt.Errorf("invalid partition: %v", err))
+	}
+
+	if ok, err := bp.validateSketchColumn(ctx); !ok || err != nil {
+		_ = bp.logError(fmt.Sprintf("Invalid sketch column: %v", err))
+		return withStatus(http.StatusBadRequest, fmt.Errorf("invalid sketch column: %v", err))
 	}
 
-	validTable, err := bp.validateTable(ctx)
-	if err != nil || !validTable {
-		_ = bp.logError(fmt.Sprintf("Table does not exist or is not valid: %v", err))
-		return true
+	if ok, err := bp.validateSnapshotDataset(ctx); !ok || err != nil {
+		_ = bp.logError(fmt.Sprintf("Invalid snapshot dataset: %v", err))
+		return withStatus(http.StatusBadRequest, fmt.Errorf("invalid snapshot dataset: %v", err))
 	}
 
 	if ok, err := bp.validateStorageBucket(ctx); !ok || err != nil {
-		_ = bp.logError("Problem validating storage bucket")
-		return true
+		err = lookupError("storage bucket "+bp.storageBucket, err)
+		_ = bp.logError(fmt.Sprintf("Invalid storage bucket: %v", err))
+		return err
+	}
+
+	if err := bp.checkCanSign(ctx); err != nil {
+		_ = bp.logError(fmt.Sprintf("Problem signing URLs: %v", err))
+		return err
+	}
+
+	if ok, err := bp.validateSecondaryBucket(ctx); !ok || err != nil {
+		err = lookupError("secondary bucket "+bp.secondaryBucket, err)
+		_ = bp.logError(fmt.Sprintf("Invalid secondary bucket: %v", err))
+		return err
+	}
+	return nil
+}
+
+// validateRequest validates the request once, as a list of tables, a whole dataset or a single
+// table, and returns the same result when called again, so that a request validated before
+// claiming its idempotency key is neither validated nor logged twice.
+func (bp *backupParams) validateRequest(ctx context.Context) error {
+	if bp.validated {
+		return bp.validationErr
+	}
+	switch {
+	case bp.isTableListBackup():
+		bp.validationErr = bp.validateListedTables(ctx)
+	case bp.isDatasetBackup():
+		bp.validationErr = bp.validateDatasetBackup(ctx)
+	default:
+		bp.validationErr = bp.validateParams(ctx)
 	}
-	return false
+	bp.validated = true
+	return bp.validationErr
 }
 
-// validateDataset validates that the specified dataset exists in the project and is accessible.
+// bigQueryLocation returns the location the source datasets are expected to live in, read from
+// the BIGQUERY_LOCATION environment variable. An empty location accepts any location.
+func bigQueryLocation() string {
+	return strings.TrimSpace(os.Getenv("BIGQUERY_LOCATION"))
+}
+
+// validateDataset validates that the specified dataset exists in the source project and is accessible.
 // It retrieves the metadata for the specified dataset and compares the full ID to the expected full ID
-// based on the project ID and dataset ID provided in the backupParams. If the full ID matches,
-// the function returns true, indicating the dataset is valid. Otherwise, it returns false.
+// based on the source project ID and dataset ID provided in the backupParams. When BIGQUERY_LOCATION is set,
+// the dataset must also live in that location. If the dataset resolved to a different project or
+// location, it returns false with an error naming what was resolved, so a same-named dataset from
+// another context is never backed up by accident.
 func (bp *backupParams) validateDataset(ctx context.Context) (bool, error) {
-	ds := bc.Dataset(bp.sourceDatasetID)
-	md, err := ds.Metadata(ctx)
+	md, err := bp.datasetMetadata(ctx)
 	if err != nil {
 		return false, err
 	}
-	if md.FullID == bp.projectID+":"+bp.sourceDatasetID {
-		return true, nil
+	want := bp.sourceProjectID() + ":" + bp.sourceDatasetID
+	if md.FullID != want {
+		return false, withStatus(http.StatusNotFound, fmt.Errorf("dataset %s is ambiguous: it resolved to %s", want, md.FullID))
 	}
-	return false, nil
+	if loc := bigQueryLocation(); loc != "" && !strings.EqualFold(md.Location, loc) {
+		return false, withStatus(http.StatusNotFound, fmt.Errorf("dataset %s is ambiguous: it is in location %s, not the configured location %s", want, md.Location, loc))
+	}
+	return true, nil
 }
 
 // validateTable validates that the specified table exists in the source dataset and is accessible.
 // It retrieves the metadata for the specified table and compares the full ID to the expected full ID
-// based on the project ID and source dataset ID provided in the backupParams. If the full ID matches,
-// the function returns true, indicating the table is valid. Otherwise, it returns false.
+// based on the source project ID and source dataset ID provided in the backupParams, and checks that the
+// table lives in the same location as its dataset. If either does not match, it returns false with
+// an error naming what was resolved.
 func (bp *backupParams) validateTable(ctx context.Context) (bool, error) {
-	md, err := bc.Dataset(bp.sourceDatasetID).Table(bp.backupTableID).Metadata(ctx)
-	fmt.Println(md.FullID)
+	md, err := bp.tableMetadata(ctx)
 	if err != nil {
 		return false, err
 	}
-	if md.FullID == bp.projectID+":"+bp.sourceDatasetID+"."+bp.backupTableID {
-		return true, nil
+	want := bp.sourceProjectID() + ":" + bp.sourceDatasetID + "." + bp.backupTableID
+	if md.FullID != want {
+		return false, withStatus(http.StatusNotFound, fmt.Errorf("table %s is ambiguous: it resolved to %s", want, md.FullID))
 	}
-	return false, nil
+	dsmd, err := bp.datasetMetadata(ctx)
+	if err != nil {
+		return false, err
+	}
+	if md.Location != "" && !strings.EqualFold(md.Location, dsmd.Location) {
+		return false, withStatus(http.StatusNotFound, fmt.Errorf("table %s is ambiguous: it is in location %s but its dataset is in %s", want, md.Location, dsmd.Location))
+	}
+	return true, nil
 }
 
-// validateStorageBucket validates that the specified storage bucket exists and is accessible.
-// It creates a new storage client, retrieves the attributes of the specified bucket, and returns
-// true if the bucket exists and can be accessed, or false otherwise.
+// requiredBucketPermissions are the permissions on the storage bucket the extract job needs. The
+// job writes the export with the function's own credentials.
+var requiredBucketPermissions = []string{"storage.objects.create"}
+
+// validateStorageBucket validates that the specified storage bucket exists and can be written to.
+// It uses the shared storage client to retrieve the attributes of the specified bucket, checks that
+// it belongs to bucket_project and encrypts with kms_key_name when those are given and that the
+// dataset can be exported to its location, and checks that the function's service account may
+// create objects in it, see checkBucketWritable. It returns true if the bucket exists and is
+// writable, or false otherwise; a bucket that is not writable is reported with 403.
 func (bp *backupParams) validateStorageBucket(ctx context.Context) (bool, error) {
-	c, err := storage.NewClient(ctx)
+	c, err := sharedStorageClient()
 	if err != nil {
 		return false, err
 	}
-	defer c.Close()
 
+	ctx, cancel := withStorageTimeout(ctx)
+	defer cancel()
 	bucket := c.Bucket(bp.storageBucket)
-	if _, err := bucket.Attrs(ctx); err != nil {
+	attrs, err := bucket.Attrs(ctx)
+	if err != nil {
+		return false, err
+	}
+	if err := bp.checkBucketProject(ctx, attrs); err != nil {
+		return false, err
+	}
+	if err := bp.checkBucketEncryption(attrs); err != nil {
+		return false, err
+	}
+	if err := bp.checkBucketStorageClass(attrs); err != nil {
+		return false, err
+	}
+	if err := bp.checkBucketLocation(attrs); err != nil {
+		return false, err
+	}
+	if err := checkBucketWritable(ctx, bp.storageBucket); err != nil {
 		return false, err
 	}
 	return true, nil
 }
 
+// missingPermissions returns the permissions in required that are not in granted.
+func missingPermissions(required, granted []string) []string {
+	have := make(map[string]bool, len(granted))
+	for _, p := range granted {
+		have[p] = true
+	}
+	var missing []string
+	for _, p := range required {
+		if !have[p] {
+			missing = append(missing, p)
+		}
+	}
+	return missing
+}
+
+// Storage functions
+
+// writeBackupObject marshals v as indented JSON and writes it to the named object inside the
+// output prefix. It returns the full object name that was written.
+func (bp *backupParams) writeBackupObject(ctx context.Context, name string, v interface{}) (string, error) {
+	return bp.writeObject(ctx, bp.outputPrefix()+"/"+name, v)
+}
+
+// writeObject marshals v as indented JSON and writes it to objectName in the storage bucket.
+// It returns objectName.
+func (bp *backupParams) writeObject(ctx context.Context, objectName string, v interface{}) (string, error) {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	return bp.putObject(ctx, objectName, "application/json", data)
+}
+
+// putObject writes data to objectName in the storage bucket with the backup's encryption key and
+// storage class. It returns objectName.
+func (bp *backupParams) putObject(ctx context.Context, objectName, contentType string, data []byte) (string, error) {
+	c, err := sharedStorageClient()
+	if err != nil {
+		return "", err
+	}
+
+	ctx, cancel := withStorageTimeout(ctx)
+	defer cancel()
+	w := c.Bucket(bp.storageBucket).Object(objectName).NewWriter(ctx)
+	w.ContentType = contentType
+	w.KMSKeyName = bp.kmsKeyName
+	w.StorageClass = bp.objectStorageClass(objectName)
+	if _, err := w.Write(data); err != nil {
+		_ = w.Close()
+		forgetBucketWritable(bp.storageBucket)
+		return "", err
+	}
+	if err := w.Close(); err != nil {
+		forgetBucketWritable(bp.storageBucket)
+		return "", err
+	}
+	return objectName, nil
+}
+
 // Logging functions
 
-// logInfo logs an informational message to the "bigquery-backup" logger.
+// defaultLogName is the name of the operational log when LOG_NAME is not set.
+const defaultLogName = "bigquery-backup"
+
+// logName returns the name of the operational log, read from the LOG_NAME environment
+// variable. It defaults to "bigquery-backup".
+func logName() string {
+	if name := strings.TrimSpace(os.Getenv("LOG_NAME")); name != "" {
+		return name
+	}
+	return defaultLogName
+}
+
+// logPayload is the structured payload of the operational log entries. Besides the message it
+// carries whatever is known about the backup at the time, so entries can be queried by table.
+type logPayload struct {
+	Message       string `json:"message"`
+	RunID         string `json:"run_id,omitempty"`
+	Dataset       string `json:"dataset,omitempty"`
+	Table         string `json:"table,omitempty"`
+	JobID         string `json:"job_id,omitempty"`
+	BytesExported int64  `json:"bytes_exported,omitempty"`
+}
+
+// newLogPayload returns the payload logging msg for this backup.
+func (bp *backupParams) newLogPayload(msg string) logPayload {
+	return logPayload{
+		Message:       msg,
+		RunID:         bp.runID,
+		Dataset:       bp.sourceDatasetID,
+		Table:         bp.backupTableID,
+		JobID:         bp.jobID,
+		BytesExported: bp.bytesExported,
+	}
+}
+
+// logInfo logs an informational message to the operational log.
 // The message is logged with the Info severity level.
 func (bp *backupParams) logInfo(msg string) error {
-	ctx := context.Background()
-	c, err := logging.NewClient(ctx, bp.projectID)
-	if err != nil {
-		log.Fatalf("Failed to create client: %v", err)
-	}
-	defer c.Close()
-	logName := "bigquery-backup"
-	logger := c.Logger(logName).StandardLogger(logging.Info)
-	logger.Println(msg)
-	return nil
+	return bp.logEvent(logging.Info, bp.newLogPayload(msg))
 }
 
-// logError logs an error message to the "bigquery-backup" logger.
+// logWarning logs a warning message to the operational log.
+// The message is logged with the Warning severity level.
+func (bp *backupParams) logWarning(msg string) error {
+	return bp.logEvent(logging.Warning, bp.newLogPayload(msg))
+}
+
+// warn logs a warning and records it for the response, so the caller sees it as well.
+func (bp *backupParams) warn(msg string) error {
+	bp.warnings = append(bp.warnings, msg)
+	return bp.logWarning(msg)
+}
+
+// logError logs an error message to the operational log.
 // The message is logged with the Error severity level.
 func (bp *backupParams) logError(msg string) error {
-	ctx := context.Background()
-	c, err := logging.NewClient(ctx, bp.projectID)
+	return bp.logEvent(logging.Error, bp.newLogPayload(msg))
+}
+
+// logEvent logs a structured payload to the operational log with the given severity,
+// so that its fields can be queried in Cloud Logging or, with LOG_BACKEND=stdout, in the JSON
+// lines written to standard output. When the log backend cannot be created the entry is written
+// to standard error instead and nil is returned: many callers return what logInfo returns as
+// their own result, and a logging outage must never fail a backup or stop the instance.
+func (bp *backupParams) logEvent(severity logging.Severity, payload interface{}) error {
+	b, err := bp.logger()
 	if err != nil {
-		log.Fatalf("Failed to create client: %v", err)
+		logFallback(severity, payload, err)
+		return nil
 	}
-	defer c.Close()
-	logName := "bigquery-backup"
-	logger := c.Logger(logName).StandardLogger(logging.Error)
-	logger.Println(msg)
+	b.Log(logging.Entry{Severity: severity, Payload: payload})
 	return nil
 }
 
-// checkBackupFormat checks the backup format specified in the backupParams and sets the appropriate compression type.
-// If the backup format is CSV or JSON, it sets the compression type to gzip.
-// If the backup format is Avro or Parquet, it sets the compression type to Snappy by default, or to Deflate or Snappy if specified.
-// If the backup format is not recognized, it defaults to Avro format with Snappy compression.
-// The function returns true if the backup format is valid, and false otherwise.
-func (bp *backupParams) checkBackupFormat() (bool, error) {
-	switch bp.destinationFormat {
-	case csvFormat:
-		err := bp.setCSVAndJSONCompression()
-		if err != nil {
-			return false, err
-		}
-		return true, nil
-	case jsonFormat:
-		err := bp.setCSVAndJSONCompression()
-		if err != nil {
-			return false, err
-		}
-		return true, nil
-	case avroFormat:
-		err := bp.setAvroParquetCompression()
-		if err != nil {
-			return false, err
-		}
-		return true, nil
-	case parquetFormat:
-		err := bp.setAvroParquetCompression()
-		if err != nil {
-			return false, err
-		}
-		return true, nil
-	default:
-		bp.destinationFormat = avroFormat
-		bp.compressionType = snappyCompression
-		return true, nil
+// normalizeFormat returns the destination format in its canonical upper-case form, matching
+// case-insensitively. An empty format defaults to DEFAULT_FORMAT, or Avro when that is not set;
+// any other unknown format is an error.
+func normalizeFormat(format string) (string, error) {
+	switch f := strings.ToUpper(strings.TrimSpace(format)); f {
+	case "":
+		return envFormatDefaults.defaultFormat(), nil
+	case csvFormat, jsonFormat, avroFormat, parquetFormat:
+		return f, nil
 	}
+	return "", fmt.Errorf("unsupported destination_format %q, must be one of %s, %s, %s or %s", format, csvFormat, jsonFormat, avroFormat, parquetFormat)
 }
 
-// setCSVAndJSONCompression sets the compression type for CSV and JSON backup formats to gzip.
-// It logs the backup format and compression type.
-func (bp *backupParams) setCSVAndJSONCompression() error {
-	bp.compressionType = gzipCompression
-	lv := fmt.Sprintf("Backup format: %s, Backup compression: %s", bp.destinationFormat, bp.compressionType)
-	err := bp.logInfo(lv)
+// checkBackupFormat checks the backup format specified in the backupParams and sets the appropriate compression type.
+// The format is matched case-insensitively and defaults to DEFAULT_FORMAT or Avro when it is not given, and the compression is then
+// checked against what BigQuery supports for that format, see setCompression.
+// The function returns true if the format and compression are valid, and false with an error otherwise.
+func (bp *backupParams) checkBackupFormat() (bool, error) {
+	format, err := normalizeFormat(bp.destinationFormat)
 	if err != nil {
-		return err
+		return false, err
 	}
-	return nil
+	bp.destinationFormat = format
+
+	if err := bp.setCompression(); err != nil {
+		return false, err
+	}
+	return true, nil
 }
 
-// setAvroParquetCompression sets the compression type for Avro and Parquet backup formats.
-// If the compression type is not set, it defaults to Snappy compression.
-// If the compression type is set to Deflate or Snappy, it logs the backup format and compression type.
-// If the compression type is set to an unsupported value, it defaults to Snappy compression.
-func (bp *backupParams) setAvroParquetCompression() error {
-	if bp.compressionType == "" {
-		bp.compressionType = snappyCompression
-		lv := fmt.Sprintf("BigQuery Table will be Backup format: %s, Backup compression: %s", bp.destinationFormat, bp.compressionType)
-		err := bp.logInfo(lv)
-		if err != nil {
-			return err
-		} else if bp.compressionType == deflateCompression || bp.compressionType == snappyCompression {
-			lv := fmt.Sprintf("Backup format: %s, Backup compression: %s", bp.destinationFormat, bp.compressionType)
-			err := bp.logInfo(lv)
-			if err != nil {
-				return err
-			}
-			return nil
-		} else {
-			bp.compressionType = snappyCompression
-			return nil
-		}
+// setCompression validates the compression type against the destination format and logs the result.
+// CSV and JSON allow NONE or GZIP and default to GZIP. Avro allows NONE, DEFLATE or SNAPPY and Parquet
+// allows NONE, GZIP, SNAPPY or ZSTD; both default to SNAPPY. DEFAULT_COMPRESSION replaces these defaults
+// for the formats that support it, and FORMAT_DEFAULTS may set the default and narrow the allowed types
+// of each format. The compression is matched case-insensitively and an unsupported combination is an
+// error rather than being silently replaced. The resolved pair is kept for the response, together
+// with the compression the request asked for.
+func (bp *backupParams) setCompression() error {
+	compression := strings.ToUpper(strings.TrimSpace(bp.compressionType))
+	if compression == "" {
+		compression = envFormatDefaults.defaultCompression(bp.destinationFormat)
 	}
-	return nil
+	if !envFormatDefaults.compressionAllowed(bp.destinationFormat, compression) {
+		return fmt.Errorf("compression_type %q is not supported for %s, must be one of %s", bp.compressionType, bp.destinationFormat, strings.Join(envFormatDefaults.compressions(bp.destinationFormat).allowed, ", "))
+	}
+	bp.export = &exportFormat{Format: bp.destinationFormat, Compression: compression, RequestedCompression: bp.compressionType}
+	bp.compressionType = compression
+	return bp.logInfo(fmt.Sprintf("Backup format: %s, Backup compression: %s", bp.destinationFormat, bp.compressionType))
 }
@@ -2,11 +2,17 @@ package bigquerybackup
 
 import (
 	"context"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"strings"
 	"sync"
 	"testing"
+	"time"
 
+	"cloud.google.com/go/bigquery"
 	"github.com/stretchr/testify/assert"
+	"google.golang.org/api/option"
 )
 
 func TestSetBigQueryClient(t *testing.T) {
@@ -63,6 +69,59 @@ func TestSetBigQueryClientConcurrency(t *testing.T) {
 	wg.Wait()
 }
 
+func TestSetBigQueryClientKeepsClientOpen(t *testing.T) {
+	ctx := context.Background()
+	bp := &backupParams{projectID: "test-project"}
+
+	assert.NoError(t, bp.setBigQueryClient(ctx))
+	first := bc
+	assert.NotNil(t, first)
+
+	assert.NoError(t, bp.setBigQueryClient(ctx))
+	assert.Same(t, first, bc, "the client is reused across requests")
+}
+
+func TestSequentialBackupsShareClient(t *testing.T) {
+	t.Setenv("GCP_PROJECT", "test-project")
+	savedFactory, savedBackend := newLogBackend, lb
+	savedNewClient, savedClient, savedClientErr := newBigQueryClient, bc, bcErr
+	t.Cleanup(func() {
+		newLogBackend, lb = savedFactory, savedBackend
+		newBigQueryClient, bc, bcErr, bcOnce = savedNewClient, savedClient, savedClientErr, sync.Once{}
+	})
+	newLogBackend = func(string) (logBackend, error) {
+		return &stdoutLogBackend{out: &strings.Builder{}}, nil
+	}
+	lb = nil
+
+	var lookups int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lookups++
+		assert.Contains(t, r.URL.Path, "/projects/test-project/datasets/ds")
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusNotFound)
+		_, _ = w.Write([]byte(`{"error": {"code": 404, "message": "Not found: Dataset test-project:ds"}}`))
+	}))
+	defer srv.Close()
+
+	var created int
+	newBigQueryClient = func(ctx context.Context, projectID string) (*bigquery.Client, error) {
+		created++
+		return bigquery.NewClient(ctx, projectID, option.WithEndpoint(srv.URL), option.WithoutAuthentication())
+	}
+	bc, bcErr, bcOnce = nil, nil, sync.Once{}
+
+	for i := 0; i < 2; i++ {
+		body := `{"dataset_name": "ds", "table_name": "t", "storage_bucket": "backups"}`
+		r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+		w := httptest.NewRecorder()
+		bigQueryBackup(w, r)
+		assert.Equal(t, http.StatusNotFound, w.Code, "backup %d: %s", i+1, w.Body.String())
+	}
+	assert.Equal(t, 2, lookups, "both backups looked up the dataset")
+	assert.Equal(t, 1, created, "the client is created once and reused across requests")
+}
+
 func TestSetProjectID(t *testing.T) {
 	tests := []struct {
 		name    string
@@ -124,3 +183,395 @@ func TestSetProjectIDEnvironmentChange(t *testing.T) {
 }
 
 // TODO: Add additional tests
+
+func TestMissingProjectRejectsRequests(t *testing.T) {
+	t.Setenv("GCP_PROJECT", "")
+	want := `{"error":"GCP_PROJECT environment variable is not set","code":500}`
+	handlers := map[string]http.HandlerFunc{
+		"Backup":  bigQueryBackup,
+		"Status":  bigQueryBackupStatus,
+		"Cleanup": bigQueryBackupCleanup,
+		"Restore": bigQueryRestore,
+	}
+
+	for name, h := range handlers {
+		t.Run(name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			h(rec, httptest.NewRequest(http.MethodPost, "/", nil))
+			assert.Equal(t, http.StatusInternalServerError, rec.Code)
+			assert.JSONEq(t, want, rec.Body.String())
+		})
+	}
+}
+
+func TestValidateDatasetAndTableResolution(t *testing.T) {
+	tests := []struct {
+		name          string
+		location      string
+		datasetFullID string
+		datasetLoc    string
+		tableFullID   string
+		tableLoc      string
+		wantDataset   bool
+		wantTable     bool
+	}{
+		{
+			name:          "Matching project and location",
+			location:      "EU",
+			datasetFullID: "test-project:ds",
+			datasetLoc:    "EU",
+			tableFullID:   "test-project:ds.t",
+			tableLoc:      "EU",
+			wantDataset:   true,
+			wantTable:     true,
+		},
+		{
+			name:          "No configured location",
+			datasetFullID: "test-project:ds",
+			datasetLoc:    "us-east1",
+			tableFullID:   "test-project:ds.t",
+			tableLoc:      "us-east1",
+			wantDataset:   true,
+			wantTable:     true,
+		},
+		{
+			name:          "Dataset from another project",
+			datasetFullID: "other-project:ds",
+			datasetLoc:    "EU",
+			tableFullID:   "other-project:ds.t",
+			tableLoc:      "EU",
+			wantDataset:   false,
+			wantTable:     false,
+		},
+		{
+			name:          "Dataset in another location",
+			location:      "US",
+			datasetFullID: "test-project:ds",
+			datasetLoc:    "EU",
+			tableFullID:   "test-project:ds.t",
+			tableLoc:      "EU",
+			wantDataset:   false,
+			wantTable:     true,
+		},
+		{
+			name:          "Table location differs from dataset",
+			datasetFullID: "test-project:ds",
+			datasetLoc:    "EU",
+			tableFullID:   "test-project:ds.t",
+			tableLoc:      "US",
+			wantDataset:   true,
+			wantTable:     false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("BIGQUERY_LOCATION", tt.location)
+			bp := &backupParams{projectID: "test-project", sourceDatasetID: "ds", backupTableID: "t", cache: newMetadataCache()}
+			_, _ = bp.cache.dataset("test-project:ds", func() (*bigquery.DatasetMetadata, error) {
+				return &bigquery.DatasetMetadata{FullID: tt.datasetFullID, Location: tt.datasetLoc}, nil
+			})
+			_, _ = bp.cache.table("test-project:ds.t", func() (*bigquery.TableMetadata, error) {
+				return &bigquery.TableMetadata{FullID: tt.tableFullID, Location: tt.tableLoc}, nil
+			})
+
+			ok, err := bp.validateDataset(context.Background())
+			assert.Equal(t, tt.wantDataset, ok)
+			assert.Equal(t, !tt.wantDataset, err != nil)
+
+			ok, err = bp.validateTable(context.Background())
+			assert.Equal(t, tt.wantTable, ok)
+			assert.Equal(t, !tt.wantTable, err != nil)
+		})
+	}
+}
+
+// BenchmarkLogInfo measures logging one line through the shared logging client. Before the
+// client was shared, every call created and closed its own client.
+func BenchmarkLogInfo(b *testing.B) {
+	bp := &backupParams{projectID: "test-project"}
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		_ = bp.logInfo("benchmark")
+	}
+}
+
+func TestBackupPrefixUsesUTC(t *testing.T) {
+	est := time.FixedZone("EST", -5*60*60)
+	bp := &backupParams{
+		sourceDatasetID: "ds",
+		backupTableID:   "t",
+		backupTime:      time.Date(2024, 1, 14, 22, 30, 15, 0, est),
+	}
+	assert.Equal(t, "ds/t.20240115T033015Z", bp.backupPrefix())
+}
+
+func TestCheckBackupFormat(t *testing.T) {
+	tests := []struct {
+		name       string
+		format     string
+		wantFormat string
+		wantErr    bool
+	}{
+		{name: "Lower case", format: "parquet", wantFormat: parquetFormat},
+		{name: "Mixed case", format: "Parquet", wantFormat: parquetFormat},
+		{name: "Upper case", format: "PARQUET", wantFormat: parquetFormat},
+		{name: "CSV", format: "csv", wantFormat: csvFormat},
+		{name: "Default", format: "", wantFormat: avroFormat},
+		{name: "Unknown", format: "xlsx", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bp := &backupParams{projectID: "test-project", destinationFormat: tt.format}
+			ok, err := bp.checkBackupFormat()
+			if tt.wantErr {
+				assert.False(t, ok)
+				assert.Error(t, err)
+				return
+			}
+			assert.True(t, ok)
+			assert.NoError(t, err)
+			assert.Equal(t, tt.wantFormat, bp.destinationFormat)
+		})
+	}
+}
+
+func TestSetCompression(t *testing.T) {
+	tests := []struct {
+		format          string
+		compression     string
+		wantCompression string
+		wantErr         bool
+	}{
+		{format: csvFormat, compression: "", wantCompression: gzipCompression},
+		{format: csvFormat, compression: "NONE", wantCompression: noneCompression},
+		{format: csvFormat, compression: "gzip", wantCompression: gzipCompression},
+		{format: csvFormat, compression: "SNAPPY", wantErr: true},
+		{format: csvFormat, compression: "DEFLATE", wantErr: true},
+		{format: csvFormat, compression: "ZSTD", wantErr: true},
+		{format: jsonFormat, compression: "", wantCompression: gzipCompression},
+		{format: jsonFormat, compression: "NONE", wantCompression: noneCompression},
+		{format: jsonFormat, compression: "GZIP", wantCompression: gzipCompression},
+		{format: jsonFormat, compression: "SNAPPY", wantErr: true},
+		{format: jsonFormat, compression: "ZSTD", wantErr: true},
+		{format: avroFormat, compression: "", wantCompression: snappyCompression},
+		{format: avroFormat, compression: "NONE", wantCompression: noneCompression},
+		{format: avroFormat, compression: "DEFLATE", wantCompression: deflateCompression},
+		{format: avroFormat, compression: "snappy", wantCompression: snappyCompression},
+		{format: avroFormat, compression: "GZIP", wantErr: true},
+		{format: avroFormat, compression: "ZSTD", wantErr: true},
+		{format: parquetFormat, compression: "", wantCompression: snappyCompression},
+		{format: parquetFormat, compression: "NONE", wantCompression: noneCompression},
+		{format: parquetFormat, compression: "GZIP", wantCompression: gzipCompression},
+		{format: parquetFormat, compression: "SNAPPY", wantCompression: snappyCompression},
+		{format: parquetFormat, compression: "ZSTD", wantCompression: zstdCompression},
+		{format: parquetFormat, compression: "DEFLATE", wantErr: true},
+		{format: parquetFormat, compression: "LZ4", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.format+"/"+tt.compression, func(t *testing.T) {
+			bp := &backupParams{projectID: "test-project", destinationFormat: tt.format, compressionType: tt.compression}
+			err := bp.setCompression()
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.wantCompression, bp.compressionType)
+			assert.Equal(t, &exportFormat{Format: tt.format, Compression: tt.wantCompression, RequestedCompression: tt.compression}, bp.export)
+		})
+	}
+}
+
+func TestSetupExtractorZSTD(t *testing.T) {
+	bp := &backupParams{
+		projectID:         "test-project",
+		sourceDatasetID:   "ds",
+		backupTableID:     "t",
+		storageBucket:     "b",
+		destinationFormat: parquetFormat,
+		compressionType:   zstdCompression,
+	}
+	assert.NoError(t, bp.setBigQueryClient(context.Background()))
+	extractor := setupExtractor(bp)
+	assert.Equal(t, bigquery.Compression("ZSTD"), extractor.Dst.Compression)
+}
+
+func TestCompressionNone(t *testing.T) {
+	saved := envFormatDefaults
+	t.Cleanup(func() { envFormatDefaults = saved })
+	envFormatDefaults = formatDefaults{compression: gzipCompression}
+	for _, format := range []string{csvFormat, jsonFormat, avroFormat, parquetFormat} {
+		t.Run(format, func(t *testing.T) {
+			bp := &backupParams{projectID: "test-project"}
+			pb := postBodyParams{DatasetName: "ds", TableName: "t", StorageBucket: "backups", Format: format, Compression: "none"}
+			ok, err := bp.checkPostBody(&pb)
+			assert.True(t, ok)
+			assert.NoError(t, err)
+			bp.setBackupParams(pb)
+			ok, err = bp.checkBackupFormat()
+			assert.True(t, ok)
+			assert.NoError(t, err)
+			assert.Equal(t, noneCompression, bp.compressionType, "not replaced by the default")
+			assert.Equal(t, bigquery.None, bp.gcsReference().Compression)
+		})
+	}
+}
+
+func TestBigQueryCompression(t *testing.T) {
+	assert.Equal(t, bigquery.None, bigQueryCompression(noneCompression))
+	assert.Equal(t, bigquery.Gzip, bigQueryCompression(gzipCompression))
+	assert.Equal(t, bigquery.Deflate, bigQueryCompression(deflateCompression))
+	assert.Equal(t, bigquery.Snappy, bigQueryCompression(snappyCompression))
+	assert.Equal(t, bigquery.Compression("ZSTD"), bigQueryCompression(zstdCompression))
+}
+
+func TestSetupExtractor(t *testing.T) {
+	tests := []struct {
+		name        string
+		format      string
+		compression string
+		printHeader bool
+		wantFormat  bigquery.DataFormat
+	}{
+		{name: "CSV without header", format: csvFormat, compression: gzipCompression, wantFormat: bigquery.CSV},
+		{name: "CSV with header", format: csvFormat, compression: noneCompression, printHeader: true, wantFormat: bigquery.CSV},
+		{name: "Avro", format: avroFormat, compression: snappyCompression, wantFormat: bigquery.Avro},
+		{name: "Parquet", format: parquetFormat, compression: zstdCompression, wantFormat: bigquery.Parquet},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bp := &backupParams{
+				projectID:         "test-project",
+				sourceDatasetID:   "ds",
+				backupTableID:     "t",
+				storageBucket:     "b",
+				destinationFormat: tt.format,
+				compressionType:   tt.compression,
+				printHeader:       tt.printHeader,
+				backupTime:        time.Date(2024, 1, 15, 10, 0, 0, 0, time.UTC),
+			}
+			assert.NoError(t, bp.setBigQueryClient(context.Background()))
+			extractor := setupExtractor(bp)
+			assert.Equal(t, []string{bp.extractURI()}, extractor.Dst.URIs)
+			assert.Equal(t, tt.wantFormat, extractor.Dst.DestinationFormat)
+			assert.Equal(t, bigquery.Compression(tt.compression), extractor.Dst.Compression)
+			assert.Equal(t, !tt.printHeader, extractor.DisableHeader)
+		})
+	}
+}
+
+func TestSetupExtractorAvroLogicalTypes(t *testing.T) {
+	tests := []struct {
+		name   string
+		format string
+		flag   bool
+		want   bool
+	}{
+		{name: "Avro", format: avroFormat, flag: true, want: true},
+		{name: "Avro without flag", format: avroFormat},
+		{name: "Parquet", format: parquetFormat, flag: true},
+		{name: "CSV", format: csvFormat, flag: true},
+		{name: "JSON", format: jsonFormat, flag: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bp := &backupParams{
+				projectID:           "test-project",
+				sourceDatasetID:     "ds",
+				backupTableID:       "t",
+				storageBucket:       "b",
+				destinationFormat:   tt.format,
+				useAvroLogicalTypes: tt.flag,
+			}
+			assert.NoError(t, bp.setBigQueryClient(context.Background()))
+			assert.Equal(t, tt.want, setupExtractor(bp).UseAvroLogicalTypes)
+		})
+	}
+}
+
+func TestCheckPostBodyPrintHeader(t *testing.T) {
+	bp := &backupParams{projectID: "test-project"}
+	_, err := bp.checkPostBody(&postBodyParams{DatasetName: "ds", StorageBucket: "backups", Format: "csv", PrintHeader: true})
+	assert.NoError(t, err)
+	_, err = bp.checkPostBody(&postBodyParams{DatasetName: "ds", StorageBucket: "backups", Format: avroFormat, PrintHeader: true})
+	assert.Error(t, err)
+}
+
+func TestCheckPostBodyFieldDelimiter(t *testing.T) {
+	tests := []struct {
+		name      string
+		format    string
+		delimiter string
+		wantErr   bool
+	}{
+		{name: "Default", format: csvFormat},
+		{name: "Tab", format: csvFormat, delimiter: "\t"},
+		{name: "Pipe", format: "csv", delimiter: "|"},
+		{name: "Multi-byte character", format: csvFormat, delimiter: "þ"},
+		{name: "Two characters", format: csvFormat, delimiter: "||", wantErr: true},
+		{name: "Not CSV", format: parquetFormat, delimiter: "|", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bp := &backupParams{projectID: "test-project"}
+			_, err := bp.checkPostBody(&postBodyParams{DatasetName: "ds", StorageBucket: "backups", Format: tt.format, FieldDelimiter: tt.delimiter})
+			assert.Equal(t, tt.wantErr, err != nil)
+		})
+	}
+}
+
+func TestGCSReferenceFieldDelimiter(t *testing.T) {
+	bp := &backupParams{sourceDatasetID: "ds", backupTableID: "t", storageBucket: "b", destinationFormat: csvFormat, compressionType: gzipCompression}
+	assert.Equal(t, "", bp.gcsReference().FieldDelimiter)
+
+	bp.fieldDelimiter = "\t"
+	assert.Equal(t, "\t", bp.gcsReference().FieldDelimiter)
+}
+
+func TestRequestContext(t *testing.T) {
+	r := httptest.NewRequest(http.MethodPost, "/", nil)
+
+	os.Setenv("REQUEST_TIMEOUT_SECONDS", "")
+	ctx, cancel := requestContext(r)
+	_, hasDeadline := ctx.Deadline()
+	assert.False(t, hasDeadline)
+	cancel()
+	assert.Error(t, ctx.Err())
+
+	os.Setenv("REQUEST_TIMEOUT_SECONDS", "30")
+	defer os.Unsetenv("REQUEST_TIMEOUT_SECONDS")
+	ctx, cancel = requestContext(r)
+	defer cancel()
+	deadline, hasDeadline := ctx.Deadline()
+	assert.True(t, hasDeadline)
+	assert.WithinDuration(t, time.Now().Add(30*time.Second), deadline, 5*time.Second)
+}
+
+func TestMissingPermissions(t *testing.T) {
+	assert.Empty(t, missingPermissions(requiredBucketPermissions, []string{"storage.objects.create", "storage.objects.get"}))
+	assert.Equal(t, []string{"storage.objects.create"}, missingPermissions(requiredBucketPermissions, nil))
+	assert.Equal(t, []string{"b"}, missingPermissions([]string{"a", "b"}, []string{"a"}))
+}
+
+func TestLogName(t *testing.T) {
+	t.Setenv("LOG_NAME", "")
+	assert.Equal(t, defaultLogName, logName())
+
+	t.Setenv("LOG_NAME", " backups ")
+	assert.Equal(t, "backups", logName())
+}
+
+func TestNewLogPayload(t *testing.T) {
+	bp := &backupParams{runID: "run-1", sourceDatasetID: "ds", backupTableID: "t"}
+	assert.Equal(t, logPayload{Message: "starting", RunID: "run-1", Dataset: "ds", Table: "t"}, bp.newLogPayload("starting"))
+
+	bp.jobID = "job-1"
+	bp.bytesExported = 42
+	assert.Equal(t, logPayload{Message: "done", RunID: "run-1", Dataset: "ds", Table: "t", JobID: "job-1", BytesExported: 42}, bp.newLogPayload("done"))
+}
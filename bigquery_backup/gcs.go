@@ -0,0 +1,100 @@
+package bigquerybackup
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/googleapis/gax-go/v2"
+)
+
+const defaultStorageOpTimeout = 60 * time.Second
+
+// storageRetryOptions builds the retry behaviour of the storage client from the environment:
+//
+//   - GCS_RETRY_POLICY: "idempotent" (default), "always" or "never"
+//   - GCS_RETRY_INITIAL_BACKOFF_MS: first retry delay, default 1000
+//   - GCS_RETRY_MAX_BACKOFF_SECONDS: longest retry delay, default 30
+//
+// Retries stop when the operation's context expires, so GCS_OP_TIMEOUT_SECONDS also bounds
+// the total time spent retrying.
+func storageRetryOptions() ([]storage.RetryOption, error) {
+	var policy storage.RetryPolicy
+	switch p := strings.ToLower(strings.TrimSpace(os.Getenv("GCS_RETRY_POLICY"))); p {
+	case "", "idempotent":
+		policy = storage.RetryIdempotent
+	case "always":
+		policy = storage.RetryAlways
+	case "never":
+		policy = storage.RetryNever
+	default:
+		return nil, fmt.Errorf("invalid GCS_RETRY_POLICY %q, must be idempotent, always or never", p)
+	}
+
+	backoff := gax.Backoff{Initial: time.Second, Max: 30 * time.Second, Multiplier: 2}
+	if v := strings.TrimSpace(os.Getenv("GCS_RETRY_INITIAL_BACKOFF_MS")); v != "" {
+		ms, err := strconv.Atoi(v)
+		if err != nil || ms <= 0 {
+			return nil, fmt.Errorf("invalid GCS_RETRY_INITIAL_BACKOFF_MS %q", v)
+		}
+		backoff.Initial = time.Duration(ms) * time.Millisecond
+	}
+	if v := strings.TrimSpace(os.Getenv("GCS_RETRY_MAX_BACKOFF_SECONDS")); v != "" {
+		secs, err := strconv.Atoi(v)
+		if err != nil || secs <= 0 {
+			return nil, fmt.Errorf("invalid GCS_RETRY_MAX_BACKOFF_SECONDS %q", v)
+		}
+		backoff.Max = time.Duration(secs) * time.Second
+	}
+	return []storage.RetryOption{storage.WithPolicy(policy), storage.WithBackoff(backoff)}, nil
+}
+
+// storageOpTimeout returns the deadline applied to each auxiliary GCS operation, read from
+// the GCS_OP_TIMEOUT_SECONDS environment variable. It defaults to 60 seconds.
+func storageOpTimeout() time.Duration {
+	secs, err := strconv.Atoi(strings.TrimSpace(os.Getenv("GCS_OP_TIMEOUT_SECONDS")))
+	if err != nil || secs <= 0 {
+		return defaultStorageOpTimeout
+	}
+	return time.Duration(secs) * time.Second
+}
+
+// withStorageTimeout derives a context bounded by the per-operation GCS timeout.
+func withStorageTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(ctx, storageOpTimeout())
+}
+
+// sc is the storage client shared by every request handled by this instance. It is safe for
+// concurrent use and kept open between requests; Close closes it when the process shuts down.
+var sc *storage.Client
+var scErr error
+var scOnce sync.Once
+
+// sharedStorageClient returns the shared storage client, creating it on first use. The client
+// is not tied to a request context, and callers must not close it.
+func sharedStorageClient() (*storage.Client, error) {
+	scOnce.Do(func() {
+		sc, scErr = newStorageClient(context.Background())
+	})
+	return sc, scErr
+}
+
+// newStorageClient creates a storage client configured with the retry settings from the
+// environment.
+func newStorageClient(ctx context.Context) (*storage.Client, error) {
+	opts, err := storageRetryOptions()
+	if err != nil {
+		return nil, err
+	}
+	c, err := storage.NewClient(ctx)
+	if err != nil {
+		return nil, err
+	}
+	c.SetRetry(opts...)
+	return c, nil
+}
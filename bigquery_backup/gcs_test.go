@@ -0,0 +1,92 @@
+package bigquerybackup
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStorageRetryOptions(t *testing.T) {
+	tests := []struct {
+		name    string
+		env     map[string]string
+		wantErr bool
+	}{
+		{name: "Defaults", env: map[string]string{}},
+		{name: "Always", env: map[string]string{"GCS_RETRY_POLICY": "always"}},
+		{name: "Never", env: map[string]string{"GCS_RETRY_POLICY": "NEVER"}},
+		{name: "Unknown policy", env: map[string]string{"GCS_RETRY_POLICY": "sometimes"}, wantErr: true},
+		{name: "Custom backoff", env: map[string]string{"GCS_RETRY_INITIAL_BACKOFF_MS": "250", "GCS_RETRY_MAX_BACKOFF_SECONDS": "5"}},
+		{name: "Bad initial backoff", env: map[string]string{"GCS_RETRY_INITIAL_BACKOFF_MS": "soon"}, wantErr: true},
+		{name: "Bad max backoff", env: map[string]string{"GCS_RETRY_MAX_BACKOFF_SECONDS": "0"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, k := range []string{"GCS_RETRY_POLICY", "GCS_RETRY_INITIAL_BACKOFF_MS", "GCS_RETRY_MAX_BACKOFF_SECONDS"} {
+				t.Setenv(k, tt.env[k])
+			}
+			opts, err := storageRetryOptions()
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+				assert.Len(t, opts, 2)
+			}
+		})
+	}
+}
+
+func TestStorageOpTimeout(t *testing.T) {
+	t.Setenv("GCS_OP_TIMEOUT_SECONDS", "")
+	assert.Equal(t, defaultStorageOpTimeout, storageOpTimeout())
+
+	t.Setenv("GCS_OP_TIMEOUT_SECONDS", "15")
+	assert.Equal(t, 15*time.Second, storageOpTimeout())
+
+	t.Setenv("GCS_OP_TIMEOUT_SECONDS", "abc")
+	assert.Equal(t, defaultStorageOpTimeout, storageOpTimeout())
+}
+
+func TestSharedStorageClient(t *testing.T) {
+	clients := make([]*storage.Client, 8)
+	var wg sync.WaitGroup
+	for i := range clients {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			c, err := sharedStorageClient()
+			assert.NoError(t, err)
+			clients[i] = c
+		}(i)
+	}
+	wg.Wait()
+	for _, c := range clients {
+		assert.Same(t, clients[0], c)
+	}
+}
+
+// BenchmarkStorageClient compares creating a storage client for every operation, as each
+// request used to, with reusing the shared one.
+func BenchmarkStorageClient(b *testing.B) {
+	b.Run("PerRequest", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			c, err := newStorageClient(context.Background())
+			if err != nil {
+				b.Fatal(err)
+			}
+			_ = c.Close()
+		}
+	})
+	b.Run("Shared", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if _, err := sharedStorageClient(); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
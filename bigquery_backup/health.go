@@ -0,0 +1,48 @@
+package bigquerybackup
+
+import (
+	"net/http"
+	"strings"
+)
+
+const (
+	healthStatusHealthy   = "healthy"
+	healthStatusReady     = "ready"
+	healthStatusUnhealthy = "unhealthy"
+)
+
+// healthResponse is the body answering a health or readiness probe.
+type healthResponse struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// serveHealth answers the GET probes of the backup function without starting a backup. GET /
+// and GET /healthz report that the function is up. GET /readyz additionally checks that the
+// project is configured and the BigQuery client can be created, and responds with 503 when it
+// cannot. GET /metrics serves the Prometheus metrics when ENABLE_PROMETHEUS is set, and GET
+// /capabilities the destination formats with their default and allowed compression types. Any
+// other GET path is answered with 404.
+func serveHealth(w http.ResponseWriter, r *http.Request) {
+	switch strings.TrimSuffix(r.URL.Path, "/") {
+	case "/metrics":
+		serveMetrics(w, r)
+	case "/capabilities":
+		writeJSON(w, http.StatusOK, envFormatDefaults.capabilities())
+	case "", "/healthz":
+		writeJSON(w, http.StatusOK, healthResponse{Status: healthStatusHealthy})
+	case "/readyz":
+		bp := &backupParams{}
+		err := bp.setProjectID()
+		if err == nil {
+			err = bp.setBigQueryClient(r.Context())
+		}
+		if err != nil {
+			writeJSON(w, http.StatusServiceUnavailable, healthResponse{Status: healthStatusUnhealthy, Error: err.Error()})
+			return
+		}
+		writeJSON(w, http.StatusOK, healthResponse{Status: healthStatusReady})
+	default:
+		writeError(w, http.StatusNotFound, "not found: "+r.URL.Path)
+	}
+}
@@ -0,0 +1,35 @@
+package bigquerybackup
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestServeHealth(t *testing.T) {
+	tests := []struct {
+		name     string
+		path     string
+		project  string
+		wantCode int
+		wantBody string
+	}{
+		{name: "Root", path: "/", wantCode: http.StatusOK, wantBody: `{"status":"healthy"}`},
+		{name: "Healthz", path: "/healthz", wantCode: http.StatusOK, wantBody: `{"status":"healthy"}`},
+		{name: "Ready", path: "/readyz", project: "test-project", wantCode: http.StatusOK, wantBody: `{"status":"ready"}`},
+		{name: "Not ready", path: "/readyz", wantCode: http.StatusServiceUnavailable, wantBody: `{"status":"unhealthy","error":"GCP_PROJECT environment variable is not set"}`},
+		{name: "Unknown path", path: "/status", wantCode: http.StatusNotFound, wantBody: `{"error":"not found: /status","code":404}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("GCP_PROJECT", tt.project)
+			rec := httptest.NewRecorder()
+			bigQueryBackup(rec, httptest.NewRequest(http.MethodGet, tt.path, nil))
+			assert.Equal(t, tt.wantCode, rec.Code)
+			assert.JSONEq(t, tt.wantBody, rec.Body.String())
+		})
+	}
+}
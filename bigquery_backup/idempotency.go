@@ -0,0 +1,311 @@
+package bigquerybackup
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/googleapi"
+)
+
+const (
+	idempotencyHeader    = "Idempotency-Key"
+	idempotencyPrefix    = "_idempotency"
+	idempotencyRunning   = "running"
+	idempotencyCompleted = "completed"
+	// idempotencyStarted marks a key whose request answered 202 because its extract job was
+	// still running, started async or outlasting JOB_TIMEOUT_SECONDS.
+	idempotencyStarted = "started"
+
+	defaultIdempotencyTTL = 24 * time.Hour
+	// idempotencyClaimTimeout is how long a running claim blocks retries before it is treated
+	// as abandoned by an instance that died mid-backup. It matches the longest Cloud Functions
+	// request timeout.
+	idempotencyClaimTimeout = time.Hour
+)
+
+// idempotencyRecord is stored in the backup bucket for every idempotency key. A record is
+// written as running when a request claims the key, and rewritten as completed with the
+// backup result once the backup succeeds, or as started with the job ID and location when the
+// request answered while its extract job was still running. Response is the body the request was answered with,
+// which retries are answered with too; it is the per-table summary of a dataset-wide or
+// table-list backup, which has no single job ID or destination.
+type idempotencyRecord struct {
+	Key            string          `json:"key"`
+	Status         string          `json:"status"`
+	RunID          string          `json:"run_id"`
+	JobID          string          `json:"job_id,omitempty"`
+	Location       string          `json:"location,omitempty"`
+	DestinationURI string          `json:"destination_uri,omitempty"`
+	Response       json.RawMessage `json:"response,omitempty"`
+	CreatedAt      time.Time       `json:"created_at"`
+	CompletedAt    time.Time       `json:"completed_at,omitempty"`
+}
+
+// idempotencyTTL returns how long a completed key is remembered, read from the
+// IDEMPOTENCY_TTL_HOURS environment variable. It defaults to 24 hours.
+func idempotencyTTL() time.Duration {
+	hours, err := strconv.Atoi(strings.TrimSpace(os.Getenv("IDEMPOTENCY_TTL_HOURS")))
+	if err != nil || hours <= 0 {
+		return defaultIdempotencyTTL
+	}
+	return time.Duration(hours) * time.Hour
+}
+
+// idempotencyObjectName returns the object that stores the record for key. The key is hashed
+// so that arbitrary client-supplied strings map to a safe object name.
+func idempotencyObjectName(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return fmt.Sprintf("%s/%s.json", idempotencyPrefix, hex.EncodeToString(sum[:]))
+}
+
+// expired reports whether rec no longer protects against a duplicate run at now. Completed
+// and started records last for ttl, running records only for idempotencyClaimTimeout.
+func (rec *idempotencyRecord) expired(now time.Time, ttl time.Duration) bool {
+	if rec.Status == idempotencyRunning {
+		return now.Sub(rec.CreatedAt) > idempotencyClaimTimeout
+	}
+	return now.Sub(rec.CompletedAt) > ttl
+}
+
+// setIdempotencyKey reads the idempotency key from the Idempotency-Key header, falling back to
+// the idempotency_key field of the POST body.
+func (bp *backupParams) setIdempotencyKey(r *http.Request, pb postBodyParams) {
+	key := strings.TrimSpace(r.Header.Get(idempotencyHeader))
+	if key == "" {
+		key = strings.TrimSpace(pb.IdempotencyKey)
+	}
+	bp.idempotencyKey = key
+}
+
+// claimIdempotencyKey atomically claims the request's idempotency key in the backup bucket.
+// It returns true when this request owns the key and should run the backup. When another
+// request already holds or completed the key it returns false together with that request's
+// record. Creation uses a DoesNotExist precondition, so concurrent retries cannot both win.
+func (bp *backupParams) claimIdempotencyKey(ctx context.Context) (bool, *idempotencyRecord, error) {
+	c, err := sharedStorageClient()
+	if err != nil {
+		return false, nil, err
+	}
+
+	obj := c.Bucket(bp.storageBucket).Object(idempotencyObjectName(bp.idempotencyKey))
+	rec := &idempotencyRecord{
+		Key:       bp.idempotencyKey,
+		Status:    idempotencyRunning,
+		RunID:     bp.runID,
+		CreatedAt: time.Now().UTC(),
+	}
+	err = writeIdempotencyRecord(ctx, obj.If(storage.Conditions{DoesNotExist: true}), rec)
+	if err == nil {
+		return true, nil, nil
+	}
+	if !isPreconditionFailed(err) {
+		return false, nil, err
+	}
+
+	existing, gen, err := readIdempotencyRecord(ctx, obj)
+	if err != nil {
+		return false, nil, err
+	}
+	if !existing.expired(time.Now().UTC(), idempotencyTTL()) {
+		return false, existing, nil
+	}
+
+	// The previous record expired; take it over unless another retry beat us to it.
+	err = writeIdempotencyRecord(ctx, obj.If(storage.Conditions{GenerationMatch: gen}), rec)
+	if isPreconditionFailed(err) {
+		existing, _, err = readIdempotencyRecord(ctx, obj)
+		if err != nil {
+			return false, nil, err
+		}
+		return false, existing, nil
+	}
+	if err != nil {
+		return false, nil, err
+	}
+	return true, nil, nil
+}
+
+// finishIdempotencyKey records the result of a claimed key under status, idempotencyCompleted
+// or idempotencyStarted, storing resp, the body the request was answered with, so that retries
+// can be answered from the record. An empty status, for a failed backup, removes the claim so
+// that a retry runs the backup again.
+func (bp *backupParams) finishIdempotencyKey(ctx context.Context, status string, resp interface{}) error {
+	c, err := sharedStorageClient()
+	if err != nil {
+		return err
+	}
+
+	obj := c.Bucket(bp.storageBucket).Object(idempotencyObjectName(bp.idempotencyKey))
+	if status == "" {
+		ctx, cancel := withStorageTimeout(ctx)
+		defer cancel()
+		return obj.Delete(ctx)
+	}
+	rec, err := bp.finishedIdempotencyRecord(status, resp, time.Now().UTC())
+	if err != nil {
+		return err
+	}
+	return writeIdempotencyRecord(ctx, obj, rec)
+}
+
+// finishedIdempotencyRecord returns the record of a key whose request finished with status and
+// was answered with resp. Only a single-table backup has a job ID, location and destination of
+// its own.
+func (bp *backupParams) finishedIdempotencyRecord(status string, resp interface{}, now time.Time) (*idempotencyRecord, error) {
+	data, err := json.Marshal(resp)
+	if err != nil {
+		return nil, err
+	}
+	rec := &idempotencyRecord{
+		Key:         bp.idempotencyKey,
+		Status:      status,
+		RunID:       bp.runID,
+		Response:    data,
+		CreatedAt:   now,
+		CompletedAt: now,
+	}
+	if !bp.isDatasetBackup() && !bp.isTableListBackup() {
+		rec.JobID, rec.Location, rec.DestinationURI = bp.jobID, bp.location, bp.destinationURI()
+	}
+	return rec, nil
+}
+
+// replay returns the status and body a retry of the finished request is answered with: 202 for
+// a started record, whose job may still be running, and 200 for a completed one. The body is
+// the response recorded for it, or for a record written without one, its job and destination.
+func (rec *idempotencyRecord) replay() (int, interface{}) {
+	code, status := http.StatusOK, "ok"
+	if rec.Status == idempotencyStarted {
+		code, status = http.StatusAccepted, asyncStatusRunning
+	}
+	if len(rec.Response) > 0 {
+		return code, rec.Response
+	}
+	return code, successResponse{Status: status, JobID: rec.JobID, Location: rec.Location, RunID: rec.RunID, DestinationURI: rec.DestinationURI}
+}
+
+func writeIdempotencyRecord(ctx context.Context, obj *storage.ObjectHandle, rec *idempotencyRecord) error {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := withStorageTimeout(ctx)
+	defer cancel()
+	w := obj.NewWriter(ctx)
+	w.ContentType = "application/json"
+	if _, err := w.Write(data); err != nil {
+		_ = w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+func readIdempotencyRecord(ctx context.Context, obj *storage.ObjectHandle) (*idempotencyRecord, int64, error) {
+	ctx, cancel := withStorageTimeout(ctx)
+	defer cancel()
+	r, err := obj.NewReader(ctx)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer r.Close()
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, 0, err
+	}
+	var rec idempotencyRecord
+	if err := json.Unmarshal(data, &rec); err != nil {
+		return nil, 0, err
+	}
+	return &rec, r.Attrs.Generation, nil
+}
+
+// isPreconditionFailed reports whether err is a GCS 412 returned for an unmet write condition.
+func isPreconditionFailed(err error) bool {
+	var gerr *googleapi.Error
+	return errors.As(err, &gerr) && gerr.Code == http.StatusPreconditionFailed
+}
+
+// maxIdempotentJobAttempts bounds how many extract jobs one idempotency key may start for a
+// table. Every failed attempt uses up one job ID, since BigQuery never reuses a job ID.
+const maxIdempotentJobAttempts = 20
+
+// idempotentJobID returns the ID of the extract job started by the given attempt to back up the
+// table for the request's idempotency key. It is derived from the key and the table, so a retry
+// computes the same IDs and BigQuery itself refuses to start a duplicate export.
+func (bp *backupParams) idempotentJobID(attempt int) string {
+	sum := sha256.Sum256([]byte(strings.Join([]string{bp.idempotencyKey, bp.sourceProjectID(), bp.sourceDatasetID, bp.backupName()}, "\x00")))
+	return fmt.Sprintf("bigquery_backup_%s_%d", hex.EncodeToString(sum[:16]), attempt)
+}
+
+// resolveIdempotentJob looks for extract jobs an earlier request with the same idempotency key
+// started for the table. It returns true when one of them succeeded, recording its job ID and
+// destination so its result is returned instead of starting a new export. A job that is still
+// running is reported as a 409 conflict. Failed attempts are passed over, and the first unused
+// job ID is kept for the new export. It does nothing without an idempotency key.
+func (bp *backupParams) resolveIdempotentJob(ctx context.Context) (bool, error) {
+	if bp.idempotencyKey == "" {
+		return false, nil
+	}
+	md, err := bp.datasetMetadata(ctx)
+	if err != nil {
+		return false, err
+	}
+	for attempt := 0; attempt < maxIdempotentJobAttempts; attempt++ {
+		id := bp.idempotentJobID(attempt)
+		info, err := bp.jobClient().extractJob(ctx, id, md.Location)
+		if isNotFound(err) {
+			bp.extractJobID = id
+			return false, nil
+		}
+		if err != nil {
+			return false, fmt.Errorf("failed to look up job %s: %v", id, err)
+		}
+		switch {
+		case !info.done:
+			return false, withStatus(http.StatusConflict, fmt.Errorf("a backup with this idempotency key is still running as job %s", id))
+		case info.err == nil:
+			bp.jobID, bp.jobReused = id, true
+			if len(info.destinationURIs) > 0 {
+				bp.reusedJobURI = info.destinationURIs[0]
+			}
+			_ = bp.logInfo(fmt.Sprintf("Job %s already backed up table %s.%s for this idempotency key, not starting another export", id, bp.sourceDatasetID, bp.backupTableID))
+			return true, nil
+		}
+		_ = bp.logInfo(fmt.Sprintf("Earlier attempt %s for this idempotency key failed: %v, trying again", id, info.err))
+	}
+	return false, withStatus(http.StatusConflict, fmt.Errorf("the backup failed %d times for this idempotency key, use a new key", maxIdempotentJobAttempts))
+}
+
+// reusedJob reports whether the request was answered with an earlier extract job for its
+// idempotency key instead of a new export.
+func (bp *backupParams) reusedJob() bool {
+	return bp.jobReused
+}
+
+// jobIDConflict reports a failure to start the extract job because its idempotent job ID is
+// already taken, meaning a concurrent retry with the same key started it first, as a 409. It
+// returns nil for any other error.
+func (bp *backupParams) jobIDConflict(err error) error {
+	var gerr *googleapi.Error
+	if bp.extractJobID == "" || !errors.As(err, &gerr) || gerr.Code != http.StatusConflict {
+		return nil
+	}
+	return withStatus(http.StatusConflict, fmt.Errorf("job %s was already started by another request with the same idempotency key", bp.extractJobID))
+}
+
+// isNotFound reports whether err is a 404 from a Google API.
+func isNotFound(err error) bool {
+	var gerr *googleapi.Error
+	return errors.As(err, &gerr) && gerr.Code == http.StatusNotFound
+}
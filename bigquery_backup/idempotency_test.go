@@ -0,0 +1,285 @@
+package bigquerybackup
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/bigquery"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/option"
+)
+
+func TestIdempotencyRecordExpired(t *testing.T) {
+	now := time.Date(2024, 1, 15, 12, 0, 0, 0, time.UTC)
+	ttl := 24 * time.Hour
+
+	tests := []struct {
+		name string
+		rec  idempotencyRecord
+		want bool
+	}{
+		{
+			name: "Recently completed",
+			rec:  idempotencyRecord{Status: idempotencyCompleted, CompletedAt: now.Add(-time.Hour)},
+			want: false,
+		},
+		{
+			name: "Completed outside the window",
+			rec:  idempotencyRecord{Status: idempotencyCompleted, CompletedAt: now.Add(-25 * time.Hour)},
+			want: true,
+		},
+		{
+			name: "Recently started",
+			rec:  idempotencyRecord{Status: idempotencyStarted, CreatedAt: now.Add(-2 * time.Hour), CompletedAt: now.Add(-2 * time.Hour)},
+			want: false,
+		},
+		{
+			name: "Started outside the window",
+			rec:  idempotencyRecord{Status: idempotencyStarted, CompletedAt: now.Add(-25 * time.Hour)},
+			want: true,
+		},
+		{
+			name: "Running claim",
+			rec:  idempotencyRecord{Status: idempotencyRunning, CreatedAt: now.Add(-10 * time.Minute)},
+			want: false,
+		},
+		{
+			name: "Abandoned running claim",
+			rec:  idempotencyRecord{Status: idempotencyRunning, CreatedAt: now.Add(-2 * time.Hour)},
+			want: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, tt.rec.expired(now, ttl))
+		})
+	}
+}
+
+func TestIdempotencyTTL(t *testing.T) {
+	t.Setenv("IDEMPOTENCY_TTL_HOURS", "")
+	assert.Equal(t, defaultIdempotencyTTL, idempotencyTTL())
+
+	t.Setenv("IDEMPOTENCY_TTL_HOURS", "6")
+	assert.Equal(t, 6*time.Hour, idempotencyTTL())
+
+	t.Setenv("IDEMPOTENCY_TTL_HOURS", "-1")
+	assert.Equal(t, defaultIdempotencyTTL, idempotencyTTL())
+}
+
+func TestIdempotencyObjectName(t *testing.T) {
+	name := idempotencyObjectName("nightly/2024-01-15")
+	assert.True(t, strings.HasPrefix(name, idempotencyPrefix+"/"))
+	assert.NotContains(t, strings.TrimPrefix(name, idempotencyPrefix+"/"), "/")
+	assert.Equal(t, name, idempotencyObjectName("nightly/2024-01-15"))
+	assert.NotEqual(t, name, idempotencyObjectName("nightly/2024-01-16"))
+}
+
+func TestSetIdempotencyKey(t *testing.T) {
+	r, _ := http.NewRequest(http.MethodPost, "/", nil)
+	bp := &backupParams{}
+
+	bp.setIdempotencyKey(r, postBodyParams{IdempotencyKey: "from-body"})
+	assert.Equal(t, "from-body", bp.idempotencyKey)
+
+	r.Header.Set(idempotencyHeader, "from-header")
+	bp.setIdempotencyKey(r, postBodyParams{IdempotencyKey: "from-body"})
+	assert.Equal(t, "from-header", bp.idempotencyKey)
+}
+
+func TestIsPreconditionFailed(t *testing.T) {
+	assert.True(t, isPreconditionFailed(fmt.Errorf("wrapped: %w", &googleapi.Error{Code: http.StatusPreconditionFailed})))
+	assert.False(t, isPreconditionFailed(&googleapi.Error{Code: http.StatusNotFound}))
+	assert.False(t, isPreconditionFailed(errors.New("boom")))
+	assert.False(t, isPreconditionFailed(nil))
+}
+
+func TestIdempotentJobID(t *testing.T) {
+	bp := &backupParams{idempotencyKey: "nightly-2024-01-15", projectID: "p", sourceDatasetID: "ds", backupTableID: "t"}
+	id := bp.idempotentJobID(0)
+	assert.Equal(t, id, bp.idempotentJobID(0))
+	assert.Regexp(t, `^bigquery_backup_[0-9a-f]{32}_0$`, id)
+	assert.NotEqual(t, id, bp.idempotentJobID(1))
+
+	other := *bp
+	other.backupTableID = "u"
+	assert.NotEqual(t, id, other.idempotentJobID(0))
+	other = *bp
+	other.idempotencyKey = "nightly-2024-01-16"
+	assert.NotEqual(t, id, other.idempotentJobID(0))
+}
+
+func TestResolveIdempotentJob(t *testing.T) {
+	key := &backupParams{idempotencyKey: "k", projectID: "p", sourceDatasetID: "ds", backupTableID: "t"}
+	first, second := key.idempotentJobID(0), key.idempotentJobID(1)
+	failed := &extractJobInfo{done: true, err: errors.New("extract failed")}
+	succeeded := &extractJobInfo{done: true, destinationURIs: []string{"gs://b/ds/t.20240115T100000Z/t-*.avro"}}
+
+	tests := []struct {
+		name      string
+		key       string
+		jobs      map[string]*extractJobInfo
+		wantReuse bool
+		wantJobID string
+		wantCode  int
+	}{
+		{name: "No key"},
+		{name: "First attempt", key: "k", wantJobID: first},
+		{name: "Earlier attempt failed", key: "k", jobs: map[string]*extractJobInfo{first: failed}, wantJobID: second},
+		{name: "Earlier attempt succeeded", key: "k", jobs: map[string]*extractJobInfo{first: failed, second: succeeded}, wantReuse: true},
+		{name: "Earlier attempt running", key: "k", jobs: map[string]*extractJobInfo{first: {}}, wantCode: http.StatusConflict},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := &fakeBigQuery{
+				datasets: map[string]*bigquery.DatasetMetadata{"p:ds": {Location: "US"}},
+				jobs:     tt.jobs,
+			}
+			bp := &backupParams{idempotencyKey: tt.key, projectID: "p", sourceDatasetID: "ds", backupTableID: "t", metadata: fake, jobs: fake, cache: newMetadataCache()}
+			reused, err := bp.resolveIdempotentJob(context.Background())
+			if tt.wantCode != 0 {
+				assert.Equal(t, tt.wantCode, statusCode(err))
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.wantReuse, reused)
+			assert.Equal(t, tt.wantReuse, bp.reusedJob())
+			assert.Equal(t, tt.wantJobID, bp.extractJobID)
+			if tt.wantReuse {
+				assert.Equal(t, second, bp.jobID)
+				assert.Equal(t, succeeded.destinationURIs[0], bp.destinationURI())
+			}
+		})
+	}
+}
+
+func TestJobIDConflict(t *testing.T) {
+	bp := &backupParams{extractJobID: "bigquery_backup_x_0"}
+	assert.Equal(t, http.StatusConflict, statusCode(bp.jobIDConflict(&googleapi.Error{Code: http.StatusConflict})))
+	assert.Nil(t, bp.jobIDConflict(&googleapi.Error{Code: http.StatusBadRequest}))
+	assert.Nil(t, bp.jobIDConflict(nil))
+	assert.Nil(t, (&backupParams{}).jobIDConflict(&googleapi.Error{Code: http.StatusConflict}))
+}
+
+func TestSetupExtractorIdempotentJobID(t *testing.T) {
+	bp := &backupParams{projectID: "test-project", sourceDatasetID: "ds", backupTableID: "t", storageBucket: "b", destinationFormat: avroFormat}
+	assert.NoError(t, bp.setBigQueryClient(context.Background()))
+	assert.Equal(t, bigquery.JobIDConfig{}, setupExtractor(bp).JobIDConfig)
+
+	bp.extractJobID = "bigquery_backup_x_0"
+	assert.Equal(t, bigquery.JobIDConfig{JobID: "bigquery_backup_x_0"}, setupExtractor(bp).JobIDConfig)
+}
+
+func TestCompletedIdempotencyRecord(t *testing.T) {
+	now := time.Date(2024, 1, 15, 12, 0, 0, 0, time.UTC)
+
+	t.Run("Single table", func(t *testing.T) {
+		bp := &backupParams{idempotencyKey: "k", runID: "run-1", jobID: "job_1", sourceDatasetID: "ds", backupTableID: "t", storageBucket: "b", destinationFormat: avroFormat, backupTime: now}
+		resp := successResponse{Status: "ok", JobID: "job_1", RunID: "run-1", DestinationURI: bp.destinationURI(), FileCount: 3}
+		rec, err := bp.finishedIdempotencyRecord(idempotencyCompleted, resp, now)
+		assert.NoError(t, err)
+		assert.Equal(t, idempotencyCompleted, rec.Status)
+		assert.Equal(t, "job_1", rec.JobID)
+		assert.Equal(t, "gs://b/ds/t.20240115T120000Z/t-*.avro", rec.DestinationURI)
+
+		code, body := rec.replay()
+		assert.Equal(t, http.StatusOK, code)
+		data, err := json.Marshal(body)
+		assert.NoError(t, err)
+		assert.JSONEq(t, `{"status": "ok", "job_id": "job_1", "run_id": "run-1", "destination_uri": "gs://b/ds/t.20240115T120000Z/t-*.avro", "file_count": 3}`, string(data))
+	})
+
+	t.Run("Dataset", func(t *testing.T) {
+		bp := &backupParams{idempotencyKey: "k", runID: "run-1", sourceDatasetID: "ds", storageBucket: "b", destinationFormat: avroFormat, backupTime: now}
+		resp := datasetResponse{Status: "ok", RunID: "run-1", Tables: []tableResult{{Table: "t1", Status: tableStatusOK, JobID: "job_1"}}}
+		rec, err := bp.finishedIdempotencyRecord(idempotencyCompleted, resp, now)
+		assert.NoError(t, err)
+		assert.Empty(t, rec.JobID)
+		assert.Empty(t, rec.DestinationURI)
+
+		code, body := rec.replay()
+		assert.Equal(t, http.StatusOK, code)
+		want, err := json.Marshal(resp)
+		assert.NoError(t, err)
+		data, err := json.Marshal(body)
+		assert.NoError(t, err)
+		assert.JSONEq(t, string(want), string(data))
+	})
+}
+
+func TestIdempotencyRecordReplayWithoutResponse(t *testing.T) {
+	rec := &idempotencyRecord{Status: idempotencyCompleted, RunID: "run-1", JobID: "job_1", DestinationURI: "gs://b/ds/t.20240115T120000Z/t-*.avro"}
+	code, body := rec.replay()
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, successResponse{Status: "ok", JobID: "job_1", RunID: "run-1", DestinationURI: "gs://b/ds/t.20240115T120000Z/t-*.avro"}, body)
+}
+
+func TestStartedIdempotencyRecord(t *testing.T) {
+	now := time.Date(2024, 1, 15, 12, 0, 0, 0, time.UTC)
+	bp := &backupParams{idempotencyKey: "k", runID: "run-1", jobID: "job_1", location: "EU", sourceDatasetID: "ds", backupTableID: "t", storageBucket: "b", destinationFormat: avroFormat, backupTime: now, async: true}
+	rec, err := bp.finishedIdempotencyRecord(idempotencyStarted, bp.startedResponse(), now)
+	assert.NoError(t, err)
+	assert.Equal(t, idempotencyStarted, rec.Status)
+	assert.Equal(t, "job_1", rec.JobID)
+	assert.Equal(t, "EU", rec.Location)
+
+	code, body := rec.replay()
+	assert.Equal(t, http.StatusAccepted, code)
+	data, err := json.Marshal(body)
+	assert.NoError(t, err)
+	assert.Contains(t, string(data), `"status":"running"`)
+	assert.Contains(t, string(data), `"location":"EU"`)
+
+	rec.Response = nil
+	code, body = rec.replay()
+	assert.Equal(t, http.StatusAccepted, code)
+	assert.Equal(t, successResponse{Status: asyncStatusRunning, JobID: "job_1", Location: "EU", RunID: "run-1", DestinationURI: rec.DestinationURI}, body)
+}
+
+func TestInvalidRequestDoesNotClaimIdempotencyKey(t *testing.T) {
+	t.Setenv("GCP_PROJECT", "test-project")
+	savedFactory, savedBackend := newLogBackend, lb
+	savedNewClient, savedClient, savedClientErr := newBigQueryClient, bc, bcErr
+	t.Cleanup(func() {
+		newLogBackend, lb = savedFactory, savedBackend
+		newBigQueryClient, bc, bcErr, bcOnce = savedNewClient, savedClient, savedClientErr, sync.Once{}
+	})
+	newLogBackend = func(string) (logBackend, error) {
+		return &stdoutLogBackend{out: &strings.Builder{}}, nil
+	}
+	lb = nil
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusNotFound)
+		_, _ = w.Write([]byte(`{"error": {"code": 404, "message": "Not found: Dataset test-project:typo"}}`))
+	}))
+	defer srv.Close()
+	newBigQueryClient = func(ctx context.Context, projectID string) (*bigquery.Client, error) {
+		return bigquery.NewClient(ctx, projectID, option.WithEndpoint(srv.URL), option.WithoutAuthentication())
+	}
+	bc, bcErr, bcOnce = nil, nil, sync.Once{}
+
+	// Claiming the key would write to the unreachable bucket and answer 500.
+	for _, body := range []string{
+		`{"dataset_name": "typo", "table_name": "t", "storage_bucket": "backups", "idempotency_key": "k"}`,
+		`{"dataset_name": "typo", "storage_bucket": "backups", "idempotency_key": "k"}`,
+		`{"dataset_name": "typo", "tables": ["a", "b"], "storage_bucket": "backups", "idempotency_key": "k"}`,
+	} {
+		r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+		w := httptest.NewRecorder()
+		bigQueryBackup(w, r)
+		assert.Equal(t, http.StatusNotFound, w.Code, "%s: %s", body, w.Body.String())
+	}
+}
@@ -0,0 +1,106 @@
+package bigquerybackup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"time"
+
+	"cloud.google.com/go/bigquery"
+)
+
+const skipReasonUnchanged = "unchanged"
+
+// incrementalClockSkew is how close to the last backup a modification has to be for the table
+// to count as changed anyway. The backup time is taken from this instance's clock when the
+// request arrives, while LastModifiedTime comes from BigQuery's, so a write just before the
+// recorded backup time may not be in the export.
+const incrementalClockSkew = 5 * time.Minute
+
+// checkIncremental validates the incremental field of the POST body. The last backup is found
+// by the default object layout, and a query result has no last-modified time to compare.
+func checkIncremental(pb *postBodyParams) error {
+	if !pb.Incremental {
+		return nil
+	}
+	switch {
+	case pb.Query != "":
+		return errors.New("incremental cannot be combined with query")
+	case pb.PathTemplate != "":
+		return errors.New("incremental cannot be combined with path_template, earlier backups are found by the default object layout")
+	}
+	return nil
+}
+
+// latestBackupTime returns the time of the most recent complete backup among the object names,
+// all under the backups of one table. A backup is complete once its manifest was written, so a
+// failed or still running export never counts. It returns false when there is none.
+func latestBackupTime(names []string) (time.Time, bool) {
+	var latest time.Time
+	found := false
+	for _, name := range names {
+		if path.Base(name) != manifestObjectName {
+			continue
+		}
+		_, _, t, ok := parseBackupPrefix(name)
+		if !ok {
+			continue
+		}
+		if !found || t.After(latest) {
+			latest, found = t, true
+		}
+	}
+	return latest, found
+}
+
+// changedSince reports whether the table described by md may have changed since a backup taken
+// at backupTime. Rows still in the streaming buffer are not reflected in LastModifiedTime, so a
+// table with a streaming buffer always counts as changed.
+func changedSince(md *bigquery.TableMetadata, backupTime time.Time) bool {
+	if md.StreamingBuffer != nil {
+		return true
+	}
+	return !md.LastModifiedTime.UTC().Before(backupTime.UTC().Add(-incrementalClockSkew))
+}
+
+// checkChanged enforces incremental. It returns true when the backup should go ahead and false
+// when the table has not changed since its most recent complete backup and should be skipped.
+// A table that was never backed up is always backed up. Without incremental every table goes
+// ahead.
+func (bp *backupParams) checkChanged(ctx context.Context) (bool, error) {
+	if !bp.incremental {
+		return true, nil
+	}
+	if bp.materializeView {
+		return true, bp.logInfo(fmt.Sprintf("%s.%s is a view, whose last-modified time does not reflect changes to its data, running a full backup", bp.sourceDatasetID, bp.backupTableID))
+	}
+	c, err := sharedStorageClient()
+	if err != nil {
+		return false, err
+	}
+
+	prefix := fmt.Sprintf("%s/%s.", bp.sourceDatasetID, bp.backupName())
+	objects, err := listObjectAttrs(ctx, c.Bucket(bp.storageBucket), prefix)
+	if err != nil {
+		return false, fmt.Errorf("failed to list earlier backups: %v", err)
+	}
+	names := make([]string, len(objects))
+	for i, attrs := range objects {
+		names[i] = attrs.Name
+	}
+	last, ok := latestBackupTime(names)
+	if !ok {
+		return true, bp.logInfo(fmt.Sprintf("No earlier backup of table %s.%s found, running a full backup", bp.sourceDatasetID, bp.backupName()))
+	}
+
+	md, err := bp.tableMetadata(ctx)
+	if err != nil {
+		return false, err
+	}
+	if changedSince(md, last) {
+		return true, nil
+	}
+	bp.skipReason = skipReasonUnchanged
+	return false, bp.logInfo(fmt.Sprintf("Skipping backup, table %s.%s was last modified at %s, before its last backup at %s", bp.sourceDatasetID, bp.backupName(), md.LastModifiedTime.UTC().Format(time.RFC3339), last.Format(time.RFC3339)))
+}
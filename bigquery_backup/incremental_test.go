@@ -0,0 +1,94 @@
+package bigquerybackup
+
+import (
+	"testing"
+	"time"
+
+	"cloud.google.com/go/bigquery"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckIncremental(t *testing.T) {
+	tests := []struct {
+		name    string
+		pb      postBodyParams
+		wantErr bool
+	}{
+		{name: "Not requested", pb: postBodyParams{Query: "SELECT 1", PathTemplate: "{dataset}/{table}/part-*.avro"}},
+		{name: "Table backup", pb: postBodyParams{TableName: "t", Incremental: true}},
+		{name: "Dataset backup", pb: postBodyParams{Incremental: true}},
+		{name: "Query", pb: postBodyParams{Query: "SELECT 1", Incremental: true}, wantErr: true},
+		{name: "Path template", pb: postBodyParams{TableName: "t", PathTemplate: "{dataset}/{table}/part-*.avro", Incremental: true}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.wantErr, checkIncremental(&tt.pb) != nil)
+		})
+	}
+}
+
+func TestLatestBackupTime(t *testing.T) {
+	tests := []struct {
+		name      string
+		names     []string
+		want      time.Time
+		wantFound bool
+	}{
+		{name: "Never backed up"},
+		{
+			name:  "Incomplete backup",
+			names: []string{"ds/t.20240115T100000Z/t-000000000000.avro"},
+		},
+		{
+			name: "Latest complete backup",
+			names: []string{
+				"ds/t.20240114T100000Z/t-000000000000.avro",
+				"ds/t.20240114T100000Z/manifest.json",
+				"ds/t.20240115T100000Z/manifest.json",
+				"ds/t.20240116T100000Z/t-000000000000.avro",
+			},
+			want:      time.Date(2024, 1, 15, 10, 0, 0, 0, time.UTC),
+			wantFound: true,
+		},
+		{
+			name:  "Unparsable prefix",
+			names: []string{"ds/t.latest/manifest.json"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, found := latestBackupTime(tt.names)
+			assert.Equal(t, tt.wantFound, found)
+			assert.True(t, tt.want.Equal(got), "got %s", got)
+		})
+	}
+}
+
+func TestChangedSince(t *testing.T) {
+	backup := time.Date(2024, 1, 15, 10, 0, 0, 0, time.UTC)
+	berlin := time.FixedZone("CET", 3600)
+	tests := []struct {
+		name string
+		md   *bigquery.TableMetadata
+		want bool
+	}{
+		{name: "Modified before the backup", md: &bigquery.TableMetadata{LastModifiedTime: backup.Add(-time.Hour)}, want: false},
+		{name: "Modified after the backup", md: &bigquery.TableMetadata{LastModifiedTime: backup.Add(time.Hour)}, want: true},
+		{name: "Modified just before the backup", md: &bigquery.TableMetadata{LastModifiedTime: backup.Add(-time.Minute)}, want: true},
+		{name: "Other time zone, before the backup", md: &bigquery.TableMetadata{LastModifiedTime: backup.Add(-time.Hour).In(berlin)}, want: false},
+		{name: "Other time zone, after the backup", md: &bigquery.TableMetadata{LastModifiedTime: backup.Add(time.Hour).In(berlin)}, want: true},
+		{
+			name: "Streaming buffer",
+			md:   &bigquery.TableMetadata{LastModifiedTime: backup.Add(-time.Hour), StreamingBuffer: &bigquery.StreamingBuffer{}},
+			want: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, changedSince(tt.md, backup))
+		})
+	}
+}
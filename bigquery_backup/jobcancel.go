@@ -0,0 +1,34 @@
+package bigquerybackup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// jobStateCancelled is the state of a backup result whose request was cancelled while waiting
+// for the extract job. It is not a BigQuery job state: the job may still be running.
+const jobStateCancelled = "CANCELLED"
+
+// jobCancelTimeout bounds the attempt to cancel the extract job of a cancelled request.
+const jobCancelTimeout = 10 * time.Second
+
+// errRequestCancelled is the error of a backup whose request was cancelled, by the caller or
+// the platform, while waiting for its extract job.
+var errRequestCancelled = errors.New("request was cancelled while waiting for the extract job")
+
+// abandonJob handles an extract job whose request was cancelled while waiting for it. Nothing
+// will run the steps after the export, so the job is cancelled on a fresh context; either way
+// its ID is logged, so operators know which job to check.
+func (bp *backupParams) abandonJob(ctx context.Context, job jobWaiter, cause error) error {
+	err := fmt.Errorf("%w: %v", errRequestCancelled, cause)
+	cctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), jobCancelTimeout)
+	defer cancel()
+	if cerr := job.Cancel(cctx); cerr != nil {
+		_ = bp.logError(fmt.Sprintf("Backup of table %s.%s was cancelled, failed to cancel extract job %s, which is orphaned and may still be running: %v", bp.sourceDatasetID, bp.backupTableID, job.ID(), cerr))
+		return err
+	}
+	_ = bp.logError(fmt.Sprintf("Backup of table %s.%s was cancelled, requested cancellation of extract job %s", bp.sourceDatasetID, bp.backupTableID, job.ID()))
+	return err
+}
@@ -0,0 +1,38 @@
+package bigquerybackup
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWaitForJobCancelled(t *testing.T) {
+	tests := []struct {
+		name      string
+		cancelErr error
+	}{
+		{name: "job cancelled"},
+		{name: "cancel failed", cancelErr: errors.New("boom")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bp := &backupParams{sourceDatasetID: "ds", backupTableID: "t", storageBucket: "backups", destinationFormat: "AVRO"}
+			job := &fakeJob{id: "job_1", running: true, cancelErr: tt.cancelErr}
+			ctx, cancel := context.WithCancel(context.Background())
+			go func() {
+				time.Sleep(10 * time.Millisecond)
+				cancel()
+			}()
+			res := bp.waitForJob(ctx, job)
+			assert.Equal(t, "job_1", res.JobID)
+			assert.Equal(t, jobStateCancelled, res.State)
+			assert.ErrorIs(t, res.Err, errRequestCancelled)
+			assert.False(t, res.Succeeded())
+			assert.False(t, bp.jobTimedOut)
+			assert.Equal(t, 1, job.cancelled)
+		})
+	}
+}
@@ -0,0 +1,141 @@
+package bigquerybackup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/bigquery"
+	"github.com/GoogleCloudPlatform/functions-framework-go/functions"
+	"github.com/google/uuid"
+	"google.golang.org/api/googleapi"
+)
+
+func init() {
+	functions.HTTP("BigQueryBackupStatus", trackHTTP(bigQueryBackupStatus))
+}
+
+const (
+	jobStatePending = "PENDING"
+	jobStateRunning = "RUNNING"
+	jobStateDone    = "DONE"
+)
+
+type statusBodyParams struct {
+	JobID    string `json:"job_id"`
+	Location string `json:"location"`
+}
+
+// jobStatusResponse is the stable description of an extract job returned by the status
+// function. Error is set when the job finished unsuccessfully; the statistics are set once the
+// job is done.
+type jobStatusResponse struct {
+	JobID                    string     `json:"job_id"`
+	State                    string     `json:"state"`
+	Error                    string     `json:"error,omitempty"`
+	DestinationURIs          []string   `json:"destination_uris,omitempty"`
+	DestinationURIFileCounts []int64    `json:"destination_uri_file_counts,omitempty"`
+	StartTime                *time.Time `json:"start_time,omitempty"`
+	EndTime                  *time.Time `json:"end_time,omitempty"`
+}
+
+// bigQueryBackupStatus is an HTTP function that reports the state of an extract job started
+// by an async backup. It looks the job up by job_id, in location when given and otherwise in
+// BIGQUERY_LOCATION, and responds with its state and, once done, its error and statistics.
+// It responds with 404 when the job does not exist.
+func bigQueryBackupStatus(w http.ResponseWriter, r *http.Request) {
+	bp := &backupParams{runID: uuid.NewString(), cache: newMetadataCache()}
+	if err := bp.setProjectID(); err != nil {
+		rejectMissingProject(w, err)
+		return
+	}
+	ctx, cancel := requestContext(r)
+	defer cancel()
+	defer func() {
+		_ = bp.flushLogs()
+	}()
+	if !bp.authorize(w, r) {
+		return
+	}
+
+	if err := bp.setBigQueryClient(ctx); err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	var body statusBodyParams
+	if err := decodeRequestBody(w, r, &body); err != nil {
+		_ = bp.logError(fmt.Sprintf("Failed to decode POST body: %v", err))
+		writeError(w, bodyErrorStatus(err), fmt.Sprintf("failed to decode POST body: %v", err))
+		return
+	}
+	jobID := strings.TrimSpace(body.JobID)
+	if jobID == "" {
+		writeError(w, http.StatusBadRequest, "invalid POST body: missing job_id")
+		return
+	}
+
+	job, err := lookupJob(ctx, jobID, strings.TrimSpace(body.Location))
+	if err != nil {
+		var gerr *googleapi.Error
+		if errors.As(err, &gerr) && gerr.Code == http.StatusNotFound {
+			writeError(w, http.StatusNotFound, fmt.Sprintf("job %s not found", jobID))
+			return
+		}
+		_ = bp.logError(fmt.Sprintf("Problem looking up job %s: %v", jobID, err))
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("problem looking up job %s: %v", jobID, err))
+		return
+	}
+
+	var uris []string
+	if cfg, err := job.Config(); err == nil {
+		if ec, ok := cfg.(*bigquery.ExtractConfig); ok && ec.Dst != nil {
+			uris = ec.Dst.URIs
+		}
+	}
+	var jobErr error
+	status := job.LastStatus()
+	if status != nil {
+		jobErr = status.Err()
+	}
+	writeJSON(w, http.StatusOK, newJobStatusResponse(job.ID(), status, jobErr, uris))
+}
+
+// lookupJob fetches a job and its current status, in location when it is given.
+func lookupJob(ctx context.Context, jobID, location string) (*bigquery.Job, error) {
+	if location != "" {
+		return bc.JobFromIDLocation(ctx, jobID, location)
+	}
+	return bc.JobFromID(ctx, jobID)
+}
+
+// newJobStatusResponse maps a BigQuery job status to the status function's response. jobErr
+// is the job's own error, status.Err(), reported once the job is done.
+func newJobStatusResponse(jobID string, status *bigquery.JobStatus, jobErr error, uris []string) jobStatusResponse {
+	resp := jobStatusResponse{JobID: jobID, State: jobStatePending, DestinationURIs: uris}
+	if status == nil {
+		return resp
+	}
+	resp.State = jobState(status.State)
+	if resp.State != jobStateDone {
+		return resp
+	}
+	if jobErr != nil {
+		resp.Error = jobErr.Error()
+	}
+	if stats := status.Statistics; stats != nil {
+		if !stats.StartTime.IsZero() {
+			resp.StartTime = &stats.StartTime
+		}
+		if !stats.EndTime.IsZero() {
+			resp.EndTime = &stats.EndTime
+		}
+		if es, ok := stats.Details.(*bigquery.ExtractStatistics); ok {
+			resp.DestinationURIFileCounts = es.DestinationURIFileCounts
+		}
+	}
+	return resp
+}
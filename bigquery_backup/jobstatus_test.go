@@ -0,0 +1,67 @@
+package bigquerybackup
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/bigquery"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewJobStatusResponse(t *testing.T) {
+	start := time.Date(2024, 1, 15, 10, 0, 0, 0, time.UTC)
+	end := start.Add(time.Minute)
+	uris := []string{"gs://b/ds/t.20240115T100000Z/t-*.avro"}
+	stats := &bigquery.JobStatistics{
+		StartTime: start,
+		EndTime:   end,
+		Details:   &bigquery.ExtractStatistics{DestinationURIFileCounts: []int64{4}},
+	}
+
+	tests := []struct {
+		name   string
+		status *bigquery.JobStatus
+		jobErr error
+		want   jobStatusResponse
+	}{
+		{
+			name: "No status",
+			want: jobStatusResponse{JobID: "job_1", State: jobStatePending, DestinationURIs: uris},
+		},
+		{
+			name:   "Pending",
+			status: &bigquery.JobStatus{State: bigquery.Pending},
+			want:   jobStatusResponse{JobID: "job_1", State: jobStatePending, DestinationURIs: uris},
+		},
+		{
+			name:   "Running",
+			status: &bigquery.JobStatus{State: bigquery.Running, Statistics: stats},
+			want:   jobStatusResponse{JobID: "job_1", State: jobStateRunning, DestinationURIs: uris},
+		},
+		{
+			name:   "Done",
+			status: &bigquery.JobStatus{State: bigquery.Done, Statistics: stats},
+			want: jobStatusResponse{
+				JobID:                    "job_1",
+				State:                    jobStateDone,
+				DestinationURIs:          uris,
+				DestinationURIFileCounts: []int64{4},
+				StartTime:                &start,
+				EndTime:                  &end,
+			},
+		},
+		{
+			name:   "Failed",
+			status: &bigquery.JobStatus{State: bigquery.Done},
+			jobErr: errors.New("access denied"),
+			want:   jobStatusResponse{JobID: "job_1", State: jobStateDone, Error: "access denied", DestinationURIs: uris},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, newJobStatusResponse("job_1", tt.status, tt.jobErr, uris))
+		})
+	}
+}
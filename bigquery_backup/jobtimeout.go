@@ -0,0 +1,90 @@
+package bigquerybackup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/bigquery"
+)
+
+// errJobStillRunning is the error of a backup result whose extract job outlasted
+// JOB_TIMEOUT_SECONDS. The job keeps running; only the wait for it was given up.
+var errJobStillRunning = errors.New("extract job is still running, poll its status with the job ID")
+
+// jobTimeout returns how long a request waits for its extract job, read from the
+// JOB_TIMEOUT_SECONDS environment variable, or 0 when it waits until the job is done.
+func jobTimeout() time.Duration {
+	secs, err := strconv.Atoi(strings.TrimSpace(os.Getenv("JOB_TIMEOUT_SECONDS")))
+	if err != nil || secs <= 0 {
+		return 0
+	}
+	return time.Duration(secs) * time.Second
+}
+
+// jobPollInterval returns how often the status of a job is polled while waiting for it, read
+// from the JOB_POLL_INTERVAL_SECONDS environment variable, or 0 to leave polling to the
+// BigQuery client's own backoff.
+func jobPollInterval() time.Duration {
+	secs, err := strconv.Atoi(strings.TrimSpace(os.Getenv("JOB_POLL_INTERVAL_SECONDS")))
+	if err != nil || secs <= 0 {
+		return 0
+	}
+	return time.Duration(secs) * time.Second
+}
+
+// withJobTimeout derives the context an extract job is waited for under, bounded by
+// JOB_TIMEOUT_SECONDS when it is set.
+func withJobTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if d := jobTimeout(); d > 0 {
+		return context.WithTimeout(ctx, d)
+	}
+	return context.WithCancel(ctx)
+}
+
+// jobStatusPoller fetches the current status of a job.
+type jobStatusPoller interface {
+	Status(ctx context.Context) (*bigquery.JobStatus, error)
+}
+
+// pollJob polls the status of job every interval until it is done or ctx is done. Like
+// jobWaiter.Wait, it returns a nil status when polling failed and the final status together
+// with the job's error when the job failed.
+func pollJob(ctx context.Context, job jobStatusPoller, interval time.Duration) (*bigquery.JobStatus, error) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		status, err := job.Status(ctx)
+		if err != nil {
+			return nil, err
+		}
+		if status.Done() {
+			return status, status.Err()
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// jobWaitTimedOut reports whether waiting for a job under waitCtx failed only because
+// JOB_TIMEOUT_SECONDS passed, while the request itself may still go on.
+func jobWaitTimedOut(ctx, waitCtx context.Context, err error) bool {
+	return err != nil && errors.Is(waitCtx.Err(), context.DeadlineExceeded) && ctx.Err() == nil
+}
+
+// logJobStillRunning logs that the wait for the extract job was given up, and what that means
+// for the steps after the export.
+func (bp *backupParams) logJobStillRunning(jobID string) {
+	msg := fmt.Sprintf("Backup of table %s.%s is still running after %s, not waiting any longer, jobID: %s; poll BigQueryBackupStatus for its outcome. The steps after the export are skipped", bp.sourceDatasetID, bp.backupTableID, jobTimeout(), jobID)
+	if bp.stagedPublish {
+		msg += fmt.Sprintf(", and the export is left unpublished under gs://%s/%s", bp.storageBucket, bp.stagingObjectPrefix())
+	}
+	_ = bp.logWarning(msg)
+}
@@ -0,0 +1,92 @@
+package bigquerybackup
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/bigquery"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJobTimeout(t *testing.T) {
+	t.Setenv("JOB_TIMEOUT_SECONDS", "")
+	assert.Zero(t, jobTimeout())
+	t.Setenv("JOB_TIMEOUT_SECONDS", "600")
+	assert.Equal(t, 10*time.Minute, jobTimeout())
+	t.Setenv("JOB_TIMEOUT_SECONDS", "-1")
+	assert.Zero(t, jobTimeout())
+	t.Setenv("JOB_TIMEOUT_SECONDS", "soon")
+	assert.Zero(t, jobTimeout())
+}
+
+func TestJobPollInterval(t *testing.T) {
+	t.Setenv("JOB_POLL_INTERVAL_SECONDS", "")
+	assert.Zero(t, jobPollInterval())
+	t.Setenv("JOB_POLL_INTERVAL_SECONDS", "30")
+	assert.Equal(t, 30*time.Second, jobPollInterval())
+	t.Setenv("JOB_POLL_INTERVAL_SECONDS", "0")
+	assert.Zero(t, jobPollInterval())
+}
+
+// fakePoller returns the given states in turn, repeating the last one.
+type fakePoller struct {
+	states []bigquery.State
+	err    error
+	polls  int
+}
+
+func (p *fakePoller) Status(ctx context.Context) (*bigquery.JobStatus, error) {
+	if p.err != nil {
+		return nil, p.err
+	}
+	i := p.polls
+	if i >= len(p.states) {
+		i = len(p.states) - 1
+	}
+	p.polls++
+	return &bigquery.JobStatus{State: p.states[i]}, nil
+}
+
+func TestPollJob(t *testing.T) {
+	p := &fakePoller{states: []bigquery.State{bigquery.Pending, bigquery.Running, bigquery.Done}}
+	status, err := pollJob(context.Background(), p, time.Millisecond)
+	assert.NoError(t, err)
+	assert.Equal(t, bigquery.Done, status.State)
+	assert.Equal(t, 3, p.polls)
+
+	_, err = pollJob(context.Background(), &fakePoller{err: errors.New("boom")}, time.Millisecond)
+	assert.EqualError(t, err, "boom")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	status, err = pollJob(ctx, &fakePoller{states: []bigquery.State{bigquery.Running}}, 5*time.Millisecond)
+	assert.Nil(t, status)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
+
+func TestJobWaitTimedOut(t *testing.T) {
+	ctx := context.Background()
+	expired, cancel := context.WithTimeout(ctx, 0)
+	defer cancel()
+	<-expired.Done()
+	assert.True(t, jobWaitTimedOut(ctx, expired, context.DeadlineExceeded))
+	assert.False(t, jobWaitTimedOut(ctx, ctx, errors.New("boom")), "the wait did not time out")
+	assert.False(t, jobWaitTimedOut(expired, expired, context.DeadlineExceeded), "the request itself timed out")
+}
+
+func TestWaitForJobTimeout(t *testing.T) {
+	t.Setenv("JOB_TIMEOUT_SECONDS", "1")
+	bp := &backupParams{sourceDatasetID: "ds", backupTableID: "t", storageBucket: "backups", destinationFormat: "AVRO"}
+	res := bp.waitForJob(context.Background(), &fakeJob{id: "job_1", running: true})
+	assert.True(t, bp.jobTimedOut)
+	assert.Equal(t, "job_1", res.JobID)
+	assert.Equal(t, jobStateRunning, res.State)
+	assert.ErrorIs(t, res.Err, errJobStillRunning)
+
+	bp = &backupParams{sourceDatasetID: "ds", backupTableID: "t", storageBucket: "backups", destinationFormat: "AVRO"}
+	res = bp.waitForJob(context.Background(), &fakeJob{id: "job_2", status: &bigquery.JobStatus{State: bigquery.Done}})
+	assert.False(t, bp.jobTimedOut)
+	assert.True(t, res.Succeeded())
+}
@@ -0,0 +1,75 @@
+package bigquerybackup
+
+import (
+	"fmt"
+	"sort"
+	"unicode"
+	"unicode/utf8"
+)
+
+// BigQuery's limits on job labels.
+const (
+	maxJobLabels      = 64
+	maxLabelRuneCount = 63
+)
+
+// defaultJobLabels are set on every job the function runs, so its jobs can be told apart from
+// others in INFORMATION_SCHEMA.JOBS and the billing export. Labels in the request override them.
+var defaultJobLabels = map[string]string{
+	"source": "bigquery-backup",
+}
+
+// checkLabels validates the labels field of the POST body against BigQuery's label rules:
+// keys are 1 to 63 characters and start with a lowercase letter, values are at most 63
+// characters, and both only contain lowercase letters, digits, underscores and dashes.
+func checkLabels(labels map[string]string) error {
+	if len(jobLabels(labels)) > maxJobLabels {
+		return fmt.Errorf("too many labels, a job can have at most %d including the defaults", maxJobLabels)
+	}
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if k == "" || utf8.RuneCountInString(k) > maxLabelRuneCount {
+			return fmt.Errorf("invalid label key %q, must be 1 to %d characters", k, maxLabelRuneCount)
+		}
+		if r, _ := utf8.DecodeRuneInString(k); !unicode.IsLower(r) {
+			return fmt.Errorf("invalid label key %q, must start with a lowercase letter", k)
+		}
+		if !isLabelText(k) {
+			return fmt.Errorf("invalid label key %q, may only contain lowercase letters, digits, underscores and dashes", k)
+		}
+		v := labels[k]
+		if utf8.RuneCountInString(v) > maxLabelRuneCount {
+			return fmt.Errorf("invalid value for label %s, must be at most %d characters", k, maxLabelRuneCount)
+		}
+		if !isLabelText(v) {
+			return fmt.Errorf("invalid value %q for label %s, may only contain lowercase letters, digits, underscores and dashes", v, k)
+		}
+	}
+	return nil
+}
+
+// isLabelText reports whether s only contains characters allowed in label keys and values.
+func isLabelText(s string) bool {
+	for _, r := range s {
+		if !unicode.IsLower(r) && !unicode.IsDigit(r) && r != '_' && r != '-' {
+			return false
+		}
+	}
+	return true
+}
+
+// jobLabels returns the labels to set on a job: the defaults merged with the requested ones.
+func jobLabels(labels map[string]string) map[string]string {
+	merged := make(map[string]string, len(defaultJobLabels)+len(labels))
+	for k, v := range defaultJobLabels {
+		merged[k] = v
+	}
+	for k, v := range labels {
+		merged[k] = v
+	}
+	return merged
+}
@@ -0,0 +1,68 @@
+package bigquerybackup
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckLabels(t *testing.T) {
+	tests := []struct {
+		name    string
+		labels  map[string]string
+		wantErr bool
+	}{
+		{name: "No labels"},
+		{name: "Team and table", labels: map[string]string{"team": "finance", "table": "orders_2024"}},
+		{name: "Empty value", labels: map[string]string{"cost-center": ""}},
+		{name: "Unicode lowercase", labels: map[string]string{"équipe": "données"}},
+		{name: "Uppercase key", labels: map[string]string{"Team": "finance"}, wantErr: true},
+		{name: "Uppercase value", labels: map[string]string{"team": "Finance"}, wantErr: true},
+		{name: "Key starts with digit", labels: map[string]string{"1team": "finance"}, wantErr: true},
+		{name: "Empty key", labels: map[string]string{"": "finance"}, wantErr: true},
+		{name: "Key too long", labels: map[string]string{strings.Repeat("k", 64): "v"}, wantErr: true},
+		{name: "Value too long", labels: map[string]string{"team": strings.Repeat("v", 64)}, wantErr: true},
+		{name: "Value at the limit", labels: map[string]string{"team": strings.Repeat("v", 63)}},
+		{name: "Dot", labels: map[string]string{"team": "fin.ance"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkLabels(tt.labels)
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestCheckLabelsTooMany(t *testing.T) {
+	labels := make(map[string]string)
+	for i := 0; i < maxJobLabels; i++ {
+		labels["l"+strings.Repeat("a", i)] = "v"
+	}
+	assert.Error(t, checkLabels(labels))
+}
+
+func TestJobLabels(t *testing.T) {
+	assert.Equal(t, map[string]string{"source": "bigquery-backup"}, jobLabels(nil))
+	assert.Equal(t, map[string]string{"source": "bigquery-backup", "team": "finance"}, jobLabels(map[string]string{"team": "finance"}))
+	assert.Equal(t, map[string]string{"source": "nightly"}, jobLabels(map[string]string{"source": "nightly"}))
+}
+
+func TestSetupExtractorLabels(t *testing.T) {
+	bp := &backupParams{
+		projectID:         "test-project",
+		sourceDatasetID:   "ds",
+		backupTableID:     "t",
+		storageBucket:     "b",
+		destinationFormat: avroFormat,
+		labels:            map[string]string{"team": "finance"},
+	}
+	assert.NoError(t, bp.setBigQueryClient(context.Background()))
+	assert.Equal(t, map[string]string{"source": "bigquery-backup", "team": "finance"}, setupExtractor(bp).Labels)
+}
@@ -0,0 +1,208 @@
+package bigquerybackup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/GoogleCloudPlatform/functions-framework-go/functions"
+	"github.com/google/uuid"
+	"google.golang.org/api/iterator"
+)
+
+func init() {
+	functions.HTTP("BigQueryBackupList", trackHTTP(bigQueryBackupList))
+}
+
+// listParams holds a request to list the backups of one dataset. It embeds backupParams for
+// the project, bucket and logging; backupTableID is empty to list the backups of every table.
+type listParams struct {
+	*backupParams
+	limit int
+}
+
+type listBodyParams struct {
+	StorageBucket string `json:"storage_bucket"`
+	DatasetName   string `json:"dataset_name"`
+	TableName     string `json:"table_name"`
+	Limit         int    `json:"limit"`
+}
+
+// listResponse is the body returned with the backups found, newest first.
+type listResponse struct {
+	Status  string        `json:"status"`
+	Backups []backupEntry `json:"backups"`
+}
+
+// backupEntry describes one backup in the bucket. Prefix ends with a slash, so it can be passed
+// to the restore function as source_path. Complete is false when the backup has no manifest,
+// because it failed or is still running.
+type backupEntry struct {
+	Prefix      string    `json:"prefix"`
+	Table       string    `json:"table"`
+	Time        time.Time `json:"time"`
+	ObjectCount int       `json:"object_count"`
+	TotalBytes  int64     `json:"total_bytes"`
+	Complete    bool      `json:"complete"`
+}
+
+// bigQueryBackupList is an HTTP function that lists the backups of a dataset, or of one of its
+// tables, newest first, with the number and total size of their objects. With limit only the
+// most recent backups are returned.
+func bigQueryBackupList(w http.ResponseWriter, r *http.Request) {
+	lp := listParams{backupParams: &backupParams{runID: uuid.NewString(), cache: newMetadataCache()}}
+	if err := lp.setProjectID(); err != nil {
+		rejectMissingProject(w, err)
+		return
+	}
+	ctx, cancel := requestContext(r)
+	defer cancel()
+	defer func() {
+		_ = lp.flushLogs()
+	}()
+	if !lp.authorize(w, r) {
+		return
+	}
+
+	var body listBodyParams
+	if err := decodeRequestBody(w, r, &body); err != nil {
+		_ = lp.logError(fmt.Sprintf("Failed to decode POST body: %v", err))
+		writeError(w, bodyErrorStatus(err), fmt.Sprintf("failed to decode POST body: %v", err))
+		return
+	}
+	if err := lp.setListParams(body); err != nil {
+		_ = lp.logError(fmt.Sprintf("Invalid POST body: %v", err))
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid POST body: %v", err))
+		return
+	}
+	if err := lp.resolveStorageBucket(ctx); err != nil {
+		_ = lp.logError(fmt.Sprintf("Invalid POST body: %v", err))
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid POST body: %v", err))
+		return
+	}
+
+	resp, err := lp.list(ctx)
+	if err != nil {
+		_ = lp.logError(fmt.Sprintf("Problem listing backups of dataset %s: %v", lp.sourceDatasetID, err))
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("problem listing backups of dataset %s: %v", lp.sourceDatasetID, err))
+		return
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// setListParams validates the POST body and copies it into the list parameters.
+// storage_bucket may be omitted when DATASET_BUCKET_MAP maps the dataset to a bucket.
+func (lp *listParams) setListParams(body listBodyParams) error {
+	switch {
+	case strings.TrimSpace(body.DatasetName) == "":
+		return errors.New("missing dataset_name")
+	case body.Limit < 0:
+		return fmt.Errorf("invalid limit %d, must not be negative", body.Limit)
+	}
+	lp.storageBucket = strings.TrimSpace(body.StorageBucket)
+	if lp.storageBucket != "" {
+		if err := validateBucketName(lp.storageBucket); err != nil {
+			return err
+		}
+	}
+	lp.sourceDatasetID = strings.TrimSpace(body.DatasetName)
+	lp.backupTableID = strings.TrimSpace(body.TableName)
+	if lp.backupTableID == allTables {
+		lp.backupTableID = ""
+	}
+	lp.limit = body.Limit
+	return nil
+}
+
+// latestBackups returns the backups among folders, the backup prefixes listed in the bucket,
+// that belong to table, newest first, keeping at most limit of them unless limit is 0. Folders
+// that do not follow the backup layout are left out.
+func latestBackups(folders []string, table string, limit int) []backupEntry {
+	var entries []backupEntry
+	for _, folder := range folders {
+		prefix, name, t, ok := parseBackupPrefix(folder)
+		if !ok || !backupOfTable(name, table) {
+			continue
+		}
+		entries = append(entries, backupEntry{Prefix: prefix + "/", Table: name, Time: t})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if !entries[i].Time.Equal(entries[j].Time) {
+			return entries[i].Time.After(entries[j].Time)
+		}
+		return entries[i].Prefix < entries[j].Prefix
+	})
+	if limit > 0 && len(entries) > limit {
+		entries = entries[:limit]
+	}
+	return entries
+}
+
+// summarize fills in the object count, total size and completeness of a backup from the
+// attributes of the objects under its prefix.
+func (e *backupEntry) summarize(objects []*storage.ObjectAttrs) {
+	e.ObjectCount, e.TotalBytes, e.Complete = len(objects), 0, false
+	for _, attrs := range objects {
+		e.TotalBytes += attrs.Size
+		if path.Base(attrs.Name) == manifestObjectName {
+			e.Complete = true
+		}
+	}
+}
+
+// list finds the backup prefixes under the dataset, or table, and lists the objects of those
+// returned to summarize them.
+func (lp *listParams) list(ctx context.Context) (listResponse, error) {
+	c, err := sharedStorageClient()
+	if err != nil {
+		return listResponse{}, err
+	}
+	bucket := c.Bucket(lp.storageBucket)
+
+	folders, err := listFolders(ctx, bucket, lp.sourceDatasetID+"/"+lp.backupTableID)
+	if err != nil {
+		return listResponse{}, fmt.Errorf("failed to list backups: %v", err)
+	}
+	resp := listResponse{Status: "ok", Backups: latestBackups(folders, lp.backupTableID, lp.limit)}
+	for i := range resp.Backups {
+		e := &resp.Backups[i]
+		objects, err := listObjectAttrs(ctx, bucket, e.Prefix)
+		if err != nil {
+			return listResponse{}, fmt.Errorf("failed to list objects of backup gs://%s/%s: %v", lp.storageBucket, e.Prefix, err)
+		}
+		e.summarize(objects)
+	}
+	if resp.Backups == nil {
+		resp.Backups = []backupEntry{}
+	}
+	_ = lp.logInfo(fmt.Sprintf("Listed %d backups of dataset %s in gs://%s", len(resp.Backups), lp.sourceDatasetID, lp.storageBucket))
+	return resp, nil
+}
+
+// listFolders returns the "folders" directly under the dataset folder whose names start with
+// prefix, e.g. "dataset/table.20060102T150405Z/", listed with a delimiter so the shards under
+// them are not.
+func listFolders(ctx context.Context, bucket *storage.BucketHandle, prefix string) ([]string, error) {
+	ctx, cancel := withStorageTimeout(ctx)
+	defer cancel()
+	var folders []string
+	it := bucket.Objects(ctx, &storage.Query{Prefix: prefix, Delimiter: "/"})
+	for {
+		attrs, err := it.Next()
+		if err == iterator.Done {
+			return folders, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if attrs.Prefix != "" {
+			folders = append(folders, attrs.Prefix)
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package bigquerybackup
+
+import (
+	"testing"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSetListParams(t *testing.T) {
+	tests := []struct {
+		name      string
+		body      listBodyParams
+		wantErr   bool
+		wantTable string
+	}{
+		{name: "Dataset", body: listBodyParams{StorageBucket: "backups", DatasetName: "ds"}},
+		{name: "Table with limit", body: listBodyParams{StorageBucket: "backups", DatasetName: "ds", TableName: "t", Limit: 5}, wantTable: "t"},
+		{name: "All tables", body: listBodyParams{DatasetName: "ds", TableName: allTables}},
+		{name: "Missing dataset", body: listBodyParams{StorageBucket: "backups"}, wantErr: true},
+		{name: "Negative limit", body: listBodyParams{StorageBucket: "backups", DatasetName: "ds", Limit: -1}, wantErr: true},
+		{name: "Invalid bucket", body: listBodyParams{StorageBucket: "gs://backups", DatasetName: "ds"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lp := &listParams{backupParams: &backupParams{}}
+			err := lp.setListParams(tt.body)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.wantTable, lp.backupTableID)
+			assert.Equal(t, tt.body.Limit, lp.limit)
+		})
+	}
+}
+
+func TestLatestBackups(t *testing.T) {
+	folders := []string{
+		"ds/t.20240101T000000Z/",
+		"ds/t.20240301T000000Z/",
+		"ds/t$20240115.20240201T000000Z/",
+		"ds/t@20240110T000000Z.20240215T000000Z/",
+		"ds/table2.20240401T000000Z/",
+		"ds/not-a-backup/",
+	}
+
+	tests := []struct {
+		name       string
+		table      string
+		limit      int
+		wantPrefix []string
+	}{
+		{name: "Table newest first", table: "t", wantPrefix: []string{"ds/t.20240301T000000Z/", "ds/t@20240110T000000Z.20240215T000000Z/", "ds/t$20240115.20240201T000000Z/", "ds/t.20240101T000000Z/"}},
+		{name: "Limit", table: "t", limit: 2, wantPrefix: []string{"ds/t.20240301T000000Z/", "ds/t@20240110T000000Z.20240215T000000Z/"}},
+		{name: "Every table", wantPrefix: []string{"ds/table2.20240401T000000Z/", "ds/t.20240301T000000Z/", "ds/t@20240110T000000Z.20240215T000000Z/", "ds/t$20240115.20240201T000000Z/", "ds/t.20240101T000000Z/"}},
+		{name: "Unknown table", table: "other"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, e := range latestBackups(folders, tt.table, tt.limit) {
+				got = append(got, e.Prefix)
+			}
+			assert.Equal(t, tt.wantPrefix, got)
+		})
+	}
+}
+
+func TestLatestBackupsParsesTime(t *testing.T) {
+	got := latestBackups([]string{"ds/t.20240115T100000Z/"}, "t", 0)
+	if assert.Len(t, got, 1) {
+		assert.Equal(t, "t", got[0].Table)
+		assert.Equal(t, time.Date(2024, 1, 15, 10, 0, 0, 0, time.UTC), got[0].Time)
+	}
+}
+
+func TestBackupEntrySummarize(t *testing.T) {
+	tests := []struct {
+		name         string
+		objects      []*storage.ObjectAttrs
+		wantCount    int
+		wantBytes    int64
+		wantComplete bool
+	}{
+		{
+			name: "Complete",
+			objects: []*storage.ObjectAttrs{
+				{Name: "ds/t.20240115T100000Z/t-000000000000.avro", Size: 100},
+				{Name: "ds/t.20240115T100000Z/t-000000000001.avro", Size: 50},
+				{Name: "ds/t.20240115T100000Z/" + manifestObjectName, Size: 10},
+			},
+			wantCount: 3, wantBytes: 160, wantComplete: true,
+		},
+		{
+			name:      "Without manifest",
+			objects:   []*storage.ObjectAttrs{{Name: "ds/t.20240115T100000Z/t-000000000000.avro", Size: 100}},
+			wantCount: 1, wantBytes: 100,
+		},
+		{name: "Empty"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var e backupEntry
+			e.summarize(tt.objects)
+			assert.Equal(t, tt.wantCount, e.ObjectCount)
+			assert.Equal(t, tt.wantBytes, e.TotalBytes)
+			assert.Equal(t, tt.wantComplete, e.Complete)
+		})
+	}
+}
@@ -0,0 +1,62 @@
+package bigquerybackup
+
+import (
+	"context"
+	"fmt"
+
+	"cloud.google.com/go/bigquery"
+)
+
+const loadConfigObjectName = "load-config.json"
+
+// loadConfig describes how to load an Avro backup back into BigQuery. It is written next to
+// the exported shards so a restore uses exactly the settings the backup assumed.
+type loadConfig struct {
+	SourceURIs          []string         `json:"source_uris"`
+	SourceFormat        string           `json:"source_format"`
+	WriteDisposition    string           `json:"write_disposition"`
+	UseAvroLogicalTypes bool             `json:"use_avro_logical_types"`
+	SchemaSource        string           `json:"schema_source"`
+	DestinationTable    loadConfigTarget `json:"destination_table"`
+}
+
+type loadConfigTarget struct {
+	ProjectID string `json:"project_id"`
+	DatasetID string `json:"dataset_id"`
+	TableID   string `json:"table_id"`
+}
+
+// newLoadConfig builds the load config for this backup. The Avro files carry their own schema,
+// so the schema source records the table the backup was taken from, and the destination
+// defaults to that same table.
+func (bp *backupParams) newLoadConfig() loadConfig {
+	return loadConfig{
+		SourceURIs:          []string{bp.destinationURI()},
+		SourceFormat:        string(bigquery.Avro),
+		WriteDisposition:    string(bigquery.WriteTruncate),
+		UseAvroLogicalTypes: bp.useAvroLogicalTypes,
+		SchemaSource:        fmt.Sprintf("%s:%s.%s", bp.sourceProjectID(), bp.sourceDatasetID, bp.backupTableID),
+		DestinationTable: loadConfigTarget{
+			ProjectID: bp.sourceProjectID(),
+			DatasetID: bp.sourceDatasetID,
+			TableID:   bp.backupTableID,
+		},
+	}
+}
+
+// writeLoadConfigObject writes load-config.json into the backup prefix when write_load_config
+// was requested. Only Avro backups get a load config; other formats are logged and skipped.
+func (bp *backupParams) writeLoadConfigObject(ctx context.Context) error {
+	if !bp.writeLoadConfig {
+		return nil
+	}
+	if bp.destinationFormat != avroFormat {
+		return bp.logInfo(fmt.Sprintf("Skipping %s, it is only written for %s backups", loadConfigObjectName, avroFormat))
+	}
+
+	name, err := bp.writeBackupObject(ctx, loadConfigObjectName, bp.newLoadConfig())
+	if err != nil {
+		return err
+	}
+	return bp.logInfo(fmt.Sprintf("Wrote load config to gs://%s/%s", bp.storageBucket, name))
+}
@@ -0,0 +1,26 @@
+package bigquerybackup
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewLoadConfig(t *testing.T) {
+	bp := &backupParams{
+		projectID:         "test-project",
+		sourceDatasetID:   "ds",
+		backupTableID:     "t",
+		storageBucket:     "bucket",
+		destinationFormat: avroFormat,
+		backupTime:        time.Date(2024, 1, 15, 10, 0, 0, 0, time.UTC),
+	}
+
+	lc := bp.newLoadConfig()
+	assert.Equal(t, []string{"gs://bucket/ds/t.20240115T100000Z/t-*.avro"}, lc.SourceURIs)
+	assert.Equal(t, "AVRO", lc.SourceFormat)
+	assert.Equal(t, "WRITE_TRUNCATE", lc.WriteDisposition)
+	assert.Equal(t, "test-project:ds.t", lc.SchemaSource)
+	assert.Equal(t, loadConfigTarget{ProjectID: "test-project", DatasetID: "ds", TableID: "t"}, lc.DestinationTable)
+}
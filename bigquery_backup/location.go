@@ -0,0 +1,77 @@
+package bigquerybackup
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"cloud.google.com/go/storage"
+)
+
+const (
+	usMultiRegion = "US"
+	euMultiRegion = "EU"
+)
+
+// locationPattern matches a BigQuery location, a multi-region such as EU or a region such as
+// europe-west1.
+var locationPattern = regexp.MustCompile(`^[A-Za-z]+(-[A-Za-z]+[0-9]+)?$`)
+
+// checkLocation validates the location field of the POST body.
+func checkLocation(pb *postBodyParams) error {
+	loc := strings.TrimSpace(pb.Location)
+	if loc != "" && !locationPattern.MatchString(loc) {
+		return fmt.Errorf("invalid location %q, must be a BigQuery location such as EU or europe-west1", loc)
+	}
+	return nil
+}
+
+// resolveLocation sets the location the extract job runs in to the location of the source
+// dataset, so that the job does not depend on BIGQUERY_LOCATION or on the API finding the
+// dataset. A location given in the request must be the dataset's, since BigQuery cannot
+// export a table from another location; otherwise it returns an error carrying 400.
+func (bp *backupParams) resolveLocation(ctx context.Context) error {
+	md, err := bp.datasetMetadata(ctx)
+	if err != nil {
+		return err
+	}
+	if bp.location != "" && !strings.EqualFold(bp.location, md.Location) {
+		return withStatus(http.StatusBadRequest, fmt.Errorf("dataset %s is in location %s, not the requested location %s", bp.sourceDatasetID, md.Location, bp.location))
+	}
+	bp.location = md.Location
+	return nil
+}
+
+// bucketLocationCompatible reports whether BigQuery can export a table in datasetLocation to
+// a bucket with attrs. A dataset in the US multi-region can be exported to any bucket. One in
+// the EU multi-region needs a bucket in EU or in a European region, and one in a region needs a
+// bucket in that region or a custom dual-region that includes it.
+func bucketLocationCompatible(datasetLocation string, attrs *storage.BucketAttrs) bool {
+	ds, bucket := strings.ToUpper(datasetLocation), strings.ToUpper(attrs.Location)
+	switch {
+	case ds == "" || ds == usMultiRegion || ds == bucket:
+		return true
+	case ds == euMultiRegion:
+		return strings.HasPrefix(bucket, "EUROPE-")
+	}
+	if attrs.CustomPlacementConfig != nil {
+		for _, loc := range attrs.CustomPlacementConfig.DataLocations {
+			if strings.EqualFold(loc, ds) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// checkBucketLocation verifies that the extract job can write to the storage bucket from the
+// dataset's location, so a mismatch is reported with 400 before the export rather than as a
+// failed job.
+func (bp *backupParams) checkBucketLocation(attrs *storage.BucketAttrs) error {
+	if bucketLocationCompatible(bp.location, attrs) {
+		return nil
+	}
+	return withStatus(http.StatusBadRequest, fmt.Errorf("bucket %s is in location %s, which a dataset in %s cannot be exported to, use a bucket in %s", bp.storageBucket, attrs.Location, bp.location, bp.location))
+}
@@ -0,0 +1,104 @@
+package bigquerybackup
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"cloud.google.com/go/bigquery"
+	"cloud.google.com/go/storage"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckLocation(t *testing.T) {
+	tests := []struct {
+		name     string
+		location string
+		wantErr  bool
+	}{
+		{name: "Not set", location: ""},
+		{name: "Multi-region", location: "EU"},
+		{name: "Region", location: "europe-west1"},
+		{name: "Upper-case region", location: "US-CENTRAL1"},
+		{name: "Path", location: "europe-west1/zone", wantErr: true},
+		{name: "Zone", location: "europe-west1-b", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkLocation(&postBodyParams{Location: tt.location})
+			assert.Equal(t, tt.wantErr, err != nil, err)
+		})
+	}
+}
+
+func TestResolveLocation(t *testing.T) {
+	tests := []struct {
+		name      string
+		requested string
+		want      string
+		wantErr   string
+	}{
+		{name: "From the dataset", want: "europe-west1"},
+		{name: "Matching request", requested: "EUROPE-WEST1", want: "europe-west1"},
+		{name: "Other location", requested: "US", wantErr: "dataset ds is in location europe-west1, not the requested location US"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bp := &backupParams{projectID: "p", sourceDatasetID: "ds", location: tt.requested, cache: newMetadataCache()}
+			_, _ = bp.cache.dataset("p:ds", func() (*bigquery.DatasetMetadata, error) {
+				return &bigquery.DatasetMetadata{FullID: "p:ds", Location: "europe-west1"}, nil
+			})
+			err := bp.resolveLocation(context.Background())
+			if tt.wantErr != "" {
+				assert.EqualError(t, err, tt.wantErr)
+				assert.Equal(t, http.StatusBadRequest, statusCode(err))
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, bp.location)
+		})
+	}
+}
+
+func TestBucketLocationCompatible(t *testing.T) {
+	tests := []struct {
+		name    string
+		dataset string
+		bucket  storage.BucketAttrs
+		want    bool
+	}{
+		{name: "US to anywhere", dataset: "US", bucket: storage.BucketAttrs{Location: "ASIA-EAST1"}, want: true},
+		{name: "EU to EU", dataset: "EU", bucket: storage.BucketAttrs{Location: "EU"}, want: true},
+		{name: "EU to a European region", dataset: "EU", bucket: storage.BucketAttrs{Location: "EUROPE-WEST4"}, want: true},
+		{name: "EU to US", dataset: "EU", bucket: storage.BucketAttrs{Location: "US"}},
+		{name: "Same region", dataset: "europe-west1", bucket: storage.BucketAttrs{Location: "EUROPE-WEST1"}, want: true},
+		{name: "Region to its multi-region", dataset: "europe-west1", bucket: storage.BucketAttrs{Location: "EU"}},
+		{name: "Region to another region", dataset: "us-central1", bucket: storage.BucketAttrs{Location: "US-EAST1"}},
+		{name: "Region in a custom dual-region", dataset: "us-central1", bucket: storage.BucketAttrs{Location: "US", CustomPlacementConfig: &storage.CustomPlacementConfig{DataLocations: []string{"US-CENTRAL1", "US-EAST1"}}}, want: true},
+		{name: "Unknown dataset location", dataset: "", bucket: storage.BucketAttrs{Location: "US"}, want: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, bucketLocationCompatible(tt.dataset, &tt.bucket))
+		})
+	}
+}
+
+func TestCheckBucketLocationMismatch(t *testing.T) {
+	bp := &backupParams{storageBucket: "us-backups", location: "europe-west1"}
+	err := bp.checkBucketLocation(&storage.BucketAttrs{Location: "US"})
+	assert.EqualError(t, err, "bucket us-backups is in location US, which a dataset in europe-west1 cannot be exported to, use a bucket in europe-west1")
+	assert.Equal(t, http.StatusBadRequest, statusCode(err))
+	assert.Equal(t, "invalid storage bucket us-backups: "+err.Error(), lookupError("storage bucket us-backups", err).Error())
+}
+
+func TestSetupExtractorLocation(t *testing.T) {
+	bp := &backupParams{projectID: "test-project", sourceDatasetID: "ds", backupTableID: "t", storageBucket: "b", destinationFormat: avroFormat, location: "europe-west1", extractJobID: "job"}
+	assert.NoError(t, bp.setBigQueryClient(context.Background()))
+	extractor := setupExtractor(bp)
+	assert.Equal(t, "europe-west1", extractor.Location)
+	assert.Equal(t, "job", extractor.JobID)
+}
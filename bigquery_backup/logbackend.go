@@ -0,0 +1,186 @@
+package bigquerybackup
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/logging"
+)
+
+const (
+	logBackendEnv    = "LOG_BACKEND"
+	logBackendCloud  = "cloud"
+	logBackendStdout = "stdout"
+)
+
+func init() {
+	switch b := logBackendName(); b {
+	case logBackendCloud, logBackendStdout:
+	default:
+		log.Printf("WARNING: ignoring %s %q, must be %s or %s", logBackendEnv, b, logBackendCloud, logBackendStdout)
+	}
+}
+
+// logBackend is where the operational log and the audit trail are written.
+type logBackend interface {
+	// Log buffers an entry of the operational log.
+	Log(e logging.Entry)
+	// LogSync writes an entry to the named log before returning.
+	LogSync(ctx context.Context, name string, e logging.Entry) error
+	// Flush writes the buffered entries.
+	Flush() error
+	// Close flushes and releases the backend.
+	Close() error
+}
+
+// logBackendName returns the log backend selected by LOG_BACKEND, lowercased. It defaults to
+// cloud.
+func logBackendName() string {
+	if b := strings.ToLower(strings.TrimSpace(os.Getenv(logBackendEnv))); b != "" {
+		return b
+	}
+	return logBackendCloud
+}
+
+// cloudLogBackend writes entries to Cloud Logging. Operational entries are buffered and sent in
+// the background.
+type cloudLogBackend struct {
+	client *logging.Client
+	logger *logging.Logger
+}
+
+// newCloudLogBackend creates a Cloud Logging client for projectID.
+func newCloudLogBackend(projectID string) (logBackend, error) {
+	c, err := logging.NewClient(context.Background(), projectID)
+	if err != nil {
+		return nil, err
+	}
+	return &cloudLogBackend{client: c, logger: c.Logger(logName())}, nil
+}
+
+func (b *cloudLogBackend) Log(e logging.Entry) {
+	b.logger.Log(e)
+}
+
+func (b *cloudLogBackend) LogSync(ctx context.Context, name string, e logging.Entry) error {
+	return b.client.Logger(name).LogSync(ctx, e)
+}
+
+func (b *cloudLogBackend) Flush() error {
+	return b.logger.Flush()
+}
+
+func (b *cloudLogBackend) Close() error {
+	return b.client.Close()
+}
+
+// stdoutLogBackend writes every entry as one line of JSON, in the structured logging format
+// that Cloud Run and Cloud Functions pick up from standard output: the payload's fields with
+// severity, time and the log name added. It needs no credentials, so the function can run
+// locally or outside Google Cloud.
+type stdoutLogBackend struct {
+	mu  sync.Mutex
+	out io.Writer
+}
+
+func (b *stdoutLogBackend) Log(e logging.Entry) {
+	_ = b.write(logName(), e)
+}
+
+func (b *stdoutLogBackend) LogSync(_ context.Context, name string, e logging.Entry) error {
+	return b.write(name, e)
+}
+
+func (b *stdoutLogBackend) Flush() error {
+	return nil
+}
+
+func (b *stdoutLogBackend) Close() error {
+	return nil
+}
+
+// write encodes e as a JSON line. A payload that is not a JSON object is written as the message.
+func (b *stdoutLogBackend) write(name string, e logging.Entry) error {
+	fields := map[string]interface{}{}
+	data, err := json.Marshal(e.Payload)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		fields = map[string]interface{}{"message": e.Payload}
+	}
+	t := e.Timestamp
+	if t.IsZero() {
+		t = time.Now()
+	}
+	fields["severity"] = strings.ToUpper(e.Severity.String())
+	fields["time"] = t.UTC().Format(time.RFC3339Nano)
+	fields["log"] = name
+	line, err := json.Marshal(fields)
+	if err != nil {
+		return err
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	_, err = fmt.Fprintf(b.out, "%s\n", line)
+	return err
+}
+
+// newLogBackend creates the log backend selected by LOG_BACKEND. It is a variable so tests can
+// make it fail.
+var newLogBackend = func(projectID string) (logBackend, error) {
+	if logBackendName() == logBackendStdout {
+		return &stdoutLogBackend{out: os.Stdout}, nil
+	}
+	return newCloudLogBackend(projectID)
+}
+
+// lb is the log backend shared by every request handled by this instance, created when it is
+// first used. flushLogs sends whatever is buffered at the end of each request. Unlike the other
+// shared clients it is guarded by a mutex rather than a sync.Once, so that a backend that could
+// not be created is tried again by the next entry instead of never.
+var lb logBackend
+var lbMu sync.Mutex
+
+// logger returns the shared log backend, creating it if it does not exist yet.
+func (bp *backupParams) logger() (logBackend, error) {
+	lbMu.Lock()
+	defer lbMu.Unlock()
+	if lb != nil {
+		return lb, nil
+	}
+	b, err := newLogBackend(bp.projectID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create logging client: %v", err)
+	}
+	lb = b
+	return lb, nil
+}
+
+// flushLogs sends all buffered log entries. It does nothing if nothing was logged yet.
+func (bp *backupParams) flushLogs() error {
+	lbMu.Lock()
+	b := lb
+	lbMu.Unlock()
+	if b == nil {
+		return nil
+	}
+	return b.Flush()
+}
+
+// logFallback writes an entry that could not reach the log backend to standard error, which
+// Cloud Functions still collects, as the JSON of its payload.
+func logFallback(severity logging.Severity, payload interface{}, cause error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		data = []byte(fmt.Sprint(payload))
+	}
+	log.Printf("%s %s (%v)", strings.ToUpper(severity.String()), data, cause)
+}
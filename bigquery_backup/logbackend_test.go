@@ -0,0 +1,77 @@
+package bigquerybackup
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/logging"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLogBackendName(t *testing.T) {
+	t.Setenv(logBackendEnv, "")
+	assert.Equal(t, logBackendCloud, logBackendName())
+	t.Setenv(logBackendEnv, " STDOUT ")
+	assert.Equal(t, logBackendStdout, logBackendName())
+}
+
+func TestStdoutLogBackend(t *testing.T) {
+	t.Setenv("LOG_NAME", "")
+	var buf bytes.Buffer
+	b := &stdoutLogBackend{out: &buf}
+	bp := &backupParams{runID: "run-1", sourceDatasetID: "ds", backupTableID: "t"}
+
+	b.Log(logging.Entry{Severity: logging.Warning, Payload: bp.newLogPayload("Backup is slow"), Timestamp: time.Date(2024, 1, 15, 10, 0, 0, 0, time.UTC)})
+	assert.NoError(t, b.LogSync(context.Background(), "bigquery-backup-audit", logging.Entry{Severity: logging.Notice, Payload: "plain text"}))
+	assert.NoError(t, b.Flush())
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	assert.Len(t, lines, 2)
+	assert.JSONEq(t, `{"severity":"WARNING","time":"2024-01-15T10:00:00Z","log":"bigquery-backup","message":"Backup is slow","run_id":"run-1","dataset":"ds","table":"t"}`, lines[0])
+	assert.Contains(t, lines[1], `"message":"plain text"`)
+	assert.Contains(t, lines[1], `"severity":"NOTICE"`)
+	assert.Contains(t, lines[1], `"log":"bigquery-backup-audit"`)
+}
+
+func TestLoggerFactoryFailure(t *testing.T) {
+	savedFactory, savedBackend := newLogBackend, lb
+	t.Cleanup(func() { newLogBackend, lb = savedFactory, savedBackend })
+	var out bytes.Buffer
+	log.SetOutput(&out)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	lb = nil
+	calls := 0
+	newLogBackend = func(string) (logBackend, error) {
+		calls++
+		return nil, errors.New("logging unavailable")
+	}
+	bp := &backupParams{runID: "run-1", sourceDatasetID: "ds", backupTableID: "t"}
+
+	assert.NoError(t, bp.logInfo("Backup started"))
+	assert.NoError(t, bp.logError("Backup failed"))
+	assert.NoError(t, bp.flushLogs())
+	assert.Contains(t, out.String(), `INFO {"message":"Backup started","run_id":"run-1","dataset":"ds","table":"t"} (failed to create logging client: logging unavailable)`)
+	assert.Contains(t, out.String(), `ERROR {"message":"Backup failed"`)
+
+	t.Setenv("AUDIT_LOG_NAME", "audit")
+	assert.EqualError(t, bp.logAudit(context.Background(), auditOutcomeFailure), "failed to create logging client: logging unavailable")
+	assert.Contains(t, out.String(), `NOTICE {"caller":""`)
+	assert.Equal(t, 3, calls, "every entry tries to create the backend again")
+
+	var buf bytes.Buffer
+	newLogBackend = func(string) (logBackend, error) {
+		calls++
+		return &stdoutLogBackend{out: &buf}, nil
+	}
+	assert.NoError(t, bp.logInfo("Logging recovered"))
+	assert.NoError(t, bp.logInfo("Still logging"))
+	assert.Equal(t, 4, calls, "the backend is created once it succeeds")
+	assert.Contains(t, buf.String(), `"message":"Still logging"`)
+}
@@ -0,0 +1,62 @@
+package bigquerybackup
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// lookupStatus returns the HTTP status a failed check of a dataset, table or bucket is reported
+// with: 404 when it does not exist, 403 when the function's service account may not read it,
+// and 500 for anything else, such as a backend error. Both the JSON and the gRPC APIs are
+// understood. An error that already carries a status keeps it.
+func lookupStatus(err error) int {
+	var rerr *requestError
+	if errors.As(err, &rerr) {
+		return rerr.code
+	}
+	if errors.Is(err, storage.ErrBucketNotExist) || errors.Is(err, storage.ErrObjectNotExist) {
+		return http.StatusNotFound
+	}
+	var gerr *googleapi.Error
+	if errors.As(err, &gerr) {
+		switch gerr.Code {
+		case http.StatusNotFound:
+			return http.StatusNotFound
+		case http.StatusUnauthorized, http.StatusForbidden:
+			return http.StatusForbidden
+		}
+		return http.StatusInternalServerError
+	}
+	switch status.Code(err) {
+	case codes.NotFound:
+		return http.StatusNotFound
+	case codes.PermissionDenied, codes.Unauthenticated:
+		return http.StatusForbidden
+	}
+	return http.StatusInternalServerError
+}
+
+// lookupError describes a failed check of what, e.g. "dataset sales", so that a missing
+// resource can be told from one the function may not read. It carries the status from
+// lookupStatus. An error that already carries a status was raised by the check itself, such as
+// a dataset in another location, and is reported as is.
+func lookupError(what string, err error) error {
+	code := lookupStatus(err)
+	var rerr *requestError
+	if errors.As(err, &rerr) {
+		return withStatus(code, fmt.Errorf("invalid %s: %v", what, err))
+	}
+	switch code {
+	case http.StatusNotFound:
+		return withStatus(code, fmt.Errorf("%s does not exist: %v", what, err))
+	case http.StatusForbidden:
+		return withStatus(code, fmt.Errorf("permission denied on %s: %v", what, err))
+	}
+	return withStatus(code, fmt.Errorf("problem validating %s: %v", what, err))
+}
@@ -0,0 +1,44 @@
+package bigquerybackup
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"cloud.google.com/go/storage"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestLookupError(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		wantCode int
+		wantMsg  string
+	}{
+		{name: "HTTP not found", err: &googleapi.Error{Code: http.StatusNotFound, Message: "Not found: Dataset p:sales"}, wantCode: http.StatusNotFound, wantMsg: "dataset sales does not exist: "},
+		{name: "HTTP forbidden", err: &googleapi.Error{Code: http.StatusForbidden, Message: "Access Denied"}, wantCode: http.StatusForbidden, wantMsg: "permission denied on dataset sales: "},
+		{name: "HTTP unauthorized", err: &googleapi.Error{Code: http.StatusUnauthorized}, wantCode: http.StatusForbidden, wantMsg: "permission denied on dataset sales: "},
+		{name: "HTTP backend error", err: &googleapi.Error{Code: http.StatusServiceUnavailable}, wantCode: http.StatusInternalServerError, wantMsg: "problem validating dataset sales: "},
+		{name: "Wrapped HTTP not found", err: fmt.Errorf("lookup: %w", &googleapi.Error{Code: http.StatusNotFound}), wantCode: http.StatusNotFound, wantMsg: "dataset sales does not exist: "},
+		{name: "gRPC not found", err: status.Error(codes.NotFound, "no such dataset"), wantCode: http.StatusNotFound, wantMsg: "dataset sales does not exist: "},
+		{name: "gRPC permission denied", err: status.Error(codes.PermissionDenied, "denied"), wantCode: http.StatusForbidden, wantMsg: "permission denied on dataset sales: "},
+		{name: "gRPC unauthenticated", err: status.Error(codes.Unauthenticated, "no credentials"), wantCode: http.StatusForbidden, wantMsg: "permission denied on dataset sales: "},
+		{name: "gRPC unavailable", err: status.Error(codes.Unavailable, "try again"), wantCode: http.StatusInternalServerError, wantMsg: "problem validating dataset sales: "},
+		{name: "Bucket does not exist", err: storage.ErrBucketNotExist, wantCode: http.StatusNotFound, wantMsg: "dataset sales does not exist: "},
+		{name: "Other error", err: errors.New("boom"), wantCode: http.StatusInternalServerError, wantMsg: "problem validating dataset sales: boom"},
+		{name: "Error with status", err: withStatus(http.StatusBadRequest, errors.New("wrong project")), wantCode: http.StatusBadRequest, wantMsg: "invalid dataset sales: wrong project"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := lookupError("dataset sales", tt.err)
+			assert.Equal(t, tt.wantCode, statusCode(err))
+			assert.Contains(t, err.Error(), tt.wantMsg)
+		})
+	}
+}
@@ -0,0 +1,118 @@
+package bigquerybackup
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"cloud.google.com/go/storage"
+)
+
+const manifestObjectName = "manifest.json"
+
+// manifest lists what belongs to one backup, so that its completeness can be verified and a
+// restore can be driven from a single object. Extract statistics do not count rows, so the row
+// and source byte counts are the table's as of the start of the backup. SnapshotTime is the
+// time a point-in-time backup read the table as of. Empty marks the backup of a table without
+// rows, which may have no objects at all. Query holds the SQL of a backup that exported a query
+// result, and QueryObject the query.sql object it was archived to.
+type manifest struct {
+	SourceTable    string           `json:"source_table"`
+	Format         string           `json:"format"`
+	Compression    string           `json:"compression"`
+	BackupTime     time.Time        `json:"backup_time"`
+	SnapshotTime   *time.Time       `json:"snapshot_time,omitempty"`
+	RunID          string           `json:"run_id"`
+	JobID          string           `json:"job_id"`
+	RowCount       uint64           `json:"row_count"`
+	SourceBytes    int64            `json:"source_bytes"`
+	BytesProcessed int64            `json:"bytes_processed"`
+	FileCount      int64            `json:"file_count"`
+	Empty          bool             `json:"empty,omitempty"`
+	Query          string           `json:"query,omitempty"`
+	QueryObject    *manifestObject  `json:"query_object,omitempty"`
+	TotalBytes     int64            `json:"total_bytes"`
+	Objects        []manifestObject `json:"objects"`
+}
+
+type manifestObject struct {
+	Name string `json:"name"`
+	Size int64  `json:"size"`
+}
+
+// newManifest builds the manifest of this backup from its shards. Object names are given under
+// the final backup prefix, also for a staged backup whose shards are still being staged.
+func (bp *backupParams) newManifest(rowCount uint64, sourceBytes int64, shards []*storage.ObjectAttrs) manifest {
+	m := manifest{
+		SourceTable: fmt.Sprintf("%s:%s.%s", bp.sourceProjectID(), bp.sourceDatasetID, bp.backupTableID),
+		Format:      bp.destinationFormat,
+		Compression: bp.compressionType,
+		BackupTime:  bp.backupTime.UTC(),
+		RunID:       bp.runID,
+		JobID:       bp.jobID,
+		RowCount:    rowCount,
+		SourceBytes: sourceBytes,
+		FileCount:   bp.extractFileCount,
+		Empty:       bp.emptyTable,
+		Objects:     make([]manifestObject, 0, len(shards)),
+	}
+	if bp.isPointInTimeBackup() {
+		t := bp.snapshotTime
+		m.SnapshotTime = &t
+	}
+	if bp.jobStats != nil {
+		m.BytesProcessed = bp.jobStats.TotalBytesProcessed
+	}
+	if sql := bp.backupQuery(); sql != "" {
+		m.Query = sql
+		m.QueryObject = &manifestObject{Name: bp.backupPrefix() + "/" + queryObjectName, Size: int64(len(sql))}
+	}
+	for _, attrs := range shards {
+		name := attrs.Name
+		if bp.stagedPublish {
+			name = bp.publishedObjectName(name)
+		}
+		m.Objects = append(m.Objects, manifestObject{Name: name, Size: attrs.Size})
+		m.TotalBytes += attrs.Size
+	}
+	return m
+}
+
+// manifestSource returns the row count and size in bytes of the source recorded in the manifest:
+// those of the partition for a partition backup, otherwise those of the whole table, counting
+// its streaming buffer like checkEmptyTable and verify do.
+func (bp *backupParams) manifestSource(ctx context.Context) (uint64, int64, error) {
+	rows, err := bp.sourceRows(ctx)
+	if err != nil {
+		return 0, 0, err
+	}
+	size, err := bp.sourceBytes(ctx)
+	if err != nil {
+		return 0, 0, err
+	}
+	return rows, size, nil
+}
+
+// writeManifest writes manifest.json into the backup prefix once the export has finished.
+func (bp *backupParams) writeManifest(ctx context.Context) error {
+	rows, sourceBytes, err := bp.manifestSource(ctx)
+	if err != nil {
+		return err
+	}
+	c, err := sharedStorageClient()
+	if err != nil {
+		return err
+	}
+
+	shards, err := bp.listShards(ctx, c, bp.outputPrefix())
+	if err != nil {
+		return fmt.Errorf("failed to list shards: %v", err)
+	}
+	m := bp.newManifest(rows, sourceBytes, shards)
+	bp.bytesExported = m.TotalBytes
+	name, err := bp.writeBackupObject(ctx, manifestObjectName, m)
+	if err != nil {
+		return err
+	}
+	return bp.logInfo(fmt.Sprintf("Wrote manifest of %d objects to gs://%s/%s", len(shards), bp.storageBucket, name))
+}
@@ -0,0 +1,106 @@
+package bigquerybackup
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/bigquery"
+	"cloud.google.com/go/storage"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewManifest(t *testing.T) {
+	bp := &backupParams{
+		projectID:         "test-project",
+		sourceDatasetID:   "ds",
+		backupTableID:     "t",
+		storageBucket:     "b",
+		destinationFormat: parquetFormat,
+		compressionType:   snappyCompression,
+		backupTime:        time.Date(2024, 1, 15, 10, 0, 0, 0, time.UTC),
+		runID:             "run-1",
+		jobID:             "job_1",
+		extractFileCount:  2,
+		jobStats:          &bigquery.JobStatistics{TotalBytesProcessed: 4096},
+	}
+	shards := []*storage.ObjectAttrs{
+		{Name: "ds/t.20240115T100000Z/t-000000000000.parquet", Size: 100},
+		{Name: "ds/t.20240115T100000Z/t-000000000001.parquet", Size: 50},
+	}
+
+	m := bp.newManifest(1000, 8192, shards)
+	assert.Equal(t, "test-project:ds.t", m.SourceTable)
+	assert.Equal(t, parquetFormat, m.Format)
+	assert.Equal(t, snappyCompression, m.Compression)
+	assert.Equal(t, bp.backupTime, m.BackupTime)
+	assert.Equal(t, uint64(1000), m.RowCount)
+	assert.Equal(t, int64(8192), m.SourceBytes)
+	assert.Equal(t, int64(4096), m.BytesProcessed)
+	assert.Equal(t, int64(2), m.FileCount)
+	assert.Equal(t, int64(150), m.TotalBytes)
+	assert.Equal(t, []manifestObject{
+		{Name: "ds/t.20240115T100000Z/t-000000000000.parquet", Size: 100},
+		{Name: "ds/t.20240115T100000Z/t-000000000001.parquet", Size: 50},
+	}, m.Objects)
+}
+
+func TestNewManifestStaged(t *testing.T) {
+	bp := &backupParams{
+		sourceDatasetID: "ds",
+		backupTableID:   "t",
+		runID:           "run-1",
+		stagedPublish:   true,
+		backupTime:      time.Date(2024, 1, 15, 10, 0, 0, 0, time.UTC),
+	}
+	m := bp.newManifest(0, 0, []*storage.ObjectAttrs{{Name: "_staging/run-1/ds/t.20240115T100000Z/t-000000000000.avro", Size: 10}})
+	assert.Equal(t, []manifestObject{{Name: "ds/t.20240115T100000Z/t-000000000000.avro", Size: 10}}, m.Objects)
+}
+
+func TestNewManifestQuery(t *testing.T) {
+	bp := &backupParams{
+		sourceDatasetID: "ds",
+		backupTableID:   "recent_orders",
+		query:           "SELECT * FROM ds.orders WHERE day > '2024-01-01'",
+		backupTime:      time.Date(2024, 1, 15, 10, 0, 0, 0, time.UTC),
+	}
+	m := bp.newManifest(0, 0, nil)
+	assert.Equal(t, bp.query, m.Query)
+	assert.Equal(t, &manifestObject{Name: "ds/recent_orders.20240115T100000Z/query.sql", Size: int64(len(bp.query))}, m.QueryObject)
+
+	bp.query = ""
+	m = bp.newManifest(0, 0, nil)
+	assert.Empty(t, m.Query)
+	assert.Nil(t, m.QueryObject, "a table backup has no query")
+}
+
+func TestManifestSource(t *testing.T) {
+	orig := queryPartitionStats
+	defer func() { queryPartitionStats = orig }()
+	queryPartitionStats = func(ctx context.Context, projectID, datasetID, tableID, partition string) (partitionStats, error) {
+		return partitionStats{rows: 40, bytes: 2048}, nil
+	}
+
+	tests := []struct {
+		name      string
+		partition string
+		wantRows  uint64
+		wantBytes int64
+	}{
+		{name: "Table", wantRows: 1005, wantBytes: 8192},
+		{name: "Partition", partition: "20240115", wantRows: 40, wantBytes: 2048},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bp := &backupParams{projectID: "p", sourceDatasetID: "ds", backupTableID: "t", partition: tt.partition, cache: newMetadataCache()}
+			_, _ = bp.cache.table("p:ds.t", func() (*bigquery.TableMetadata, error) {
+				return &bigquery.TableMetadata{NumRows: 1000, NumBytes: 8192, StreamingBuffer: &bigquery.StreamingBuffer{EstimatedRows: 5}}, nil
+			})
+			rows, sourceBytes, err := bp.manifestSource(context.Background())
+			assert.NoError(t, err)
+			assert.Equal(t, tt.wantRows, rows)
+			assert.Equal(t, tt.wantBytes, sourceBytes)
+		})
+	}
+}
@@ -0,0 +1,90 @@
+package bigquerybackup
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	monitoring "cloud.google.com/go/monitoring/apiv3/v2"
+	"cloud.google.com/go/monitoring/apiv3/v2/monitoringpb"
+	metricpb "google.golang.org/genproto/googleapis/api/metric"
+	"google.golang.org/genproto/googleapis/api/monitoredres"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// metricTypePrefix is the prefix of the custom metrics written to Cloud Monitoring.
+const metricTypePrefix = "custom.googleapis.com/bigquery_backup/"
+
+const (
+	metricBackupSuccess  = "backup_success"
+	metricBackupFailure  = "backup_failure"
+	metricBackupDuration = "backup_duration_seconds"
+	metricBackupBytes    = "backup_bytes_exported"
+)
+
+// mc is the shared Cloud Monitoring client, created on first use.
+var mc *monitoring.MetricClient
+var mcErr error
+var mcOnce sync.Once
+
+// metricsEnabled reports whether backup metrics are written, which is opt-in through the
+// ENABLE_METRICS environment variable.
+func metricsEnabled() bool {
+	return strings.EqualFold(strings.TrimSpace(os.Getenv("ENABLE_METRICS")), "true")
+}
+
+// backupTimeSeries builds the points describing one finished backup, labeled by dataset and
+// table: a success or failure count and, for a success, its duration and exported bytes.
+func (bp *backupParams) backupTimeSeries(success bool, duration time.Duration, bytes int64, now time.Time) []*monitoringpb.TimeSeries {
+	point := func(v *monitoringpb.TypedValue) []*monitoringpb.Point {
+		return []*monitoringpb.Point{{Interval: &monitoringpb.TimeInterval{EndTime: timestamppb.New(now)}, Value: v}}
+	}
+	series := func(name string, kind metricpb.MetricDescriptor_ValueType, v *monitoringpb.TypedValue) *monitoringpb.TimeSeries {
+		return &monitoringpb.TimeSeries{
+			Metric: &metricpb.Metric{
+				Type:   metricTypePrefix + name,
+				Labels: map[string]string{"dataset": bp.sourceDatasetID, "table": bp.backupTableID},
+			},
+			Resource:   &monitoredres.MonitoredResource{Type: "global", Labels: map[string]string{"project_id": bp.projectID}},
+			MetricKind: metricpb.MetricDescriptor_GAUGE,
+			ValueType:  kind,
+			Points:     point(v),
+		}
+	}
+	one := &monitoringpb.TypedValue{Value: &monitoringpb.TypedValue_Int64Value{Int64Value: 1}}
+	if !success {
+		return []*monitoringpb.TimeSeries{series(metricBackupFailure, metricpb.MetricDescriptor_INT64, one)}
+	}
+	return []*monitoringpb.TimeSeries{
+		series(metricBackupSuccess, metricpb.MetricDescriptor_INT64, one),
+		series(metricBackupDuration, metricpb.MetricDescriptor_DOUBLE, &monitoringpb.TypedValue{Value: &monitoringpb.TypedValue_DoubleValue{DoubleValue: duration.Seconds()}}),
+		series(metricBackupBytes, metricpb.MetricDescriptor_INT64, &monitoringpb.TypedValue{Value: &monitoringpb.TypedValue_Int64Value{Int64Value: bytes}}),
+	}
+}
+
+// emitBackupMetrics writes the metrics of a finished backup to Cloud Monitoring when
+// ENABLE_METRICS is set. A failure to write them is logged and never fails the backup.
+func (bp *backupParams) emitBackupMetrics(ctx context.Context, success bool, duration time.Duration) {
+	if !metricsEnabled() {
+		return
+	}
+	mcOnce.Do(func() {
+		mc, mcErr = monitoring.NewMetricClient(context.Background())
+	})
+	if mcErr != nil {
+		_ = bp.logError(fmt.Sprintf("Failed to create Cloud Monitoring client: %v", mcErr))
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), triggerTimeout)
+	defer cancel()
+	err := mc.CreateTimeSeries(ctx, &monitoringpb.CreateTimeSeriesRequest{
+		Name:       "projects/" + bp.projectID,
+		TimeSeries: bp.backupTimeSeries(success, duration, bp.bytesExported, time.Now()),
+	})
+	if err != nil {
+		_ = bp.logError(fmt.Sprintf("Failed to write backup metrics: %v", err))
+	}
+}
@@ -0,0 +1,44 @@
+package bigquerybackup
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBackupTimeSeries(t *testing.T) {
+	bp := &backupParams{projectID: "test-project", sourceDatasetID: "ds", backupTableID: "t"}
+	now := time.Date(2024, 1, 15, 10, 0, 0, 0, time.UTC)
+
+	failed := bp.backupTimeSeries(false, time.Minute, 0, now)
+	assert.Len(t, failed, 1)
+	assert.Equal(t, metricTypePrefix+metricBackupFailure, failed[0].Metric.Type)
+	assert.Equal(t, map[string]string{"dataset": "ds", "table": "t"}, failed[0].Metric.Labels)
+	assert.Equal(t, "test-project", failed[0].Resource.Labels["project_id"])
+	assert.Equal(t, int64(1), failed[0].Points[0].Value.GetInt64Value())
+
+	succeeded := bp.backupTimeSeries(true, 90*time.Second, 2048, now)
+	assert.Len(t, succeeded, 3)
+	values := map[string]interface{}{}
+	for _, ts := range succeeded {
+		assert.Equal(t, now.Unix(), ts.Points[0].Interval.EndTime.GetSeconds())
+		if ts.Metric.Type == metricTypePrefix+metricBackupDuration {
+			values[ts.Metric.Type] = ts.Points[0].Value.GetDoubleValue()
+		} else {
+			values[ts.Metric.Type] = ts.Points[0].Value.GetInt64Value()
+		}
+	}
+	assert.Equal(t, map[string]interface{}{
+		metricTypePrefix + metricBackupSuccess:  int64(1),
+		metricTypePrefix + metricBackupDuration: 90.0,
+		metricTypePrefix + metricBackupBytes:    int64(2048),
+	}, values)
+}
+
+func TestMetricsEnabled(t *testing.T) {
+	t.Setenv("ENABLE_METRICS", "")
+	assert.False(t, metricsEnabled())
+	t.Setenv("ENABLE_METRICS", "TRUE")
+	assert.True(t, metricsEnabled())
+}
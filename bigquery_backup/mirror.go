@@ -0,0 +1,159 @@
+package bigquerybackup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+
+	"cloud.google.com/go/storage"
+)
+
+const (
+	mirrorStatusOK      = "ok"
+	mirrorStatusPartial = "partial"
+	mirrorStatusFailed  = "failed"
+)
+
+// mirrorResult reports the copy of a backup to the secondary bucket, with the outcome of every
+// object.
+type mirrorResult struct {
+	Bucket  string               `json:"bucket"`
+	Status  string               `json:"status"`
+	Error   string               `json:"error,omitempty"`
+	Objects []mirrorObjectResult `json:"objects"`
+}
+
+// mirrorObjectResult is the outcome of copying one object to the secondary bucket.
+type mirrorObjectResult struct {
+	Object string `json:"object"`
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// partial reports whether the backup only partly reached the secondary bucket.
+func (m *mirrorResult) partial() bool {
+	return m != nil && m.Status != mirrorStatusOK
+}
+
+// checkSecondaryBucket validates the secondary_bucket field of the POST body.
+func checkSecondaryBucket(pb *postBodyParams) error {
+	bucket := strings.TrimSpace(pb.SecondaryBucket)
+	if bucket == "" {
+		return nil
+	}
+	if err := validateBucketName(bucket); err != nil {
+		return fmt.Errorf("invalid secondary_bucket: %v", err)
+	}
+	if bucket == strings.TrimSpace(pb.StorageBucket) {
+		return errors.New("secondary_bucket must differ from storage_bucket")
+	}
+	return nil
+}
+
+// validateSecondaryBucket checks that the secondary bucket exists, is not the primary bucket,
+// which may only be known once DATASET_BUCKET_MAP has been applied, and can be written to. It
+// returns true when no secondary bucket was requested.
+func (bp *backupParams) validateSecondaryBucket(ctx context.Context) (bool, error) {
+	if bp.secondaryBucket == "" {
+		return true, nil
+	}
+	if bp.secondaryBucket == bp.storageBucket {
+		return false, withStatus(http.StatusBadRequest, errors.New("secondary_bucket must differ from storage_bucket"))
+	}
+	c, err := sharedStorageClient()
+	if err != nil {
+		return false, err
+	}
+
+	ctx, cancel := withStorageTimeout(ctx)
+	defer cancel()
+	bucket := c.Bucket(bp.secondaryBucket)
+	if _, err := bucket.Attrs(ctx); err != nil {
+		return false, err
+	}
+	if err := checkBucketWritable(ctx, bp.secondaryBucket); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// mirrorBackup copies every object of the finished backup to the secondary bucket under the
+// same name. The _SUCCESS marker is copied last, so consumers of the secondary bucket see it
+// only once the rest of the backup is there. Failures are logged as warnings and recorded in
+// bp.mirror rather than failing the backup, which is already safe in the primary bucket. It
+// does nothing when no secondary bucket was requested.
+func (bp *backupParams) mirrorBackup(ctx context.Context) {
+	if bp.secondaryBucket == "" {
+		return
+	}
+	bp.mirror = &mirrorResult{Bucket: bp.secondaryBucket, Objects: []mirrorObjectResult{}}
+	c, err := sharedStorageClient()
+	if err != nil {
+		bp.failMirror(err)
+		return
+	}
+
+	src := c.Bucket(bp.storageBucket)
+	objects, err := listObjectAttrs(ctx, src, bp.backupPrefix()+"/")
+	if err != nil {
+		bp.failMirror(fmt.Errorf("failed to list backup objects: %v", err))
+		return
+	}
+	successMarkerLast(objects)
+
+	dst := c.Bucket(bp.secondaryBucket)
+	failed := 0
+	for _, attrs := range objects {
+		result := mirrorObjectResult{Object: attrs.Name, Status: mirrorStatusOK}
+		if err := bp.copyToSecondary(ctx, src, dst, attrs); err != nil {
+			failed++
+			forgetBucketWritable(bp.secondaryBucket)
+			result.Status, result.Error = mirrorStatusFailed, err.Error()
+			_ = bp.logWarning(fmt.Sprintf("Failed to copy gs://%s/%s to secondary bucket %s: %v", bp.storageBucket, attrs.Name, bp.secondaryBucket, err))
+		}
+		bp.mirror.Objects = append(bp.mirror.Objects, result)
+	}
+
+	switch {
+	case failed == 0:
+		bp.mirror.Status = mirrorStatusOK
+		_ = bp.logInfo(fmt.Sprintf("Copied %d objects of backup of table %s.%s to secondary bucket %s", len(objects), bp.sourceDatasetID, bp.backupTableID, bp.secondaryBucket))
+	case failed == len(objects):
+		bp.mirror.Status = mirrorStatusFailed
+	default:
+		bp.mirror.Status = mirrorStatusPartial
+	}
+}
+
+// successMarkerLast moves the _SUCCESS marker to the end of objects, keeping the order of the
+// others.
+func successMarkerLast(objects []*storage.ObjectAttrs) {
+	sort.SliceStable(objects, func(i, j int) bool {
+		return path.Base(objects[i].Name) != successMarkerName && path.Base(objects[j].Name) == successMarkerName
+	})
+}
+
+// failMirror records that the backup could not be copied to the secondary bucket at all.
+func (bp *backupParams) failMirror(err error) {
+	bp.mirror.Status, bp.mirror.Error = mirrorStatusFailed, err.Error()
+	_ = bp.logWarning(fmt.Sprintf("Failed to copy backup of table %s.%s to secondary bucket %s: %v", bp.sourceDatasetID, bp.backupTableID, bp.secondaryBucket, err))
+}
+
+// copyToSecondary copies one object to the secondary bucket. The copy is written in the
+// requested storage class, or the secondary bucket's default, and so carries the object's
+// content type and custom metadata over explicitly.
+func (bp *backupParams) copyToSecondary(ctx context.Context, src, dst *storage.BucketHandle, attrs *storage.ObjectAttrs) error {
+	ctx, cancel := withStorageTimeout(ctx)
+	defer cancel()
+	copier := dst.Object(attrs.Name).CopierFrom(src.Object(attrs.Name).Generation(attrs.Generation))
+	copier.ContentType = attrs.ContentType
+	copier.ContentEncoding = attrs.ContentEncoding
+	copier.Metadata = attrs.Metadata
+	copier.StorageClass = bp.storageClass
+	_, err := copier.Run(ctx)
+	return err
+}
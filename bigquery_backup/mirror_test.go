@@ -0,0 +1,62 @@
+package bigquerybackup
+
+import (
+	"testing"
+
+	"cloud.google.com/go/storage"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckSecondaryBucket(t *testing.T) {
+	tests := []struct {
+		name    string
+		pb      postBodyParams
+		wantErr bool
+	}{
+		{name: "No secondary", pb: postBodyParams{StorageBucket: "backups"}},
+		{name: "Other bucket", pb: postBodyParams{StorageBucket: "backups", SecondaryBucket: "backups-dr"}},
+		{name: "Primary from bucket map", pb: postBodyParams{SecondaryBucket: "backups-dr"}},
+		{name: "Same bucket", pb: postBodyParams{StorageBucket: "backups", SecondaryBucket: " backups "}, wantErr: true},
+		{name: "Invalid name", pb: postBodyParams{StorageBucket: "backups", SecondaryBucket: "gs://backups-dr"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkSecondaryBucket(&tt.pb)
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestMirrorResultPartial(t *testing.T) {
+	var none *mirrorResult
+	assert.False(t, none.partial())
+	assert.False(t, (&mirrorResult{Status: mirrorStatusOK}).partial())
+	assert.True(t, (&mirrorResult{Status: mirrorStatusPartial}).partial())
+	assert.True(t, (&mirrorResult{Status: mirrorStatusFailed}).partial())
+}
+
+func TestSuccessMarkerLast(t *testing.T) {
+	objects := []*storage.ObjectAttrs{
+		{Name: "ds/t.20240115T100000Z/" + successMarkerName},
+		{Name: "ds/t.20240115T100000Z/manifest.json"},
+		{Name: "ds/t.20240115T100000Z/t-000000000000.avro"},
+		{Name: "ds/t.20240115T100000Z/t-000000000001.avro"},
+	}
+	successMarkerLast(objects)
+
+	var names []string
+	for _, attrs := range objects {
+		names = append(names, attrs.Name)
+	}
+	assert.Equal(t, []string{
+		"ds/t.20240115T100000Z/manifest.json",
+		"ds/t.20240115T100000Z/t-000000000000.avro",
+		"ds/t.20240115T100000Z/t-000000000001.avro",
+		"ds/t.20240115T100000Z/" + successMarkerName,
+	}, names)
+}
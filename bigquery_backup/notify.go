@@ -0,0 +1,74 @@
+package bigquerybackup
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+const (
+	notifyStatusSucceeded = "succeeded"
+	notifyStatusFailed    = "failed"
+	notifyStatusSkipped   = "skipped"
+)
+
+// backupNotification is the message published to notify_topic once a table backup has
+// finished, whether it succeeded, failed or was skipped. Error is set for a failed backup.
+type backupNotification struct {
+	Status         string    `json:"status"`
+	ProjectID      string    `json:"project_id"`
+	DatasetName    string    `json:"dataset_name"`
+	TableName      string    `json:"table_name"`
+	JobID          string    `json:"job_id,omitempty"`
+	RunID          string    `json:"run_id"`
+	DestinationURI string    `json:"destination_uri"`
+	BytesExported  int64     `json:"bytes_exported"`
+	Error          string    `json:"error,omitempty"`
+	Timestamp      time.Time `json:"timestamp"`
+}
+
+// newBackupNotification describes the outcome of the backup: skipped when it was skipped,
+// otherwise failed when backupErr is set and succeeded when it is not.
+func (bp *backupParams) newBackupNotification(skipped bool, backupErr error) backupNotification {
+	n := backupNotification{
+		Status:         notifyStatusSucceeded,
+		ProjectID:      bp.sourceProjectID(),
+		DatasetName:    bp.sourceDatasetID,
+		TableName:      bp.backupTableID,
+		JobID:          bp.jobID,
+		RunID:          bp.runID,
+		DestinationURI: bp.destinationURI(),
+		BytesExported:  bp.bytesExported,
+		Timestamp:      time.Now().UTC(),
+	}
+	switch {
+	case backupErr != nil:
+		n.Status = notifyStatusFailed
+		n.Error = backupErr.Error()
+	case skipped:
+		n.Status = notifyStatusSkipped
+	}
+	return n
+}
+
+// notifyBackupResult publishes the outcome of the backup to notify_topic when one was
+// requested. A failure to publish is logged and never changes the outcome of the backup.
+func (bp *backupParams) notifyBackupResult(ctx context.Context, skipped bool, backupErr error) {
+	if bp.notifyTopic == "" {
+		return
+	}
+	n := bp.newBackupNotification(skipped, backupErr)
+	data, err := json.Marshal(n)
+	if err != nil {
+		_ = bp.logError(fmt.Sprintf("Failed to encode backup notification: %v", err))
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), triggerTimeout)
+	defer cancel()
+	if err := publishToTopic(ctx, bp.projectID, bp.notifyTopic, data); err != nil {
+		_ = bp.logError(fmt.Sprintf("Failed to publish backup notification to %s: %v", bp.notifyTopic, err))
+		return
+	}
+	_ = bp.logInfo(fmt.Sprintf("Published %s notification for backup of table %s.%s to %s", n.Status, bp.sourceDatasetID, bp.backupTableID, bp.notifyTopic))
+}
@@ -0,0 +1,50 @@
+package bigquerybackup
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewBackupNotification(t *testing.T) {
+	bp := &backupParams{
+		projectID:         "p",
+		sourceDatasetID:   "ds",
+		backupTableID:     "t",
+		storageBucket:     "b",
+		destinationFormat: avroFormat,
+		jobID:             "job-1",
+		runID:             "run-1",
+		bytesExported:     2048,
+		backupTime:        time.Date(2024, 1, 15, 10, 0, 0, 0, time.UTC),
+	}
+
+	n := bp.newBackupNotification(false, nil)
+	assert.Equal(t, notifyStatusSucceeded, n.Status)
+	assert.Equal(t, "ds", n.DatasetName)
+	assert.Equal(t, "t", n.TableName)
+	assert.Equal(t, "job-1", n.JobID)
+	assert.Equal(t, "gs://b/ds/t.20240115T100000Z/t-*.avro", n.DestinationURI)
+	assert.Equal(t, int64(2048), n.BytesExported)
+	assert.Empty(t, n.Error)
+	assert.WithinDuration(t, time.Now(), n.Timestamp, time.Minute)
+
+	n = bp.newBackupNotification(false, errors.New("extract failed"))
+	assert.Equal(t, notifyStatusFailed, n.Status)
+	assert.Equal(t, "extract failed", n.Error)
+
+	n = bp.newBackupNotification(true, nil)
+	assert.Equal(t, notifyStatusSkipped, n.Status)
+}
+
+func TestCheckPostBodyNotifyTopic(t *testing.T) {
+	bp := &backupParams{projectID: "test-project"}
+	_, err := bp.checkPostBody(&postBodyParams{DatasetName: "ds", StorageBucket: "backups", NotifyTopic: "backup-results"})
+	assert.NoError(t, err)
+	_, err = bp.checkPostBody(&postBodyParams{DatasetName: "ds", StorageBucket: "backups", NotifyTopic: "projects/other/topics/backup-results"})
+	assert.NoError(t, err)
+	_, err = bp.checkPostBody(&postBodyParams{DatasetName: "ds", StorageBucket: "backups", NotifyTopic: "projects/other/subscriptions/x"})
+	assert.Error(t, err)
+}
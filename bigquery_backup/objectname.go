@@ -0,0 +1,111 @@
+package bigquerybackup
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// maxObjectNameBytes is the longest object name Cloud Storage accepts, in UTF-8 bytes.
+const maxObjectNameBytes = 1024
+
+// Bucket names are 3 to 63 characters long, or up to 222 when they contain dots, with every
+// dot-separated component at most 63 characters.
+const (
+	minBucketNameLength       = 3
+	maxBucketNameLength       = 63
+	maxDottedBucketNameLength = 222
+)
+
+// validateBucketName checks name against the Cloud Storage bucket naming rules, so a mistyped
+// bucket is rejected with the rule it breaks instead of failing inside the storage client. It
+// calls out the common mistakes of pasting a gs:// URI or a path within the bucket.
+func validateBucketName(name string) error {
+	switch {
+	case strings.HasPrefix(name, "gs://"):
+		return fmt.Errorf("bucket name %q must not start with gs://, give only the bucket name", name)
+	case strings.Contains(name, "/"):
+		return fmt.Errorf("bucket name %q must not contain a path or trailing slash, give only the bucket name", name)
+	case len(name) < minBucketNameLength:
+		return fmt.Errorf("bucket name %q is shorter than %d characters", name, minBucketNameLength)
+	case !strings.Contains(name, ".") && len(name) > maxBucketNameLength:
+		return fmt.Errorf("bucket name %q is longer than %d characters", name, maxBucketNameLength)
+	case len(name) > maxDottedBucketNameLength:
+		return fmt.Errorf("bucket name %q is longer than %d characters", name, maxDottedBucketNameLength)
+	}
+	for _, r := range name {
+		if !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '-' || r == '_' || r == '.') {
+			if unicode.IsUpper(r) {
+				return fmt.Errorf("bucket name %q must be lowercase", name)
+			}
+			return fmt.Errorf("bucket name %q contains %q, only lowercase letters, numbers, dashes, underscores and dots are allowed", name, r)
+		}
+	}
+	if !isBucketNameEdge(name[0]) || !isBucketNameEdge(name[len(name)-1]) {
+		return fmt.Errorf("bucket name %q must start and end with a letter or number", name)
+	}
+	for _, component := range strings.Split(name, ".") {
+		if component == "" {
+			return fmt.Errorf("bucket name %q must not contain consecutive dots", name)
+		}
+		if len(component) > maxBucketNameLength {
+			return fmt.Errorf("bucket name %q has a dot-separated component longer than %d characters", name, maxBucketNameLength)
+		}
+	}
+	switch {
+	case net.ParseIP(name) != nil:
+		return fmt.Errorf("bucket name %q must not be an IP address", name)
+	case strings.HasPrefix(name, "goog"):
+		return fmt.Errorf("bucket name %q must not start with goog", name)
+	case strings.Contains(name, "google"):
+		return fmt.Errorf("bucket name %q must not contain google", name)
+	}
+	return nil
+}
+
+// isBucketNameEdge reports whether c may start or end a bucket name.
+func isBucketNameEdge(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= '0' && c <= '9'
+}
+
+// validateObjectName checks name against the Cloud Storage object naming requirements: valid
+// UTF-8 of at most 1024 bytes, no control characters, not "." or "..", and not under the
+// reserved ".well-known/acme-challenge/" prefix.
+func validateObjectName(name string) error {
+	switch {
+	case name == "":
+		return errors.New("object name is empty")
+	case len(name) > maxObjectNameBytes:
+		return fmt.Errorf("object name is %d bytes, longer than the %d byte limit", len(name), maxObjectNameBytes)
+	case !utf8.ValidString(name):
+		return errors.New("object name is not valid UTF-8")
+	case name == "." || name == "..":
+		return fmt.Errorf("object name %q is not allowed", name)
+	case strings.HasPrefix(name, ".well-known/acme-challenge/"):
+		return errors.New("object names under .well-known/acme-challenge/ are reserved")
+	}
+	for _, r := range name {
+		if unicode.IsControl(r) {
+			return fmt.Errorf("object name contains control character %U", r)
+		}
+	}
+	return nil
+}
+
+// validateDestinationObjectName checks that the objects the extract job will write have legal
+// names. The shard wildcard is expanded to the 12-digit number BigQuery substitutes, so the
+// longest name of the export is checked before the job runs.
+func (bp *backupParams) validateDestinationObjectName() error {
+	if err := checkShardWildcard(bp.shardObjectPattern()); err != nil {
+		return err
+	}
+	prefix := "gs://" + bp.storageBucket + "/"
+	name := strings.Replace(strings.TrimPrefix(bp.extractURI(), prefix), "*", "000000000000", 1)
+	if err := validateObjectName(name); err != nil {
+		return fmt.Errorf("invalid backup object name %q: %v", name, err)
+	}
+	return nil
+}
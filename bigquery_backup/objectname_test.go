@@ -0,0 +1,92 @@
+package bigquerybackup
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateBucketName(t *testing.T) {
+	tests := []struct {
+		name    string
+		bucket  string
+		wantErr bool
+	}{
+		{name: "Typical bucket", bucket: "my-backups"},
+		{name: "Underscores and digits", bucket: "backups_2024"},
+		{name: "Dotted", bucket: "backups.example.com"},
+		{name: "Shortest", bucket: "abc"},
+		{name: "Longest", bucket: strings.Repeat("a", maxBucketNameLength)},
+		{name: "gs:// prefix", bucket: "gs://my-backups", wantErr: true},
+		{name: "Trailing slash", bucket: "my-backups/", wantErr: true},
+		{name: "Path", bucket: "my-backups/daily", wantErr: true},
+		{name: "Uppercase", bucket: "My-Backups", wantErr: true},
+		{name: "Space", bucket: "my backups", wantErr: true},
+		{name: "Too short", bucket: "ab", wantErr: true},
+		{name: "Too long", bucket: strings.Repeat("a", maxBucketNameLength+1), wantErr: true},
+		{name: "Dotted component too long", bucket: strings.Repeat("a", maxBucketNameLength+1) + ".com", wantErr: true},
+		{name: "Leading dash", bucket: "-backups", wantErr: true},
+		{name: "Trailing dash", bucket: "backups-", wantErr: true},
+		{name: "Consecutive dots", bucket: "backups..com", wantErr: true},
+		{name: "IP address", bucket: "192.168.5.4", wantErr: true},
+		{name: "goog prefix", bucket: "goog-backups", wantErr: true},
+		{name: "Contains google", bucket: "my-google-backups", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateBucketName(tt.bucket)
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestValidateObjectName(t *testing.T) {
+	tests := []struct {
+		name    string
+		object  string
+		wantErr bool
+	}{
+		{name: "Typical backup object", object: "ds/t.20240115T100000Z/t-000000000000.avro"},
+		{name: "Unicode", object: "données/t-000000000000.csv"},
+		{name: "Empty", object: "", wantErr: true},
+		{name: "Too long", object: strings.Repeat("a", maxObjectNameBytes+1), wantErr: true},
+		{name: "At the limit", object: strings.Repeat("a", maxObjectNameBytes)},
+		{name: "Newline", object: "ds/t\n-000000000000.avro", wantErr: true},
+		{name: "Tab", object: "ds/t\t-000000000000.avro", wantErr: true},
+		{name: "Invalid UTF-8", object: "ds/\xff.avro", wantErr: true},
+		{name: "Dot", object: ".", wantErr: true},
+		{name: "ACME prefix", object: ".well-known/acme-challenge/x", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateObjectName(tt.object)
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestValidateDestinationObjectName(t *testing.T) {
+	bp := &backupParams{
+		storageBucket:     "bucket",
+		sourceDatasetID:   "ds",
+		backupTableID:     "t",
+		destinationFormat: avroFormat,
+		backupTime:        time.Date(2024, 1, 15, 0, 0, 0, 0, time.UTC),
+	}
+	assert.NoError(t, bp.validateDestinationObjectName())
+
+	bp.backupTableID = strings.Repeat("t", 600)
+	assert.Error(t, bp.validateDestinationObjectName())
+}
@@ -0,0 +1,70 @@
+package bigquerybackup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"google.golang.org/api/idtoken"
+)
+
+const (
+	allowedInvokersEnv = "ALLOWED_INVOKERS"
+	oidcAudienceEnv    = "OIDC_AUDIENCE"
+)
+
+// validateIDToken checks the signature, expiry and audience of a Google-signed ID token. It is
+// a variable so tests can replace it without fetching Google's certificates.
+var validateIDToken = idtoken.Validate
+
+// allowedInvokers returns the service account emails, lowercased, that may invoke the
+// functions with an ID token, read from the comma-separated ALLOWED_INVOKERS. An empty list
+// disables ID token authentication.
+func allowedInvokers() []string {
+	var emails []string
+	for _, e := range strings.Split(os.Getenv(allowedInvokersEnv), ",") {
+		if e = strings.ToLower(strings.TrimSpace(e)); e != "" {
+			emails = append(emails, e)
+		}
+	}
+	return emails
+}
+
+// oidcAudience returns the audience ID tokens must be issued for, read from OIDC_AUDIENCE. For
+// Cloud Scheduler it is the target URL, the function's URL unless the job sets another one.
+func oidcAudience() string {
+	return strings.TrimSpace(os.Getenv(oidcAudienceEnv))
+}
+
+// verifyInvoker validates the ID token in the Authorization header against OIDC_AUDIENCE and
+// returns the email of the service account it was issued to. It returns an error carrying 401
+// when the token is missing or invalid, and 403 when it is valid but the service account is not
+// in ALLOWED_INVOKERS.
+func verifyInvoker(ctx context.Context, header string, invokers []string) (string, error) {
+	audience := oidcAudience()
+	if audience == "" {
+		return "", fmt.Errorf("%s is set but %s is not, cannot verify ID tokens", allowedInvokersEnv, oidcAudienceEnv)
+	}
+	scheme, token, ok := strings.Cut(strings.TrimSpace(header), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return "", withStatus(http.StatusUnauthorized, errors.New("missing bearer token"))
+	}
+	payload, err := validateIDToken(ctx, strings.TrimSpace(token), audience)
+	if err != nil {
+		return "", withStatus(http.StatusUnauthorized, fmt.Errorf("invalid ID token: %v", err))
+	}
+	email, _ := payload.Claims["email"].(string)
+	if verified, _ := payload.Claims["email_verified"].(bool); email == "" || !verified {
+		return "", withStatus(http.StatusUnauthorized, errors.New("ID token carries no verified email"))
+	}
+	email = strings.ToLower(email)
+	for _, allowed := range invokers {
+		if email == allowed {
+			return email, nil
+		}
+	}
+	return email, withStatus(http.StatusForbidden, fmt.Errorf("%s is not allowed to invoke this function", email))
+}
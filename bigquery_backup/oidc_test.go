@@ -0,0 +1,117 @@
+package bigquerybackup
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/api/idtoken"
+)
+
+// fakeIDTokens replaces validateIDToken for the test, accepting the tokens in claims, keyed by
+// token, when they were issued for audience.
+func fakeIDTokens(t *testing.T, audience string, claims map[string]map[string]interface{}) {
+	orig := validateIDToken
+	t.Cleanup(func() { validateIDToken = orig })
+	validateIDToken = func(ctx context.Context, token, aud string) (*idtoken.Payload, error) {
+		c, ok := claims[token]
+		if !ok {
+			return nil, errors.New("bad signature")
+		}
+		if aud != audience {
+			return nil, errors.New("audience mismatch")
+		}
+		return &idtoken.Payload{Audience: aud, Claims: c}, nil
+	}
+}
+
+func TestAllowedInvokers(t *testing.T) {
+	t.Setenv(allowedInvokersEnv, "")
+	assert.Empty(t, allowedInvokers())
+	t.Setenv(allowedInvokersEnv, " Scheduler@p.iam.gserviceaccount.com, ,ops@p.iam.gserviceaccount.com")
+	assert.Equal(t, []string{"scheduler@p.iam.gserviceaccount.com", "ops@p.iam.gserviceaccount.com"}, allowedInvokers())
+}
+
+func TestVerifyInvoker(t *testing.T) {
+	const aud = "https://europe-west1-p.cloudfunctions.net/BigQueryBackup"
+	fakeIDTokens(t, aud, map[string]map[string]interface{}{
+		"scheduler":  {"email": "Scheduler@p.iam.gserviceaccount.com", "email_verified": true},
+		"other":      {"email": "other@p.iam.gserviceaccount.com", "email_verified": true},
+		"unverified": {"email": "scheduler@p.iam.gserviceaccount.com", "email_verified": false},
+		"no-email":   {},
+	})
+	invokers := []string{"scheduler@p.iam.gserviceaccount.com"}
+	t.Setenv(oidcAudienceEnv, aud)
+
+	tests := []struct {
+		name      string
+		header    string
+		wantEmail string
+		wantCode  int
+	}{
+		{name: "Allowed service account", header: "Bearer scheduler", wantEmail: "scheduler@p.iam.gserviceaccount.com"},
+		{name: "Other service account", header: "Bearer other", wantEmail: "other@p.iam.gserviceaccount.com", wantCode: http.StatusForbidden},
+		{name: "Unverified email", header: "Bearer unverified", wantCode: http.StatusUnauthorized},
+		{name: "No email", header: "Bearer no-email", wantCode: http.StatusUnauthorized},
+		{name: "Invalid token", header: "Bearer forged", wantCode: http.StatusUnauthorized},
+		{name: "Missing header", wantCode: http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			email, err := verifyInvoker(context.Background(), tt.header, invokers)
+			assert.Equal(t, tt.wantEmail, email)
+			if tt.wantCode == 0 {
+				assert.NoError(t, err)
+			} else {
+				assert.Equal(t, tt.wantCode, statusCode(err))
+			}
+		})
+	}
+
+	t.Setenv(oidcAudienceEnv, "https://elsewhere.example.com")
+	_, err := verifyInvoker(context.Background(), "Bearer scheduler", invokers)
+	assert.Equal(t, http.StatusUnauthorized, statusCode(err), "token for another audience")
+
+	t.Setenv(oidcAudienceEnv, "")
+	_, err = verifyInvoker(context.Background(), "Bearer scheduler", invokers)
+	assert.Equal(t, http.StatusInternalServerError, statusCode(err), "no audience configured")
+}
+
+func TestAuthorizeIDToken(t *testing.T) {
+	const aud = "https://europe-west1-p.cloudfunctions.net/BigQueryBackup"
+	fakeIDTokens(t, aud, map[string]map[string]interface{}{
+		"scheduler": {"email": "scheduler@p.iam.gserviceaccount.com", "email_verified": true},
+		"other":     {"email": "other@p.iam.gserviceaccount.com", "email_verified": true},
+	})
+	t.Setenv(oidcAudienceEnv, aud)
+	t.Setenv(allowedInvokersEnv, "scheduler@p.iam.gserviceaccount.com")
+	t.Setenv(authTokenEnv, "s3cret")
+
+	request := func(header string) (*backupParams, *httptest.ResponseRecorder, bool) {
+		r := httptest.NewRequest(http.MethodPost, "/", nil)
+		r.Header.Set("Authorization", header)
+		bp, rec := &backupParams{}, httptest.NewRecorder()
+		return bp, rec, bp.authorize(rec, r)
+	}
+
+	bp, _, ok := request("Bearer scheduler")
+	assert.True(t, ok)
+	assert.Equal(t, "scheduler@p.iam.gserviceaccount.com", bp.caller, "the invoker is recorded as the caller")
+
+	bp, _, ok = request("Bearer s3cret")
+	assert.True(t, ok, "the shared secret is still accepted")
+	assert.Empty(t, bp.caller)
+
+	_, rec, ok := request("Bearer other")
+	assert.False(t, ok)
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+
+	_, rec, ok = request("Bearer forged")
+	assert.False(t, ok)
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	assert.NotEmpty(t, rec.Header().Get("WWW-Authenticate"))
+}
@@ -0,0 +1,26 @@
+package bigquerybackup
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+)
+
+// checkOverwrite refuses to write a backup into a prefix that already holds objects, so
+// backups are never overwritten unless allow_overwrite, or its older name overwrite, was
+// requested. Existing objects are then replaced by name, so shards of an earlier, larger export
+// may remain next to the new ones.
+func (bp *backupParams) checkOverwrite(ctx context.Context) error {
+	if bp.overwrite {
+		return nil
+	}
+	prefix := bp.backupPrefix()
+	existing, err := bp.objectClient().listObjectNames(ctx, bp.storageBucket, prefix+"/", 1)
+	if err != nil {
+		return fmt.Errorf("failed to check backup prefix gs://%s/%s: %v", bp.storageBucket, prefix, err)
+	}
+	if len(existing) > 0 {
+		return withStatus(http.StatusConflict, fmt.Errorf("backup prefix gs://%s/%s already holds objects such as %s, set allow_overwrite to replace them", bp.storageBucket, prefix, existing[0]))
+	}
+	return nil
+}
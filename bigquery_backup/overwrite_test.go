@@ -0,0 +1,62 @@
+package bigquerybackup
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckOverwriteAllowed(t *testing.T) {
+	bp := &backupParams{storageBucket: "b", sourceDatasetID: "ds", backupTableID: "t", overwrite: true}
+	assert.NoError(t, bp.checkOverwrite(context.Background()))
+}
+
+func TestCheckOverwriteWithFake(t *testing.T) {
+	backupTime := time.Date(2024, 1, 15, 10, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name      string
+		names     []string
+		listErr   error
+		overwrite bool
+		wantCode  int
+	}{
+		{name: "Empty prefix", names: []string{"ds/t.20240114T100000Z/t-000000000000.avro"}},
+		{name: "Existing backup", names: []string{"ds/t.20240115T100000Z/t-000000000000.avro"}, wantCode: http.StatusConflict},
+		{name: "Existing backup with allow_overwrite", names: []string{"ds/t.20240115T100000Z/t-000000000000.avro"}, overwrite: true},
+		{name: "Longer table name", names: []string{"ds/t.20240115T100000Z2/t-000000000000.avro"}},
+		{name: "Listing fails", listErr: errors.New("boom"), wantCode: http.StatusInternalServerError},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lister := &fakeObjectLister{names: tt.names, err: tt.listErr}
+			bp := &backupParams{storageBucket: "backups", sourceDatasetID: "ds", backupTableID: "t", backupTime: backupTime, overwrite: tt.overwrite, objects: lister}
+			err := bp.checkOverwrite(context.Background())
+			if tt.wantCode == 0 {
+				assert.NoError(t, err)
+			} else {
+				assert.Error(t, err)
+				assert.Equal(t, tt.wantCode, statusCode(err))
+			}
+			if tt.overwrite {
+				assert.Empty(t, lister.prefixes, "nothing is listed when overwriting is allowed")
+			} else {
+				assert.Equal(t, []string{"ds/t.20240115T100000Z/"}, lister.prefixes)
+			}
+		})
+	}
+}
+
+func TestSetBackupParamsAllowOverwrite(t *testing.T) {
+	bp := &backupParams{}
+	bp.setBackupParams(postBodyParams{AllowOverwrite: true})
+	assert.True(t, bp.overwrite)
+	bp.setBackupParams(postBodyParams{Overwrite: true})
+	assert.True(t, bp.overwrite, "overwrite is still accepted")
+	bp.setBackupParams(postBodyParams{})
+	assert.False(t, bp.overwrite)
+}
@@ -0,0 +1,225 @@
+package bigquerybackup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/bigquery"
+	"google.golang.org/api/iterator"
+)
+
+const partitionDecorator = "$"
+
+// Special partitions holding the rows with a NULL or out-of-range partitioning value, and the
+// rows still in the streaming buffer.
+const (
+	nullPartition          = "__NULL__"
+	unpartitionedPartition = "__UNPARTITIONED__"
+)
+
+// timePartitionFormats are the partition ID layouts of each time partitioning type.
+var timePartitionFormats = map[bigquery.TimePartitioningType]string{
+	bigquery.HourPartitioningType:  "2006010215",
+	bigquery.DayPartitioningType:   "20060102",
+	bigquery.MonthPartitioningType: "200601",
+	bigquery.YearPartitioningType:  "2006",
+}
+
+// normalizePartition returns the partition ID of a partition field, which may be given with or
+// without the "$" decorator, e.g. "20240115" or "$20240115".
+func normalizePartition(partition string) string {
+	return strings.TrimPrefix(strings.TrimSpace(partition), partitionDecorator)
+}
+
+// checkPartition validates the partition field of the POST body. A partition only names part
+// of a single table, so it cannot be combined with a dataset-wide or query backup. Whether the
+// partition fits the table is checked against its metadata later, by validatePartition.
+func checkPartition(pb *postBodyParams) error {
+	if strings.TrimSpace(pb.Partition) == "" {
+		return nil
+	}
+	switch {
+	case pb.Query != "":
+		return errors.New("partition cannot be combined with query")
+	case pb.TableName == "" || pb.TableName == allTables:
+		return errors.New("partition is only supported for single-table backups")
+	}
+	id := normalizePartition(pb.Partition)
+	if id == nullPartition || id == unpartitionedPartition {
+		return nil
+	}
+	if _, err := strconv.ParseInt(id, 10, 64); err != nil {
+		return fmt.Errorf("invalid partition %q, must be a partition ID such as 20240115", pb.Partition)
+	}
+	return nil
+}
+
+// partitionIDError checks a partition ID against the partitioning of a table, returning an
+// error when the table is not partitioned or the ID cannot name one of its partitions.
+func partitionIDError(md *bigquery.TableMetadata, id string) error {
+	if id == nullPartition || id == unpartitionedPartition {
+		if md.TimePartitioning == nil && md.RangePartitioning == nil {
+			return errors.New("table is not partitioned")
+		}
+		return nil
+	}
+	switch {
+	case md.TimePartitioning != nil:
+		kind := md.TimePartitioning.Type
+		if kind == "" {
+			kind = bigquery.DayPartitioningType
+		}
+		layout, ok := timePartitionFormats[kind]
+		if !ok {
+			return fmt.Errorf("unsupported time partitioning type %s", kind)
+		}
+		if len(id) != len(layout) {
+			return fmt.Errorf("partition %s does not match the table's %s partitioning, expected a %s partition ID", id, kind, layout)
+		}
+		if _, err := time.Parse(layout, id); err != nil {
+			return fmt.Errorf("partition %s is not a valid %s partition ID", id, kind)
+		}
+		return nil
+	case md.RangePartitioning != nil:
+		if _, err := strconv.ParseInt(id, 10, 64); err != nil {
+			return fmt.Errorf("partition %s is not a valid integer range partition ID", id)
+		}
+		return nil
+	}
+	return errors.New("table is not partitioned")
+}
+
+// validatePartition checks that the requested partition fits the partitioning of the table. It
+// returns true when no partition was requested.
+func (bp *backupParams) validatePartition(ctx context.Context) (bool, error) {
+	if bp.partition == "" {
+		return true, nil
+	}
+	md, err := bp.tableMetadata(ctx)
+	if err != nil {
+		return false, err
+	}
+	if err := partitionIDError(md, bp.partition); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// backupName returns the name the backup is written under in its prefix: the table name, with
+// the partition decorator appended for a partition backup, so each partition gets its own
+// folder, e.g. "table$20240115", or the snapshot time for a point-in-time backup, e.g.
+// "table@20240115T080000Z".
+func (bp *backupParams) backupName() string {
+	if bp.partition != "" {
+		return bp.backupTableID + partitionDecorator + bp.partition
+	}
+	if bp.isPointInTimeBackup() {
+		return bp.backupTableID + asOfDecorator + bp.snapshotTime.Format(backupTimeFormat)
+	}
+	return bp.backupTableID
+}
+
+// splitBackupName is the inverse of backupName: it splits the name a backup is written under
+// into the table and, for a partition backup, the partition ID, dropping the snapshot time of a
+// point-in-time backup. "table$20240115" gives "table" and "20240115", and
+// "table@20240115T080000Z" gives "table" and no partition.
+func splitBackupName(name string) (table, partition string) {
+	name, _, _ = strings.Cut(name, asOfDecorator)
+	table, partition, _ = strings.Cut(name, partitionDecorator)
+	return table, partition
+}
+
+// extractTableID returns the table the extract job reads, including the partition decorator
+// for a partition backup.
+func (bp *backupParams) extractTableID() string {
+	if bp.partition != "" {
+		return bp.sourceTableID() + partitionDecorator + bp.partition
+	}
+	return bp.sourceTableID()
+}
+
+// partitionStats holds the size of one partition of a table, which the table metadata does not
+// report.
+type partitionStats struct {
+	rows  uint64
+	bytes int64
+}
+
+type partitionStatsRow struct {
+	TotalRows         bigquery.NullInt64 `bigquery:"total_rows"`
+	TotalLogicalBytes bigquery.NullInt64 `bigquery:"total_logical_bytes"`
+}
+
+// partitionStatsQuery returns the SQL that reads the size of a partition from the
+// INFORMATION_SCHEMA.PARTITIONS view of the dataset.
+func partitionStatsQuery(projectID, datasetID string) string {
+	return fmt.Sprintf("SELECT total_rows, total_logical_bytes FROM `%s.%s.INFORMATION_SCHEMA.PARTITIONS` WHERE table_name = @table AND partition_id = @partition", projectID, datasetID)
+}
+
+// queryPartitionStats returns the size of partition of a table. A partition that does not
+// exist, or holds no rows, has no row in PARTITIONS and is reported as empty. Tests replace it.
+var queryPartitionStats = func(ctx context.Context, projectID, datasetID, tableID, partition string) (partitionStats, error) {
+	q := bc.Query(partitionStatsQuery(projectID, datasetID))
+	q.Parameters = []bigquery.QueryParameter{
+		{Name: "table", Value: tableID},
+		{Name: "partition", Value: partition},
+	}
+	it, err := q.Read(ctx)
+	if err != nil {
+		return partitionStats{}, err
+	}
+	var row partitionStatsRow
+	err = it.Next(&row)
+	if err == iterator.Done {
+		return partitionStats{}, nil
+	}
+	if err != nil {
+		return partitionStats{}, err
+	}
+	return partitionStats{rows: uint64(row.TotalRows.Int64), bytes: row.TotalLogicalBytes.Int64}, nil
+}
+
+// sourcePartitionStats returns the size of the partition a partition backup exports, looked up
+// once per backup.
+func (bp *backupParams) sourcePartitionStats(ctx context.Context) (partitionStats, error) {
+	if bp.partitionStats == nil {
+		stats, err := queryPartitionStats(ctx, bp.sourceProjectID(), bp.sourceDatasetID, bp.sourceTableID(), bp.partition)
+		if err != nil {
+			return partitionStats{}, fmt.Errorf("failed to look up partition %s: %v", bp.partition, err)
+		}
+		bp.partitionStats = &stats
+	}
+	return *bp.partitionStats, nil
+}
+
+// sourceRows returns the number of rows the export reads: those of the partition for a
+// partition backup, otherwise those of the whole table, see tableRows.
+func (bp *backupParams) sourceRows(ctx context.Context) (uint64, error) {
+	if bp.partition != "" {
+		stats, err := bp.sourcePartitionStats(ctx)
+		return stats.rows, err
+	}
+	md, err := bp.tableMetadata(ctx)
+	if err != nil {
+		return 0, err
+	}
+	return tableRows(md), nil
+}
+
+// sourceBytes returns the logical size of the data the export reads: that of the partition for
+// a partition backup, otherwise the table's NumBytes.
+func (bp *backupParams) sourceBytes(ctx context.Context) (int64, error) {
+	if bp.partition != "" {
+		stats, err := bp.sourcePartitionStats(ctx)
+		return stats.bytes, err
+	}
+	md, err := bp.tableMetadata(ctx)
+	if err != nil {
+		return 0, err
+	}
+	return md.NumBytes, nil
+}
@@ -0,0 +1,104 @@
+package bigquerybackup
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/bigquery"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckPartition(t *testing.T) {
+	tests := []struct {
+		name    string
+		pb      postBodyParams
+		wantErr bool
+	}{
+		{name: "No partition", pb: postBodyParams{}},
+		{name: "Day", pb: postBodyParams{TableName: "t", Partition: "20240115"}},
+		{name: "Decorator", pb: postBodyParams{TableName: "t", Partition: "$20240115"}},
+		{name: "Null partition", pb: postBodyParams{TableName: "t", Partition: "__NULL__"}},
+		{name: "Dataset", pb: postBodyParams{Partition: "20240115"}, wantErr: true},
+		{name: "All tables", pb: postBodyParams{TableName: allTables, Partition: "20240115"}, wantErr: true},
+		{name: "Query", pb: postBodyParams{Query: "SELECT 1", Partition: "20240115"}, wantErr: true},
+		{name: "Date with dashes", pb: postBodyParams{TableName: "t", Partition: "2024-01-15"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.wantErr, checkPartition(&tt.pb) != nil)
+		})
+	}
+}
+
+func TestPartitionIDError(t *testing.T) {
+	day := &bigquery.TableMetadata{TimePartitioning: &bigquery.TimePartitioning{Field: "day"}}
+	hour := &bigquery.TableMetadata{TimePartitioning: &bigquery.TimePartitioning{Type: bigquery.HourPartitioningType}}
+	ranged := &bigquery.TableMetadata{RangePartitioning: &bigquery.RangePartitioning{Field: "id"}}
+	plain := &bigquery.TableMetadata{}
+
+	tests := []struct {
+		name    string
+		md      *bigquery.TableMetadata
+		id      string
+		wantErr bool
+	}{
+		{name: "Day", md: day, id: "20240115"},
+		{name: "Day with hour ID", md: day, id: "2024011510", wantErr: true},
+		{name: "Day with invalid date", md: day, id: "20241345", wantErr: true},
+		{name: "Hour", md: hour, id: "2024011510"},
+		{name: "Hour with day ID", md: hour, id: "20240115", wantErr: true},
+		{name: "Range", md: ranged, id: "100"},
+		{name: "Null", md: day, id: nullPartition},
+		{name: "Not partitioned", md: plain, id: "20240115", wantErr: true},
+		{name: "Not partitioned null", md: plain, id: nullPartition, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.wantErr, partitionIDError(tt.md, tt.id) != nil)
+		})
+	}
+}
+
+func TestPartitionBackup(t *testing.T) {
+	bp := &backupParams{projectID: "test-project"}
+	bp.setBackupParams(postBodyParams{DatasetName: "ds", TableName: "t", StorageBucket: "b", Format: avroFormat, Partition: "$20240115"})
+	bp.backupTime = time.Date(2024, 1, 16, 2, 0, 0, 0, time.UTC)
+
+	assert.Equal(t, "20240115", bp.partition)
+	assert.Equal(t, "ds/t$20240115.20240116T020000Z", bp.backupPrefix())
+	assert.Equal(t, "t$20240115", bp.extractTableID())
+
+	assert.NoError(t, bp.setBigQueryClient(context.Background()))
+	extractor := setupExtractor(bp)
+	assert.Equal(t, "t$20240115", extractor.Src.TableID)
+	assert.Equal(t, []string{"gs://b/ds/t$20240115.20240116T020000Z/t-*.avro"}, extractor.Dst.URIs)
+	assert.Equal(t, "t-*", shardPattern(bp.backupPrefix()+"/"))
+}
+
+func TestSplitBackupName(t *testing.T) {
+	tests := []struct {
+		name          string
+		wantTable     string
+		wantPartition string
+	}{
+		{name: "orders", wantTable: "orders"},
+		{name: "orders$20240115", wantTable: "orders", wantPartition: "20240115"},
+		{name: "orders$__NULL__", wantTable: "orders", wantPartition: nullPartition},
+		{name: "orders@20240115T080000Z", wantTable: "orders"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			table, partition := splitBackupName(tt.name)
+			assert.Equal(t, tt.wantTable, table)
+			assert.Equal(t, tt.wantPartition, partition)
+		})
+	}
+}
+
+func TestPartitionStatsQuery(t *testing.T) {
+	assert.Equal(t, "SELECT total_rows, total_logical_bytes FROM `p.ds.INFORMATION_SCHEMA.PARTITIONS` WHERE table_name = @table AND partition_id = @partition", partitionStatsQuery("p", "ds"))
+}
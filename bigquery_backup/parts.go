@@ -0,0 +1,61 @@
+package bigquerybackup
+
+import (
+	"context"
+	"fmt"
+	"path"
+
+	"cloud.google.com/go/storage"
+)
+
+// backupPartMetadataKey is the custom metadata key set on every object of a backup except the
+// _SUCCESS marker, so that Cloud Storage event consumers can ignore shards and react once per
+// backup.
+const backupPartMetadataKey = "backup_part"
+
+var backupPartMetadata = map[string]string{backupPartMetadataKey: "true"}
+
+// isBackupPart reports whether name is an object of the backup that should carry the
+// backup_part flag, that is anything other than the _SUCCESS marker.
+func isBackupPart(name string) bool {
+	return path.Base(name) != successMarkerName
+}
+
+// markPublishedParts flags the objects of an unstaged backup with backup_part=true and then
+// writes the _SUCCESS marker without it. Staged backups set the flag while copying and write
+// the marker when publishing, so this does nothing for them. It also does nothing unless
+// mark_backup_parts was requested.
+func (bp *backupParams) markPublishedParts(ctx context.Context) error {
+	if !bp.markBackupParts || bp.stagedPublish {
+		return nil
+	}
+	c, err := sharedStorageClient()
+	if err != nil {
+		return err
+	}
+
+	objects, err := bp.listObjects(ctx, c, bp.backupPrefix())
+	if err != nil {
+		return fmt.Errorf("failed to list backup objects: %v", err)
+	}
+	bucket := c.Bucket(bp.storageBucket)
+	parts := make([]string, 0, len(objects))
+	for _, name := range objects {
+		if !isBackupPart(name) {
+			continue
+		}
+		opCtx, cancel := withStorageTimeout(ctx)
+		_, err := bucket.Object(name).Update(opCtx, storage.ObjectAttrsToUpdate{Metadata: backupPartMetadata})
+		cancel()
+		if err != nil {
+			return fmt.Errorf("failed to mark %s as a backup part: %v", name, err)
+		}
+		parts = append(parts, name)
+	}
+
+	name, err := bp.writeSuccessMarker(ctx, parts)
+	if err != nil {
+		return err
+	}
+	return bp.logInfo(fmt.Sprintf("Marked %d objects of backup of table %s.%s as parts, marker gs://%s/%s", len(parts), bp.sourceDatasetID, bp.backupTableID, bp.storageBucket, name))
+}
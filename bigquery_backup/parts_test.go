@@ -0,0 +1,25 @@
+package bigquerybackup
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsBackupPart(t *testing.T) {
+	tests := []struct {
+		name   string
+		object string
+		want   bool
+	}{
+		{name: "Shard", object: "ds/t.20240115T100000Z/t-000000000000.avro", want: true},
+		{name: "Side file", object: "ds/t.20240115T100000Z/load-config.json", want: true},
+		{name: "Marker", object: "ds/t.20240115T100000Z/_SUCCESS", want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, isBackupPart(tt.object))
+		})
+	}
+}
@@ -0,0 +1,90 @@
+package bigquerybackup
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// pathTemplateDateFormat formats the {date} placeholder of a path template, in UTC.
+const pathTemplateDateFormat = "2006-01-02"
+
+// pathTemplatePlaceholder matches the placeholders of a path template.
+var pathTemplatePlaceholder = regexp.MustCompile(`\{[^{}]*\}`)
+
+// pathTemplatePlaceholders are the placeholders a path template may use.
+var pathTemplatePlaceholders = map[string]bool{
+	"{dataset}":   true,
+	"{table}":     true,
+	"{date}":      true,
+	"{timestamp}": true,
+	"{format}":    true,
+}
+
+// checkPathTemplate validates the path_template field of the POST body. The template is the
+// object name of the shards relative to the bucket, e.g.
+// "backups/prod/{dataset}/{table}/{date}/{table}-*.{format}". Its folder, the backup prefix,
+// must name the table, so backups of different tables do not share side files or overwrite each
+// other, and its last path segment must hold the single wildcard BigQuery replaces with the
+// shard number.
+func checkPathTemplate(tmpl string) error {
+	if tmpl == "" {
+		return nil
+	}
+	switch {
+	case strings.HasPrefix(tmpl, "gs://"):
+		return fmt.Errorf("invalid path_template %q, give the object path without gs:// and the bucket", tmpl)
+	case strings.HasPrefix(tmpl, "/"):
+		return fmt.Errorf("invalid path_template %q, must not start with /", tmpl)
+	case strings.Count(tmpl, "*") != 1:
+		return fmt.Errorf("invalid path_template %q, must contain exactly one * wildcard for the shard number", tmpl)
+	case strings.Contains(path.Dir(tmpl), "*"):
+		return fmt.Errorf("invalid path_template %q, the * wildcard must be in the last path segment", tmpl)
+	case !strings.Contains(tmpl, "/"):
+		return fmt.Errorf("invalid path_template %q, must contain a folder for the backup, e.g. {dataset}/{table}/{table}-*.{format}", tmpl)
+	case !strings.Contains(path.Dir(tmpl), "{table}"):
+		return fmt.Errorf("invalid path_template %q, its folder must contain the {table} placeholder", tmpl)
+	}
+	for _, placeholder := range pathTemplatePlaceholder.FindAllString(tmpl, -1) {
+		if !pathTemplatePlaceholders[placeholder] {
+			return fmt.Errorf("invalid path_template %q, unknown placeholder %s", tmpl, placeholder)
+		}
+	}
+	for _, segment := range strings.Split(tmpl, "/") {
+		if segment == "" {
+			return errors.New("invalid path_template, must not contain empty path segments")
+		}
+	}
+	return nil
+}
+
+// renderPathTemplate returns the path template with its placeholders replaced for this backup.
+// {table} is the backup name, so a partition backup gets its own folder.
+func (bp *backupParams) renderPathTemplate() string {
+	t := bp.backupTime.UTC()
+	return strings.NewReplacer(
+		"{dataset}", bp.sourceDatasetID,
+		"{table}", bp.backupName(),
+		"{date}", t.Format(pathTemplateDateFormat),
+		"{timestamp}", t.Format(backupTimeFormat),
+		"{format}", strings.ToLower(bp.destinationFormat),
+	).Replace(bp.pathTemplate)
+}
+
+// shardObjectPattern returns the wildcard object name of the shards within the backup prefix,
+// e.g. "table-*.avro", or the last path segment of the rendered path template.
+func (bp *backupParams) shardObjectPattern() string {
+	if bp.pathTemplate != "" {
+		return path.Base(bp.renderPathTemplate())
+	}
+	return fmt.Sprintf("%s-*.%s", bp.backupTableID, strings.ToLower(bp.destinationFormat))
+}
+
+// shardNamePrefix returns the part of the shard pattern before the wildcard, which every shard
+// name within the backup prefix starts with.
+func (bp *backupParams) shardNamePrefix() string {
+	prefix, _, _ := strings.Cut(bp.shardObjectPattern(), "*")
+	return prefix
+}
@@ -0,0 +1,68 @@
+package bigquerybackup
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckPathTemplate(t *testing.T) {
+	tests := []struct {
+		name    string
+		tmpl    string
+		wantErr bool
+	}{
+		{name: "No template", tmpl: ""},
+		{name: "Env layout", tmpl: "backups/prod/{dataset}/{table}/{date}/{table}-*.{format}"},
+		{name: "Timestamp", tmpl: "{dataset}/{table}/{timestamp}/part-*.avro"},
+		{name: "Missing table", tmpl: "{dataset}/{date}/shard-*.{format}", wantErr: true},
+		{name: "Missing wildcard", tmpl: "{dataset}/{table}/{date}/{table}.avro", wantErr: true},
+		{name: "Two wildcards", tmpl: "{dataset}/{table}/*/{table}-*.avro", wantErr: true},
+		{name: "Wildcard in folder", tmpl: "{dataset}/{table}-*/{table}.avro", wantErr: true},
+		{name: "No folder", tmpl: "{table}-*.avro", wantErr: true},
+		{name: "Table only in file name", tmpl: "backups/{dataset}/{table}-*.{format}", wantErr: true},
+		{name: "Unknown placeholder", tmpl: "{env}/{table}/{table}-*.avro", wantErr: true},
+		{name: "gs:// URI", tmpl: "gs://bucket/{table}/{table}-*.avro", wantErr: true},
+		{name: "Leading slash", tmpl: "/{table}/{table}-*.avro", wantErr: true},
+		{name: "Empty segment", tmpl: "{dataset}//{table}/{table}-*.avro", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkPathTemplate(tt.tmpl)
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestPathTemplateURIs(t *testing.T) {
+	bp := &backupParams{
+		storageBucket:     "bucket",
+		sourceDatasetID:   "ds",
+		backupTableID:     "t",
+		destinationFormat: avroFormat,
+		backupTime:        time.Date(2024, 1, 15, 10, 0, 0, 0, time.UTC),
+	}
+	assert.Equal(t, "ds/t.20240115T100000Z", bp.backupPrefix())
+	assert.Equal(t, "gs://bucket/ds/t.20240115T100000Z/t-*.avro", bp.destinationURI())
+	assert.Equal(t, "t-", bp.shardNamePrefix())
+
+	bp.pathTemplate = "backups/prod/{dataset}/{table}/{date}/{table}-*.{format}"
+	assert.Equal(t, "backups/prod/ds/t/2024-01-15", bp.backupPrefix())
+	assert.Equal(t, "gs://bucket/backups/prod/ds/t/2024-01-15/t-*.avro", bp.destinationURI())
+	assert.Equal(t, "t-", bp.shardNamePrefix())
+
+	bp.pathTemplate = "{dataset}/{table}/{timestamp}/part-*.parquet"
+	bp.partition = "20240114"
+	assert.Equal(t, "ds/t$20240114/20240115T100000Z", bp.backupPrefix())
+	assert.Equal(t, "part-", bp.shardNamePrefix())
+
+	bp.stagedPublish = true
+	bp.runID = "run"
+	assert.Equal(t, "gs://bucket/_staging/run/ds/t$20240114/20240115T100000Z/part-*.parquet", bp.extractURI())
+}
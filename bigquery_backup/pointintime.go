@@ -0,0 +1,69 @@
+package bigquerybackup
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// asOfDecorator separates the table name from the snapshot time in the name of a point-in-time
+// backup, e.g. "orders@20240115T080000Z".
+const asOfDecorator = "@"
+
+// timeTravelWindow is the longest time travel window BigQuery offers. A dataset may be
+// configured with a shorter one, in which case an older snapshot_time fails when its query runs.
+const timeTravelWindow = 7 * 24 * time.Hour
+
+// parseSnapshotTime parses the snapshot_time field of the POST body, an RFC 3339 timestamp, and
+// checks that it lies within the time travel window before now.
+func parseSnapshotTime(raw string, now time.Time) (time.Time, error) {
+	t, err := time.Parse(time.RFC3339, raw)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid snapshot_time %q, must be an RFC 3339 timestamp such as 2024-01-15T08:00:00Z", raw)
+	}
+	switch {
+	case t.After(now):
+		return time.Time{}, fmt.Errorf("snapshot_time %s is in the future", raw)
+	case t.Before(now.Add(-timeTravelWindow)):
+		return time.Time{}, fmt.Errorf("snapshot_time %s is outside BigQuery's 7-day time travel window", raw)
+	}
+	return t.UTC(), nil
+}
+
+// checkSnapshotTime validates the snapshot_time field of the POST body. The table is read as
+// of that time by a query, so the options that need the table itself, or its current state, are
+// rejected.
+func checkSnapshotTime(pb *postBodyParams) error {
+	raw := strings.TrimSpace(pb.SnapshotTime)
+	if raw == "" {
+		return nil
+	}
+	switch {
+	case pb.Query != "":
+		return errors.New("snapshot_time cannot be combined with query, use FOR SYSTEM_TIME AS OF in the query instead")
+	case pb.Partition != "":
+		return errors.New("snapshot_time cannot be combined with partition")
+	case pb.Async:
+		return errors.New("snapshot_time cannot be combined with async, the table has to be read as of that time before the export")
+	case pb.SnapshotDataset != "":
+		return errors.New("snapshot_time cannot be combined with snapshot_dataset")
+	case pb.Incremental:
+		return errors.New("snapshot_time cannot be combined with incremental")
+	case pb.RequireUpdatedWithinHours > 0:
+		return errors.New("snapshot_time cannot be combined with require_updated_within_hours")
+	}
+	_, err := parseSnapshotTime(raw, time.Now())
+	return err
+}
+
+// isPointInTimeBackup reports whether the table is backed up as it was at snapshot_time.
+func (bp *backupParams) isPointInTimeBackup() bool {
+	return !bp.snapshotTime.IsZero()
+}
+
+// pointInTimeQuery returns the query that reads the table being backed up as it was at
+// snapshot_time, using BigQuery time travel.
+func (bp *backupParams) pointInTimeQuery() string {
+	return fmt.Sprintf("SELECT * FROM `%s.%s.%s` FOR SYSTEM_TIME AS OF TIMESTAMP('%s')", bp.sourceProjectID(), bp.sourceDatasetID, bp.backupTableID, bp.snapshotTime.Format(time.RFC3339Nano))
+}
@@ -0,0 +1,85 @@
+package bigquerybackup
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseSnapshotTime(t *testing.T) {
+	now := time.Date(2024, 1, 15, 10, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name    string
+		raw     string
+		want    time.Time
+		wantErr bool
+	}{
+		{name: "UTC", raw: "2024-01-15T08:00:00Z", want: time.Date(2024, 1, 15, 8, 0, 0, 0, time.UTC)},
+		{name: "Offset", raw: "2024-01-15T09:00:00+01:00", want: time.Date(2024, 1, 15, 8, 0, 0, 0, time.UTC)},
+		{name: "Edge of the window", raw: "2024-01-08T10:00:00Z", want: time.Date(2024, 1, 8, 10, 0, 0, 0, time.UTC)},
+		{name: "Outside the window", raw: "2024-01-08T09:59:59Z", wantErr: true},
+		{name: "Future", raw: "2024-01-15T10:00:01Z", wantErr: true},
+		{name: "Date only", raw: "2024-01-15", wantErr: true},
+		{name: "Millis", raw: "1705305600000", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseSnapshotTime(tt.raw, now)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+			assert.Equal(t, time.UTC, got.Location())
+		})
+	}
+}
+
+func TestCheckSnapshotTime(t *testing.T) {
+	recent := time.Now().Add(-time.Hour).Format(time.RFC3339)
+	tests := []struct {
+		name    string
+		pb      postBodyParams
+		wantErr bool
+	}{
+		{name: "Not set", pb: postBodyParams{TableName: "t"}},
+		{name: "Table", pb: postBodyParams{TableName: "t", SnapshotTime: recent}},
+		{name: "Dataset", pb: postBodyParams{SnapshotTime: recent}},
+		{name: "Too old", pb: postBodyParams{TableName: "t", SnapshotTime: "2020-01-01T00:00:00Z"}, wantErr: true},
+		{name: "Query", pb: postBodyParams{Query: "SELECT 1", SnapshotTime: recent}, wantErr: true},
+		{name: "Partition", pb: postBodyParams{TableName: "t", Partition: "20240115", SnapshotTime: recent}, wantErr: true},
+		{name: "Async", pb: postBodyParams{TableName: "t", Async: true, SnapshotTime: recent}, wantErr: true},
+		{name: "Snapshot dataset", pb: postBodyParams{TableName: "t", SnapshotDataset: "snaps", SnapshotTime: recent}, wantErr: true},
+		{name: "Incremental", pb: postBodyParams{TableName: "t", Incremental: true, SnapshotTime: recent}, wantErr: true},
+		{name: "Freshness", pb: postBodyParams{TableName: "t", RequireUpdatedWithinHours: 24, SnapshotTime: recent}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.wantErr, checkSnapshotTime(&tt.pb) != nil)
+		})
+	}
+}
+
+func TestPointInTimeBackup(t *testing.T) {
+	bp := &backupParams{
+		projectID:         "p",
+		sourceDatasetID:   "ds",
+		backupTableID:     "orders",
+		storageBucket:     "backups",
+		destinationFormat: avroFormat,
+		runID:             "0b6c-42",
+		backupTime:        time.Date(2024, 1, 16, 2, 0, 0, 0, time.UTC),
+		snapshotTime:      time.Date(2024, 1, 15, 8, 0, 0, 0, time.UTC),
+	}
+	assert.Equal(t, "SELECT * FROM `p.ds.orders` FOR SYSTEM_TIME AS OF TIMESTAMP('2024-01-15T08:00:00Z')", bp.backupQuery())
+	assert.Equal(t, "_backup_asof_orders_0b6c_42", bp.queryTableID())
+	assert.Equal(t, "orders@20240115T080000Z", bp.backupName())
+	assert.Equal(t, "gs://backups/ds/orders@20240115T080000Z.20240116T020000Z/orders-*.avro", bp.destinationURI())
+
+	m := bp.newManifest(0, 0, nil)
+	assert.Equal(t, bp.snapshotTime, *m.SnapshotTime)
+}
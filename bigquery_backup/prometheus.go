@@ -0,0 +1,66 @@
+package bigquerybackup
+
+import (
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// promLabels are the labels of the Prometheus metrics. They are kept to the table and the
+// outcome, so the number of series grows with the tables backed up and nothing else.
+var promLabels = []string{"dataset", "table", "status"}
+
+var (
+	promRegistry = prometheus.NewRegistry()
+
+	promBackups = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: "bigquery_backup",
+		Name:      "backups_total",
+		Help:      "Table backups that finished, by outcome: succeeded, failed or skipped.",
+	}, promLabels)
+
+	promDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: "bigquery_backup",
+		Name:      "backup_duration_seconds",
+		Help:      "How long finished table backups took, by outcome.",
+		Buckets:   []float64{5, 15, 30, 60, 120, 300, 600, 1200, 1800, 3600},
+	}, promLabels)
+)
+
+func init() {
+	promRegistry.MustRegister(promBackups, promDuration)
+}
+
+// prometheusEnabled reports whether the Prometheus metrics are recorded and served on
+// GET /metrics, which is opt-in through the ENABLE_PROMETHEUS environment variable.
+func prometheusEnabled() bool {
+	return strings.EqualFold(strings.TrimSpace(os.Getenv("ENABLE_PROMETHEUS")), "true")
+}
+
+// observeBackup records a finished table backup in the Prometheus metrics of this instance
+// when ENABLE_PROMETHEUS is set. Its status follows notify_topic.
+func (bp *backupParams) observeBackup(skipped bool, backupErr error, duration time.Duration) {
+	if !prometheusEnabled() {
+		return
+	}
+	status := bp.newBackupNotification(skipped, backupErr).Status
+	promBackups.WithLabelValues(bp.sourceDatasetID, bp.backupTableID, status).Inc()
+	promDuration.WithLabelValues(bp.sourceDatasetID, bp.backupTableID, status).Observe(duration.Seconds())
+}
+
+// promHandler serves the Prometheus metrics in the text exposition format.
+var promHandler = promhttp.HandlerFor(promRegistry, promhttp.HandlerOpts{})
+
+// serveMetrics answers GET /metrics with the Prometheus metrics of this instance, or with 404
+// when ENABLE_PROMETHEUS is not set.
+func serveMetrics(w http.ResponseWriter, r *http.Request) {
+	if !prometheusEnabled() {
+		writeError(w, http.StatusNotFound, "not found: "+r.URL.Path)
+		return
+	}
+	promHandler.ServeHTTP(w, r)
+}
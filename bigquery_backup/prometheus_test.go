@@ -0,0 +1,44 @@
+package bigquerybackup
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestServeMetrics(t *testing.T) {
+	t.Setenv("ENABLE_PROMETHEUS", "true")
+	bp := &backupParams{sourceDatasetID: "ds", backupTableID: "prom_orders", storageBucket: "backups", destinationFormat: avroFormat}
+	bp.observeBackup(false, nil, 90*time.Second)
+	bp.observeBackup(false, errors.New("boom"), 10*time.Second)
+	bp.observeBackup(true, nil, time.Second)
+
+	rec := httptest.NewRecorder()
+	serveHealth(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	body := rec.Body.String()
+	assert.Contains(t, body, `bigquery_backup_backups_total{dataset="ds",status="succeeded",table="prom_orders"} 1`)
+	assert.Contains(t, body, `bigquery_backup_backups_total{dataset="ds",status="failed",table="prom_orders"} 1`)
+	assert.Contains(t, body, `bigquery_backup_backups_total{dataset="ds",status="skipped",table="prom_orders"} 1`)
+	assert.Contains(t, body, `bigquery_backup_backup_duration_seconds_bucket{dataset="ds",status="succeeded",table="prom_orders",le="120"} 1`)
+	assert.Contains(t, body, `bigquery_backup_backup_duration_seconds_sum{dataset="ds",status="succeeded",table="prom_orders"} 90`)
+}
+
+func TestServeMetricsDisabled(t *testing.T) {
+	t.Setenv("ENABLE_PROMETHEUS", "")
+	bp := &backupParams{sourceDatasetID: "ds", backupTableID: "prom_disabled"}
+	bp.observeBackup(false, nil, time.Second)
+
+	rec := httptest.NewRecorder()
+	serveHealth(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+
+	t.Setenv("ENABLE_PROMETHEUS", "true")
+	rec = httptest.NewRecorder()
+	serveHealth(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	assert.NotContains(t, rec.Body.String(), "prom_disabled", "nothing is recorded while disabled")
+}
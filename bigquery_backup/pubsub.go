@@ -0,0 +1,80 @@
+package bigquerybackup
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/GoogleCloudPlatform/functions-framework-go/functions"
+	"github.com/cloudevents/sdk-go/v2/event"
+	"github.com/google/uuid"
+)
+
+func init() {
+	functions.CloudEvent("BigQueryBackupPubSub", trackEvent(bigQueryBackupPubSub))
+}
+
+// pubSubEvent is the data of a google.cloud.pubsub.topic.v1.messagePublished CloudEvent.
+type pubSubEvent struct {
+	Message struct {
+		Data      string `json:"data"`
+		MessageID string `json:"messageId"`
+	} `json:"message"`
+	Subscription string `json:"subscription"`
+}
+
+// decodePubSubBody extracts the backup request from a Pub/Sub CloudEvent. The message data is
+// the base64 encoded JSON of the same body the HTTP function accepts. Unless the body names
+// an idempotency key, the message ID is used, so a redelivered message does not run the backup
+// twice.
+func decodePubSubBody(e event.Event) (postBodyParams, error) {
+	var pb postBodyParams
+	var msg pubSubEvent
+	if err := e.DataAs(&msg); err != nil {
+		return pb, fmt.Errorf("failed to decode Pub/Sub event: %v", err)
+	}
+	data, err := base64.StdEncoding.DecodeString(msg.Message.Data)
+	if err != nil {
+		return pb, fmt.Errorf("failed to decode Pub/Sub message data: %v", err)
+	}
+	if err := decodeJSON(bytes.NewReader(data), &pb); err != nil {
+		return pb, fmt.Errorf("failed to decode Pub/Sub message data: %v", err)
+	}
+	if pb.IdempotencyKey == "" {
+		pb.IdempotencyKey = msg.Message.MessageID
+	}
+	return pb, nil
+}
+
+// bigQueryBackupPubSub is a CloudEvent function that runs a backup for every message published
+// to a Pub/Sub topic, such as one fed by Cloud Scheduler. The message data is the same JSON as
+// the body of the HTTP function. Malformed messages and invalid requests are logged and
+// acknowledged, since redelivering them cannot succeed; only failures that may be transient
+// return an error so that Pub/Sub retries them when the trigger has retries enabled.
+func bigQueryBackupPubSub(ctx context.Context, e event.Event) error {
+	bp := &backupParams{runID: uuid.NewString(), cache: newMetadataCache()}
+	if err := bp.setProjectID(); err != nil {
+		log.Printf("ERROR: cannot handle Pub/Sub message %s: %v", e.ID(), err)
+		return err
+	}
+	defer func() {
+		_ = bp.flushLogs()
+	}()
+
+	pb, err := decodePubSubBody(e)
+	if err != nil {
+		_ = bp.logError(fmt.Sprintf("Dropping Pub/Sub message %s: %v", e.ID(), err))
+		return nil
+	}
+
+	code, body := bp.serveBackup(ctx, func() error {
+		return bp.setup(ctx, pb)
+	})
+	if code >= http.StatusInternalServerError {
+		return fmt.Errorf("backup failed with status %d: %+v", code, body)
+	}
+	return nil
+}
@@ -0,0 +1,43 @@
+package bigquerybackup
+
+import (
+	"encoding/base64"
+	"testing"
+
+	"github.com/cloudevents/sdk-go/v2/event"
+	"github.com/stretchr/testify/assert"
+)
+
+func newPubSubEvent(t *testing.T, data string) event.Event {
+	e := event.New()
+	e.SetID("event-1")
+	e.SetType("google.cloud.pubsub.topic.v1.messagePublished")
+	e.SetSource("//pubsub.googleapis.com/projects/p/topics/backups")
+	err := e.SetData(event.ApplicationJSON, map[string]interface{}{
+		"message":      map[string]string{"data": data, "messageId": "123"},
+		"subscription": "projects/p/subscriptions/backups",
+	})
+	assert.NoError(t, err)
+	return e
+}
+
+func TestDecodePubSubBody(t *testing.T) {
+	encode := func(s string) string { return base64.StdEncoding.EncodeToString([]byte(s)) }
+
+	pb, err := decodePubSubBody(newPubSubEvent(t, encode(`{"dataset_name":"ds","table_name":"t","storage_bucket":"b"}`)))
+	assert.NoError(t, err)
+	assert.Equal(t, "ds", pb.DatasetName)
+	assert.Equal(t, "t", pb.TableName)
+	assert.Equal(t, "b", pb.StorageBucket)
+	assert.Equal(t, "123", pb.IdempotencyKey, "the message ID is the default idempotency key")
+
+	pb, err = decodePubSubBody(newPubSubEvent(t, encode(`{"dataset_name":"ds","idempotency_key":"nightly"}`)))
+	assert.NoError(t, err)
+	assert.Equal(t, "nightly", pb.IdempotencyKey)
+
+	_, err = decodePubSubBody(newPubSubEvent(t, "not base64!"))
+	assert.Error(t, err)
+
+	_, err = decodePubSubBody(newPubSubEvent(t, encode("not json")))
+	assert.Error(t, err)
+}
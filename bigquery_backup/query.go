@@ -0,0 +1,149 @@
+package bigquerybackup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/bigquery"
+)
+
+const (
+	defaultQueryName     = "query"
+	queryTableExpiration = 24 * time.Hour
+	queryObjectName      = "query.sql"
+)
+
+// queryNamePattern matches the names a query backup may be written under. The name takes the
+// place of the table name in the backup prefix and shard names, so it is kept to the characters
+// table names allow.
+var queryNamePattern = regexp.MustCompile(`^[A-Za-z0-9_]{1,1000}$`)
+
+// checkQuery validates the query fields of the POST body. A query replaces table_name, so the
+// two cannot be combined, and the options that read the source table before the query has run
+// are rejected.
+func checkQuery(pb *postBodyParams) error {
+	if strings.TrimSpace(pb.Query) == "" {
+		if strings.TrimSpace(pb.QueryName) != "" {
+			return errors.New("query_name requires query")
+		}
+		return nil
+	}
+	switch {
+	case pb.TableName != "":
+		return errors.New("exactly one of table_name or query must be given, not both")
+	case pb.Async:
+		return errors.New("query cannot be combined with async")
+	case pb.SnapshotDataset != "":
+		return errors.New("query cannot be combined with snapshot_dataset")
+	case pb.RequireUpdatedWithinHours > 0:
+		return errors.New("query cannot be combined with require_updated_within_hours")
+	case pb.MaxOutputBytes > 0:
+		return errors.New("query cannot be combined with max_output_bytes")
+	case pb.SketchColumn != "":
+		return errors.New("query cannot be combined with sketch_column")
+	case pb.WriteLoadConfig:
+		return errors.New("query cannot be combined with write_load_config")
+	}
+	if name := strings.TrimSpace(pb.QueryName); name != "" && !queryNamePattern.MatchString(name) {
+		return fmt.Errorf("invalid query_name %q, must only contain letters, numbers and underscores", name)
+	}
+	return nil
+}
+
+// isQueryBackup reports whether the backup exports the result of a query instead of a table.
+func (bp *backupParams) isQueryBackup() bool {
+	return bp.query != ""
+}
+
+// queryTableID returns the name of the temporary table the query result is written to. A
+// materialized view and a table read as of snapshot_time get their own name, since a
+// dataset-wide backup may read several of them in one run.
+func (bp *backupParams) queryTableID() string {
+	if bp.materializeView {
+		return "_backup_view_" + bp.backupTableID + "_" + strings.ReplaceAll(bp.runID, "-", "_")
+	}
+	if bp.isPointInTimeBackup() {
+		return "_backup_asof_" + bp.backupTableID + "_" + strings.ReplaceAll(bp.runID, "-", "_")
+	}
+	return "_backup_query_" + strings.ReplaceAll(bp.runID, "-", "_")
+}
+
+// backupQuery returns the query whose result is exported: the query of a query backup, the
+// one materializing a view, or the one reading a table as of snapshot_time. It is empty for
+// other table backups.
+func (bp *backupParams) backupQuery() string {
+	if bp.materializeView {
+		return bp.viewQuery()
+	}
+	if bp.isPointInTimeBackup() {
+		return bp.pointInTimeQuery()
+	}
+	return bp.query
+}
+
+// sourceTableID returns the table the extract job reads: the temporary query result table once
+// the query has run, otherwise the table being backed up.
+func (bp *backupParams) sourceTableID() string {
+	if bp.queryTable != "" {
+		return bp.queryTable
+	}
+	return bp.backupTableID
+}
+
+// runQuery runs the query of a query backup, a view being materialized or a point-in-time
+// backup into a temporary table in the source dataset, which the export then reads. The table
+// is created with an expiration in case the returned cleanup fails to delete it. It does nothing
+// for other table backups.
+func (bp *backupParams) runQuery(ctx context.Context) (cleanup func(), err error) {
+	if bp.backupQuery() == "" {
+		return func() {}, nil
+	}
+	tmp := bc.DatasetInProject(bp.sourceProjectID(), bp.sourceDatasetID).Table(bp.queryTableID())
+	if err := tmp.Create(ctx, &bigquery.TableMetadata{ExpirationTime: time.Now().Add(queryTableExpiration)}); err != nil {
+		return nil, fmt.Errorf("failed to create query table: %v", err)
+	}
+	cleanup = func() {
+		if err := tmp.Delete(context.WithoutCancel(ctx)); err != nil {
+			_ = bp.logError(fmt.Sprintf("Failed to delete query table %s: %v", tmp.TableID, err))
+		}
+	}
+
+	_ = bp.logInfo(fmt.Sprintf("Running query for backup %s.%s into %s", bp.sourceDatasetID, bp.backupTableID, tmp.TableID))
+	q := bc.Query(bp.backupQuery())
+	q.Dst = tmp
+	q.WriteDisposition = bigquery.WriteTruncate
+	q.CreateDisposition = bigquery.CreateNever
+	q.Labels = jobLabels(bp.labels)
+	job, err := q.Run(ctx)
+	if err == nil {
+		var status *bigquery.JobStatus
+		if status, err = job.Wait(ctx); err == nil {
+			err = status.Err()
+		}
+	}
+	if err != nil {
+		cleanup()
+		return nil, fmt.Errorf("query failed: %v", err)
+	}
+	bp.queryTable = tmp.TableID
+	return cleanup, bp.logInfo(fmt.Sprintf("Query for backup %s.%s completed, jobID: %s", bp.sourceDatasetID, bp.backupTableID, job.ID()))
+}
+
+// writeQueryObject writes the query whose result was exported to query.sql in the backup
+// prefix, so the backup records exactly what it captured and can be reproduced. It does nothing
+// for backups that read a table directly.
+func (bp *backupParams) writeQueryObject(ctx context.Context) error {
+	sql := bp.backupQuery()
+	if sql == "" {
+		return nil
+	}
+	name, err := bp.putObject(ctx, bp.outputPrefix()+"/"+queryObjectName, "application/sql", []byte(sql))
+	if err != nil {
+		return err
+	}
+	return bp.logInfo(fmt.Sprintf("Wrote query to gs://%s/%s", bp.storageBucket, name))
+}
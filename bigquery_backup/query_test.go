@@ -0,0 +1,76 @@
+package bigquerybackup
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckQuery(t *testing.T) {
+	tests := []struct {
+		name    string
+		pb      postBodyParams
+		wantErr bool
+	}{
+		{name: "Table backup", pb: postBodyParams{TableName: "t"}},
+		{name: "Dataset backup", pb: postBodyParams{}},
+		{name: "Query", pb: postBodyParams{Query: "SELECT * FROM ds.t WHERE day > '2024-01-01'"}},
+		{name: "Named query", pb: postBodyParams{Query: "SELECT 1", QueryName: "recent_orders"}},
+		{name: "Table and query", pb: postBodyParams{TableName: "t", Query: "SELECT 1"}, wantErr: true},
+		{name: "Name without query", pb: postBodyParams{TableName: "t", QueryName: "recent"}, wantErr: true},
+		{name: "Invalid name", pb: postBodyParams{Query: "SELECT 1", QueryName: "recent/orders"}, wantErr: true},
+		{name: "Async", pb: postBodyParams{Query: "SELECT 1", Async: true}, wantErr: true},
+		{name: "Snapshot", pb: postBodyParams{Query: "SELECT 1", SnapshotDataset: "snaps"}, wantErr: true},
+		{name: "Freshness", pb: postBodyParams{Query: "SELECT 1", RequireUpdatedWithinHours: 24}, wantErr: true},
+		{name: "Output cap", pb: postBodyParams{Query: "SELECT 1", MaxOutputBytes: 1 << 30}, wantErr: true},
+		{name: "Sketch", pb: postBodyParams{Query: "SELECT 1", SketchColumn: "id"}, wantErr: true},
+		{name: "Load config", pb: postBodyParams{Query: "SELECT 1", WriteLoadConfig: true}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.wantErr, checkQuery(&tt.pb) != nil)
+		})
+	}
+}
+
+func TestSetBackupParamsQuery(t *testing.T) {
+	bp := &backupParams{}
+	bp.setBackupParams(postBodyParams{DatasetName: "ds", StorageBucket: "b", Query: " SELECT 1 "})
+	assert.True(t, bp.isQueryBackup())
+	assert.False(t, bp.isDatasetBackup())
+	assert.Equal(t, "SELECT 1", bp.query)
+	assert.Equal(t, defaultQueryName, bp.backupTableID)
+
+	bp.setBackupParams(postBodyParams{DatasetName: "ds", StorageBucket: "b", Query: "SELECT 1", QueryName: "recent"})
+	assert.Equal(t, "recent", bp.backupTableID)
+
+	bp.setBackupParams(postBodyParams{DatasetName: "ds", StorageBucket: "b", TableName: "t"})
+	assert.False(t, bp.isQueryBackup())
+	assert.Equal(t, "t", bp.backupTableID)
+}
+
+func TestQueryBackupExtractsResultTable(t *testing.T) {
+	bp := &backupParams{
+		projectID:         "test-project",
+		runID:             "1234-abcd",
+		sourceDatasetID:   "ds",
+		backupTableID:     "recent",
+		storageBucket:     "b",
+		destinationFormat: avroFormat,
+		query:             "SELECT 1",
+		backupTime:        time.Date(2024, 1, 15, 10, 0, 0, 0, time.UTC),
+	}
+	assert.Equal(t, "_backup_query_1234_abcd", bp.queryTableID())
+	assert.Equal(t, "recent", bp.sourceTableID())
+
+	bp.queryTable = bp.queryTableID()
+	assert.Equal(t, "_backup_query_1234_abcd", bp.sourceTableID())
+
+	assert.NoError(t, bp.setBigQueryClient(context.Background()))
+	extractor := setupExtractor(bp)
+	assert.Equal(t, "_backup_query_1234_abcd", extractor.Src.TableID)
+	assert.Equal(t, []string{"gs://b/ds/recent.20240115T100000Z/recent-*.avro"}, extractor.Dst.URIs)
+}
@@ -0,0 +1,99 @@
+package bigquerybackup
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// defaultCompressionRatios estimates the size of an export relative to the table's logical
+// NumBytes for each FORMAT/COMPRESSION pair. They are deliberately rough; override them with
+// the COMPRESSION_RATIOS environment variable when your data compresses differently.
+var defaultCompressionRatios = map[string]float64{
+	"CSV/NONE":       1.0,
+	"CSV/GZIP":       0.25,
+	"JSON/NONE":      1.5,
+	"JSON/GZIP":      0.3,
+	"AVRO/NONE":      1.0,
+	"AVRO/DEFLATE":   0.35,
+	"AVRO/SNAPPY":    0.5,
+	"PARQUET/NONE":   0.8,
+	"PARQUET/GZIP":   0.3,
+	"PARQUET/SNAPPY": 0.4,
+	"PARQUET/ZSTD":   0.3,
+}
+
+// compressionRatios returns the built-in ratio estimates merged with any overrides from the
+// COMPRESSION_RATIOS environment variable, a JSON object such as {"PARQUET/SNAPPY": 0.2}.
+func compressionRatios() (map[string]float64, error) {
+	ratios := make(map[string]float64, len(defaultCompressionRatios))
+	for k, v := range defaultCompressionRatios {
+		ratios[k] = v
+	}
+	raw := strings.TrimSpace(os.Getenv("COMPRESSION_RATIOS"))
+	if raw == "" {
+		return ratios, nil
+	}
+	var overrides map[string]float64
+	if err := json.Unmarshal([]byte(raw), &overrides); err != nil {
+		return nil, fmt.Errorf("invalid COMPRESSION_RATIOS: %v", err)
+	}
+	for k, v := range overrides {
+		if v <= 0 {
+			return nil, fmt.Errorf("invalid COMPRESSION_RATIOS: ratio for %s must be positive", k)
+		}
+		ratios[strings.ToUpper(k)] = v
+	}
+	return ratios, nil
+}
+
+// estimateOutputBytes predicts the size of an export of numBytes logical bytes in the given
+// format and compression. Pairs without an estimate are assumed not to compress at all.
+func estimateOutputBytes(numBytes int64, format, compression string, ratios map[string]float64) int64 {
+	if compression == "" {
+		compression = "NONE"
+	}
+	ratio, ok := ratios[strings.ToUpper(format)+"/"+strings.ToUpper(compression)]
+	if !ok {
+		ratio = 1.0
+	}
+	return int64(float64(numBytes) * ratio)
+}
+
+// checkMaxOutputBytes validates the max_output_bytes field of the POST body.
+func checkMaxOutputBytes(pb *postBodyParams) error {
+	if pb.MaxOutputBytes < 0 {
+		return errors.New("max_output_bytes must not be negative")
+	}
+	return nil
+}
+
+// checkOutputQuota rejects the backup before it runs when the estimated output size is larger
+// than the max_output_bytes requested in the POST body. A partition backup is estimated from
+// the partition's size rather than the whole table's. It returns true when no cap is set or
+// the estimate fits within it.
+func (bp *backupParams) checkOutputQuota(ctx context.Context) (bool, error) {
+	if bp.maxOutputBytes <= 0 {
+		return true, nil
+	}
+	size, err := bp.sourceBytes(ctx)
+	if err != nil {
+		return false, err
+	}
+	ratios, err := compressionRatios()
+	if err != nil {
+		return false, err
+	}
+	estimate := estimateOutputBytes(size, bp.destinationFormat, bp.compressionType, ratios)
+	if estimate > bp.maxOutputBytes {
+		return false, fmt.Errorf("estimated output of %d bytes for table %s.%s exceeds max_output_bytes %d", estimate, bp.sourceDatasetID, bp.backupTableID, bp.maxOutputBytes)
+	}
+	err = bp.logInfo(fmt.Sprintf("Estimated output of %d bytes is within max_output_bytes %d", estimate, bp.maxOutputBytes))
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
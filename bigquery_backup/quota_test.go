@@ -0,0 +1,99 @@
+package bigquerybackup
+
+import (
+	"context"
+	"testing"
+
+	"cloud.google.com/go/bigquery"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckMaxOutputBytes(t *testing.T) {
+	assert.NoError(t, checkMaxOutputBytes(&postBodyParams{}))
+	assert.NoError(t, checkMaxOutputBytes(&postBodyParams{MaxOutputBytes: 10 << 30}))
+	assert.EqualError(t, checkMaxOutputBytes(&postBodyParams{MaxOutputBytes: -1}), "max_output_bytes must not be negative")
+
+	bp := &backupParams{projectID: "test-project"}
+	ok, err := bp.checkPostBody(&postBodyParams{DatasetName: "ds", TableName: "t", StorageBucket: "backups", MaxOutputBytes: -1})
+	assert.False(t, ok)
+	assert.EqualError(t, err, "max_output_bytes must not be negative")
+}
+
+func TestEstimateOutputBytes(t *testing.T) {
+	ratios := map[string]float64{"PARQUET/SNAPPY": 0.5}
+
+	tests := []struct {
+		name        string
+		format      string
+		compression string
+		want        int64
+	}{
+		{name: "Known pair", format: "PARQUET", compression: "SNAPPY", want: 500},
+		{name: "Lowercase pair", format: "parquet", compression: "snappy", want: 500},
+		{name: "Unknown pair", format: "CSV", compression: "GZIP", want: 1000},
+		{name: "Empty compression", format: "CSV", compression: "", want: 1000},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, estimateOutputBytes(1000, tt.format, tt.compression, ratios))
+		})
+	}
+}
+
+func TestCompressionRatiosOverride(t *testing.T) {
+	t.Setenv("COMPRESSION_RATIOS", `{"parquet/snappy": 0.1}`)
+	ratios, err := compressionRatios()
+	assert.NoError(t, err)
+	assert.Equal(t, 0.1, ratios["PARQUET/SNAPPY"])
+	assert.Equal(t, defaultCompressionRatios["CSV/GZIP"], ratios["CSV/GZIP"])
+
+	t.Setenv("COMPRESSION_RATIOS", `{"CSV/GZIP": -1}`)
+	_, err = compressionRatios()
+	assert.Error(t, err)
+
+	t.Setenv("COMPRESSION_RATIOS", `not json`)
+	_, err = compressionRatios()
+	assert.Error(t, err)
+}
+
+func TestCheckOutputQuotaWithoutCap(t *testing.T) {
+	bp := &backupParams{}
+	ok, err := bp.checkOutputQuota(context.Background())
+	assert.True(t, ok)
+	assert.NoError(t, err)
+}
+
+func TestCheckOutputQuotaPartition(t *testing.T) {
+	t.Setenv("COMPRESSION_RATIOS", "")
+	orig := queryPartitionStats
+	defer func() { queryPartitionStats = orig }()
+	queryPartitionStats = func(ctx context.Context, projectID, datasetID, tableID, partition string) (partitionStats, error) {
+		assert.Equal(t, "20240115", partition)
+		return partitionStats{rows: 10, bytes: 1000}, nil
+	}
+
+	tests := []struct {
+		name      string
+		partition string
+		wantOK    bool
+	}{
+		{name: "Whole table", wantOK: false},
+		{name: "Partition", partition: "20240115", wantOK: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bp := &backupParams{projectID: "p", sourceDatasetID: "ds", backupTableID: "t", partition: tt.partition, destinationFormat: avroFormat, compressionType: snappyCompression, maxOutputBytes: 1 << 20, cache: newMetadataCache()}
+			_, _ = bp.cache.table("p:ds.t", func() (*bigquery.TableMetadata, error) {
+				return &bigquery.TableMetadata{NumRows: 1000000, NumBytes: 1 << 40}, nil
+			})
+			ok, err := bp.checkOutputQuota(context.Background())
+			assert.Equal(t, tt.wantOK, ok)
+			if tt.wantOK {
+				assert.NoError(t, err)
+			} else {
+				assert.ErrorContains(t, err, "exceeds max_output_bytes")
+			}
+		})
+	}
+}
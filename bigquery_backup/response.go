@@ -0,0 +1,89 @@
+package bigquerybackup
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// runIDHeader is the response header carrying the run ID of a backup request, which is also
+// attached to every log entry of the request.
+const runIDHeader = "X-Run-ID"
+
+// errorResponse is the body of every failed request. Its shape is stable so that callers can
+// parse it: Error is a human-readable message and Code repeats the HTTP status code. RunID is
+// set once the request has been assigned one.
+type errorResponse struct {
+	Error string `json:"error"`
+	Code  int    `json:"code"`
+	RunID string `json:"run_id,omitempty"`
+}
+
+// successResponse is the body of a request whose backup ran to completion. PartialSuccess is set
+// when the backup succeeded but could not be completely copied to the secondary bucket, whose
+// copy is described by Secondary. Result describes the extract job when this request ran it.
+// DryRun marks a dry run, which only checked the backup and reports where it would be written.
+// OversizedFiles lists the files larger than max_file_bytes, and SignedURLs the URLs requested
+// with generate_signed_urls. Warnings repeats the warnings logged about the request, such as a
+// CSV backup of nested columns allowed with nested_csv. Export names the format and compression
+// the backup was written with, and the compression the request asked for.
+type successResponse struct {
+	Status         string          `json:"status"`
+	JobID          string          `json:"job_id"`
+	Location       string          `json:"location,omitempty"`
+	RunID          string          `json:"run_id"`
+	DestinationURI string          `json:"destination_uri"`
+	FileCount      int64           `json:"file_count,omitempty"`
+	PartialSuccess bool            `json:"partial_success,omitempty"`
+	Secondary      *mirrorResult   `json:"secondary,omitempty"`
+	OversizedFiles []oversizedFile `json:"oversized_files,omitempty"`
+	SignedURLs     []signedURL     `json:"signed_urls,omitempty"`
+	Export         *exportFormat   `json:"export,omitempty"`
+	Warnings       []string        `json:"warnings,omitempty"`
+	Result         *BackupResult   `json:"result,omitempty"`
+	DryRun         bool            `json:"dry_run,omitempty"`
+}
+
+// requestError is an error that carries the HTTP status code the request should fail with.
+type requestError struct {
+	code int
+	err  error
+}
+
+func (e *requestError) Error() string {
+	return e.err.Error()
+}
+
+func (e *requestError) Unwrap() error {
+	return e.err
+}
+
+// withStatus annotates err with the HTTP status code it should be reported as.
+func withStatus(code int, err error) error {
+	return &requestError{code: code, err: err}
+}
+
+// statusCode returns the HTTP status code for err, defaulting to 500 for errors that were not
+// annotated with withStatus.
+func statusCode(err error) int {
+	if rerr, ok := err.(*requestError); ok {
+		return rerr.code
+	}
+	return http.StatusInternalServerError
+}
+
+// writeJSON writes v as the JSON body of the response with the given status code.
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// errorResponse returns the error body for a failed backup request, carrying its run ID.
+func (bp *backupParams) errorResponse(code int, msg string) errorResponse {
+	return errorResponse{Error: msg, Code: code, RunID: bp.runID}
+}
+
+// writeError answers the request with the stable JSON error shape.
+func writeError(w http.ResponseWriter, code int, msg string) {
+	writeJSON(w, code, errorResponse{Error: msg, Code: code})
+}
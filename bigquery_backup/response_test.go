@@ -0,0 +1,39 @@
+package bigquerybackup
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStatusCode(t *testing.T) {
+	assert.Equal(t, http.StatusNotFound, statusCode(withStatus(http.StatusNotFound, errors.New("missing"))))
+	assert.Equal(t, http.StatusInternalServerError, statusCode(errors.New("boom")))
+	assert.Equal(t, "missing", withStatus(http.StatusNotFound, errors.New("missing")).Error())
+}
+
+func TestWriteError(t *testing.T) {
+	rec := httptest.NewRecorder()
+	writeError(rec, http.StatusBadRequest, "missing dataset_name in POST body")
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+	assert.JSONEq(t, `{"error":"missing dataset_name in POST body","code":400}`, rec.Body.String())
+}
+
+func TestWriteJSONSuccess(t *testing.T) {
+	rec := httptest.NewRecorder()
+	writeJSON(rec, http.StatusOK, successResponse{Status: "ok", JobID: "job_1", RunID: "run-1", DestinationURI: "gs://b/ds/t.20240115T100000Z/t-*.avro"})
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, fmt.Sprintf(`{"status":"ok","job_id":"job_1","run_id":"run-1","destination_uri":%q}`, "gs://b/ds/t.20240115T100000Z/t-*.avro"), rec.Body.String())
+}
+
+func TestBackupErrorResponse(t *testing.T) {
+	bp := &backupParams{runID: "run-1"}
+	rec := httptest.NewRecorder()
+	writeJSON(rec, http.StatusNotFound, bp.errorResponse(http.StatusNotFound, "table not found"))
+	assert.JSONEq(t, `{"error":"table not found","code":404,"run_id":"run-1"}`, rec.Body.String())
+}
@@ -0,0 +1,272 @@
+package bigquerybackup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/bigquery"
+	"cloud.google.com/go/storage"
+	"github.com/GoogleCloudPlatform/functions-framework-go/functions"
+	"github.com/google/uuid"
+)
+
+func init() {
+	functions.HTTP("BigQueryRestore", trackHTTP(bigQueryRestore))
+}
+
+// restoreParams holds a request to load a backup back into BigQuery. It embeds backupParams for
+// the project, logging and metadata cache, with sourceDatasetID and backupTableID naming the
+// table being restored into.
+type restoreParams struct {
+	*backupParams
+	sourcePath       string
+	sourceFormat     string
+	writeDisposition bigquery.TableWriteDisposition
+	schemaPath       string
+	schema           bigquery.Schema
+	layout           tableLayout
+}
+
+type restoreBodyParams struct {
+	StorageBucket    string `json:"storage_bucket"`
+	SourcePath       string `json:"source_path"`
+	DatasetName      string `json:"dataset_name"`
+	TableName        string `json:"table_name"`
+	SourceFormat     string `json:"source_format"`
+	WriteDisposition string `json:"write_disposition"`
+	SchemaPath       string `json:"schema_path"`
+}
+
+// restoreResponse is the body returned once a restore load job completes.
+type restoreResponse struct {
+	Status string `json:"status"`
+	JobID  string `json:"job_id"`
+}
+
+// bigQueryRestore is an HTTP function that loads a backup from cloud storage into a BigQuery
+// table. It validates the request and that the destination dataset exists, runs a load job
+// from the backup objects and responds with the load job ID once the job has completed.
+func bigQueryRestore(w http.ResponseWriter, r *http.Request) {
+	rp := restoreParams{backupParams: &backupParams{runID: uuid.NewString(), cache: newMetadataCache()}}
+	if err := rp.setProjectID(); err != nil {
+		rejectMissingProject(w, err)
+		return
+	}
+	ctx, cancel := requestContext(r)
+	defer cancel()
+	defer func() {
+		_ = rp.flushLogs()
+	}()
+	if !rp.authorize(w, r) {
+		return
+	}
+
+	if err := rp.setBigQueryClient(ctx); err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	var body restoreBodyParams
+	if err := decodeRequestBody(w, r, &body); err != nil {
+		_ = rp.logError(fmt.Sprintf("Failed to decode POST body: %v", err))
+		writeError(w, bodyErrorStatus(err), fmt.Sprintf("failed to decode POST body: %v", err))
+		return
+	}
+	if err := rp.setRestoreParams(body); err != nil {
+		_ = rp.logError(fmt.Sprintf("Invalid POST body: %v", err))
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid POST body: %v", err))
+		return
+	}
+
+	if err := rp.loadSchema(ctx); err != nil {
+		_ = rp.logError(fmt.Sprintf("Problem reading schema: %v", err))
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("problem reading schema: %v", err))
+		return
+	}
+
+	if ok, err := rp.validateDataset(ctx); !ok || err != nil {
+		err = lookupError("destination dataset "+rp.sourceDatasetID, err)
+		_ = rp.logError(fmt.Sprintf("Invalid destination dataset: %v", err))
+		writeError(w, statusCode(err), err.Error())
+		return
+	}
+
+	jobID, err := rp.restoreTable(ctx)
+	if err != nil {
+		_ = rp.logError(fmt.Sprintf("Problem restoring BigQuery table: %v", err))
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("problem restoring BigQuery table: %v", err))
+		return
+	}
+	writeJSON(w, http.StatusOK, restoreResponse{Status: "ok", JobID: jobID})
+}
+
+// setRestoreParams validates the POST body and copies it into the restore parameters. The
+// source format defaults to AVRO and the write disposition to WRITE_EMPTY, so a restore never
+// overwrites or appends to an existing table unless asked to.
+func (rp *restoreParams) setRestoreParams(body restoreBodyParams) error {
+	switch {
+	case strings.TrimSpace(body.StorageBucket) == "":
+		return errors.New("missing storage_bucket")
+	case strings.TrimSpace(body.SourcePath) == "":
+		return errors.New("missing source_path")
+	case strings.TrimSpace(body.DatasetName) == "":
+		return errors.New("missing dataset_name")
+	case strings.TrimSpace(body.TableName) == "":
+		return errors.New("missing table_name")
+	}
+
+	if err := validateBucketName(strings.TrimSpace(body.StorageBucket)); err != nil {
+		return err
+	}
+
+	format := strings.ToUpper(strings.TrimSpace(body.SourceFormat))
+	switch format {
+	case "":
+		format = avroFormat
+	case csvFormat, jsonFormat, avroFormat, parquetFormat:
+	default:
+		return fmt.Errorf("unsupported source_format %q, must be one of %s, %s, %s or %s", body.SourceFormat, csvFormat, jsonFormat, avroFormat, parquetFormat)
+	}
+
+	disposition := bigquery.TableWriteDisposition(strings.ToUpper(strings.TrimSpace(body.WriteDisposition)))
+	switch disposition {
+	case "":
+		disposition = bigquery.WriteEmpty
+	case bigquery.WriteTruncate, bigquery.WriteAppend, bigquery.WriteEmpty:
+	default:
+		return fmt.Errorf("unsupported write_disposition %q, must be one of %s, %s or %s", body.WriteDisposition, bigquery.WriteTruncate, bigquery.WriteAppend, bigquery.WriteEmpty)
+	}
+
+	rp.storageBucket = strings.TrimSpace(body.StorageBucket)
+	rp.sourcePath = strings.TrimPrefix(strings.TrimSpace(body.SourcePath), "/")
+	rp.sourceDatasetID = strings.TrimSpace(body.DatasetName)
+	rp.backupTableID = strings.TrimSpace(body.TableName)
+	rp.sourceFormat = format
+	rp.writeDisposition = disposition
+	rp.schemaPath = strings.TrimPrefix(strings.TrimSpace(body.SchemaPath), "/")
+	return nil
+}
+
+// schemaObject returns the object the restore reads its schema from, and whether it must exist.
+// An explicit schema_path must exist; otherwise a backup prefix is checked for the schema.json
+// written by the backup. Avro and Parquet backups carry a schema of their own, but nested and
+// repeated fields do not always load back as the types they were exported from, so the saved
+// schema is used for them too.
+func (rp *restoreParams) schemaObject() (string, bool) {
+	if rp.schemaPath != "" {
+		return rp.schemaPath, true
+	}
+	if strings.HasSuffix(rp.sourcePath, "/") {
+		return rp.sourcePath + schemaObjectName, false
+	}
+	return "", false
+}
+
+// loadSchema reads the schema of the backup from the bucket, see schemaObject. A backup without
+// one is restored with a detected schema, which is logged as a warning.
+func (rp *restoreParams) loadSchema(ctx context.Context) error {
+	name, required := rp.schemaObject()
+	if name == "" {
+		return nil
+	}
+	c, err := sharedStorageClient()
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := withStorageTimeout(ctx)
+	defer cancel()
+	r, err := c.Bucket(rp.storageBucket).Object(name).NewReader(ctx)
+	if err == storage.ErrObjectNotExist && !required {
+		return rp.logWarning(fmt.Sprintf("No schema found at gs://%s/%s, detecting it instead, so column types may differ from the backed up table", rp.storageBucket, name))
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read gs://%s/%s: %v", rp.storageBucket, name, err)
+	}
+	defer r.Close()
+	if rp.schema, rp.layout, err = readSchema(r); err != nil {
+		return fmt.Errorf("invalid schema in gs://%s/%s: %v", rp.storageBucket, name, err)
+	}
+	return rp.logInfo(fmt.Sprintf("Restoring with schema from gs://%s/%s", rp.storageBucket, name))
+}
+
+// sourceURI returns the GCS URI the backup is loaded from. A source_path ending in "/" is a
+// backup prefix and loads every shard of the source format under it, leaving out side files
+// such as schema.json; otherwise the path is used as given and may contain its own wildcard.
+func (rp *restoreParams) sourceURI() string {
+	path := rp.sourcePath
+	if strings.HasSuffix(path, "/") {
+		path += shardPattern(path) + "." + strings.ToLower(rp.sourceFormat)
+	}
+	return fmt.Sprintf("gs://%s/%s", rp.storageBucket, path)
+}
+
+// shardPattern returns the wildcard matching the shards under a backup prefix. The shards of a
+// backup under "dataset/table.20060102T150405Z/", of a partition under
+// "dataset/table$20240115.20060102T150405Z/" or of a point-in-time backup under
+// "dataset/table@20240115T080000Z.20060102T150405Z/" are named "table-<n>"; any other prefix
+// matches every object.
+func shardPattern(prefix string) string {
+	backup := path.Base(strings.TrimSuffix(prefix, "/"))
+	if i := strings.LastIndex(backup, "."); i > 0 {
+		if _, err := time.Parse(backupTimeFormat, backup[i+1:]); err == nil {
+			table, _ := splitBackupName(backup[:i])
+			return table + "-*"
+		}
+	}
+	return "*"
+}
+
+// setupLoader creates the load job configuration for the restore. The schema saved with the
+// backup is applied whatever the format. Without one, CSV and JSON schemas are detected unless
+// the rows are appended to an existing table, while Avro and Parquet are loaded with the schema
+// of their files; Parquet lists are then inferred as repeated fields, which is how BigQuery
+// exported them, rather than as records wrapping a list. A table created by the restore gets the
+// partitioning and clustering saved with the schema; rows appended to an existing table keep its
+// own.
+func (rp *restoreParams) setupLoader() *bigquery.Loader {
+	gcsRef := bigquery.NewGCSReference(rp.sourceURI())
+	gcsRef.SourceFormat = bigQueryDataFormat(rp.sourceFormat)
+	switch {
+	case rp.schema != nil:
+		gcsRef.Schema = rp.schema
+	case rp.sourceFormat == csvFormat || rp.sourceFormat == jsonFormat:
+		gcsRef.AutoDetect = rp.writeDisposition != bigquery.WriteAppend
+	}
+	if rp.sourceFormat == parquetFormat {
+		gcsRef.ParquetOptions = &bigquery.ParquetOptions{EnableListInference: true}
+	}
+	loader := bc.DatasetInProject(rp.projectID, rp.sourceDatasetID).Table(rp.backupTableID).LoaderFrom(gcsRef)
+	loader.WriteDisposition = rp.writeDisposition
+	loader.CreateDisposition = bigquery.CreateIfNeeded
+	if rp.writeDisposition != bigquery.WriteAppend {
+		loader.TimePartitioning = rp.layout.timePartitioning
+		loader.RangePartitioning = rp.layout.rangePartitioning
+		loader.Clustering = rp.layout.clustering
+	}
+	return loader
+}
+
+// restoreTable runs the load job and waits for it to complete, returning its job ID.
+func (rp *restoreParams) restoreTable(ctx context.Context) (string, error) {
+	_ = rp.logInfo(fmt.Sprintf("Starting restore of %s into table %s.%s", rp.sourceURI(), rp.sourceDatasetID, rp.backupTableID))
+	job, err := rp.setupLoader().Run(ctx)
+	if err != nil {
+		return "", err
+	}
+	_ = rp.logInfo(fmt.Sprintf("Restore of table %s.%s started successfully, jobID: %s", rp.sourceDatasetID, rp.backupTableID, job.ID()))
+	status, err := job.Wait(ctx)
+	if err != nil {
+		return job.ID(), err
+	}
+	if status.Err() != nil {
+		return job.ID(), status.Err()
+	}
+	_ = rp.logInfo(fmt.Sprintf("Restore of table %s.%s completed successfully", rp.sourceDatasetID, rp.backupTableID))
+	return job.ID(), nil
+}
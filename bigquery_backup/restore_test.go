@@ -0,0 +1,200 @@
+package bigquerybackup
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"cloud.google.com/go/bigquery"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSetRestoreParams(t *testing.T) {
+	valid := restoreBodyParams{StorageBucket: "backups", SourcePath: "ds/t.20240115T100000Z/", DatasetName: "ds", TableName: "t"}
+	tests := []struct {
+		name            string
+		modify          func(*restoreBodyParams)
+		wantErr         bool
+		wantFormat      string
+		wantDisposition bigquery.TableWriteDisposition
+	}{
+		{name: "Defaults", modify: func(*restoreBodyParams) {}, wantFormat: avroFormat, wantDisposition: bigquery.WriteEmpty},
+		{name: "Parquet truncate", modify: func(b *restoreBodyParams) {
+			b.SourceFormat = "parquet"
+			b.WriteDisposition = "write_truncate"
+		}, wantFormat: parquetFormat, wantDisposition: bigquery.WriteTruncate},
+		{name: "CSV append", modify: func(b *restoreBodyParams) {
+			b.SourceFormat = "CSV"
+			b.WriteDisposition = "WRITE_APPEND"
+		}, wantFormat: csvFormat, wantDisposition: bigquery.WriteAppend},
+		{name: "Missing bucket", modify: func(b *restoreBodyParams) { b.StorageBucket = "" }, wantErr: true},
+		{name: "Missing path", modify: func(b *restoreBodyParams) { b.SourcePath = " " }, wantErr: true},
+		{name: "Missing dataset", modify: func(b *restoreBodyParams) { b.DatasetName = "" }, wantErr: true},
+		{name: "Missing table", modify: func(b *restoreBodyParams) { b.TableName = "" }, wantErr: true},
+		{name: "Unknown format", modify: func(b *restoreBodyParams) { b.SourceFormat = "ORC" }, wantErr: true},
+		{name: "Unknown disposition", modify: func(b *restoreBodyParams) { b.WriteDisposition = "WRITE_MERGE" }, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := valid
+			tt.modify(&body)
+			rp := &restoreParams{backupParams: &backupParams{}}
+			err := rp.setRestoreParams(body)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.wantFormat, rp.sourceFormat)
+			assert.Equal(t, tt.wantDisposition, rp.writeDisposition)
+		})
+	}
+}
+
+func TestRestoreSourceURI(t *testing.T) {
+	rp := &restoreParams{backupParams: &backupParams{storageBucket: "backups"}, sourceFormat: parquetFormat}
+
+	rp.sourcePath = "ds/t.20240115T100000Z/"
+	assert.Equal(t, "gs://backups/ds/t.20240115T100000Z/t-*.parquet", rp.sourceURI())
+
+	rp.sourcePath = "ds/t@20240115T080000Z.20240116T020000Z/"
+	assert.Equal(t, "gs://backups/ds/t@20240115T080000Z.20240116T020000Z/t-*.parquet", rp.sourceURI())
+
+	rp.sourcePath = "exports/"
+	assert.Equal(t, "gs://backups/exports/*.parquet", rp.sourceURI())
+
+	rp.sourcePath = "ds/t.20240115T100000Z/t-*.parquet"
+	assert.Equal(t, "gs://backups/ds/t.20240115T100000Z/t-*.parquet", rp.sourceURI())
+}
+
+func TestSetupLoader(t *testing.T) {
+	rp := &restoreParams{backupParams: &backupParams{projectID: "test-project"}}
+	assert.NoError(t, rp.setBigQueryClient(context.Background()))
+	assert.NoError(t, rp.setRestoreParams(restoreBodyParams{StorageBucket: "backups", SourcePath: "ds/t.20240115T100000Z/", DatasetName: "ds", TableName: "t", SourceFormat: "JSON"}))
+
+	loader := rp.setupLoader()
+	assert.Equal(t, bigquery.WriteEmpty, loader.WriteDisposition)
+	assert.Equal(t, "t", loader.Dst.TableID)
+	gcsRef, ok := loader.Src.(*bigquery.GCSReference)
+	assert.True(t, ok)
+	assert.Equal(t, []string{"gs://backups/ds/t.20240115T100000Z/t-*.json"}, gcsRef.URIs)
+	assert.Equal(t, bigquery.JSON, gcsRef.SourceFormat)
+	assert.True(t, gcsRef.AutoDetect)
+}
+
+func TestRestoreSchemaObject(t *testing.T) {
+	tests := []struct {
+		name         string
+		body         restoreBodyParams
+		wantName     string
+		wantRequired bool
+	}{
+		{name: "CSV backup prefix", body: restoreBodyParams{SourcePath: "ds/t.20240115T100000Z/", SourceFormat: "CSV"}, wantName: "ds/t.20240115T100000Z/schema.json"},
+		{name: "Explicit schema path", body: restoreBodyParams{SourcePath: "ds/t.20240115T100000Z/t-*.json", SourceFormat: "JSON", SchemaPath: "/schemas/t.json"}, wantName: "schemas/t.json", wantRequired: true},
+		{name: "Single object", body: restoreBodyParams{SourcePath: "ds/t.20240115T100000Z/t-000000000000.csv", SourceFormat: "CSV"}},
+		{name: "Avro backup prefix", body: restoreBodyParams{SourcePath: "ds/t.20240115T100000Z/"}, wantName: "ds/t.20240115T100000Z/schema.json"},
+		{name: "Parquet schema path", body: restoreBodyParams{SourcePath: "ds/t.20240115T100000Z/", SourceFormat: "PARQUET", SchemaPath: "schemas/t.json"}, wantName: "schemas/t.json", wantRequired: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := tt.body
+			body.StorageBucket, body.DatasetName, body.TableName = "backups", "ds", "t"
+			rp := &restoreParams{backupParams: &backupParams{}}
+			assert.NoError(t, rp.setRestoreParams(body))
+			name, required := rp.schemaObject()
+			assert.Equal(t, tt.wantName, name)
+			assert.Equal(t, tt.wantRequired, required)
+		})
+	}
+}
+
+func TestSetupLoaderWithSchema(t *testing.T) {
+	rp := &restoreParams{backupParams: &backupParams{projectID: "test-project"}}
+	assert.NoError(t, rp.setBigQueryClient(context.Background()))
+	assert.NoError(t, rp.setRestoreParams(restoreBodyParams{StorageBucket: "backups", SourcePath: "ds/t.20240115T100000Z/", DatasetName: "ds", TableName: "t", SourceFormat: "CSV"}))
+	rp.schema = bigquery.Schema{{Name: "id", Type: bigquery.IntegerFieldType, Required: true}}
+
+	gcsRef, ok := rp.setupLoader().Src.(*bigquery.GCSReference)
+	assert.True(t, ok)
+	assert.False(t, gcsRef.AutoDetect)
+	assert.Equal(t, rp.schema, gcsRef.Schema)
+}
+
+func TestRestoreNestedSchemaRoundTrip(t *testing.T) {
+	table := bigquery.Schema{
+		{Name: "id", Type: bigquery.IntegerFieldType, Required: true},
+		{Name: "items", Type: bigquery.RecordFieldType, Repeated: true, Schema: bigquery.Schema{
+			{Name: "sku", Type: bigquery.StringFieldType},
+			{Name: "tags", Type: bigquery.StringFieldType, Repeated: true},
+			{Name: "price", Type: bigquery.NumericFieldType, Precision: 10, Scale: 2},
+		}},
+		{Name: "shipped_at", Type: bigquery.TimestampFieldType},
+	}
+	// A schema.json holding only the fields, as bq show --schema prints it.
+	saved, err := table.ToJSONFields()
+	assert.NoError(t, err)
+
+	for _, format := range []string{avroFormat, parquetFormat} {
+		t.Run(format, func(t *testing.T) {
+			rp := &restoreParams{backupParams: &backupParams{projectID: "test-project"}}
+			assert.NoError(t, rp.setBigQueryClient(context.Background()))
+			assert.NoError(t, rp.setRestoreParams(restoreBodyParams{StorageBucket: "backups", SourcePath: "ds/t.20240115T100000Z/", DatasetName: "ds", TableName: "t", SourceFormat: format}))
+			rp.schema, rp.layout, err = readSchema(bytes.NewReader(saved))
+			assert.NoError(t, err)
+
+			gcsRef, ok := rp.setupLoader().Src.(*bigquery.GCSReference)
+			assert.True(t, ok)
+			assert.False(t, gcsRef.AutoDetect)
+			assert.Equal(t, table, gcsRef.Schema)
+		})
+	}
+}
+
+func TestSetupLoaderTableLayout(t *testing.T) {
+	layout := tableLayout{
+		timePartitioning: &bigquery.TimePartitioning{Type: bigquery.DayPartitioningType, Field: "created"},
+		clustering:       &bigquery.Clustering{Fields: []string{"customer"}},
+	}
+	tests := []struct {
+		name        string
+		disposition string
+		wantLayout  bool
+	}{
+		{name: "New table", wantLayout: true},
+		{name: "Truncate", disposition: "WRITE_TRUNCATE", wantLayout: true},
+		{name: "Append keeps the existing table's layout", disposition: "WRITE_APPEND"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rp := &restoreParams{backupParams: &backupParams{projectID: "test-project"}}
+			assert.NoError(t, rp.setBigQueryClient(context.Background()))
+			assert.NoError(t, rp.setRestoreParams(restoreBodyParams{StorageBucket: "backups", SourcePath: "ds/t.20240115T100000Z/", DatasetName: "ds", TableName: "t", WriteDisposition: tt.disposition}))
+			rp.layout = layout
+
+			loader := rp.setupLoader()
+			if tt.wantLayout {
+				assert.Equal(t, layout.timePartitioning, loader.TimePartitioning)
+				assert.Equal(t, layout.clustering, loader.Clustering)
+			} else {
+				assert.Nil(t, loader.TimePartitioning)
+				assert.Nil(t, loader.Clustering)
+			}
+			assert.Nil(t, loader.RangePartitioning)
+		})
+	}
+}
+
+func TestSetupLoaderWithoutSchema(t *testing.T) {
+	rp := &restoreParams{backupParams: &backupParams{projectID: "test-project"}}
+	assert.NoError(t, rp.setBigQueryClient(context.Background()))
+	assert.NoError(t, rp.setRestoreParams(restoreBodyParams{StorageBucket: "backups", SourcePath: "ds/t.20240115T100000Z/", DatasetName: "ds", TableName: "t", SourceFormat: "PARQUET"}))
+
+	gcsRef, ok := rp.setupLoader().Src.(*bigquery.GCSReference)
+	assert.True(t, ok)
+	assert.Nil(t, gcsRef.Schema)
+	assert.False(t, gcsRef.AutoDetect)
+	assert.Equal(t, &bigquery.ParquetOptions{EnableListInference: true}, gcsRef.ParquetOptions)
+}
@@ -0,0 +1,82 @@
+package bigquerybackup
+
+import (
+	"encoding/json"
+	"time"
+
+	"cloud.google.com/go/bigquery"
+)
+
+// BackupResult describes the extract job of a table backup. A backup succeeded when Err is
+// nil; otherwise Err says why, and the other fields describe as much of the job as is known,
+// e.g. JobID is empty when the job could not be started. FileCounts holds the number of files
+// the job wrote for each of DestinationURIs, and is set once the job is done. Duration is how
+// long the backup took, SubmitDuration of which was spent starting the extract job and
+// WaitDuration waiting for it to finish.
+type BackupResult struct {
+	JobID           string
+	State           string
+	BytesProcessed  int64
+	DestinationURIs []string
+	FileCounts      []int64
+	Duration        time.Duration
+	SubmitDuration  time.Duration
+	WaitDuration    time.Duration
+	Err             error
+}
+
+// FileCount returns the total number of files the extract job wrote.
+func (r *BackupResult) FileCount() int64 {
+	var n int64
+	for _, c := range r.FileCounts {
+		n += c
+	}
+	return n
+}
+
+// Succeeded reports whether the extract job completed without error.
+func (r *BackupResult) Succeeded() bool {
+	return r.Err == nil
+}
+
+// MarshalJSON encodes the result for responses, with the total file count, the durations in
+// seconds and the error as its message.
+func (r *BackupResult) MarshalJSON() ([]byte, error) {
+	out := struct {
+		JobID           string   `json:"job_id"`
+		State           string   `json:"state"`
+		BytesProcessed  int64    `json:"bytes_processed"`
+		DestinationURIs []string `json:"destination_uris"`
+		FileCounts      []int64  `json:"destination_uri_file_counts,omitempty"`
+		FileCount       int64    `json:"file_count"`
+		DurationSeconds float64  `json:"duration_seconds"`
+		SubmitSeconds   float64  `json:"submit_seconds"`
+		WaitSeconds     float64  `json:"wait_seconds"`
+		Error           string   `json:"error,omitempty"`
+	}{
+		JobID:           r.JobID,
+		State:           r.State,
+		BytesProcessed:  r.BytesProcessed,
+		DestinationURIs: r.DestinationURIs,
+		FileCounts:      r.FileCounts,
+		FileCount:       r.FileCount(),
+		DurationSeconds: r.Duration.Seconds(),
+		SubmitSeconds:   r.SubmitDuration.Seconds(),
+		WaitSeconds:     r.WaitDuration.Seconds(),
+	}
+	if r.Err != nil {
+		out.Error = r.Err.Error()
+	}
+	return json.Marshal(out)
+}
+
+// jobState names the state of a BigQuery job: PENDING, RUNNING or DONE.
+func jobState(state bigquery.State) string {
+	switch state {
+	case bigquery.Running:
+		return jobStateRunning
+	case bigquery.Done:
+		return jobStateDone
+	}
+	return jobStatePending
+}
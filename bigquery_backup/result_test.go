@@ -0,0 +1,77 @@
+package bigquerybackup
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/bigquery"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBackupResultJSON(t *testing.T) {
+	res := &BackupResult{
+		JobID:           "job_1",
+		State:           jobStateDone,
+		BytesProcessed:  4096,
+		DestinationURIs: []string{"gs://b/ds/t.20240115T100000Z/t-*.avro"},
+		FileCounts:      []int64{3},
+		Duration:        1500 * time.Millisecond,
+		SubmitDuration:  250 * time.Millisecond,
+		WaitDuration:    1250 * time.Millisecond,
+	}
+	assert.True(t, res.Succeeded())
+	data, err := json.Marshal(res)
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"job_id": "job_1", "state": "DONE", "bytes_processed": 4096, "destination_uris": ["gs://b/ds/t.20240115T100000Z/t-*.avro"], "destination_uri_file_counts": [3], "file_count": 3, "duration_seconds": 1.5, "submit_seconds": 0.25, "wait_seconds": 1.25}`, string(data))
+
+	res.Err = errors.New("extract failed")
+	assert.False(t, res.Succeeded())
+	data, err = json.Marshal(res)
+	assert.NoError(t, err)
+	assert.Contains(t, string(data), `"error":"extract failed"`)
+}
+
+func TestJobState(t *testing.T) {
+	assert.Equal(t, jobStatePending, jobState(bigquery.Pending))
+	assert.Equal(t, jobStateRunning, jobState(bigquery.Running))
+	assert.Equal(t, jobStateDone, jobState(bigquery.Done))
+}
+
+func TestBackupResultFileCount(t *testing.T) {
+	assert.Zero(t, (&BackupResult{}).FileCount())
+	assert.Equal(t, int64(7), (&BackupResult{FileCounts: []int64{3, 4}}).FileCount())
+}
+
+func TestWaitForJobFileCounts(t *testing.T) {
+	bp := &backupParams{sourceDatasetID: "ds", backupTableID: "t", storageBucket: "backups", destinationFormat: "AVRO", backupTime: time.Date(2024, 1, 15, 10, 0, 0, 0, time.UTC)}
+	status := &bigquery.JobStatus{State: bigquery.Done, Statistics: &bigquery.JobStatistics{
+		Details: &bigquery.ExtractStatistics{DestinationURIFileCounts: []int64{12}},
+	}}
+	res := bp.waitForJob(context.Background(), &fakeJob{id: "job_1", status: status})
+	assert.True(t, res.Succeeded())
+	assert.Equal(t, []string{"gs://backups/ds/t.20240115T100000Z/t-*.avro"}, res.DestinationURIs)
+	assert.Equal(t, []int64{12}, res.FileCounts)
+	assert.Equal(t, int64(12), bp.extractFileCount)
+}
+
+func TestBackupBigQueryTableDuration(t *testing.T) {
+	job := &fakeJob{id: "job_1", status: &bigquery.JobStatus{State: bigquery.Done}, delay: 20 * time.Millisecond}
+	bp := &backupParams{
+		projectID:         "test-project",
+		sourceDatasetID:   "ds",
+		backupTableID:     "t",
+		storageBucket:     "b",
+		destinationFormat: avroFormat,
+		compressionType:   snappyCompression,
+		extractor:         &fakeBigQuery{job: job},
+	}
+	assert.NoError(t, bp.setBigQueryClient(context.Background()))
+
+	res := bp.backupBigQueryTable(context.Background())
+	assert.True(t, res.Succeeded())
+	assert.GreaterOrEqual(t, res.WaitDuration, 20*time.Millisecond)
+	assert.Equal(t, res.SubmitDuration+res.WaitDuration, res.Duration)
+}
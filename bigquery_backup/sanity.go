@@ -0,0 +1,139 @@
+package bigquerybackup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/bigquery"
+	"google.golang.org/api/iterator"
+)
+
+const (
+	defaultSanitySampleRows = 1000
+	defaultSanityAssertion  = "SELECT COUNT(*) > 0 FROM {sample}"
+	sanitySamplePlaceholder = "{sample}"
+	sanityTableExpiration   = time.Hour
+)
+
+// sanityCheck is the sanity_check section of the POST body. After the export, the first shard
+// of the backup is loaded into a temporary table and Assertion is run against its first
+// SampleRows rows. Assertion is a query returning a single BOOL, with {sample} standing in
+// for the sampled rows, e.g. "SELECT COUNTIF(id IS NULL) = 0 FROM {sample}".
+type sanityCheck struct {
+	SampleRows int    `json:"sample_rows"`
+	Assertion  string `json:"assertion"`
+}
+
+// validate fills in defaults and checks the assertion can be rendered.
+func (s *sanityCheck) validate() error {
+	if s.SampleRows < 0 {
+		return errors.New("sanity_check sample_rows must not be negative")
+	}
+	if s.SampleRows == 0 {
+		s.SampleRows = defaultSanitySampleRows
+	}
+	s.Assertion = strings.TrimSpace(s.Assertion)
+	if s.Assertion == "" {
+		s.Assertion = defaultSanityAssertion
+	}
+	if !strings.Contains(s.Assertion, sanitySamplePlaceholder) {
+		return fmt.Errorf("sanity_check assertion must reference %s", sanitySamplePlaceholder)
+	}
+	return nil
+}
+
+// query renders the assertion against the first SampleRows rows of the given table.
+func (s *sanityCheck) query(project, dataset, table string) string {
+	sample := fmt.Sprintf("(SELECT * FROM `%s.%s.%s` LIMIT %d)", project, dataset, table, s.SampleRows)
+	return strings.ReplaceAll(s.Assertion, sanitySamplePlaceholder, sample)
+}
+
+// bigQueryDataFormat maps a destination format to the BigQuery data format name. JSON exports
+// are newline-delimited JSON as far as the BigQuery API is concerned.
+func bigQueryDataFormat(format string) bigquery.DataFormat {
+	if format == jsonFormat {
+		return bigquery.JSON
+	}
+	return bigquery.DataFormat(format)
+}
+
+// firstShardURI returns the URI of the first file the extract job writes.
+func (bp *backupParams) firstShardURI() string {
+	return strings.Replace(bp.extractURI(), "*", "000000000000", 1)
+}
+
+// sanityTableID returns the name of the temporary table the sample is loaded into.
+func (bp *backupParams) sanityTableID() string {
+	return "_backup_sanity_" + strings.ReplaceAll(bp.runID, "-", "_")
+}
+
+// runSanityCheck loads a sample of the backup into a temporary table in the source dataset and
+// runs the configured assertion against it, returning an error when the assertion does not
+// hold. The temporary table is deleted afterwards, and is created with a short expiration in
+// case the delete fails. It does nothing when no sanity check was requested.
+func (bp *backupParams) runSanityCheck(ctx context.Context) error {
+	if bp.sanityCheck == nil {
+		return nil
+	}
+	if bp.emptyTable {
+		return bp.logInfo(fmt.Sprintf("Skipping sanity check of table %s.%s, which is empty", bp.sourceDatasetID, bp.backupTableID))
+	}
+	md, err := bp.tableMetadata(ctx)
+	if err != nil {
+		return err
+	}
+
+	tmp := bc.DatasetInProject(bp.sourceProjectID(), bp.sourceDatasetID).Table(bp.sanityTableID())
+	err = tmp.Create(ctx, &bigquery.TableMetadata{ExpirationTime: time.Now().Add(sanityTableExpiration)})
+	if err != nil {
+		return fmt.Errorf("failed to create sanity table: %v", err)
+	}
+	defer func() {
+		if err := tmp.Delete(context.WithoutCancel(ctx)); err != nil {
+			_ = bp.logError(fmt.Sprintf("Failed to delete sanity table %s: %v", tmp.TableID, err))
+		}
+	}()
+
+	gcsRef := bigquery.NewGCSReference(bp.firstShardURI())
+	gcsRef.SourceFormat = bigQueryDataFormat(bp.destinationFormat)
+	if bp.destinationFormat == csvFormat || bp.destinationFormat == jsonFormat {
+		gcsRef.Schema = md.Schema
+	}
+	loader := tmp.LoaderFrom(gcsRef)
+	loader.WriteDisposition = bigquery.WriteTruncate
+	job, err := loader.Run(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to load sample: %v", err)
+	}
+	status, err := job.Wait(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to load sample: %v", err)
+	}
+	if status.Err() != nil {
+		return fmt.Errorf("failed to load sample: %v", status.Err())
+	}
+
+	it, err := bc.Query(bp.sanityCheck.query(bp.sourceProjectID(), bp.sourceDatasetID, tmp.TableID)).Read(ctx)
+	if err != nil {
+		return fmt.Errorf("sanity assertion failed to run: %v", err)
+	}
+	var row []bigquery.Value
+	err = it.Next(&row)
+	if err == iterator.Done || (err == nil && len(row) != 1) {
+		return errors.New("sanity assertion must return exactly one value")
+	}
+	if err != nil {
+		return fmt.Errorf("sanity assertion failed to run: %v", err)
+	}
+	passed, ok := row[0].(bool)
+	if !ok {
+		return fmt.Errorf("sanity assertion returned %v, expected a BOOL", row[0])
+	}
+	if !passed {
+		return fmt.Errorf("sanity assertion did not hold for backup of table %s.%s: %s", bp.sourceDatasetID, bp.backupTableID, bp.sanityCheck.Assertion)
+	}
+	return bp.logInfo(fmt.Sprintf("Sanity assertion held for a %d row sample of backup of table %s.%s", bp.sanityCheck.SampleRows, bp.sourceDatasetID, bp.backupTableID))
+}
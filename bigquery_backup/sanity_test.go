@@ -0,0 +1,48 @@
+package bigquerybackup
+
+import (
+	"testing"
+	"time"
+
+	"cloud.google.com/go/bigquery"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSanityCheckValidate(t *testing.T) {
+	s := &sanityCheck{}
+	assert.NoError(t, s.validate())
+	assert.Equal(t, defaultSanitySampleRows, s.SampleRows)
+	assert.Equal(t, defaultSanityAssertion, s.Assertion)
+
+	s = &sanityCheck{SampleRows: 10, Assertion: "SELECT COUNTIF(id IS NULL) = 0 FROM {sample}"}
+	assert.NoError(t, s.validate())
+	assert.Equal(t, 10, s.SampleRows)
+
+	assert.Error(t, (&sanityCheck{SampleRows: -1}).validate())
+	assert.Error(t, (&sanityCheck{Assertion: "SELECT TRUE"}).validate())
+}
+
+func TestSanityCheckQuery(t *testing.T) {
+	s := &sanityCheck{SampleRows: 5, Assertion: "SELECT COUNTIF(id IS NULL) = 0 FROM {sample}"}
+	assert.Equal(t, "SELECT COUNTIF(id IS NULL) = 0 FROM (SELECT * FROM `p.ds._backup_sanity_x` LIMIT 5)", s.query("p", "ds", "_backup_sanity_x"))
+}
+
+func TestFirstShardURIAndSanityTableID(t *testing.T) {
+	bp := &backupParams{
+		storageBucket:     "bucket",
+		sourceDatasetID:   "ds",
+		backupTableID:     "t",
+		destinationFormat: parquetFormat,
+		backupTime:        time.Date(2024, 1, 15, 0, 0, 0, 0, time.UTC),
+		runID:             "0b6c-41d2",
+	}
+	assert.Equal(t, "gs://bucket/ds/t.20240115T000000Z/t-000000000000.parquet", bp.firstShardURI())
+	assert.Equal(t, "_backup_sanity_0b6c_41d2", bp.sanityTableID())
+}
+
+func TestBigQueryDataFormat(t *testing.T) {
+	assert.Equal(t, bigquery.JSON, bigQueryDataFormat(jsonFormat))
+	assert.Equal(t, bigquery.Avro, bigQueryDataFormat(avroFormat))
+	assert.Equal(t, bigquery.Parquet, bigQueryDataFormat(parquetFormat))
+	assert.Equal(t, bigquery.CSV, bigQueryDataFormat(csvFormat))
+}
@@ -0,0 +1,153 @@
+package bigquerybackup
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"cloud.google.com/go/bigquery"
+)
+
+const schemaObjectName = "schema.json"
+
+// tableSchema is the content of schema.json, named as in the table resource of the BigQuery
+// API: the fields in the JSON format used by the bq tool, and the table's partitioning and
+// clustering when it has them.
+type tableSchema struct {
+	Fields            json.RawMessage        `json:"fields"`
+	TimePartitioning  *timePartitioningJSON  `json:"timePartitioning,omitempty"`
+	RangePartitioning *rangePartitioningJSON `json:"rangePartitioning,omitempty"`
+	Clustering        *clusteringJSON        `json:"clustering,omitempty"`
+}
+
+type timePartitioningJSON struct {
+	Type                   string `json:"type"`
+	Field                  string `json:"field,omitempty"`
+	ExpirationMs           int64  `json:"expirationMs,omitempty,string"`
+	RequirePartitionFilter bool   `json:"requirePartitionFilter,omitempty"`
+}
+
+type rangePartitioningJSON struct {
+	Field string `json:"field"`
+	Range struct {
+		Start    int64 `json:"start,string"`
+		End      int64 `json:"end,string"`
+		Interval int64 `json:"interval,string"`
+	} `json:"range"`
+}
+
+type clusteringJSON struct {
+	Fields []string `json:"fields"`
+}
+
+// tableLayout is the partitioning and clustering of a table, which a restore recreates the
+// table with. Each is nil when the table has none.
+type tableLayout struct {
+	timePartitioning  *bigquery.TimePartitioning
+	rangePartitioning *bigquery.RangePartitioning
+	clustering        *bigquery.Clustering
+}
+
+// newTableSchema returns the schema.json content describing the table md.
+func newTableSchema(md *bigquery.TableMetadata) (tableSchema, error) {
+	fields, err := md.Schema.ToJSONFields()
+	if err != nil {
+		return tableSchema{}, err
+	}
+	ts := tableSchema{Fields: fields}
+	if p := md.TimePartitioning; p != nil {
+		partitionType := p.Type
+		if partitionType == "" {
+			partitionType = bigquery.DayPartitioningType
+		}
+		ts.TimePartitioning = &timePartitioningJSON{
+			Type:                   string(partitionType),
+			Field:                  p.Field,
+			ExpirationMs:           p.Expiration.Milliseconds(),
+			RequirePartitionFilter: p.RequirePartitionFilter || md.RequirePartitionFilter,
+		}
+	}
+	if p := md.RangePartitioning; p != nil && p.Range != nil {
+		ts.RangePartitioning = &rangePartitioningJSON{Field: p.Field}
+		ts.RangePartitioning.Range.Start = p.Range.Start
+		ts.RangePartitioning.Range.End = p.Range.End
+		ts.RangePartitioning.Range.Interval = p.Range.Interval
+	}
+	if c := md.Clustering; c != nil && len(c.Fields) > 0 {
+		ts.Clustering = &clusteringJSON{Fields: c.Fields}
+	}
+	return ts, nil
+}
+
+// layout converts the partitioning and clustering of schema.json back to the client's types.
+func (ts tableSchema) layout() tableLayout {
+	var l tableLayout
+	if p := ts.TimePartitioning; p != nil {
+		l.timePartitioning = &bigquery.TimePartitioning{
+			Type:                   bigquery.TimePartitioningType(p.Type),
+			Field:                  p.Field,
+			Expiration:             time.Duration(p.ExpirationMs) * time.Millisecond,
+			RequirePartitionFilter: p.RequirePartitionFilter,
+		}
+	}
+	if p := ts.RangePartitioning; p != nil {
+		l.rangePartitioning = &bigquery.RangePartitioning{
+			Field: p.Field,
+			Range: &bigquery.RangePartitioningRange{Start: p.Range.Start, End: p.Range.End, Interval: p.Range.Interval},
+		}
+	}
+	if c := ts.Clustering; c != nil && len(c.Fields) > 0 {
+		l.clustering = &bigquery.Clustering{Fields: c.Fields}
+	}
+	return l
+}
+
+// writeSchemaObject writes the source table's schema to schema.json in the backup prefix: column
+// names, types, modes and descriptions, with nested fields for records, together with the
+// table's time or range partitioning and clustering. CSV and JSON backups carry no schema of
+// their own, so this is what a restore loads them with, and the partitioning and clustering are
+// what it recreates the table with. It does nothing unless include_schema is set, which it is by
+// default.
+func (bp *backupParams) writeSchemaObject(ctx context.Context) error {
+	if !bp.includeSchema {
+		return nil
+	}
+	md, err := bp.tableMetadata(ctx)
+	if err != nil {
+		return err
+	}
+	schema, err := newTableSchema(md)
+	if err != nil {
+		return err
+	}
+	name, err := bp.writeBackupObject(ctx, schemaObjectName, schema)
+	if err != nil {
+		return err
+	}
+	return bp.logInfo(fmt.Sprintf("Wrote schema to gs://%s/%s", bp.storageBucket, name))
+}
+
+// readSchema parses a schema written by writeSchemaObject, or a bare array of fields as printed
+// by bq show --schema and written by earlier backups, which has no partitioning or clustering.
+func readSchema(r io.Reader) (bigquery.Schema, tableLayout, error) {
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return nil, tableLayout{}, err
+	}
+	if bytes.HasPrefix(bytes.TrimSpace(b), []byte("[")) {
+		schema, err := bigquery.SchemaFromJSON(b)
+		return schema, tableLayout{}, err
+	}
+	var ts tableSchema
+	if err := json.Unmarshal(b, &ts); err != nil {
+		return nil, tableLayout{}, err
+	}
+	schema, err := bigquery.SchemaFromJSON(ts.Fields)
+	if err != nil {
+		return nil, tableLayout{}, err
+	}
+	return schema, ts.layout(), nil
+}
@@ -0,0 +1,108 @@
+package bigquerybackup
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/bigquery"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSchemaRoundTrip(t *testing.T) {
+	schema := bigquery.Schema{
+		{Name: "id", Type: bigquery.IntegerFieldType, Required: true, Description: "Order ID"},
+		{Name: "tags", Type: bigquery.StringFieldType, Repeated: true},
+		{Name: "customer", Type: bigquery.RecordFieldType, Schema: bigquery.Schema{
+			{Name: "name", Type: bigquery.StringFieldType},
+		}},
+	}
+	b, err := schema.ToJSONFields()
+	assert.NoError(t, err)
+	assert.Contains(t, string(b), `"mode": "REQUIRED"`)
+	assert.Contains(t, string(b), `"description": "Order ID"`)
+
+	got, layout, err := readSchema(bytes.NewReader(b))
+	assert.NoError(t, err)
+	assert.Equal(t, schema, got)
+	assert.Equal(t, tableLayout{}, layout)
+}
+
+func TestTableSchemaRoundTrip(t *testing.T) {
+	tests := []struct {
+		name       string
+		md         *bigquery.TableMetadata
+		wantLayout tableLayout
+		wantJSON   []string
+	}{
+		{
+			name: "Not partitioned",
+			md:   &bigquery.TableMetadata{},
+		},
+		{
+			name: "Time partitioned and clustered",
+			md: &bigquery.TableMetadata{
+				TimePartitioning:       &bigquery.TimePartitioning{Field: "created", Expiration: 48 * time.Hour},
+				RequirePartitionFilter: true,
+				Clustering:             &bigquery.Clustering{Fields: []string{"customer", "id"}},
+			},
+			wantLayout: tableLayout{
+				timePartitioning: &bigquery.TimePartitioning{Type: bigquery.DayPartitioningType, Field: "created", Expiration: 48 * time.Hour, RequirePartitionFilter: true},
+				clustering:       &bigquery.Clustering{Fields: []string{"customer", "id"}},
+			},
+			wantJSON: []string{`"timePartitioning": {`, `"expirationMs": "172800000"`, `"clustering": {`},
+		},
+		{
+			name: "Range partitioned",
+			md: &bigquery.TableMetadata{RangePartitioning: &bigquery.RangePartitioning{
+				Field: "id",
+				Range: &bigquery.RangePartitioningRange{Start: 0, End: 1000, Interval: 10},
+			}},
+			wantLayout: tableLayout{rangePartitioning: &bigquery.RangePartitioning{
+				Field: "id",
+				Range: &bigquery.RangePartitioningRange{Start: 0, End: 1000, Interval: 10},
+			}},
+			wantJSON: []string{`"rangePartitioning": {`, `"start": "0"`, `"interval": "10"`},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.md.Schema = bigquery.Schema{
+				{Name: "id", Type: bigquery.IntegerFieldType, Required: true},
+				{Name: "customer", Type: bigquery.StringFieldType},
+				{Name: "created", Type: bigquery.DateFieldType},
+			}
+			ts, err := newTableSchema(tt.md)
+			assert.NoError(t, err)
+			b, err := json.MarshalIndent(ts, "", "  ")
+			assert.NoError(t, err)
+			for _, want := range tt.wantJSON {
+				assert.Contains(t, string(b), want)
+			}
+
+			schema, layout, err := readSchema(bytes.NewReader(b))
+			assert.NoError(t, err)
+			assert.Equal(t, tt.md.Schema, schema)
+			assert.Equal(t, tt.wantLayout, layout)
+		})
+	}
+}
+
+func TestReadSchemaInvalid(t *testing.T) {
+	_, _, err := readSchema(strings.NewReader(`{"timePartitioning": {"type": "DAY"}}`))
+	assert.Error(t, err, "fields are required")
+	_, _, err = readSchema(strings.NewReader(`not json`))
+	assert.Error(t, err)
+}
+
+func TestSetBackupParamsIncludeSchema(t *testing.T) {
+	off := false
+	bp := &backupParams{}
+	bp.setBackupParams(postBodyParams{DatasetName: "ds", TableName: "t"})
+	assert.True(t, bp.includeSchema, "include_schema defaults to true")
+	bp.setBackupParams(postBodyParams{DatasetName: "ds", TableName: "t", IncludeSchema: &off})
+	assert.False(t, bp.includeSchema)
+}
@@ -0,0 +1,361 @@
+package bigquerybackup
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"cloud.google.com/go/storage"
+)
+
+var (
+	avroMagic    = []byte("Obj\x01")
+	parquetMagic = []byte("PAR1")
+)
+
+// maxAvroHeaderBytes bounds how much of a shard is read looking for the Avro header, so a
+// corrupt length cannot make the function buffer an entire shard.
+const maxAvroHeaderBytes = 16 << 20
+
+// avroSchema reads the header of an Avro object container file and returns the writer schema
+// stored under the "avro.schema" metadata key.
+func avroSchema(r io.Reader) (string, error) {
+	br := bufio.NewReader(io.LimitReader(r, maxAvroHeaderBytes))
+	magic := make([]byte, len(avroMagic))
+	if _, err := io.ReadFull(br, magic); err != nil || !bytes.Equal(magic, avroMagic) {
+		return "", errors.New("not an Avro object container file")
+	}
+	for {
+		count, err := binary.ReadVarint(br)
+		if err != nil {
+			return "", fmt.Errorf("invalid Avro header: %v", err)
+		}
+		if count == 0 {
+			return "", errors.New("no avro.schema in Avro header")
+		}
+		if count < 0 {
+			// A negative count is followed by the block size in bytes, which we don't need.
+			count = -count
+			if _, err := binary.ReadVarint(br); err != nil {
+				return "", fmt.Errorf("invalid Avro header: %v", err)
+			}
+		}
+		for i := int64(0); i < count; i++ {
+			key, err := readAvroBytes(br)
+			if err != nil {
+				return "", err
+			}
+			value, err := readAvroBytes(br)
+			if err != nil {
+				return "", err
+			}
+			if string(key) == "avro.schema" {
+				return string(value), nil
+			}
+		}
+	}
+}
+
+func readAvroBytes(br *bufio.Reader) ([]byte, error) {
+	n, err := binary.ReadVarint(br)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Avro header: %v", err)
+	}
+	if n < 0 || n > maxAvroHeaderBytes {
+		return nil, fmt.Errorf("invalid Avro header: bad length %d", n)
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(br, b); err != nil {
+		return nil, fmt.Errorf("invalid Avro header: %v", err)
+	}
+	return b, nil
+}
+
+// parquetSchema returns the encoded schema of a Parquet file given its footer, the Thrift
+// compact encoded FileMetaData. The schema is field 2 of FileMetaData; its raw bytes are
+// returned, which is enough to tell whether two files share a schema.
+func parquetSchema(footer []byte) ([]byte, error) {
+	d := &thriftDecoder{buf: footer}
+	var lastID int16
+	for {
+		id, typ, err := d.fieldHeader(lastID)
+		if err != nil {
+			return nil, err
+		}
+		if typ == thriftStop {
+			return nil, errors.New("no schema in Parquet footer")
+		}
+		start := d.pos
+		if err := d.skip(typ); err != nil {
+			return nil, err
+		}
+		if id == 2 {
+			return footer[start:d.pos], nil
+		}
+		lastID = id
+	}
+}
+
+// Thrift compact protocol type IDs.
+const (
+	thriftStop   = 0
+	thriftTrue   = 1
+	thriftFalse  = 2
+	thriftByte   = 3
+	thriftI16    = 4
+	thriftI32    = 5
+	thriftI64    = 6
+	thriftDouble = 7
+	thriftBinary = 8
+	thriftList   = 9
+	thriftSet    = 10
+	thriftMap    = 11
+	thriftStruct = 12
+)
+
+const (
+	maxThriftDepth  = 64
+	errThriftFormat = "invalid Parquet footer"
+)
+
+// thriftDecoder walks a Thrift compact protocol buffer. It only knows how to skip values,
+// which is all that is needed to find a field's bytes.
+type thriftDecoder struct {
+	buf   []byte
+	pos   int
+	depth int
+}
+
+func (d *thriftDecoder) byte() (byte, error) {
+	if d.pos >= len(d.buf) {
+		return 0, fmt.Errorf("%s: unexpected end", errThriftFormat)
+	}
+	b := d.buf[d.pos]
+	d.pos++
+	return b, nil
+}
+
+func (d *thriftDecoder) uvarint() (uint64, error) {
+	v, n := binary.Uvarint(d.buf[d.pos:])
+	if n <= 0 {
+		return 0, fmt.Errorf("%s: bad varint", errThriftFormat)
+	}
+	d.pos += n
+	return v, nil
+}
+
+func (d *thriftDecoder) advance(n uint64) error {
+	if n > uint64(len(d.buf)-d.pos) {
+		return fmt.Errorf("%s: unexpected end", errThriftFormat)
+	}
+	d.pos += int(n)
+	return nil
+}
+
+// fieldHeader reads a struct field header and returns the field ID and type.
+func (d *thriftDecoder) fieldHeader(lastID int16) (int16, byte, error) {
+	b, err := d.byte()
+	if err != nil {
+		return 0, 0, err
+	}
+	typ := b & 0x0f
+	if typ == thriftStop {
+		return 0, thriftStop, nil
+	}
+	if delta := int16(b >> 4); delta != 0 {
+		return lastID + delta, typ, nil
+	}
+	v, err := d.uvarint()
+	if err != nil {
+		return 0, 0, err
+	}
+	return int16(v>>1) ^ -int16(v&1), typ, nil
+}
+
+// skip advances past one value of type typ.
+func (d *thriftDecoder) skip(typ byte) error {
+	d.depth++
+	defer func() { d.depth-- }()
+	if d.depth > maxThriftDepth {
+		return fmt.Errorf("%s: nested too deeply", errThriftFormat)
+	}
+	switch typ {
+	case thriftTrue, thriftFalse:
+		return nil
+	case thriftByte:
+		return d.advance(1)
+	case thriftI16, thriftI32, thriftI64:
+		_, err := d.uvarint()
+		return err
+	case thriftDouble:
+		return d.advance(8)
+	case thriftBinary:
+		n, err := d.uvarint()
+		if err != nil {
+			return err
+		}
+		return d.advance(n)
+	case thriftList, thriftSet:
+		b, err := d.byte()
+		if err != nil {
+			return err
+		}
+		size, elem := uint64(b>>4), b&0x0f
+		if size == 15 {
+			if size, err = d.uvarint(); err != nil {
+				return err
+			}
+		}
+		for i := uint64(0); i < size; i++ {
+			// Booleans in collections take a byte each, unlike booleans in struct fields.
+			if elem == thriftTrue || elem == thriftFalse {
+				err = d.advance(1)
+			} else {
+				err = d.skip(elem)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	case thriftMap:
+		size, err := d.uvarint()
+		if err != nil || size == 0 {
+			return err
+		}
+		b, err := d.byte()
+		if err != nil {
+			return err
+		}
+		for i := uint64(0); i < size; i++ {
+			for _, t := range []byte{b >> 4, b & 0x0f} {
+				if t == thriftTrue || t == thriftFalse {
+					err = d.advance(1)
+				} else {
+					err = d.skip(t)
+				}
+				if err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	case thriftStruct:
+		var lastID int16
+		for {
+			id, t, err := d.fieldHeader(lastID)
+			if err != nil {
+				return err
+			}
+			if t == thriftStop {
+				return nil
+			}
+			if err := d.skip(t); err != nil {
+				return err
+			}
+			lastID = id
+		}
+	}
+	return fmt.Errorf("%s: unknown type %d", errThriftFormat, typ)
+}
+
+// readShardSchema returns the schema embedded in one shard of the backup.
+func (bp *backupParams) readShardSchema(ctx context.Context, obj *storage.ObjectHandle) (string, error) {
+	ctx, cancel := withStorageTimeout(ctx)
+	defer cancel()
+
+	if bp.destinationFormat == avroFormat {
+		r, err := obj.NewReader(ctx)
+		if err != nil {
+			return "", err
+		}
+		defer r.Close()
+		return avroSchema(r)
+	}
+
+	attrs, err := obj.Attrs(ctx)
+	if err != nil {
+		return "", err
+	}
+	if attrs.Size < 12 {
+		return "", errors.New("object is too small to be a Parquet file")
+	}
+	tail, err := readRange(ctx, obj, attrs.Size-8, 8)
+	if err != nil {
+		return "", err
+	}
+	if len(tail) != 8 || !bytes.Equal(tail[4:], parquetMagic) {
+		return "", errors.New("not a Parquet file")
+	}
+	footerLen := int64(binary.LittleEndian.Uint32(tail[:4]))
+	if footerLen > attrs.Size-12 {
+		return "", fmt.Errorf("%s: bad footer length %d", errThriftFormat, footerLen)
+	}
+	footer, err := readRange(ctx, obj, attrs.Size-8-footerLen, footerLen)
+	if err != nil {
+		return "", err
+	}
+	schema, err := parquetSchema(footer)
+	if err != nil {
+		return "", err
+	}
+	return string(schema), nil
+}
+
+func readRange(ctx context.Context, obj *storage.ObjectHandle, offset, length int64) ([]byte, error) {
+	r, err := obj.NewRangeReader(ctx, offset, length)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return io.ReadAll(r)
+}
+
+// checkShardSchemas reads the schema embedded in every shard of an Avro or Parquet backup and
+// fails when they are not all identical. It does nothing unless check_shard_schemas was
+// requested.
+func (bp *backupParams) checkShardSchemas(ctx context.Context) error {
+	if !bp.checkShardSchemasEnabled {
+		return nil
+	}
+	c, err := sharedStorageClient()
+	if err != nil {
+		return err
+	}
+
+	objects, err := bp.listObjects(ctx, c, bp.outputPrefix())
+	if err != nil {
+		return fmt.Errorf("failed to list shards: %v", err)
+	}
+	shardPrefix := bp.outputPrefix() + "/" + bp.shardNamePrefix()
+	bucket := c.Bucket(bp.storageBucket)
+	var first, firstSchema string
+	shards := 0
+	for _, name := range objects {
+		if !strings.HasPrefix(name, shardPrefix) {
+			continue
+		}
+		schema, err := bp.readShardSchema(ctx, bucket.Object(name))
+		if err != nil {
+			return fmt.Errorf("failed to read schema of gs://%s/%s: %v", bp.storageBucket, name, err)
+		}
+		if shards == 0 {
+			first, firstSchema = name, schema
+		} else if schema != firstSchema {
+			return fmt.Errorf("shard gs://%s/%s has a different schema than gs://%s/%s", bp.storageBucket, name, bp.storageBucket, first)
+		}
+		shards++
+	}
+	if shards == 0 {
+		if bp.emptyTable {
+			return nil
+		}
+		return fmt.Errorf("no shards found under gs://%s/%s", bp.storageBucket, bp.outputPrefix())
+	}
+	return bp.logInfo(fmt.Sprintf("All %d shards of backup of table %s.%s share the same schema", shards, bp.sourceDatasetID, bp.backupTableID))
+}
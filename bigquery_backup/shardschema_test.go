@@ -0,0 +1,83 @@
+package bigquerybackup
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func avroHeader(meta ...string) []byte {
+	var b bytes.Buffer
+	b.Write(avroMagic)
+	writeLong := func(v int64) { b.Write(binary.AppendVarint(nil, v)) }
+	writeLong(int64(len(meta) / 2))
+	for _, s := range meta {
+		writeLong(int64(len(s)))
+		b.WriteString(s)
+	}
+	writeLong(0)
+	b.Write(make([]byte, 16))
+	return b.Bytes()
+}
+
+// parquetFooter encodes a FileMetaData with a version, a schema of one named element and a row
+// count, in the Thrift compact protocol.
+func parquetFooter(schemaName string) []byte {
+	b := []byte{0x15, 0x02}                    // 1: version = 1
+	b = append(b, 0x19, 0x1c)                  // 2: schema, list of 1 struct
+	b = append(b, 0x48, byte(len(schemaName))) // 4: name
+	b = append(b, schemaName...)
+	b = append(b, 0x00)       // end of SchemaElement
+	b = append(b, 0x16, 0x14) // 3: num_rows = 10
+	return append(b, 0x00)
+}
+
+func TestAvroSchema(t *testing.T) {
+	schema := `{"type":"record","name":"Root","fields":[]}`
+	tests := []struct {
+		name    string
+		data    []byte
+		want    string
+		wantErr bool
+	}{
+		{name: "Schema after codec", data: avroHeader("avro.codec", "snappy", "avro.schema", schema), want: schema},
+		{name: "Schema first", data: avroHeader("avro.schema", schema, "avro.codec", "null"), want: schema},
+		{name: "No schema", data: avroHeader("avro.codec", "null"), wantErr: true},
+		{name: "Not Avro", data: []byte("PAR1"), wantErr: true},
+		{name: "Truncated", data: avroHeader("avro.schema", schema)[:10], wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := avroSchema(bytes.NewReader(tt.data))
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestParquetSchema(t *testing.T) {
+	a, err := parquetSchema(parquetFooter("schema"))
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{0x1c, 0x48, 0x06, 's', 'c', 'h', 'e', 'm', 'a', 0x00}, a)
+
+	same, err := parquetSchema(parquetFooter("schema"))
+	assert.NoError(t, err)
+	assert.Equal(t, a, same)
+
+	other, err := parquetSchema(parquetFooter("schemb"))
+	assert.NoError(t, err)
+	assert.NotEqual(t, a, other)
+
+	_, err = parquetSchema([]byte{0x15, 0x02, 0x00})
+	assert.Error(t, err, "footer without a schema")
+
+	_, err = parquetSchema(parquetFooter("schema")[:6])
+	assert.Error(t, err, "truncated footer")
+}
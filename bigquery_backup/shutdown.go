@@ -0,0 +1,90 @@
+package bigquerybackup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+
+	"github.com/cloudevents/sdk-go/v2/event"
+)
+
+// errShuttingDown is returned for requests that arrive after Shutdown was called. A Pub/Sub
+// message rejected with it is redelivered, to another instance if this one is gone.
+var errShuttingDown = errors.New("instance is shutting down, retry the request")
+
+// shuttingDown is set by Shutdown, after which no new request is started. It is guarded by
+// shutdownMu together with the calls to inFlight.Add, so that Shutdown never waits while a
+// request is being admitted.
+var shuttingDown bool
+var shutdownMu sync.Mutex
+var inFlight sync.WaitGroup
+
+// closeClients closes the shared clients once Shutdown stopped waiting. It is a variable so
+// tests can shut down without closing clients other tests still use.
+var closeClients = Close
+
+// beginRequest registers a request in flight. It returns false once the instance is shutting
+// down; otherwise the caller must call inFlight.Done when the request finished.
+func beginRequest() bool {
+	shutdownMu.Lock()
+	defer shutdownMu.Unlock()
+	if shuttingDown {
+		return false
+	}
+	inFlight.Add(1)
+	return true
+}
+
+// trackHTTP wraps an HTTP function so Shutdown waits for its requests, and answers requests
+// arriving during the shutdown with 503.
+func trackHTTP(fn http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !beginRequest() {
+			writeError(w, http.StatusServiceUnavailable, errShuttingDown.Error())
+			return
+		}
+		defer inFlight.Done()
+		fn(w, r)
+	}
+}
+
+// trackEvent wraps a CloudEvent function like trackHTTP, failing events that arrive during the
+// shutdown so they are retried.
+func trackEvent(fn func(context.Context, event.Event) error) func(context.Context, event.Event) error {
+	return func(ctx context.Context, e event.Event) error {
+		if !beginRequest() {
+			return errShuttingDown
+		}
+		defer inFlight.Done()
+		return fn(ctx, e)
+	}
+}
+
+// Shutdown stops the functions of this process from starting new requests, which are answered
+// with 503, and waits for the requests in flight to finish or for ctx to be done, whichever
+// comes first. It then flushes the logs and closes the shared clients with Close. It returns
+// ctx's error when requests were still running, after closing the clients anyway.
+//
+// Shutdown does not stop the HTTP server: funcframework.Start offers no way to close its
+// listener, so new connections are accepted, and their requests answered with 503, until the
+// process exits.
+func Shutdown(ctx context.Context) error {
+	shutdownMu.Lock()
+	shuttingDown = true
+	shutdownMu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		inFlight.Wait()
+		close(done)
+	}()
+	var waitErr error
+	select {
+	case <-done:
+	case <-ctx.Done():
+		waitErr = fmt.Errorf("requests still in flight: %w", ctx.Err())
+	}
+	return errors.Join(waitErr, closeClients())
+}
@@ -0,0 +1,96 @@
+package bigquerybackup
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/cloudevents/sdk-go/v2/event"
+	"github.com/stretchr/testify/assert"
+)
+
+// resetShutdown lets the functions accept requests again after a test called Shutdown, and
+// keeps it from closing the shared clients.
+func resetShutdown(t *testing.T) {
+	orig := closeClients
+	closeClients = func() error { return nil }
+	t.Cleanup(func() {
+		closeClients = orig
+		shutdownMu.Lock()
+		shuttingDown = false
+		shutdownMu.Unlock()
+	})
+}
+
+func TestTrackHTTPRejectsDuringShutdown(t *testing.T) {
+	resetShutdown(t)
+	called := false
+	h := trackHTTP(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+		w.WriteHeader(http.StatusOK)
+	})
+
+	rec := httptest.NewRecorder()
+	h(rec, httptest.NewRequest(http.MethodPost, "/", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.True(t, called)
+
+	assert.NoError(t, Shutdown(context.Background()))
+	called = false
+	rec = httptest.NewRecorder()
+	h(rec, httptest.NewRequest(http.MethodPost, "/", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	assert.False(t, called)
+}
+
+func TestTrackEventRejectsDuringShutdown(t *testing.T) {
+	resetShutdown(t)
+	fn := trackEvent(func(context.Context, event.Event) error { return nil })
+	assert.NoError(t, fn(context.Background(), event.New()))
+
+	assert.NoError(t, Shutdown(context.Background()))
+	assert.ErrorIs(t, fn(context.Background(), event.New()), errShuttingDown)
+}
+
+func TestShutdownWaitsForRequestsInFlight(t *testing.T) {
+	resetShutdown(t)
+	started, release := make(chan struct{}), make(chan struct{})
+	h := trackHTTP(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+	})
+	go h(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/", nil))
+	<-started
+
+	done := make(chan error, 1)
+	go func() {
+		done <- Shutdown(context.Background())
+	}()
+	select {
+	case <-done:
+		t.Fatal("Shutdown returned while a request was in flight")
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(release)
+	assert.NoError(t, <-done)
+}
+
+func TestShutdownGracePeriodExpires(t *testing.T) {
+	resetShutdown(t)
+	started, release := make(chan struct{}), make(chan struct{})
+	defer close(release)
+	h := trackHTTP(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+	})
+	go h(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/", nil))
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	err := Shutdown(ctx)
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
+}
@@ -0,0 +1,122 @@
+package bigquerybackup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"path"
+	"time"
+
+	"cloud.google.com/go/storage"
+)
+
+const (
+	defaultSignedURLTTL = 60 * time.Minute
+	// maxSignedURLTTL is the longest a V4 signed URL can be valid for.
+	maxSignedURLTTL = 7 * 24 * time.Hour
+)
+
+// signObjectURL signs a URL for an object in bucket. It is a variable so tests can replace it
+// without credentials that can sign.
+var signObjectURL = func(bucket *storage.BucketHandle, object string, opts *storage.SignedURLOptions) (string, error) {
+	return bucket.SignedURL(object, opts)
+}
+
+// signedURL is a time-limited URL to read one file of the backup.
+type signedURL struct {
+	Object  string    `json:"object"`
+	URL     string    `json:"url"`
+	Expires time.Time `json:"expires"`
+}
+
+// checkSignedURLs validates the generate_signed_urls and signed_url_ttl_minutes fields of the
+// POST body.
+func checkSignedURLs(pb *postBodyParams) error {
+	switch {
+	case pb.SignedURLTTLMinutes != 0 && !pb.GenerateSignedURLs:
+		return errors.New("signed_url_ttl_minutes requires generate_signed_urls")
+	case pb.SignedURLTTLMinutes < 0:
+		return errors.New("signed_url_ttl_minutes must not be negative")
+	case time.Duration(pb.SignedURLTTLMinutes)*time.Minute > maxSignedURLTTL:
+		return fmt.Errorf("signed_url_ttl_minutes must be at most %d, signed URLs are valid for 7 days at most", int(maxSignedURLTTL/time.Minute))
+	}
+	return nil
+}
+
+// signedURLTTL returns how long signed URLs are valid for, signed_url_ttl_minutes or an hour.
+func (bp *backupParams) signedURLTTL() time.Duration {
+	if bp.signedURLTTLMinutes > 0 {
+		return time.Duration(bp.signedURLTTLMinutes) * time.Minute
+	}
+	return defaultSignedURLTTL
+}
+
+// signingError explains why a URL could not be signed. Without a private key the storage client
+// signs with the IAM Credentials API, which needs the function's service account to hold
+// roles/iam.serviceAccountTokenCreator on itself; a denied request is reported with 403.
+func signingError(err error) error {
+	if lookupStatus(err) == http.StatusForbidden {
+		return withStatus(http.StatusForbidden, fmt.Errorf("service account cannot sign URLs, grant it roles/iam.serviceAccountTokenCreator on itself: %v", err))
+	}
+	return fmt.Errorf("cannot sign URLs: %v", err)
+}
+
+// signObjects returns V4 signed GET URLs for the named objects, valid from now for
+// signedURLTTL.
+func (bp *backupParams) signObjects(bucket *storage.BucketHandle, names []string, now time.Time) ([]signedURL, error) {
+	expires := now.Add(bp.signedURLTTL()).UTC()
+	urls := make([]signedURL, 0, len(names))
+	for _, name := range names {
+		u, err := signObjectURL(bucket, name, &storage.SignedURLOptions{
+			Scheme:  storage.SigningSchemeV4,
+			Method:  http.MethodGet,
+			Expires: expires,
+		})
+		if err != nil {
+			return nil, signingError(err)
+		}
+		urls = append(urls, signedURL{Object: path.Base(name), URL: u, Expires: expires})
+	}
+	return urls, nil
+}
+
+// checkCanSign signs a URL for an object that does not exist yet, which needs no storage call,
+// so that a service account that cannot sign is reported before the export rather than after
+// it. It does nothing unless generate_signed_urls was requested.
+func (bp *backupParams) checkCanSign(ctx context.Context) error {
+	if !bp.generateSignedURLs {
+		return nil
+	}
+	c, err := sharedStorageClient()
+	if err != nil {
+		return err
+	}
+	_, err = bp.signObjects(c.Bucket(bp.storageBucket), []string{bp.backupPrefix() + "/" + manifestObjectName}, time.Now())
+	return err
+}
+
+// signBackupURLs signs a URL for every file of the published backup when
+// generate_signed_urls was requested. It does nothing otherwise.
+func (bp *backupParams) signBackupURLs(ctx context.Context) error {
+	if !bp.generateSignedURLs {
+		return nil
+	}
+	c, err := sharedStorageClient()
+	if err != nil {
+		return err
+	}
+
+	shards, err := bp.listShards(ctx, c, bp.backupPrefix())
+	if err != nil {
+		return fmt.Errorf("failed to list shards: %v", err)
+	}
+	names := make([]string, len(shards))
+	for i, attrs := range shards {
+		names[i] = attrs.Name
+	}
+	if bp.signedURLs, err = bp.signObjects(c.Bucket(bp.storageBucket), names, time.Now()); err != nil {
+		return err
+	}
+	return bp.logInfo(fmt.Sprintf("Signed %d URLs for backup of table %s.%s, valid until %s", len(bp.signedURLs), bp.sourceDatasetID, bp.backupTableID, time.Now().Add(bp.signedURLTTL()).UTC().Format(time.RFC3339)))
+}
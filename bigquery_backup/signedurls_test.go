@@ -0,0 +1,93 @@
+package bigquerybackup
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/api/googleapi"
+)
+
+func TestCheckSignedURLs(t *testing.T) {
+	tests := []struct {
+		name    string
+		pb      postBodyParams
+		wantErr string
+	}{
+		{name: "Not requested", pb: postBodyParams{}},
+		{name: "Default TTL", pb: postBodyParams{GenerateSignedURLs: true}},
+		{name: "Seven days", pb: postBodyParams{GenerateSignedURLs: true, SignedURLTTLMinutes: 10080}},
+		{name: "TTL without URLs", pb: postBodyParams{SignedURLTTLMinutes: 30}, wantErr: "signed_url_ttl_minutes requires generate_signed_urls"},
+		{name: "Negative TTL", pb: postBodyParams{GenerateSignedURLs: true, SignedURLTTLMinutes: -1}, wantErr: "signed_url_ttl_minutes must not be negative"},
+		{name: "Too long", pb: postBodyParams{GenerateSignedURLs: true, SignedURLTTLMinutes: 10081}, wantErr: "signed_url_ttl_minutes must be at most 10080, signed URLs are valid for 7 days at most"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkSignedURLs(&tt.pb)
+			if tt.wantErr == "" {
+				assert.NoError(t, err)
+				return
+			}
+			assert.EqualError(t, err, tt.wantErr)
+		})
+	}
+}
+
+func TestSignedURLTTL(t *testing.T) {
+	assert.Equal(t, time.Hour, (&backupParams{}).signedURLTTL())
+	assert.Equal(t, 15*time.Minute, (&backupParams{signedURLTTLMinutes: 15}).signedURLTTL())
+}
+
+func TestSignObjects(t *testing.T) {
+	saved := signObjectURL
+	t.Cleanup(func() { signObjectURL = saved })
+	var got []*storage.SignedURLOptions
+	signObjectURL = func(_ *storage.BucketHandle, object string, opts *storage.SignedURLOptions) (string, error) {
+		got = append(got, opts)
+		return "https://storage.googleapis.com/backups/" + object + "?X-Goog-Signature=sig", nil
+	}
+
+	now := time.Date(2024, 1, 15, 10, 0, 0, 0, time.UTC)
+	bp := &backupParams{signedURLTTLMinutes: 30}
+	urls, err := bp.signObjects(nil, []string{"ds/t.20240115T100000Z/t-000000000000.avro"}, now)
+	assert.NoError(t, err)
+	assert.Equal(t, []signedURL{{
+		Object:  "t-000000000000.avro",
+		URL:     "https://storage.googleapis.com/backups/ds/t.20240115T100000Z/t-000000000000.avro?X-Goog-Signature=sig",
+		Expires: now.Add(30 * time.Minute),
+	}}, urls)
+	assert.Equal(t, storage.SigningSchemeV4, got[0].Scheme)
+	assert.Equal(t, http.MethodGet, got[0].Method)
+	assert.Equal(t, now.Add(30*time.Minute), got[0].Expires)
+}
+
+func TestSignObjectsErrors(t *testing.T) {
+	saved := signObjectURL
+	t.Cleanup(func() { signObjectURL = saved })
+
+	tests := []struct {
+		name     string
+		err      error
+		wantCode int
+		wantMsg  string
+	}{
+		{name: "Permission denied", err: &googleapi.Error{Code: http.StatusForbidden, Message: "denied"}, wantCode: http.StatusForbidden, wantMsg: "service account cannot sign URLs, grant it roles/iam.serviceAccountTokenCreator on itself: "},
+		{name: "No service account", err: errors.New("storage: unable to detect default GoogleAccessID"), wantCode: http.StatusInternalServerError, wantMsg: "cannot sign URLs: storage: unable to detect default GoogleAccessID"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			signObjectURL = func(*storage.BucketHandle, string, *storage.SignedURLOptions) (string, error) {
+				return "", tt.err
+			}
+			urls, err := (&backupParams{}).signObjects(nil, []string{"a", "b"}, time.Now())
+			assert.Nil(t, urls)
+			assert.Equal(t, tt.wantCode, statusCode(err))
+			assert.Contains(t, err.Error(), tt.wantMsg)
+		})
+	}
+}
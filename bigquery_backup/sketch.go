@@ -0,0 +1,130 @@
+package bigquerybackup
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/bigquery"
+	"google.golang.org/api/iterator"
+)
+
+const sketchObjectName = "sketch.json"
+
+// sketch is the cardinality summary of one column written to sketch.json in the backup prefix.
+// HLLSketch holds the HLL++ sketch bytes from HLL_COUNT.INIT and is only present when
+// sketch_hll was requested; it can be merged or re-counted with the HLL_COUNT functions.
+type sketch struct {
+	Table               string    `json:"table"`
+	Column              string    `json:"column"`
+	ApproxDistinctCount int64     `json:"approx_distinct_count"`
+	HLLSketch           []byte    `json:"hll_sketch,omitempty"`
+	ComputedAt          time.Time `json:"computed_at"`
+}
+
+type sketchRow struct {
+	ApproxDistinctCount int64  `bigquery:"approx_distinct_count"`
+	HLLSketch           []byte `bigquery:"hll_sketch"`
+}
+
+// hllFieldTypes are the column types HLL_COUNT.INIT accepts.
+var hllFieldTypes = map[bigquery.FieldType]bool{
+	bigquery.IntegerFieldType:    true,
+	bigquery.NumericFieldType:    true,
+	bigquery.BigNumericFieldType: true,
+	bigquery.StringFieldType:     true,
+	bigquery.BytesFieldType:      true,
+}
+
+// findSchemaField looks up a column by name in schema, matching case-insensitively as BigQuery
+// does. Nested columns are addressed with dots, e.g. "customer.id", and may only pass through
+// non-repeated RECORD fields. It returns nil when the column does not exist.
+func findSchemaField(schema bigquery.Schema, column string) *bigquery.FieldSchema {
+	parts := strings.Split(column, ".")
+	var field *bigquery.FieldSchema
+	for i, part := range parts {
+		field = nil
+		for _, f := range schema {
+			if strings.EqualFold(f.Name, part) {
+				field = f
+				break
+			}
+		}
+		if field == nil {
+			return nil
+		}
+		if i < len(parts)-1 {
+			if field.Type != bigquery.RecordFieldType || field.Repeated {
+				return nil
+			}
+			schema = field.Schema
+		}
+	}
+	return field
+}
+
+// validateSketchColumn checks that the requested sketch_column exists in the table and can be
+// counted. It returns true when no sketch was requested.
+func (bp *backupParams) validateSketchColumn(ctx context.Context) (bool, error) {
+	if bp.sketchColumn == "" {
+		return true, nil
+	}
+	md, err := bp.tableMetadata(ctx)
+	if err != nil {
+		return false, err
+	}
+	field := findSchemaField(md.Schema, bp.sketchColumn)
+	if field == nil {
+		return false, fmt.Errorf("sketch_column %q does not exist in table %s.%s", bp.sketchColumn, bp.sourceDatasetID, bp.backupTableID)
+	}
+	switch {
+	case field.Repeated, field.Type == bigquery.RecordFieldType, field.Type == bigquery.GeographyFieldType, field.Type == bigquery.JSONFieldType:
+		return false, fmt.Errorf("sketch_column %q has type %s and cannot be counted", bp.sketchColumn, field.Type)
+	case bp.sketchHLL && !hllFieldTypes[field.Type]:
+		return false, fmt.Errorf("sketch_column %q has type %s, which HLL_COUNT.INIT does not support", bp.sketchColumn, field.Type)
+	}
+	return true, nil
+}
+
+// sketchQuery returns the SQL that computes the sketch of the requested column.
+func (bp *backupParams) sketchQuery() string {
+	column := "`" + strings.Join(strings.Split(bp.sketchColumn, "."), "`.`") + "`"
+	sql := fmt.Sprintf("SELECT APPROX_COUNT_DISTINCT(%s) AS approx_distinct_count", column)
+	if bp.sketchHLL {
+		sql += fmt.Sprintf(", HLL_COUNT.INIT(%s) AS hll_sketch", column)
+	}
+	return sql + fmt.Sprintf(" FROM `%s.%s.%s`", bp.sourceProjectID(), bp.sourceDatasetID, bp.backupTableID)
+}
+
+// writeSketch computes the sketch of sketch_column and writes it to sketch.json in the backup
+// prefix. It does nothing when no sketch was requested.
+func (bp *backupParams) writeSketch(ctx context.Context) error {
+	if bp.sketchColumn == "" {
+		return nil
+	}
+	it, err := bc.Query(bp.sketchQuery()).Read(ctx)
+	if err != nil {
+		return err
+	}
+	var row sketchRow
+	err = it.Next(&row)
+	if err == iterator.Done {
+		return fmt.Errorf("sketch query for column %q returned no rows", bp.sketchColumn)
+	}
+	if err != nil {
+		return err
+	}
+
+	name, err := bp.writeBackupObject(ctx, sketchObjectName, sketch{
+		Table:               fmt.Sprintf("%s:%s.%s", bp.sourceProjectID(), bp.sourceDatasetID, bp.backupTableID),
+		Column:              bp.sketchColumn,
+		ApproxDistinctCount: row.ApproxDistinctCount,
+		HLLSketch:           row.HLLSketch,
+		ComputedAt:          time.Now().UTC(),
+	})
+	if err != nil {
+		return err
+	}
+	return bp.logInfo(fmt.Sprintf("Wrote sketch of column %s (approx. %d distinct values) to gs://%s/%s", bp.sketchColumn, row.ApproxDistinctCount, bp.storageBucket, name))
+}
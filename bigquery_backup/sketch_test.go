@@ -0,0 +1,79 @@
+package bigquerybackup
+
+import (
+	"context"
+	"testing"
+
+	"cloud.google.com/go/bigquery"
+	"github.com/stretchr/testify/assert"
+)
+
+var sketchTestSchema = bigquery.Schema{
+	{Name: "id", Type: bigquery.IntegerFieldType},
+	{Name: "location", Type: bigquery.GeographyFieldType},
+	{Name: "created", Type: bigquery.TimestampFieldType},
+	{Name: "tags", Type: bigquery.StringFieldType, Repeated: true},
+	{Name: "customer", Type: bigquery.RecordFieldType, Schema: bigquery.Schema{
+		{Name: "email", Type: bigquery.StringFieldType},
+	}},
+}
+
+func TestFindSchemaField(t *testing.T) {
+	assert.Equal(t, "id", findSchemaField(sketchTestSchema, "ID").Name)
+	assert.Equal(t, "email", findSchemaField(sketchTestSchema, "customer.email").Name)
+	assert.Nil(t, findSchemaField(sketchTestSchema, "missing"))
+	assert.Nil(t, findSchemaField(sketchTestSchema, "customer.missing"))
+	assert.Nil(t, findSchemaField(sketchTestSchema, "tags.value"))
+}
+
+func TestValidateSketchColumn(t *testing.T) {
+	tests := []struct {
+		name    string
+		column  string
+		hll     bool
+		wantErr bool
+	}{
+		{name: "No sketch", column: "", wantErr: false},
+		{name: "Integer column", column: "id", hll: true, wantErr: false},
+		{name: "Nested string column", column: "customer.email", hll: true, wantErr: false},
+		{name: "Timestamp without HLL", column: "created", wantErr: false},
+		{name: "Timestamp with HLL", column: "created", hll: true, wantErr: true},
+		{name: "Geography column", column: "location", wantErr: true},
+		{name: "Repeated column", column: "tags", wantErr: true},
+		{name: "Record column", column: "customer", wantErr: true},
+		{name: "Missing column", column: "nope", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bp := &backupParams{
+				projectID:       "test-project",
+				sourceDatasetID: "ds",
+				backupTableID:   "t",
+				sketchColumn:    tt.column,
+				sketchHLL:       tt.hll,
+				cache:           newMetadataCache(),
+			}
+			_, _ = bp.cache.table("test-project:ds.t", func() (*bigquery.TableMetadata, error) {
+				return &bigquery.TableMetadata{Schema: sketchTestSchema}, nil
+			})
+
+			ok, err := bp.validateSketchColumn(context.Background())
+			if tt.wantErr {
+				assert.False(t, ok)
+				assert.Error(t, err)
+			} else {
+				assert.True(t, ok)
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestSketchQuery(t *testing.T) {
+	bp := &backupParams{projectID: "test-project", sourceDatasetID: "ds", backupTableID: "t", sketchColumn: "customer.email"}
+	assert.Equal(t, "SELECT APPROX_COUNT_DISTINCT(`customer`.`email`) AS approx_distinct_count FROM `test-project.ds.t`", bp.sketchQuery())
+
+	bp.sketchHLL = true
+	assert.Equal(t, "SELECT APPROX_COUNT_DISTINCT(`customer`.`email`) AS approx_distinct_count, HLL_COUNT.INIT(`customer`.`email`) AS hll_sketch FROM `test-project.ds.t`", bp.sketchQuery())
+}
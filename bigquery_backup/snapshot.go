@@ -0,0 +1,65 @@
+package bigquerybackup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// snapshotTableID returns the name of the BigQuery snapshot taken for this backup. It carries
+// the source dataset and table and the UTC backup time, e.g. "sales__orders_20240115T020000Z",
+// so snapshots of many tables can share one snapshot dataset.
+func (bp *backupParams) snapshotTableID() string {
+	return fmt.Sprintf("%s__%s_%s", bp.sourceDatasetID, bp.backupTableID, bp.backupTime.UTC().Format(backupTimeFormat))
+}
+
+// snapshotQuery returns the DDL that creates the snapshot, expiring it after the retention
+// period when one is set.
+func (bp *backupParams) snapshotQuery() string {
+	sql := fmt.Sprintf("CREATE SNAPSHOT TABLE `%s.%s.%s` CLONE `%s.%s.%s`",
+		bp.sourceProjectID(), bp.snapshotDataset, bp.snapshotTableID(),
+		bp.sourceProjectID(), bp.sourceDatasetID, bp.backupTableID)
+	if bp.snapshotRetentionDays > 0 {
+		sql += fmt.Sprintf(" OPTIONS(expiration_timestamp = TIMESTAMP_ADD(CURRENT_TIMESTAMP(), INTERVAL %d DAY))", bp.snapshotRetentionDays)
+	}
+	return sql
+}
+
+// validateSnapshotDataset checks that the dataset snapshots are written to exists. It returns
+// true when no snapshot was requested.
+func (bp *backupParams) validateSnapshotDataset(ctx context.Context) (bool, error) {
+	if bp.snapshotDataset == "" {
+		return true, nil
+	}
+	if bp.snapshotRetentionDays < 0 {
+		return false, errors.New("snapshot_retention_days must not be negative")
+	}
+	if _, err := bc.DatasetInProject(bp.sourceProjectID(), bp.snapshotDataset).Metadata(ctx); err != nil {
+		return false, fmt.Errorf("snapshot dataset %s is not accessible: %v", bp.snapshotDataset, err)
+	}
+	return true, nil
+}
+
+// createSnapshot creates a BigQuery snapshot of the source table in the snapshot dataset, a
+// restore point that can be queried or cloned without reloading the GCS backup. It does
+// nothing when no snapshot dataset was requested.
+func (bp *backupParams) createSnapshot(ctx context.Context) error {
+	if bp.snapshotDataset == "" {
+		return nil
+	}
+	q := bc.Query(bp.snapshotQuery())
+	q.Labels = jobLabels(bp.labels)
+	job, err := q.Run(ctx)
+	if err != nil {
+		return err
+	}
+	status, err := job.Wait(ctx)
+	if err != nil {
+		return err
+	}
+	if status.Err() != nil {
+		return status.Err()
+	}
+	bp.snapshotTable = fmt.Sprintf("%s.%s.%s", bp.sourceProjectID(), bp.snapshotDataset, bp.snapshotTableID())
+	return bp.logInfo(fmt.Sprintf("Created snapshot %s of table %s.%s", bp.snapshotTable, bp.sourceDatasetID, bp.backupTableID))
+}
@@ -0,0 +1,37 @@
+package bigquerybackup
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSnapshotQuery(t *testing.T) {
+	bp := &backupParams{
+		projectID:       "test-project",
+		sourceDatasetID: "sales",
+		backupTableID:   "orders",
+		snapshotDataset: "restore_points",
+		backupTime:      time.Date(2024, 1, 15, 2, 0, 0, 0, time.UTC),
+	}
+	assert.Equal(t, "sales__orders_20240115T020000Z", bp.snapshotTableID())
+	assert.Equal(t, "CREATE SNAPSHOT TABLE `test-project.restore_points.sales__orders_20240115T020000Z` CLONE `test-project.sales.orders`", bp.snapshotQuery())
+
+	bp.snapshotRetentionDays = 30
+	assert.Equal(t, "CREATE SNAPSHOT TABLE `test-project.restore_points.sales__orders_20240115T020000Z` CLONE `test-project.sales.orders`"+
+		" OPTIONS(expiration_timestamp = TIMESTAMP_ADD(CURRENT_TIMESTAMP(), INTERVAL 30 DAY))", bp.snapshotQuery())
+}
+
+func TestValidateSnapshotDatasetWithoutSnapshot(t *testing.T) {
+	bp := &backupParams{}
+	ok, err := bp.validateSnapshotDataset(context.Background())
+	assert.True(t, ok)
+	assert.NoError(t, err)
+
+	bp = &backupParams{snapshotDataset: "restore_points", snapshotRetentionDays: -1}
+	ok, err = bp.validateSnapshotDataset(context.Background())
+	assert.False(t, ok)
+	assert.Error(t, err)
+}
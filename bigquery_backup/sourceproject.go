@@ -0,0 +1,32 @@
+package bigquerybackup
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// projectIDPattern matches a project ID, optionally scoped to a domain as in
+// example.com:my-project.
+var projectIDPattern = regexp.MustCompile(`^([a-z0-9.-]+:)?[a-z][a-z0-9-]{4,28}[a-z0-9]$`)
+
+// checkSourceProject validates the source_project field of the POST body.
+func checkSourceProject(pb *postBodyParams) error {
+	p := strings.TrimSpace(pb.SourceProject)
+	if p == "" {
+		return nil
+	}
+	if !projectIDPattern.MatchString(p) {
+		return fmt.Errorf("invalid source_project %q, must be a project ID", p)
+	}
+	return nil
+}
+
+// sourceProjectID returns the project of the dataset being backed up: source_project when the
+// request sets it, otherwise GCP_PROJECT. Jobs always run in, and are billed to, GCP_PROJECT.
+func (bp *backupParams) sourceProjectID() string {
+	if bp.sourceProject != "" {
+		return bp.sourceProject
+	}
+	return bp.projectID
+}
@@ -0,0 +1,69 @@
+package bigquerybackup
+
+import (
+	"context"
+	"testing"
+
+	"cloud.google.com/go/bigquery"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckSourceProject(t *testing.T) {
+	tests := []struct {
+		name    string
+		project string
+		wantErr bool
+	}{
+		{name: "Not set", project: ""},
+		{name: "Project ID", project: "data-warehouse-prod"},
+		{name: "Domain-scoped project", project: "example.com:warehouse"},
+		{name: "Surrounding whitespace", project: " data-warehouse "},
+		{name: "Upper case", project: "Data-Warehouse", wantErr: true},
+		{name: "Too short", project: "abc", wantErr: true},
+		{name: "Starts with a digit", project: "1warehouse", wantErr: true},
+		{name: "Ends with a hyphen", project: "warehouse-", wantErr: true},
+		{name: "Contains a dataset", project: "warehouse.sales", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkSourceProject(&postBodyParams{SourceProject: tt.project})
+			assert.Equal(t, tt.wantErr, err != nil, err)
+		})
+	}
+}
+
+func TestSourceProjectID(t *testing.T) {
+	bp := &backupParams{projectID: "billing"}
+	assert.Equal(t, "billing", bp.sourceProjectID())
+
+	bp.setBackupParams(postBodyParams{DatasetName: "ds", TableName: "t", SourceProject: " warehouse "})
+	assert.Equal(t, "warehouse", bp.sourceProjectID())
+	assert.Equal(t, "billing", bp.projectID, "jobs still run in GCP_PROJECT")
+}
+
+func TestValidateInSourceProject(t *testing.T) {
+	bp := &backupParams{projectID: "billing", sourceProject: "warehouse", sourceDatasetID: "ds", backupTableID: "t", cache: newMetadataCache()}
+	_, _ = bp.cache.dataset("warehouse:ds", func() (*bigquery.DatasetMetadata, error) {
+		return &bigquery.DatasetMetadata{FullID: "warehouse:ds", Location: "EU"}, nil
+	})
+	_, _ = bp.cache.table("warehouse:ds.t", func() (*bigquery.TableMetadata, error) {
+		return &bigquery.TableMetadata{FullID: "warehouse:ds.t", Location: "EU"}, nil
+	})
+
+	ok, err := bp.validateDataset(context.Background())
+	assert.True(t, ok)
+	assert.NoError(t, err)
+	ok, err = bp.validateTable(context.Background())
+	assert.True(t, ok)
+	assert.NoError(t, err)
+
+	bp.sourceProject = ""
+	bp.cache = newMetadataCache()
+	_, _ = bp.cache.dataset("billing:ds", func() (*bigquery.DatasetMetadata, error) {
+		return &bigquery.DatasetMetadata{FullID: "warehouse:ds", Location: "EU"}, nil
+	})
+	ok, err = bp.validateDataset(context.Background())
+	assert.False(t, ok)
+	assert.EqualError(t, err, "dataset billing:ds is ambiguous: it resolved to warehouse:ds")
+}
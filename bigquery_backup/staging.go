@@ -0,0 +1,228 @@
+package bigquerybackup
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/iterator"
+)
+
+const (
+	stagingPrefix     = "_staging"
+	successMarkerName = "_SUCCESS"
+)
+
+// successMarker is written to the final backup prefix after every object of a staged backup
+// has been published, or of a backup with mark_backup_parts has been marked. Consumers watching the final prefix should wait for it before reading.
+type successMarker struct {
+	RunID       string    `json:"run_id"`
+	JobID       string    `json:"job_id"`
+	Objects     []string  `json:"objects"`
+	PublishedAt time.Time `json:"published_at"`
+}
+
+// stagingObjectPrefix returns the prefix a staged backup is written under before it is
+// published, e.g. "_staging/<run_id>/dataset/table.20060102T150405Z". It lives outside the final
+// prefix so that nothing appears there until the backup is complete.
+func (bp *backupParams) stagingObjectPrefix() string {
+	return fmt.Sprintf("%s/%s/%s", stagingPrefix, bp.runID, bp.backupPrefix())
+}
+
+// outputPrefix returns the prefix the export and its side files are written to: the staging
+// prefix for a staged backup, otherwise the final backup prefix.
+func (bp *backupParams) outputPrefix() string {
+	if bp.stagedPublish {
+		return bp.stagingObjectPrefix()
+	}
+	return bp.backupPrefix()
+}
+
+// extractURI returns the wildcard GCS URI the extract job writes its shards to. It equals
+// destinationURI unless the backup is staged.
+func (bp *backupParams) extractURI() string {
+	return fmt.Sprintf("gs://%s/%s/%s", bp.storageBucket, bp.outputPrefix(), bp.shardObjectPattern())
+}
+
+// publishedObjectName maps an object under the staging prefix to its name under the final
+// backup prefix.
+func (bp *backupParams) publishedObjectName(staged string) string {
+	return bp.backupPrefix() + strings.TrimPrefix(staged, bp.stagingObjectPrefix())
+}
+
+// verifyStagedShards checks that the staged objects hold every shard the extract job
+// reported. fileCount is the number of files from the job statistics, or 0 when unknown, in
+// which case at least one shard must be present unless the table is empty.
+func (bp *backupParams) verifyStagedShards(objects []string, fileCount int64) error {
+	shardPrefix := bp.stagingObjectPrefix() + "/" + bp.shardNamePrefix()
+	var shards int64
+	for _, name := range objects {
+		if strings.HasPrefix(name, shardPrefix) {
+			shards++
+		}
+	}
+	if shards == 0 && !bp.emptyTable {
+		return fmt.Errorf("no shards found under gs://%s/%s", bp.storageBucket, bp.stagingObjectPrefix())
+	}
+	if fileCount > 0 && shards != fileCount {
+		return fmt.Errorf("found %d shards under gs://%s/%s, the extract job wrote %d", shards, bp.storageBucket, bp.stagingObjectPrefix(), fileCount)
+	}
+	return nil
+}
+
+// listObjects returns the names of all objects under prefix in the storage bucket.
+func (bp *backupParams) listObjects(ctx context.Context, c *storage.Client, prefix string) ([]string, error) {
+	ctx, cancel := withStorageTimeout(ctx)
+	defer cancel()
+	var names []string
+	it := c.Bucket(bp.storageBucket).Objects(ctx, &storage.Query{Prefix: prefix + "/"})
+	for {
+		attrs, err := it.Next()
+		if err == iterator.Done {
+			return names, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		names = append(names, attrs.Name)
+	}
+}
+
+// listShards returns the attributes of the export's shards under prefix, leaving out side files.
+func (bp *backupParams) listShards(ctx context.Context, c *storage.Client, prefix string) ([]*storage.ObjectAttrs, error) {
+	ctx, cancel := withStorageTimeout(ctx)
+	defer cancel()
+	var shards []*storage.ObjectAttrs
+	it := c.Bucket(bp.storageBucket).Objects(ctx, &storage.Query{Prefix: prefix + "/" + bp.shardNamePrefix()})
+	for {
+		attrs, err := it.Next()
+		if err == iterator.Done {
+			return shards, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		shards = append(shards, attrs)
+	}
+}
+
+// publishStagedBackup moves a staged backup to the final prefix. It verifies that every shard
+// is present, copies each staged object to the final prefix, deletes the staged copies, and
+// writes the _SUCCESS marker last. If a copy or the marker fails, the objects this run created
+// under the final prefix are removed again. Objects that were already there, which
+// allow_overwrite lets it replace, are kept, so that a failed publish never deletes an earlier
+// backup. It does nothing for unstaged backups.
+func (bp *backupParams) publishStagedBackup(ctx context.Context) error {
+	if !bp.stagedPublish {
+		return nil
+	}
+	c, err := sharedStorageClient()
+	if err != nil {
+		return err
+	}
+
+	staged, err := bp.listObjects(ctx, c, bp.stagingObjectPrefix())
+	if err != nil {
+		return fmt.Errorf("failed to list staged objects: %v", err)
+	}
+	if err := bp.verifyStagedShards(staged, bp.extractFileCount); err != nil {
+		return err
+	}
+	existing, err := bp.listObjects(ctx, c, bp.backupPrefix())
+	if err != nil {
+		return fmt.Errorf("failed to list objects under the backup prefix: %v", err)
+	}
+
+	bucket := c.Bucket(bp.storageBucket)
+	published := make([]string, 0, len(staged))
+	for _, name := range staged {
+		dst := bp.publishedObjectName(name)
+		opCtx, cancel := withStorageTimeout(ctx)
+		copier := bucket.Object(dst).CopierFrom(bucket.Object(name))
+		if bp.markBackupParts {
+			copier.Metadata = backupPartMetadata
+		}
+		copier.StorageClass = bp.targetStorageClass()
+		_, err := copier.Run(opCtx)
+		cancel()
+		if err != nil {
+			bp.deleteObjects(ctx, bucket, createdObjects(published, existing))
+			return fmt.Errorf("failed to publish %s: %v", name, err)
+		}
+		published = append(published, dst)
+	}
+	bp.deleteObjects(ctx, bucket, staged)
+
+	name, err := bp.writeSuccessMarker(ctx, published)
+	if err != nil {
+		bp.deleteObjects(ctx, bucket, createdObjects(published, existing))
+		return err
+	}
+	return bp.logInfo(fmt.Sprintf("Published %d objects of backup of table %s.%s, marker gs://%s/%s", len(published), bp.sourceDatasetID, bp.backupTableID, bp.storageBucket, name))
+}
+
+// createdObjects returns the published names that were not among the existing objects, i.e.
+// those a failed publish created and should remove again.
+func createdObjects(published, existing []string) []string {
+	had := make(map[string]bool, len(existing))
+	for _, name := range existing {
+		had[name] = true
+	}
+	var created []string
+	for _, name := range published {
+		if !had[name] {
+			created = append(created, name)
+		}
+	}
+	return created
+}
+
+// writeSuccessMarker writes the _SUCCESS marker listing objects to the final backup prefix.
+func (bp *backupParams) writeSuccessMarker(ctx context.Context, objects []string) (string, error) {
+	name, err := bp.writeObject(ctx, bp.backupPrefix()+"/"+successMarkerName, successMarker{
+		RunID:       bp.runID,
+		JobID:       bp.jobID,
+		Objects:     objects,
+		PublishedAt: time.Now().UTC(),
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to write %s marker: %v", successMarkerName, err)
+	}
+	return name, nil
+}
+
+// cleanupStaging deletes whatever is left under the staging prefix. It is called once the
+// request is done, so a failed staged backup never leaves partial output behind. An export that
+// outlasted JOB_TIMEOUT_SECONDS is still writing there and is left alone.
+func (bp *backupParams) cleanupStaging(ctx context.Context) {
+	if !bp.stagedPublish || bp.jobTimedOut {
+		return
+	}
+	c, err := sharedStorageClient()
+	if err != nil {
+		_ = bp.logError(fmt.Sprintf("Failed to clean up staging prefix: %v", err))
+		return
+	}
+
+	staged, err := bp.listObjects(ctx, c, bp.stagingObjectPrefix())
+	if err != nil {
+		_ = bp.logError(fmt.Sprintf("Failed to clean up staging prefix: %v", err))
+		return
+	}
+	bp.deleteObjects(ctx, c.Bucket(bp.storageBucket), staged)
+}
+
+// deleteObjects deletes the named objects, logging rather than returning failures since it is
+// only used to clean up.
+func (bp *backupParams) deleteObjects(ctx context.Context, bucket *storage.BucketHandle, names []string) {
+	for _, name := range names {
+		opCtx, cancel := withStorageTimeout(ctx)
+		err := bucket.Object(name).Delete(opCtx)
+		cancel()
+		if err != nil && err != storage.ErrObjectNotExist {
+			_ = bp.logError(fmt.Sprintf("Failed to delete gs://%s/%s: %v", bp.storageBucket, name, err))
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package bigquerybackup
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStagedPrefixes(t *testing.T) {
+	bp := &backupParams{
+		sourceDatasetID:   "ds",
+		backupTableID:     "t",
+		storageBucket:     "b",
+		destinationFormat: avroFormat,
+		runID:             "run-1",
+		backupTime:        time.Date(2024, 1, 15, 10, 0, 0, 0, time.UTC),
+	}
+	assert.Equal(t, "ds/t.20240115T100000Z", bp.outputPrefix())
+	assert.Equal(t, bp.destinationURI(), bp.extractURI())
+
+	bp.stagedPublish = true
+	assert.Equal(t, "_staging/run-1/ds/t.20240115T100000Z", bp.outputPrefix())
+	assert.Equal(t, "gs://b/_staging/run-1/ds/t.20240115T100000Z/t-*.avro", bp.extractURI())
+	assert.Equal(t, "gs://b/ds/t.20240115T100000Z/t-*.avro", bp.destinationURI())
+	assert.Equal(t, "gs://b/_staging/run-1/ds/t.20240115T100000Z/t-000000000000.avro", bp.firstShardURI())
+	assert.Equal(t, "ds/t.20240115T100000Z/t-000000000001.avro", bp.publishedObjectName("_staging/run-1/ds/t.20240115T100000Z/t-000000000001.avro"))
+	assert.Equal(t, "ds/t.20240115T100000Z/sketch.json", bp.publishedObjectName("_staging/run-1/ds/t.20240115T100000Z/sketch.json"))
+}
+
+func TestVerifyStagedShards(t *testing.T) {
+	bp := &backupParams{
+		sourceDatasetID: "ds",
+		backupTableID:   "t",
+		storageBucket:   "b",
+		runID:           "run-1",
+		stagedPublish:   true,
+		backupTime:      time.Date(2024, 1, 15, 10, 0, 0, 0, time.UTC),
+	}
+	shard := func(n string) string { return "_staging/run-1/ds/t.20240115T100000Z/t-" + n + ".avro" }
+	sideFile := "_staging/run-1/ds/t.20240115T100000Z/load-config.json"
+
+	tests := []struct {
+		name      string
+		objects   []string
+		fileCount int64
+		wantErr   bool
+	}{
+		{name: "All shards present", objects: []string{shard("000000000000"), shard("000000000001"), sideFile}, fileCount: 2},
+		{name: "Unknown file count", objects: []string{shard("000000000000")}},
+		{name: "Missing shard", objects: []string{shard("000000000000"), sideFile}, fileCount: 2, wantErr: true},
+		{name: "No shards", objects: []string{sideFile}, wantErr: true},
+		{name: "Nothing staged", wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := bp.verifyStagedShards(tt.objects, tt.fileCount)
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestCreatedObjects(t *testing.T) {
+	published := []string{"ds/t/part-000000000000.avro", "ds/t/part-000000000001.avro", "ds/t/manifest.json"}
+	existing := []string{"ds/t/part-000000000000.avro", "ds/t/_SUCCESS"}
+	assert.Equal(t, []string{"ds/t/part-000000000001.avro", "ds/t/manifest.json"}, createdObjects(published, existing))
+	assert.Equal(t, published, createdObjects(published, nil))
+	assert.Empty(t, createdObjects(published, published))
+}
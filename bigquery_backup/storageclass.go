@@ -0,0 +1,131 @@
+package bigquerybackup
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"cloud.google.com/go/storage"
+	"google.golang.org/api/iterator"
+)
+
+// Storage classes a backup can be moved to. Backups are rarely read, so only the cold classes
+// are offered.
+const (
+	nearlineStorageClass = "NEARLINE"
+	coldlineStorageClass = "COLDLINE"
+	archiveStorageClass  = "ARCHIVE"
+)
+
+// checkStorageClass validates the storage_class field of the POST body, matching
+// case-insensitively.
+func checkStorageClass(pb *postBodyParams) error {
+	switch strings.ToUpper(strings.TrimSpace(pb.StorageClass)) {
+	case "", nearlineStorageClass, coldlineStorageClass, archiveStorageClass:
+		return nil
+	}
+	return fmt.Errorf("unsupported storage_class %q, must be one of %s, %s or %s", pb.StorageClass, nearlineStorageClass, coldlineStorageClass, archiveStorageClass)
+}
+
+// checkBucketStorageClass records the default storage class of the bucket, so objects are not
+// rewritten when they already land in the requested class, and rejects a storage class for a
+// bucket managed by Autoclass, which does not allow setting the class of objects.
+func (bp *backupParams) checkBucketStorageClass(attrs *storage.BucketAttrs) error {
+	bp.bucketStorageClass = attrs.StorageClass
+	if bp.storageClass == "" {
+		return nil
+	}
+	if attrs.Autoclass != nil && attrs.Autoclass.Enabled {
+		return withStatus(http.StatusBadRequest, fmt.Errorf("bucket %s uses Autoclass, which manages storage classes itself, omit storage_class", bp.storageBucket))
+	}
+	return nil
+}
+
+// targetStorageClass returns the storage class the objects of the backup have to be moved to,
+// or "" when none was requested or the bucket already writes new objects in that class.
+func (bp *backupParams) targetStorageClass() string {
+	if bp.storageClass == "" || bp.storageClass == bp.bucketStorageClass {
+		return ""
+	}
+	return bp.storageClass
+}
+
+// objectStorageClass returns the storage class to write the named object with. Objects under
+// the staging prefix keep the bucket default, since they are deleted again right away and the
+// cold classes charge for early deletion.
+func (bp *backupParams) objectStorageClass(name string) string {
+	if strings.HasPrefix(name, stagingPrefix+"/") {
+		return ""
+	}
+	return bp.targetStorageClass()
+}
+
+// applyStorageClass rewrites the objects of an unstaged backup to the requested storage class.
+// The extract job always writes in the bucket's default class; side files written by the
+// function already carry the requested class and are left alone. Staged backups get the class
+// when they are published, so this does nothing for them, nor when no rewrite is needed.
+func (bp *backupParams) applyStorageClass(ctx context.Context) error {
+	class := bp.targetStorageClass()
+	if bp.storageClass != "" && class == "" {
+		return bp.logInfo(fmt.Sprintf("Bucket %s already writes %s objects, not rewriting the backup", bp.storageBucket, bp.storageClass))
+	}
+	if class == "" || bp.stagedPublish {
+		return nil
+	}
+	c, err := sharedStorageClient()
+	if err != nil {
+		return err
+	}
+
+	bucket := c.Bucket(bp.storageBucket)
+	objects, err := listObjectAttrs(ctx, bucket, bp.backupPrefix()+"/")
+	if err != nil {
+		return fmt.Errorf("failed to list backup objects: %v", err)
+	}
+	rewritten := 0
+	for _, attrs := range objects {
+		if attrs.StorageClass == class {
+			continue
+		}
+		if err := bp.rewriteStorageClass(ctx, bucket, attrs, class); err != nil {
+			return fmt.Errorf("failed to rewrite %s to %s: %v", attrs.Name, class, err)
+		}
+		rewritten++
+	}
+	return bp.logInfo(fmt.Sprintf("Rewrote %d objects of backup of table %s.%s to %s", rewritten, bp.sourceDatasetID, bp.backupTableID, class))
+}
+
+// rewriteStorageClass rewrites an object onto itself in another storage class. A rewrite
+// replaces the object's metadata, so its content type and custom metadata are carried over.
+func (bp *backupParams) rewriteStorageClass(ctx context.Context, bucket *storage.BucketHandle, attrs *storage.ObjectAttrs, class string) error {
+	ctx, cancel := withStorageTimeout(ctx)
+	defer cancel()
+	obj := bucket.Object(attrs.Name)
+	copier := obj.CopierFrom(obj.If(storage.Conditions{GenerationMatch: attrs.Generation}))
+	copier.ContentType = attrs.ContentType
+	copier.ContentEncoding = attrs.ContentEncoding
+	copier.Metadata = attrs.Metadata
+	copier.StorageClass = class
+	copier.DestinationKMSKeyName = bp.kmsKeyName
+	_, err := copier.Run(ctx)
+	return err
+}
+
+// listObjectAttrs returns the attributes of every object under prefix.
+func listObjectAttrs(ctx context.Context, bucket *storage.BucketHandle, prefix string) ([]*storage.ObjectAttrs, error) {
+	ctx, cancel := withStorageTimeout(ctx)
+	defer cancel()
+	var objects []*storage.ObjectAttrs
+	it := bucket.Objects(ctx, &storage.Query{Prefix: prefix})
+	for {
+		attrs, err := it.Next()
+		if err == iterator.Done {
+			return objects, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		objects = append(objects, attrs)
+	}
+}
@@ -0,0 +1,53 @@
+package bigquerybackup
+
+import (
+	"net/http"
+	"testing"
+
+	"cloud.google.com/go/storage"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckStorageClass(t *testing.T) {
+	assert.NoError(t, checkStorageClass(&postBodyParams{}))
+	assert.NoError(t, checkStorageClass(&postBodyParams{StorageClass: "NEARLINE"}))
+	assert.NoError(t, checkStorageClass(&postBodyParams{StorageClass: " coldline "}))
+	assert.NoError(t, checkStorageClass(&postBodyParams{StorageClass: "Archive"}))
+	assert.Error(t, checkStorageClass(&postBodyParams{StorageClass: "STANDARD"}))
+	assert.Error(t, checkStorageClass(&postBodyParams{StorageClass: "GLACIER"}))
+}
+
+func TestCheckBucketStorageClass(t *testing.T) {
+	tests := []struct {
+		name       string
+		class      string
+		attrs      *storage.BucketAttrs
+		wantCode   int
+		wantTarget string
+	}{
+		{name: "No class requested", attrs: &storage.BucketAttrs{StorageClass: "STANDARD"}},
+		{name: "Rewrite from standard", class: coldlineStorageClass, attrs: &storage.BucketAttrs{StorageClass: "STANDARD"}, wantTarget: coldlineStorageClass},
+		{name: "Bucket default matches", class: archiveStorageClass, attrs: &storage.BucketAttrs{StorageClass: archiveStorageClass}},
+		{name: "Autoclass", class: nearlineStorageClass, attrs: &storage.BucketAttrs{StorageClass: "STANDARD", Autoclass: &storage.Autoclass{Enabled: true}}, wantCode: http.StatusBadRequest},
+		{name: "Autoclass without class", attrs: &storage.BucketAttrs{StorageClass: "STANDARD", Autoclass: &storage.Autoclass{Enabled: true}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bp := &backupParams{storageBucket: "b", storageClass: tt.class}
+			err := bp.checkBucketStorageClass(tt.attrs)
+			if tt.wantCode != 0 {
+				assert.Equal(t, tt.wantCode, statusCode(err))
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.wantTarget, bp.targetStorageClass())
+		})
+	}
+}
+
+func TestObjectStorageClass(t *testing.T) {
+	bp := &backupParams{storageClass: coldlineStorageClass, bucketStorageClass: "STANDARD"}
+	assert.Equal(t, coldlineStorageClass, bp.objectStorageClass("ds/t.20240115T100000Z/manifest.json"))
+	assert.Equal(t, "", bp.objectStorageClass(stagingPrefix+"/run/ds/t.20240115T100000Z/manifest.json"))
+}
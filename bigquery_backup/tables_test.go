@@ -0,0 +1,112 @@
+package bigquerybackup
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"cloud.google.com/go/bigquery"
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/api/googleapi"
+)
+
+func TestCheckTables(t *testing.T) {
+	tests := []struct {
+		name    string
+		pb      postBodyParams
+		wantErr bool
+	}{
+		{name: "Not given", pb: postBodyParams{DatasetName: "ds", TableName: "t"}},
+		{name: "Tables of dataset_name", pb: postBodyParams{DatasetName: "ds", Tables: []string{"t1", "t2"}}},
+		{name: "Dataset and table pairs", pb: postBodyParams{StorageBucket: "backups", Tables: []string{"ds1.t1", "ds2.t2"}}},
+		{name: "Pair in dataset_name", pb: postBodyParams{DatasetName: "ds", Tables: []string{"t1", "ds.t2"}}},
+		{name: "Empty", pb: postBodyParams{DatasetName: "ds", Tables: []string{}}, wantErr: true},
+		{name: "With table_name", pb: postBodyParams{DatasetName: "ds", TableName: "t", Tables: []string{"t1"}}, wantErr: true},
+		{name: "With query", pb: postBodyParams{DatasetName: "ds", Query: "SELECT 1", Tables: []string{"t1"}}, wantErr: true},
+		{name: "Empty entry", pb: postBodyParams{DatasetName: "ds", Tables: []string{"t1", " "}}, wantErr: true},
+		{name: "Wildcard entry", pb: postBodyParams{DatasetName: "ds", Tables: []string{"*"}}, wantErr: true},
+		{name: "Too many parts", pb: postBodyParams{DatasetName: "ds", Tables: []string{"p.ds.t"}}, wantErr: true},
+		{name: "Table without dataset", pb: postBodyParams{StorageBucket: "backups", Tables: []string{"t1"}}, wantErr: true},
+		{name: "Other dataset without bucket", pb: postBodyParams{DatasetName: "ds", Tables: []string{"other.t1"}}, wantErr: true},
+		{name: "Duplicate", pb: postBodyParams{DatasetName: "ds", Tables: []string{"t1", "ds.t1"}}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.wantErr, checkTables(&tt.pb) != nil)
+		})
+	}
+}
+
+func TestCheckPostBodyTables(t *testing.T) {
+	bp := &backupParams{}
+	ok, err := bp.checkPostBody(&postBodyParams{StorageBucket: "backups", Tables: []string{"ds.t1"}})
+	assert.True(t, ok, "dataset_name may be left out when every entry names its dataset")
+	assert.NoError(t, err)
+
+	ok, err = bp.checkPostBody(&postBodyParams{StorageBucket: "backups"})
+	assert.False(t, ok)
+	assert.EqualError(t, err, "missing dataset_name")
+
+	ok, err = bp.checkPostBody(&postBodyParams{DatasetName: "ds", StorageBucket: "backups", Tables: []string{"t1"}, Async: true})
+	assert.False(t, ok, "async is only for single tables")
+	assert.Error(t, err)
+}
+
+func TestIsTableListBackup(t *testing.T) {
+	bp := &backupParams{sourceDatasetID: "ds", tables: []string{"t1"}}
+	assert.True(t, bp.isTableListBackup())
+	assert.False(t, bp.isDatasetBackup(), "an empty table_name does not mean the whole dataset")
+}
+
+func TestForListedTable(t *testing.T) {
+	bp := &backupParams{sourceDatasetID: "ds", tables: []string{"t1", "other.t2"}, jobID: "job_1"}
+	t1 := bp.forListedTable("ds.t1")
+	assert.Equal(t, "ds", t1.sourceDatasetID)
+	assert.Equal(t, "t1", t1.backupTableID)
+	assert.Empty(t, t1.jobID)
+
+	t2 := bp.forListedTable("other.t2")
+	assert.Equal(t, "other", t2.sourceDatasetID)
+	assert.Equal(t, "t2", t2.backupTableID)
+	assert.Equal(t, "ds", bp.sourceDatasetID, "the request is not modified")
+}
+
+func TestBackupTablesFailsFastOnMissingTable(t *testing.T) {
+	t.Setenv("BIGQUERY_LOCATION", "")
+	bp := &backupParams{projectID: "p", sourceDatasetID: "ds", tables: []string{"t1", "typo"}, cache: newMetadataCache()}
+	_, _ = bp.cache.dataset("p:ds", func() (*bigquery.DatasetMetadata, error) {
+		return &bigquery.DatasetMetadata{FullID: "p:ds", Location: "US"}, nil
+	})
+	_, _ = bp.cache.table("p:ds.t1", func() (*bigquery.TableMetadata, error) {
+		return &bigquery.TableMetadata{FullID: "p:ds.t1", Location: "US", Type: bigquery.RegularTable}, nil
+	})
+	_, _ = bp.cache.table("p:ds.typo", func() (*bigquery.TableMetadata, error) {
+		return nil, &googleapi.Error{Code: http.StatusNotFound, Message: "Not found: Table p:ds.typo"}
+	})
+
+	resp := bp.backupTables(context.Background())
+	assert.Equal(t, tableStatusFailed, resp.Status)
+	assert.Equal(t, http.StatusNotFound, resp.Code)
+	assert.Contains(t, resp.Error, "ds.typo")
+	assert.NotContains(t, resp.Error, "ds.t1")
+	assert.Empty(t, resp.Tables, "no table is backed up")
+}
+
+func TestBackupTablesReportsPermissionDenied(t *testing.T) {
+	t.Setenv("BIGQUERY_LOCATION", "")
+	bp := &backupParams{projectID: "p", sourceDatasetID: "ds", tables: []string{"typo", "secret"}, cache: newMetadataCache()}
+	// Only the 404 is cached, the 403 is looked up by every use.
+	bp.metadata = &fakeBigQuery{tableErrs: map[string]error{"p:ds.secret": &googleapi.Error{Code: http.StatusForbidden}}}
+	_, _ = bp.cache.dataset("p:ds", func() (*bigquery.DatasetMetadata, error) {
+		return &bigquery.DatasetMetadata{FullID: "p:ds", Location: "US"}, nil
+	})
+	_, _ = bp.cache.table("p:ds.typo", func() (*bigquery.TableMetadata, error) {
+		return nil, &googleapi.Error{Code: http.StatusNotFound}
+	})
+
+	resp := bp.backupTables(context.Background())
+	assert.Equal(t, http.StatusForbidden, resp.Code)
+	assert.Contains(t, resp.Error, "table ds.typo does not exist")
+	assert.Contains(t, resp.Error, "permission denied on table ds.secret")
+}
@@ -0,0 +1,177 @@
+package bigquerybackup
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"google.golang.org/api/idtoken"
+)
+
+const (
+	triggerAttempts = 3
+	triggerTimeout  = 10 * time.Second
+)
+
+// triggerBackoff is the delay before the first retry of a trigger; it doubles on each retry.
+var triggerBackoff = time.Second
+
+// successTrigger is the on_success_trigger section of the POST body. Exactly one of URL or
+// Topic is set. URL is called with an HTTPS POST, using a Google-signed ID token for Audience
+// when one is given so that private Cloud Run services can be invoked. Topic is a Pub/Sub topic
+// ID in the function's project or a full "projects/<project>/topics/<topic>" name.
+type successTrigger struct {
+	URL      string `json:"url"`
+	Audience string `json:"audience"`
+	Topic    string `json:"topic"`
+}
+
+// backupResultPayload is the body sent to the next stage of a pipeline once a backup succeeds.
+type backupResultPayload struct {
+	ProjectID      string    `json:"project_id"`
+	DatasetName    string    `json:"dataset_name"`
+	TableName      string    `json:"table_name"`
+	StorageBucket  string    `json:"storage_bucket"`
+	DestinationURI string    `json:"destination_uri"`
+	Format         string    `json:"destination_format"`
+	Compression    string    `json:"compression_type"`
+	JobID          string    `json:"job_id"`
+	RunID          string    `json:"run_id"`
+	SnapshotTable  string    `json:"snapshot_table,omitempty"`
+	CompletedAt    time.Time `json:"completed_at"`
+}
+
+// validate checks that the trigger names exactly one well-formed target.
+func (t *successTrigger) validate() error {
+	if t.URL == "" && t.Topic == "" {
+		return errors.New("on_success_trigger needs a url or a topic")
+	}
+	if t.URL != "" && t.Topic != "" {
+		return errors.New("on_success_trigger accepts either a url or a topic, not both")
+	}
+	if t.URL != "" {
+		u, err := url.Parse(t.URL)
+		if err != nil || u.Scheme != "https" || u.Host == "" {
+			return fmt.Errorf("on_success_trigger url %q must be an absolute https URL", t.URL)
+		}
+		return nil
+	}
+	if _, _, err := parseTopic(t.Topic, "default"); err != nil {
+		return err
+	}
+	return nil
+}
+
+// parseTopic splits a topic given either as a bare topic ID or as a full resource name into
+// its project and topic ID. Bare IDs belong to defaultProject.
+func parseTopic(topic, defaultProject string) (string, string, error) {
+	parts := strings.Split(topic, "/")
+	switch {
+	case len(parts) == 1 && parts[0] != "":
+		return defaultProject, parts[0], nil
+	case len(parts) == 4 && parts[0] == "projects" && parts[1] != "" && parts[2] == "topics" && parts[3] != "":
+		return parts[1], parts[3], nil
+	}
+	return "", "", fmt.Errorf("invalid topic %q, expected a topic ID or projects/<project>/topics/<topic>", topic)
+}
+
+// newBackupResultPayload describes the completed backup for the next pipeline stage.
+func (bp *backupParams) newBackupResultPayload() backupResultPayload {
+	return backupResultPayload{
+		ProjectID:      bp.sourceProjectID(),
+		DatasetName:    bp.sourceDatasetID,
+		TableName:      bp.backupTableID,
+		StorageBucket:  bp.storageBucket,
+		DestinationURI: bp.destinationURI(),
+		Format:         bp.destinationFormat,
+		Compression:    bp.compressionType,
+		JobID:          bp.jobID,
+		RunID:          bp.runID,
+		SnapshotTable:  bp.snapshotTable,
+		CompletedAt:    time.Now().UTC(),
+	}
+}
+
+// fireSuccessTrigger hands the backup result to the configured on_success_trigger. Each
+// attempt is bounded by a short timeout and failed attempts are retried with backoff. It does
+// nothing when no trigger was requested.
+func (bp *backupParams) fireSuccessTrigger(ctx context.Context) error {
+	if bp.successTrigger == nil {
+		return nil
+	}
+	data, err := json.Marshal(bp.newBackupResultPayload())
+	if err != nil {
+		return err
+	}
+
+	var send func(context.Context) error
+	if bp.successTrigger.URL != "" {
+		client := &http.Client{}
+		if bp.successTrigger.Audience != "" {
+			client, err = idtoken.NewClient(ctx, bp.successTrigger.Audience)
+			if err != nil {
+				return fmt.Errorf("failed to create ID token client for %s: %v", bp.successTrigger.Audience, err)
+			}
+		}
+		send = func(ctx context.Context) error {
+			return postTrigger(ctx, client, bp.successTrigger.URL, data)
+		}
+	} else {
+		send = func(ctx context.Context) error {
+			return publishToTopic(ctx, bp.projectID, bp.successTrigger.Topic, data)
+		}
+	}
+
+	if err := retryTrigger(ctx, send); err != nil {
+		return err
+	}
+	return bp.logInfo(fmt.Sprintf("Triggered next stage for backup of table %s.%s", bp.sourceDatasetID, bp.backupTableID))
+}
+
+// retryTrigger calls send up to triggerAttempts times, giving each attempt triggerTimeout.
+func retryTrigger(ctx context.Context, send func(context.Context) error) error {
+	backoff := triggerBackoff
+	var err error
+	for attempt := 1; attempt <= triggerAttempts; attempt++ {
+		attemptCtx, cancel := context.WithTimeout(ctx, triggerTimeout)
+		err = send(attemptCtx)
+		cancel()
+		if err == nil {
+			return nil
+		}
+		if attempt == triggerAttempts {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+	return fmt.Errorf("trigger failed after %d attempts: %v", triggerAttempts, err)
+}
+
+// postTrigger POSTs the payload to url and treats any non-2xx response as a failure.
+func postTrigger(ctx context.Context, client *http.Client, url string, data []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("trigger %s returned %s", url, resp.Status)
+	}
+	return nil
+}
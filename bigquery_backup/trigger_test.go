@@ -0,0 +1,96 @@
+package bigquerybackup
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSuccessTriggerValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		trigger successTrigger
+		wantErr bool
+	}{
+		{name: "HTTPS URL", trigger: successTrigger{URL: "https://next-stage-abc.a.run.app/run"}},
+		{name: "Topic ID", trigger: successTrigger{Topic: "backups-done"}},
+		{name: "Full topic name", trigger: successTrigger{Topic: "projects/other/topics/backups-done"}},
+		{name: "Empty", trigger: successTrigger{}, wantErr: true},
+		{name: "Both targets", trigger: successTrigger{URL: "https://example.com", Topic: "t"}, wantErr: true},
+		{name: "Plain HTTP URL", trigger: successTrigger{URL: "http://example.com"}, wantErr: true},
+		{name: "Relative URL", trigger: successTrigger{URL: "/run"}, wantErr: true},
+		{name: "Malformed topic", trigger: successTrigger{Topic: "projects/other/subscriptions/s"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.trigger.validate()
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestParseTopic(t *testing.T) {
+	project, topic, err := parseTopic("backups-done", "test-project")
+	assert.NoError(t, err)
+	assert.Equal(t, "test-project", project)
+	assert.Equal(t, "backups-done", topic)
+
+	project, topic, err = parseTopic("projects/other/topics/backups-done", "test-project")
+	assert.NoError(t, err)
+	assert.Equal(t, "other", project)
+	assert.Equal(t, "backups-done", topic)
+}
+
+func TestPostTrigger(t *testing.T) {
+	var got backupResultPayload
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		assert.NoError(t, json.NewDecoder(r.Body).Decode(&got))
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer srv.Close()
+
+	bp := &backupParams{sourceDatasetID: "ds", backupTableID: "t", jobID: "job-1"}
+	data, _ := json.Marshal(bp.newBackupResultPayload())
+	assert.NoError(t, postTrigger(context.Background(), srv.Client(), srv.URL, data))
+	assert.Equal(t, "job-1", got.JobID)
+
+	failing := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer failing.Close()
+	assert.Error(t, postTrigger(context.Background(), failing.Client(), failing.URL, data))
+}
+
+func TestRetryTrigger(t *testing.T) {
+	triggerBackoff = 0
+
+	calls := 0
+	err := retryTrigger(context.Background(), func(context.Context) error {
+		calls++
+		if calls < 2 {
+			return errors.New("unavailable")
+		}
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, calls)
+
+	calls = 0
+	err = retryTrigger(context.Background(), func(context.Context) error {
+		calls++
+		return errors.New("unavailable")
+	})
+	assert.Error(t, err)
+	assert.Equal(t, triggerAttempts, calls)
+}
@@ -0,0 +1,63 @@
+package bigquerybackup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"cloud.google.com/go/storage"
+)
+
+// verifyShards checks the exported shards against the source table and the extract job
+// statistics. BigQuery does not report how many rows an extract job wrote, so instead of
+// comparing row counts it checks that the job wrote the files it reported, fileCount, when that
+// is known, and that a table with rows produced at least one non-empty shard. An empty table
+// may produce no shard at all.
+func verifyShards(numRows uint64, fileCount int64, shards []*storage.ObjectAttrs) error {
+	if fileCount > 0 && int64(len(shards)) != fileCount {
+		return fmt.Errorf("found %d shards, the extract job reported %d", len(shards), fileCount)
+	}
+	if numRows == 0 {
+		return nil
+	}
+	if len(shards) == 0 {
+		return errors.New("no shards were written")
+	}
+	for _, attrs := range shards {
+		if attrs.Size > 0 {
+			return nil
+		}
+	}
+	return fmt.Errorf("every shard is empty but the source has %d rows", numRows)
+}
+
+// verifyBackup checks that the export is complete when verify was requested, see
+// verifyExportedShards. It does nothing otherwise.
+func (bp *backupParams) verifyBackup(ctx context.Context) error {
+	if !bp.verify {
+		return nil
+	}
+	c, err := sharedStorageClient()
+	if err != nil {
+		return err
+	}
+
+	shards, err := bp.listShards(ctx, c, bp.outputPrefix())
+	if err != nil {
+		return fmt.Errorf("failed to list shards: %v", err)
+	}
+	return bp.verifyExportedShards(ctx, shards)
+}
+
+// verifyExportedShards checks the shards of the export against the rows of the source, those of
+// the partition for a partition backup, see verifyShards.
+func (bp *backupParams) verifyExportedShards(ctx context.Context, shards []*storage.ObjectAttrs) error {
+	rows, err := bp.sourceRows(ctx)
+	if err != nil {
+		return err
+	}
+	if err := verifyShards(rows, bp.extractFileCount, shards); err != nil {
+		return fmt.Errorf("backup of table %s.%s under gs://%s/%s is incomplete: %v", bp.sourceDatasetID, bp.backupName(), bp.storageBucket, bp.outputPrefix(), err)
+	}
+	return bp.logInfo(fmt.Sprintf("Verified backup of table %s.%s: %d shards for %d source rows", bp.sourceDatasetID, bp.backupName(), len(shards), rows))
+}
@@ -0,0 +1,75 @@
+package bigquerybackup
+
+import (
+	"context"
+	"testing"
+
+	"cloud.google.com/go/bigquery"
+	"cloud.google.com/go/storage"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVerifyShards(t *testing.T) {
+	full := &storage.ObjectAttrs{Name: "ds/t.20240115T100000Z/t-000000000000.avro", Size: 1024}
+	empty := &storage.ObjectAttrs{Name: "ds/t.20240115T100000Z/t-000000000001.avro"}
+
+	tests := []struct {
+		name      string
+		numRows   uint64
+		fileCount int64
+		shards    []*storage.ObjectAttrs
+		wantErr   bool
+	}{
+		{name: "Complete", numRows: 100, fileCount: 2, shards: []*storage.ObjectAttrs{full, empty}},
+		{name: "File count unknown", numRows: 100, shards: []*storage.ObjectAttrs{full}},
+		{name: "Empty table", numRows: 0, fileCount: 1, shards: []*storage.ObjectAttrs{empty}},
+		{name: "Empty table without shards", numRows: 0},
+		{name: "Empty table with missing shard", numRows: 0, fileCount: 1, wantErr: true},
+		{name: "No shards", numRows: 100, fileCount: 1, wantErr: true},
+		{name: "Missing shard", numRows: 100, fileCount: 3, shards: []*storage.ObjectAttrs{full, empty}, wantErr: true},
+		{name: "All empty", numRows: 100, fileCount: 1, shards: []*storage.ObjectAttrs{empty}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := verifyShards(tt.numRows, tt.fileCount, tt.shards)
+			assert.Equal(t, tt.wantErr, err != nil)
+		})
+	}
+}
+
+func TestVerifyExportedShards(t *testing.T) {
+	full := &storage.ObjectAttrs{Name: "ds/t$20240115.20240115T100000Z/t-000000000000.avro", Size: 1024}
+
+	tests := []struct {
+		name          string
+		md            *bigquery.TableMetadata
+		partition     string
+		partitionRows uint64
+		shards        []*storage.ObjectAttrs
+		wantErr       bool
+	}{
+		{name: "Table with rows", md: &bigquery.TableMetadata{NumRows: 100}, shards: []*storage.ObjectAttrs{full}},
+		{name: "Table with rows without shards", md: &bigquery.TableMetadata{NumRows: 100}, wantErr: true},
+		{name: "Empty partition of a table with rows", md: &bigquery.TableMetadata{NumRows: 100}, partition: "20240115"},
+		{name: "Partition with rows", md: &bigquery.TableMetadata{}, partition: "20240115", partitionRows: 40, shards: []*storage.ObjectAttrs{full}},
+		{name: "Partition with rows without shards", md: &bigquery.TableMetadata{}, partition: "20240115", partitionRows: 40, wantErr: true},
+	}
+
+	orig := queryPartitionStats
+	defer func() { queryPartitionStats = orig }()
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			queryPartitionStats = func(ctx context.Context, projectID, datasetID, tableID, partition string) (partitionStats, error) {
+				return partitionStats{rows: tt.partitionRows}, nil
+			}
+			bp := &backupParams{projectID: "p", sourceDatasetID: "ds", backupTableID: "t", partition: tt.partition, storageBucket: "backups", cache: newMetadataCache()}
+			_, _ = bp.cache.table("p:ds.t", func() (*bigquery.TableMetadata, error) {
+				return tt.md, nil
+			})
+			err := bp.verifyExportedShards(context.Background(), tt.shards)
+			assert.Equal(t, tt.wantErr, err != nil)
+		})
+	}
+}
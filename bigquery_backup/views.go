@@ -0,0 +1,48 @@
+package bigquerybackup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"cloud.google.com/go/bigquery"
+)
+
+// isView reports whether a table of type t is a logical or materialized view, neither of which
+// an extract job can read.
+func isView(t bigquery.TableType) bool {
+	return t == bigquery.ViewTable || t == bigquery.MaterializedView
+}
+
+// checkView decides how a view is backed up. With allow_views it is materialized: the view is
+// queried into a temporary table, which is then exported like a query result. Without it a view
+// is rejected with 400, as are options that need a real table. It does nothing for tables.
+func (bp *backupParams) checkView(ctx context.Context) error {
+	md, err := bp.tableMetadata(ctx)
+	if err != nil {
+		return err
+	}
+	if !isView(md.Type) {
+		return nil
+	}
+	if !bp.allowViews {
+		return withStatus(http.StatusBadRequest, fmt.Errorf("%s.%s is a %s, which cannot be exported directly, set allow_views to materialize it first", bp.sourceDatasetID, bp.backupTableID, strings.ToLower(string(md.Type))))
+	}
+	switch {
+	case bp.isPointInTimeBackup():
+		return withStatus(http.StatusBadRequest, errors.New("snapshot_time cannot be used to back up a view, which has no time travel"))
+	case bp.snapshotDataset != "":
+		return withStatus(http.StatusBadRequest, errors.New("snapshot_dataset cannot be used to back up a view"))
+	case bp.async:
+		return withStatus(http.StatusBadRequest, errors.New("async cannot be used to back up a view, which has to be materialized before the export"))
+	}
+	bp.materializeView = true
+	return bp.logInfo(fmt.Sprintf("%s.%s is a %s, materializing it into %s before the export", bp.sourceDatasetID, bp.backupTableID, md.Type, bp.queryTableID()))
+}
+
+// viewQuery returns the query that materializes the view being backed up.
+func (bp *backupParams) viewQuery() string {
+	return fmt.Sprintf("SELECT * FROM `%s.%s.%s`", bp.sourceProjectID(), bp.sourceDatasetID, bp.backupTableID)
+}
@@ -0,0 +1,87 @@
+package bigquerybackup
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/bigquery"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckView(t *testing.T) {
+	tests := []struct {
+		name        string
+		tableType   bigquery.TableType
+		allowViews  bool
+		snapshot    string
+		async       bool
+		asOf        bool
+		wantCode    int
+		materialize bool
+	}{
+		{name: "Table", tableType: bigquery.RegularTable},
+		{name: "Table with allow_views", tableType: bigquery.RegularTable, allowViews: true},
+		{name: "View", tableType: bigquery.ViewTable, wantCode: http.StatusBadRequest},
+		{name: "View with allow_views", tableType: bigquery.ViewTable, allowViews: true, materialize: true},
+		{name: "Materialized view with allow_views", tableType: bigquery.MaterializedView, allowViews: true, materialize: true},
+		{name: "View with snapshot", tableType: bigquery.ViewTable, allowViews: true, snapshot: "snaps", wantCode: http.StatusBadRequest},
+		{name: "View with async", tableType: bigquery.ViewTable, allowViews: true, async: true, wantCode: http.StatusBadRequest},
+		{name: "View with snapshot_time", tableType: bigquery.ViewTable, allowViews: true, asOf: true, wantCode: http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bp := &backupParams{
+				projectID:       "p",
+				sourceDatasetID: "ds",
+				backupTableID:   "v",
+				runID:           "0b6c-42",
+				allowViews:      tt.allowViews,
+				snapshotDataset: tt.snapshot,
+				async:           tt.async,
+				cache:           newMetadataCache(),
+			}
+			if tt.asOf {
+				bp.snapshotTime = time.Now().Add(-time.Hour)
+			}
+			_, _ = bp.cache.table("p:ds.v", func() (*bigquery.TableMetadata, error) {
+				return &bigquery.TableMetadata{Type: tt.tableType}, nil
+			})
+			err := bp.checkView(context.Background())
+			if tt.wantCode == 0 {
+				assert.NoError(t, err)
+			} else {
+				assert.Equal(t, tt.wantCode, statusCode(err))
+			}
+			assert.Equal(t, tt.materialize, bp.materializeView)
+		})
+	}
+}
+
+func TestViewQuery(t *testing.T) {
+	bp := &backupParams{projectID: "p", sourceDatasetID: "ds", backupTableID: "v", runID: "0b6c-42"}
+	assert.Empty(t, bp.backupQuery(), "a table backup runs no query")
+	assert.Equal(t, "_backup_query_0b6c_42", bp.queryTableID())
+
+	bp.materializeView = true
+	assert.Equal(t, "SELECT * FROM `p.ds.v`", bp.backupQuery())
+	assert.Equal(t, "_backup_view_v_0b6c_42", bp.queryTableID())
+}
+
+func TestBackupDatasetTableSkipsViewsUnlessAllowed(t *testing.T) {
+	bp := &backupParams{projectID: "p", sourceDatasetID: "ds", cache: newMetadataCache()}
+	_, _ = bp.cache.table("p:ds.mv", func() (*bigquery.TableMetadata, error) {
+		return &bigquery.TableMetadata{Type: bigquery.MaterializedView}, nil
+	})
+	result := bp.forTable("mv").backupDatasetTable(context.Background())
+	assert.Equal(t, tableResult{Table: "mv", Status: tableStatusSkipped, Reason: skipReasonUnsupportedType}, result)
+
+	_, _ = bp.cache.table("p:ds.ext", func() (*bigquery.TableMetadata, error) {
+		return &bigquery.TableMetadata{Type: bigquery.ExternalTable}, nil
+	})
+	bp.allowViews = true
+	result = bp.forTable("ext").backupDatasetTable(context.Background())
+	assert.Equal(t, skipReasonUnsupportedType, result.Reason, "allow_views does not make external tables exportable")
+}
@@ -0,0 +1,78 @@
+package bigquerybackup
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// webhookTimeout bounds the call to webhook_url, so a slow receiver cannot hold up the response.
+const webhookTimeout = 5 * time.Second
+
+// webhookClient is the HTTP client webhook_url is called with.
+var webhookClient = &http.Client{}
+
+// webhookPayload is the summary POSTed to webhook_url once a table backup has finished. Text
+// repeats it as one line so that Slack-compatible incoming webhooks can display it as is.
+type webhookPayload struct {
+	Text            string  `json:"text"`
+	Status          string  `json:"status"`
+	Table           string  `json:"table"`
+	JobID           string  `json:"job_id,omitempty"`
+	RunID           string  `json:"run_id"`
+	DurationSeconds float64 `json:"duration_seconds"`
+	Error           string  `json:"error,omitempty"`
+}
+
+// checkWebhookURL validates the webhook_url field of the POST body. Only absolute https URLs
+// are accepted, so a summary is never sent in plain text by accident.
+func checkWebhookURL(raw string) error {
+	if raw == "" {
+		return nil
+	}
+	u, err := url.Parse(raw)
+	if err != nil || u.Scheme != "https" || u.Host == "" {
+		return fmt.Errorf("webhook_url %q must be an absolute https URL", raw)
+	}
+	return nil
+}
+
+// newWebhookPayload summarizes the outcome of the backup, which took duration. The status
+// follows notify_topic: skipped, failed when backupErr is set, and succeeded otherwise.
+func (bp *backupParams) newWebhookPayload(skipped bool, backupErr error, duration time.Duration) webhookPayload {
+	n := bp.newBackupNotification(skipped, backupErr)
+	p := webhookPayload{
+		Status:          n.Status,
+		Table:           fmt.Sprintf("%s.%s.%s", bp.sourceProjectID(), bp.sourceDatasetID, bp.backupTableID),
+		JobID:           bp.jobID,
+		RunID:           bp.runID,
+		DurationSeconds: duration.Round(time.Millisecond).Seconds(),
+		Error:           n.Error,
+	}
+	p.Text = fmt.Sprintf("Backup of %s %s after %s", p.Table, p.Status, duration.Round(time.Second))
+	if p.Error != "" {
+		p.Text += ": " + p.Error
+	}
+	return p
+}
+
+// callWebhook POSTs the outcome of the backup to webhook_url when one was requested. A failed
+// call or a non-2xx response is logged and never changes the outcome of the backup.
+func (bp *backupParams) callWebhook(ctx context.Context, skipped bool, backupErr error, duration time.Duration) {
+	if bp.webhookURL == "" {
+		return
+	}
+	data, err := json.Marshal(bp.newWebhookPayload(skipped, backupErr, duration))
+	if err != nil {
+		_ = bp.logError(fmt.Sprintf("Failed to encode webhook payload: %v", err))
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), webhookTimeout)
+	defer cancel()
+	if err := postTrigger(ctx, webhookClient, bp.webhookURL, data); err != nil {
+		_ = bp.logError(fmt.Sprintf("Failed to call webhook for backup of table %s.%s: %v", bp.sourceDatasetID, bp.backupTableID, err))
+	}
+}
@@ -0,0 +1,59 @@
+package bigquerybackup
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckWebhookURL(t *testing.T) {
+	assert.NoError(t, checkWebhookURL(""))
+	assert.NoError(t, checkWebhookURL("https://hooks.example.com/services/T000/B000/XXXX"))
+	assert.Error(t, checkWebhookURL("http://hooks.example.com/services/T000"))
+	assert.Error(t, checkWebhookURL("hooks.example.com/services/T000"))
+	assert.Error(t, checkWebhookURL("https:///services"))
+}
+
+func TestNewWebhookPayload(t *testing.T) {
+	bp := &backupParams{projectID: "p", sourceDatasetID: "ds", backupTableID: "t", jobID: "job-1", runID: "run-1"}
+
+	p := bp.newWebhookPayload(false, nil, 90*time.Second)
+	assert.Equal(t, webhookPayload{
+		Text:            "Backup of p.ds.t succeeded after 1m30s",
+		Status:          notifyStatusSucceeded,
+		Table:           "p.ds.t",
+		JobID:           "job-1",
+		RunID:           "run-1",
+		DurationSeconds: 90,
+	}, p)
+
+	p = bp.newWebhookPayload(false, errors.New("extract failed"), 2*time.Second)
+	assert.Equal(t, notifyStatusFailed, p.Status)
+	assert.Equal(t, "extract failed", p.Error)
+	assert.Equal(t, "Backup of p.ds.t failed after 2s: extract failed", p.Text)
+}
+
+func TestCallWebhook(t *testing.T) {
+	var got webhookPayload
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		_ = json.Unmarshal(body, &got)
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer srv.Close()
+	orig := webhookClient
+	webhookClient = srv.Client()
+	defer func() { webhookClient = orig }()
+
+	bp := &backupParams{projectID: "test-project", sourceDatasetID: "ds", backupTableID: "t", webhookURL: srv.URL}
+	// A failing webhook is only logged.
+	bp.callWebhook(context.Background(), true, nil, time.Second)
+	assert.Equal(t, notifyStatusSkipped, got.Status)
+}